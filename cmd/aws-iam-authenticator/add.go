@@ -46,14 +46,20 @@ var addUserCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		checkPrompt(fmt.Sprintf("add userarn %s, username %s, groups %s", userARN, userName, groups))
-		cli := createClient()
-
-		cm, err := cli.AddUser(&config.UserMapping{
+		user := &config.UserMapping{
 			UserARN:  userARN,
 			Username: userName,
 			Groups:   groups,
-		})
+		}
+		if dryRun {
+			printPreview(createClient().PreviewAddUser(user))
+			return
+		}
+
+		checkPrompt(fmt.Sprintf("add userarn %s, username %s, groups %s", userARN, userName, groups))
+		cli := createClient()
+
+		cm, err := cli.AddUser(user)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -113,15 +119,21 @@ var addRoleCmd = &cobra.Command{
 			}
 		}
 
-		checkPrompt(fmt.Sprintf("add %s %s, username %s, groups %s", arnOrSSORole, roleARN, userName, groups))
-		cli := createClient()
-
-		cm, err := cli.AddRole(&config.RoleMapping{
+		role := &config.RoleMapping{
 			RoleARN:  roleARN,
 			SSO:      ssoRoleConfig,
 			Username: userName,
 			Groups:   groups,
-		})
+		}
+		if dryRun {
+			printPreview(createClient().PreviewAddRole(role))
+			return
+		}
+
+		checkPrompt(fmt.Sprintf("add %s %s, username %s, groups %s", arnOrSSORole, roleARN, userName, groups))
+		cli := createClient()
+
+		cm, err := cli.AddRole(role)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -136,6 +148,18 @@ var addRoleCmd = &cobra.Command{
 	},
 }
 
+func printPreview(p *client.Preview, err error) {
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if p.Diff == "" {
+		fmt.Println("no changes")
+		return
+	}
+	fmt.Printf("configmap changes (dry run):\n\n%s\n", p.Diff)
+}
+
 func checkPrompt(action string) {
 	if !prompt {
 		return
@@ -197,7 +221,14 @@ func createClient() client.Client {
 		os.Exit(1)
 	}
 
-	return client.New(clientset.CoreV1().ConfigMaps("kube-system"))
+	var opts []client.Option
+	if createIfMissing {
+		opts = append(opts, client.CreateIfMissing("kube-system"))
+	}
+	if usePatch {
+		opts = append(opts, client.UsePatch())
+	}
+	return client.New(clientset.CoreV1().ConfigMaps("kube-system"), opts...)
 }
 
 var (
@@ -205,6 +236,9 @@ var (
 	masterURL         string
 	kubeconfigPath    string
 	kubeconfigContext string
+	createIfMissing   bool
+	dryRun            bool
+	usePatch          bool
 
 	userARN  string
 	userName string
@@ -225,6 +259,9 @@ func init() {
 	addCmd.PersistentFlags().StringVar(&masterURL, "master-url", "", "kube-apiserver URL for creating Kubernetes client")
 	addCmd.PersistentFlags().StringVar(&kubeconfigPath, "kubeconfig", "", "kubeconfig file path, if empty, it loads the default config")
 	addCmd.PersistentFlags().StringVar(&kubeconfigContext, "kubeconfig-context", "", "kubeconfig context, if empty, it uses the default context")
+	addCmd.PersistentFlags().BoolVar(&createIfMissing, "create-if-missing", false, "create the aws-auth configmap if it does not exist")
+	addCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print the configmap changes without applying them")
+	addCmd.PersistentFlags().BoolVar(&usePatch, "patch", false, "patch only the changed mapping keys instead of replacing the configmap")
 
 	addUserCmd.PersistentFlags().StringVar(&userARN, "userarn", "", "A new user ARN")
 	addUserCmd.PersistentFlags().StringVar(&userName, "username", "", "A new user name")
@@ -108,6 +108,38 @@ func getConfig() (config.Config, error) {
 		DynamicFilePath: viper.GetString("server.dynamicfilepath"),
 		//DynamicFileUserIDStrict: if true, then aws UserId from sts will be used to look up the roleMapping/userMapping; or aws IdentityArn is used
 		DynamicFileUserIDStrict: viper.GetBool("server.dynamicfileUserIDStrict"),
+		//MountedFilePath: the config file to reload MountedFile mode mappings from
+		MountedFilePath: viper.ConfigFileUsed(),
+		//ConfigMapNamespace and ConfigMapName: the location of the configmap for EKSConfigMap mode
+		ConfigMapNamespace:             viper.GetString("server.configMapNamespace"),
+		ConfigMapName:                  viper.GetString("server.configMapName"),
+		ConfigMapCacheSize:             viper.GetInt("server.configMapCacheSize"),
+		ConfigMapCacheTTL:              viper.GetDuration("server.configMapCacheTTL"),
+		ConfigMapStrictParse:           viper.GetBool("server.configMapStrictParse"),
+		ConfigMapResyncInterval:        viper.GetDuration("server.configMapResyncInterval"),
+		ConfigMapDebounce:              viper.GetDuration("server.configMapDebounce"),
+		ConfigMapAccountAllowPolicy:    config.AccountAllowPolicy(viper.GetString("server.configMapAccountAllowPolicy")),
+		ConfigMapPatternMatchMetrics:   viper.GetBool("server.configMapPatternMatchMetrics"),
+		ConfigMapMaxMappings:           viper.GetInt("server.configMapMaxMappings"),
+		ConfigMapIgnorePath:            viper.GetBool("server.configMapIgnorePath"),
+		ConfigMapInformer:              viper.GetBool("server.configMapInformer"),
+		ConfigMapRequireAccountAllowed: viper.GetBool("server.configMapRequireAccountAllowed"),
+		ConfigMapEnforceAccountAllowed: viper.GetBool("server.configMapEnforceAccountAllowed"),
+		ConfigMapAccountBypassGroups:   viper.GetStringSlice("server.configMapAccountBypassGroups"),
+		ConfigMapAllowedGroups:         viper.GetStringSlice("server.configMapAllowedGroups"),
+		ConfigMapDisallowedGroups:      viper.GetStringSlice("server.configMapDisallowedGroups"),
+		ConfigMapWarnDisallowedGroups:  viper.GetBool("server.configMapWarnDisallowedGroups"),
+		//DynamoDBTableName, DynamoDBRegion and DynamoDBWarmCache: the table to read DynamoDB mode mappings from
+		DynamoDBTableName: viper.GetString("server.dynamoDBTableName"),
+		DynamoDBRegion:    viper.GetString("server.dynamoDBRegion"),
+		DynamoDBWarmCache: viper.GetBool("server.dynamoDBWarmCache"),
+		//Webhook*: the endpoint and client settings for Webhook mode
+		WebhookURL:            viper.GetString("server.webhookURL"),
+		WebhookTimeout:        viper.GetDuration("server.webhookTimeout"),
+		WebhookCacheTTL:       viper.GetDuration("server.webhookCacheTTL"),
+		WebhookCAFile:         viper.GetString("server.webhookCAFile"),
+		WebhookClientCertFile: viper.GetString("server.webhookClientCertFile"),
+		WebhookClientKeyFile:  viper.GetString("server.webhookClientKeyFile"),
 	}
 	if err := viper.UnmarshalKey("server.mapRoles", &cfg.RoleMappings); err != nil {
 		return cfg, fmt.Errorf("invalid server role mappings: %v", err)
@@ -128,6 +160,8 @@ func getConfig() (config.Config, error) {
 			cfg.ReservedPrefixConfig[c.BackendMode] = c
 		}
 	}
+	config.PatternMappingDeniedGroups = viper.GetStringSlice("server.patternMappingDeniedGroups")
+	config.RequireMappingGroups = viper.GetBool("server.requireMappingGroups")
 	if featureGates.Enabled(config.SSORoleMatch) {
 		logrus.Info("SSORoleMatch feature enabled")
 		config.SSORoleMatchEnabled = true
@@ -17,13 +17,16 @@ import (
 //   * IAM role: arn:aws:iam::123456789012:role/S3Access
 //   * IAM Assumed role: arn:aws:sts::123456789012:assumed-role/Accounting-Role/Mary (converted to IAM role)
 //   * Federated user: arn:aws:sts::123456789012:federated-user/Bob
+//
+// The partition may be AnyPartition, which is kept as is, so mapping ARNs
+// for every partition canonicalize like any other.
 func Canonicalize(arn string) (string, error) {
 	parsed, err := awsarn.Parse(arn)
 	if err != nil {
 		return "", fmt.Errorf("arn '%s' is invalid: '%v'", arn, err)
 	}
 
-	if err := checkPartition(parsed.Partition); err != nil {
+	if err := checkPartition(parsed.Partition); err != nil && parsed.Partition != AnyPartition {
 		return "", fmt.Errorf("arn '%s' does not have a recognized partition", arn)
 	}
 
@@ -36,12 +39,11 @@ func Canonicalize(arn string) (string, error) {
 		case "federated-user":
 			return arn, nil
 		case "assumed-role":
-			if len(parts) < 3 {
+			roleARN, _, ok := AssumedRole(arn)
+			if !ok {
 				return "", fmt.Errorf("assumed-role arn '%s' does not have a role", arn)
 			}
-			// IAM ARNs can contain paths, part[0] is resource, parts[len(parts)] is the SessionName.
-			role := strings.Join(parts[1:len(parts)-1], "/")
-			return fmt.Sprintf("arn:%s:iam::%s:role/%s", parsed.Partition, parsed.AccountID, role), nil
+			return roleARN, nil
 		default:
 			return "", fmt.Errorf("unrecognized resource %s for service sts", parsed.Resource)
 		}
@@ -57,6 +59,117 @@ func Canonicalize(arn string) (string, error) {
 	return "", fmt.Errorf("service %s in arn %s is not a valid service for identities", parsed.Service, arn)
 }
 
+// AnyPartition is the partition of a mapping ARN that matches the ARN in
+// every partition, so arn:*:iam::123456789012:role/Admin matches both
+// arn:aws:iam::123456789012:role/Admin and
+// arn:aws-us-gov:iam::123456789012:role/Admin.
+const AnyPartition = "*"
+
+// WithAnyPartition returns arn with its partition replaced by
+// AnyPartition. Strings that don't parse as an ARN are returned as is.
+func WithAnyPartition(arn string) string {
+	sections, err := parse(arn)
+	if err != nil {
+		return arn
+	}
+	sections[sectionPartition] = AnyPartition
+	return strings.Join(sections, arnDelimiter)
+}
+
+// MatchesExact returns true if mappingARN, the ARN of an exact mapping, is
+// subject, or if it has the AnyPartition partition and is subject in any
+// partition. Both are compared as given, so callers normalize their case.
+func MatchesExact(mappingARN, subject string) bool {
+	if mappingARN == subject {
+		return true
+	}
+	return strings.HasPrefix(mappingARN, arnPrefix+AnyPartition+arnDelimiter) && mappingARN == WithAnyPartition(subject)
+}
+
+// AssumedRole splits an STS assumed-role ARN into the ARN of the IAM role
+// and the session name:
+//
+//   arn:aws:sts::123456789012:assumed-role/path/Accounting-Role/Mary
+//   -> arn:aws:iam::123456789012:role/path/Accounting-Role, Mary
+//
+// ok is false if arn is not an assumed-role ARN.
+func AssumedRole(arn string) (roleARN, sessionName string, ok bool) {
+	parsed, err := awsarn.Parse(arn)
+	if err != nil || parsed.Service != "sts" {
+		return "", "", false
+	}
+	// IAM ARNs can contain paths, part[0] is resource, parts[len(parts)-1] is the SessionName.
+	parts := strings.Split(parsed.Resource, "/")
+	if parts[0] != "assumed-role" || len(parts) < 3 {
+		return "", "", false
+	}
+	role := strings.Join(parts[1:len(parts)-1], "/")
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", parsed.Partition, parsed.AccountID, role), parts[len(parts)-1], true
+}
+
+// NormalizeCase lowercases the sections of arn that AWS treats
+// case-insensitively, the partition, service, region and account, and
+// leaves the case-sensitive resource as is. Strings that don't parse as an
+// ARN are lowercased entirely.
+func NormalizeCase(arn string) string {
+	sections, err := parse(arn)
+	if err != nil {
+		return strings.ToLower(arn)
+	}
+	resource := sections[sectionResource]
+	return strings.ToLower(strings.Join(sections[:sectionResource], arnDelimiter)) + arnDelimiter + resource
+}
+
+// StripPath returns arn without the path of an IAM role or user, so
+// arn:aws:iam::123456789012:role/team/Admin becomes
+// arn:aws:iam::123456789012:role/Admin. Any other arn, including sts
+// assumed-role ARNs, is returned as is.
+func StripPath(arn string) string {
+	parsed, err := awsarn.Parse(arn)
+	if err != nil || parsed.Service != "iam" {
+		return arn
+	}
+	kind, name, ok := strings.Cut(parsed.Resource, "/")
+	if !ok || kind != "role" && kind != "user" || !strings.Contains(name, "/") {
+		return arn
+	}
+	return arn[:len(arn)-len(parsed.Resource)] + kind + "/" + name[strings.LastIndex(name, "/")+1:]
+}
+
+// ssoRolePrefix starts the name of the roles IAM Identity Center (SSO)
+// creates for a permission set, AWSReservedSSO_<PermissionSetName>_<hash>.
+const ssoRolePrefix = "awsreservedsso_"
+
+// SSOPermissionSetARN returns the canonical permission-set form of the ARN
+// of an IAM Identity Center (SSO) role or one of its sessions, the role ARN
+// without its path or the random suffix of its name:
+//
+//   arn:aws:sts::123456789012:assumed-role/AWSReservedSSO_Admin_0123456789abcdef/alice
+//   -> arn:aws:iam::123456789012:role/AWSReservedSSO_Admin
+//
+// so every session of a permission set, in any account, has the same form.
+// ok is false if arn is not an SSO role.
+func SSOPermissionSetARN(arn string) (permissionSetARN string, ok bool) {
+	canonical, err := Canonicalize(arn)
+	if err != nil {
+		return "", false
+	}
+	parsed, err := awsarn.Parse(canonical)
+	if err != nil || parsed.Service != "iam" || !strings.HasPrefix(parsed.Resource, "role/") {
+		return "", false
+	}
+	name := parsed.Resource[strings.LastIndex(parsed.Resource, "/")+1:]
+	if !strings.HasPrefix(strings.ToLower(name), ssoRolePrefix) {
+		return "", false
+	}
+	// permission set names may contain underscores, the suffix can't
+	i := strings.LastIndex(name, "_")
+	if i <= len(ssoRolePrefix) || i == len(name)-1 {
+		return "", false
+	}
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", parsed.Partition, parsed.AccountID, name[:i]), true
+}
+
 func checkPartition(partition string) error {
 	for _, p := range endpoints.DefaultPartitions() {
 		if partition == p.ID() {
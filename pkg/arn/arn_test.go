@@ -17,8 +17,15 @@ var arnTests = []struct {
 	{"arn:aws:sts::123456789012:federated-user/Bob", "arn:aws:sts::123456789012:federated-user/Bob", nil},
 	{"arn:aws:iam::123456789012:root", "arn:aws:iam::123456789012:root", nil},
 	{"arn:aws:sts::123456789012:assumed-role/Org/Team/Admin/Session", "arn:aws:iam::123456789012:role/Org/Team/Admin", nil},
+	{"arn:aws-cn:iam::123456789012:role/Users", "arn:aws-cn:iam::123456789012:role/Users", nil},
+	{"arn:aws-cn:sts::123456789012:assumed-role/Admin/Session", "arn:aws-cn:iam::123456789012:role/Admin", nil},
+	{"arn:aws-us-gov:iam::123456789012:user/Alice", "arn:aws-us-gov:iam::123456789012:user/Alice", nil},
+	{"arn:aws-us-gov:sts::123456789012:assumed-role/Org/Admin/Session", "arn:aws-us-gov:iam::123456789012:role/Org/Admin", nil},
+	{"arn:aws-nk:iam::123456789012:role/Users", "", fmt.Errorf("unrecognized partition")},
 	{"arn:aws-iso:iam::123456789012:user/Chris", "arn:aws-iso:iam::123456789012:user/Chris", nil},
 	{"arn:aws-iso-b:iam::123456789012:user/Chris", "arn:aws-iso-b:iam::123456789012:user/Chris", nil},
+	{"arn:*:iam::123456789012:role/Users", "arn:*:iam::123456789012:role/Users", nil},
+	{"arn:*:sts::123456789012:assumed-role/Admin/Session", "arn:*:iam::123456789012:role/Admin", nil},
 }
 
 func TestUserARN(t *testing.T) {
@@ -33,3 +40,109 @@ func TestUserARN(t *testing.T) {
 		}
 	}
 }
+
+func TestMatchesExact(t *testing.T) {
+	for _, subject := range []string{
+		"arn:aws:iam::123456789012:role/Admin",
+		"arn:aws-us-gov:iam::123456789012:role/Admin",
+	} {
+		if !MatchesExact("arn:*:iam::123456789012:role/Admin", subject) {
+			t.Errorf("expected the AnyPartition mapping to match %s", subject)
+		}
+	}
+	for _, subject := range []string{
+		"arn:aws:iam::123456789012:role/Admins",
+		"arn:aws:iam::210987654321:role/Admin",
+		"NOT AN ARN",
+	} {
+		if MatchesExact("arn:*:iam::123456789012:role/Admin", subject) {
+			t.Errorf("expected the AnyPartition mapping not to match %s", subject)
+		}
+	}
+	if MatchesExact("arn:aws:iam::123456789012:role/Admin", "arn:aws-us-gov:iam::123456789012:role/Admin") {
+		t.Error("expected a mapping for one partition not to match another")
+	}
+}
+
+func TestNormalizeCase(t *testing.T) {
+	tests := map[string]string{
+		"arn:AWS:IAM::123456789012:role/Admin":            "arn:aws:iam::123456789012:role/Admin",
+		"arn:aws:iam::123456789012:role/Org/Team/Admin":   "arn:aws:iam::123456789012:role/Org/Team/Admin",
+		"arn:aws:iam::123456789012:user/path:with:Colons": "arn:aws:iam::123456789012:user/path:with:Colons",
+		"arn:IAM:Matlan": "arn:iam:matlan",
+		"NOT AN ARN":     "not an arn",
+	}
+	for input, expected := range tests {
+		if got := NormalizeCase(input); got != expected {
+			t.Errorf("NormalizeCase(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestSSOPermissionSetARN(t *testing.T) {
+	tests := []struct {
+		arn      string
+		expected string
+		ok       bool
+	}{
+		// two sessions of the Admin permission set
+		{"arn:aws:sts::123456789012:assumed-role/AWSReservedSSO_Admin_0123456789abcdef/alice@example.com", "arn:aws:iam::123456789012:role/AWSReservedSSO_Admin", true},
+		{"arn:aws:sts::123456789012:assumed-role/AWSReservedSSO_Admin_0123456789abcdef/bob@example.com", "arn:aws:iam::123456789012:role/AWSReservedSSO_Admin", true},
+		// the same permission set provisioned again gets a new suffix
+		{"arn:aws:sts::123456789012:assumed-role/AWSReservedSSO_Admin_fedcba9876543210/alice@example.com", "arn:aws:iam::123456789012:role/AWSReservedSSO_Admin", true},
+		{"arn:aws:iam::123456789012:role/aws-reserved/sso.amazonaws.com/eu-west-1/AWSReservedSSO_Admin_0123456789abcdef", "arn:aws:iam::123456789012:role/AWSReservedSSO_Admin", true},
+		{"arn:aws:iam::123456789012:role/AWSReservedSSO_Admin_ReadOnly_0123456789abcdef", "arn:aws:iam::123456789012:role/AWSReservedSSO_Admin_ReadOnly", true},
+		{"arn:aws-cn:iam::123456789012:role/awsreservedsso_admin_0123456789abcdef", "arn:aws-cn:iam::123456789012:role/awsreservedsso_admin", true},
+		{"arn:aws:iam::123456789012:role/AWSReservedSSO_Admin", "", false},
+		{"arn:aws:iam::123456789012:role/AWSReservedSSO_Admin_", "", false},
+		{"arn:aws:iam::123456789012:role/Admin_0123456789abcdef", "", false},
+		{"arn:aws:iam::123456789012:user/AWSReservedSSO_Admin_0123456789abcdef", "", false},
+		{"NOT AN ARN", "", false},
+	}
+	for _, tc := range tests {
+		actual, ok := SSOPermissionSetARN(tc.arn)
+		if actual != tc.expected || ok != tc.ok {
+			t.Errorf("SSOPermissionSetARN(%s) = %q, %t, expected %q, %t", tc.arn, actual, ok, tc.expected, tc.ok)
+		}
+	}
+}
+
+func TestStripPath(t *testing.T) {
+	tests := map[string]string{
+		"arn:aws:iam::123456789012:role/team/Admin":            "arn:aws:iam::123456789012:role/Admin",
+		"arn:aws:iam::123456789012:role/Org/Team/Admin":        "arn:aws:iam::123456789012:role/Admin",
+		"arn:aws:iam::123456789012:user/team/Alice":            "arn:aws:iam::123456789012:user/Alice",
+		"arn:aws:iam::123456789012:role/Admin":                 "arn:aws:iam::123456789012:role/Admin",
+		"arn:aws:sts::123456789012:assumed-role/Admin/Session": "arn:aws:sts::123456789012:assumed-role/Admin/Session",
+		"arn:aws:iam::123456789012:root":                       "arn:aws:iam::123456789012:root",
+		"NOT AN ARN":                                           "NOT AN ARN",
+	}
+	for input, expected := range tests {
+		if got := StripPath(input); got != expected {
+			t.Errorf("StripPath(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestAssumedRole(t *testing.T) {
+	tests := []struct {
+		arn         string
+		roleARN     string
+		sessionName string
+		ok          bool
+	}{
+		{"arn:aws:sts::123456789012:assumed-role/Admin/alice", "arn:aws:iam::123456789012:role/Admin", "alice", true},
+		{"arn:aws:sts::123456789012:assumed-role/team/Admin/i-0123", "arn:aws:iam::123456789012:role/team/Admin", "i-0123", true},
+		{"arn:aws-cn:sts::123456789012:assumed-role/Admin/bob@example.com", "arn:aws-cn:iam::123456789012:role/Admin", "bob@example.com", true},
+		{"arn:aws:sts::123456789012:assumed-role/Admin", "", "", false},
+		{"arn:aws:iam::123456789012:role/Admin", "", "", false},
+		{"arn:aws:sts::123456789012:federated-user/Bob", "", "", false},
+		{"NOT AN ARN", "", "", false},
+	}
+	for _, test := range tests {
+		roleARN, sessionName, ok := AssumedRole(test.arn)
+		if roleARN != test.roleARN || sessionName != test.sessionName || ok != test.ok {
+			t.Errorf("AssumedRole(%q) = %q, %q, %v, expected %q, %q, %v", test.arn, roleARN, sessionName, ok, test.roleARN, test.sessionName, test.ok)
+		}
+	}
+}
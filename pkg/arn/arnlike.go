@@ -32,27 +32,174 @@ func ArnLike(arn, pattern string) (bool, error) {
 	if err != nil {
 		return false, fmt.Errorf("Could not parse input arn: %v", err)
 	}
+	compiled, err := CompilePattern(pattern)
+	if err != nil {
+		return false, err
+	}
+	return compiled.matchSections(arnSections), nil
+}
+
+// CompiledPattern is an ArnLike pattern that has been parsed once so it can
+// be matched against many ARNs.
+type CompiledPattern struct {
+	sections []*regexp.Regexp
+}
+
+// CompilePattern parses an ArnLike pattern for use with CompiledPattern.Match.
+func CompilePattern(pattern string) (*CompiledPattern, error) {
 	patternSections, err := parse(pattern)
 	if err != nil {
-		return false, fmt.Errorf("Could not parse ArnLike string: %v", err)
+		return nil, fmt.Errorf("Could not parse ArnLike string: %v", err)
 	}
 
 	// Tidy regexp special characters. Escape the ones not used in ArnLike.
 	// Replace multiple * with .* - we're assuming `\` is not allowed in ARNs
 	preparePatternSections(patternSections)
 
-	for index := range arnSections {
-		patternGlob, err := regexp.Compile(patternSections[index])
+	compiled := &CompiledPattern{sections: make([]*regexp.Regexp, len(patternSections))}
+	for index, section := range patternSections {
+		patternGlob, err := regexp.Compile(section)
 		if err != nil {
-			return false, fmt.Errorf("Could not parse %s: %v", patternSections[index], err)
+			return nil, fmt.Errorf("Could not parse %s: %v", section, err)
 		}
+		compiled.sections[index] = patternGlob
+	}
+	return compiled, nil
+}
+
+// Match returns true if arn is matched by the pattern, like ArnLike.
+func (p *CompiledPattern) Match(arn string) (bool, error) {
+	arnSections, err := parse(arn)
+	if err != nil {
+		return false, fmt.Errorf("Could not parse input arn: %v", err)
+	}
+	return p.matchSections(arnSections), nil
+}
 
-		if !patternGlob.MatchString(arnSections[index]) {
-			return false, nil
+func (p *CompiledPattern) matchSections(arnSections []string) bool {
+	for index := range arnSections {
+		if !p.sections[index].MatchString(arnSections[index]) {
+			return false
 		}
 	}
+	return true
+}
+
+// RolePatternShape is the shape passed to ValidatePattern for patterns
+// matching IAM roles.
+const RolePatternShape = "arn:*:iam:*:*:role/*"
+
+// ValidatePattern returns an error if pattern is not an ArnLike pattern
+// for the kind of identity shape describes: it must have the six sections
+// of an ARN, compile, and itself be matched by shape, such as
+// RolePatternShape, so a pattern that could never match the identities it
+// is meant for is rejected up front.
+func ValidatePattern(pattern, shape string) error {
+	if _, err := CompilePattern(pattern); err != nil {
+		return err
+	}
+	ok, err := ArnLike(pattern, shape)
+	if err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("pattern %s does not match %s", pattern, shape)
+	}
+	return nil
+}
+
+// wildcardPenalty is what each run of * in a pattern takes off its
+// Specificity.
+const wildcardPenalty = 10
+
+// Specificity scores an ArnLike pattern by how narrowly it matches, so that
+// when several patterns match an ARN the most specific can be preferred.
+// Every literal character scores one point and every run of * costs
+// wildcardPenalty, a run matching the same as a single *. A ? scores
+// nothing: it matches any character but still fixes the length. So
+// arn:aws:iam::123:role/comp* outranks arn:aws:iam::*:role/*, and an ARN
+// without wildcards outranks any pattern of the same length. The pattern
+// isn't parsed, so any string can be scored.
+func Specificity(pattern string) int {
+	score := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			if i == 0 || pattern[i-1] != '*' {
+				score -= wildcardPenalty
+			}
+		case '?':
+		default:
+			score++
+		}
+	}
+	return score
+}
+
+// AccountID returns the account ID section of an ARN or ArnLike pattern.
+// ok is false if arn can't be parsed, or if its account ID is empty or
+// contains a wildcard and so could match more than one account.
+func AccountID(arn string) (accountID string, ok bool) {
+	sections, err := parse(arn)
+	if err != nil {
+		return "", false
+	}
+	accountID = sections[sectionAccountID]
+	if accountID == "" || IsAccountPattern(accountID) {
+		return "", false
+	}
+	return accountID, true
+}
+
+// accountIDLength is the number of digits in an AWS account ID.
+const accountIDLength = 12
+
+// AccountPattern is an AWS account ID that may contain the ArnLike
+// wildcards * and ?, such as 01234567*, matched the same way as the account
+// ID section of an ArnLike pattern.
+type AccountPattern struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// IsAccountPattern returns true if accountID contains a wildcard and so
+// should be compiled with CompileAccountPattern rather than compared
+// exactly.
+func IsAccountPattern(accountID string) bool {
+	return strings.ContainsAny(accountID, "*?")
+}
+
+// CompileAccountPattern parses an account ID pattern. The pattern may only
+// hold digits and wildcards, and must be able to match a 12 digit account
+// ID.
+func CompileAccountPattern(pattern string) (*AccountPattern, error) {
+	fixed := 0
+	for _, c := range pattern {
+		switch {
+		case c == '*':
+		case c == '?' || (c >= '0' && c <= '9'):
+			fixed++
+		default:
+			return nil, fmt.Errorf("account pattern %q may only contain digits, * and ?", pattern)
+		}
+	}
+	if fixed > accountIDLength || (fixed < accountIDLength && !strings.Contains(pattern, "*")) {
+		return nil, fmt.Errorf("account pattern %q can't match a %d digit account ID", pattern, accountIDLength)
+	}
+	re, err := regexp.Compile(`^` + quoteMeta(pattern) + `$`)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %s: %v", pattern, err)
+	}
+	return &AccountPattern{pattern: pattern, re: re}, nil
+}
+
+// Match returns true if accountID is matched by the pattern.
+func (p *AccountPattern) Match(accountID string) bool {
+	return p.re.MatchString(accountID)
+}
 
-	return true, nil
+// String returns the pattern as it was written.
+func (p *AccountPattern) String() string {
+	return p.pattern
 }
 
 // parse is a copy of arn.Parse from the AWS SDK but represents the ARN as []string
@@ -131,6 +131,113 @@ func TestArnLikeInvalidArns(t *testing.T) {
 	}
 }
 
+func TestCompiledPattern(t *testing.T) {
+	pattern, err := CompilePattern(`arn:aws:iam::000000000000:role/some-*`)
+	if err != nil {
+		t.Fatalf("Expected no error compiling pattern: %v", err)
+	}
+
+	for arn, expected := range map[string]bool{
+		`arn:aws:iam::000000000000:role/some-role`:  true,
+		`arn:aws:iam::000000000000:role/other-role`: false,
+		`arn:aws:iam::111111111111:role/some-role`:  false,
+	} {
+		ok, err := pattern.Match(arn)
+		if err != nil {
+			t.Errorf("Expected no error for input arn: %s", arn)
+		}
+		if ok != expected {
+			t.Errorf("Expected %v for input arn: %s", expected, arn)
+		}
+	}
+
+	if _, err := pattern.Match(`nar:aws:iam::000000000000:role/some-role`); err == nil {
+		t.Errorf("Expected error matching an invalid arn")
+	}
+	if _, err := CompilePattern("arn:*"); err == nil {
+		t.Errorf("Expected error compiling an incomplete pattern")
+	}
+}
+
+var (
+	benchmarkArn     = `arn:aws:iam::000000000000:role/awsreservedsso_viewonlyaccess_0123456789abcdef`
+	benchmarkPattern = `arn:aws:iam::000000000000:role/awsreservedsso_viewonlyaccess_*`
+)
+
+func BenchmarkArnLike(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := ArnLike(benchmarkArn, benchmarkPattern); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompiledPatternMatch(b *testing.B) {
+	pattern, err := CompilePattern(benchmarkPattern)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := pattern.Match(benchmarkArn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestAccountID(t *testing.T) {
+	inputs := []struct {
+		arn, accountID string
+		ok             bool
+	}{
+		{`arn:aws:iam::000000000000:role/some-role`, "000000000000", true},
+		{`arn:aws:iam::000000000000:role/AWSReservedSSO_Admin_*`, "000000000000", true},
+		{`arn:aws:sts::000000000000:assumed-role/some-role/session`, "000000000000", true},
+		{`arn:aws:iam::*:role/some-role`, "", false},
+		{`arn:aws:iam::0000000000?0:role/some-role`, "", false},
+		{`arn:aws:s3:::some-bucket`, "", false},
+		{`arn:iam:matlan`, "", false},
+		{`not an arn`, "", false},
+	}
+	for _, input := range inputs {
+		accountID, ok := AccountID(input.arn)
+		if accountID != input.accountID || ok != input.ok {
+			t.Errorf("AccountID(%q) = %q, %v; expected %q, %v", input.arn, accountID, ok, input.accountID, input.ok)
+		}
+	}
+}
+
+func TestAccountPattern(t *testing.T) {
+	inputs := []struct {
+		pattern, accountID string
+		match              bool
+	}{
+		{"01234567*", "012345678912", true},
+		{"01234567*", "112345678912", false},
+		{"0123456789?2", "012345678912", true},
+		{"0123456789?2", "012345678913", false},
+		{"*", "012345678912", true},
+	}
+	for _, input := range inputs {
+		pattern, err := CompileAccountPattern(input.pattern)
+		if err != nil {
+			t.Fatalf("CompileAccountPattern(%q) failed: %v", input.pattern, err)
+		}
+		if match := pattern.Match(input.accountID); match != input.match {
+			t.Errorf("%q.Match(%q) = %v; expected %v", input.pattern, input.accountID, match, input.match)
+		}
+		if pattern.String() != input.pattern {
+			t.Errorf("expected String() to return %q, got %q", input.pattern, pattern.String())
+		}
+	}
+
+	for _, invalid := range []string{"", "0123abc*", "01234567.*", "0123456789?", "0123456789012*", "[0-9]*"} {
+		if _, err := CompileAccountPattern(invalid); err == nil {
+			t.Errorf("expected CompileAccountPattern(%q) to fail", invalid)
+		}
+	}
+}
+
 func TestQuoteMeta(t *testing.T) {
 	inputs := []quoteMetaInput{
 		{
@@ -166,3 +273,45 @@ func TestQuoteMeta(t *testing.T) {
 		}
 	}
 }
+
+func TestSpecificity(t *testing.T) {
+	// most specific first
+	patterns := []string{
+		`arn:aws:iam::123456789012:role/computer`,
+		`arn:aws:iam::123456789012:role/comp????`,
+		`arn:aws:iam::123456789012:role/comp*`,
+		`arn:aws:iam::123:role/comp*`,
+		`arn:aws:iam::123:role/*`,
+		`arn:aws:iam::*:role/comp*`,
+		`arn:aws:iam::*:role/*`,
+		`arn:*:iam::*:role/*`,
+		`arn:*:*:*:*:*`,
+	}
+	for i := 1; i < len(patterns); i++ {
+		if a, b := Specificity(patterns[i-1]), Specificity(patterns[i]); a <= b {
+			t.Errorf("expected %s (%d) to outrank %s (%d)", patterns[i-1], a, patterns[i], b)
+		}
+	}
+
+	if a, b := Specificity(`arn:aws:iam::123:role/**`), Specificity(`arn:aws:iam::123:role/*`); a != b {
+		t.Errorf("expected a run of * to score like a single *, got %d and %d", a, b)
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	if err := ValidatePattern(`arn:aws:iam::123456789012:role/*`, RolePatternShape); err != nil {
+		t.Errorf("unexpected error for a role pattern: %v", err)
+	}
+
+	for _, pattern := range []string{
+		`arn:aws:iam::123456789012`,
+		`arn:aws:iam:123456789012:role/*`,
+		`role/*`,
+		`arn:aws:iam::123456789012:user/*`,
+		`arn:aws:sts::123456789012:assumed-role/*`,
+	} {
+		if err := ValidatePattern(pattern, RolePatternShape); err == nil {
+			t.Errorf("expected %s to be rejected as a role pattern", pattern)
+		}
+	}
+}
@@ -18,16 +18,84 @@ package config
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
 	"path/filepath"
+	"regexp"
 	"strconv"
 
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/arn"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/config/certs"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/config/kubeconfig"
 )
 
+// ErrDuplicateMapping is wrapped by the errors Validate returns for a
+// mapping whose ARN or pattern is already mapped earlier in the config.
+var ErrDuplicateMapping = errors.New("duplicate mapping")
+
+var accountIDRegexp = regexp.MustCompile("^[0-9]{12}$")
+
+// Validate validates every role and user mapping of c, checks that every
+// AutoMappedAWSAccounts entry is a 12 digit account ID or account pattern,
+// and that no two mappings have the same key. Every problem found is
+// returned in one aggregated error, nil if there are none.
+func (c *Config) Validate() error {
+	var errs []error
+	roleKeys := make(map[string]int)
+	for i := range c.RoleMappings {
+		role := c.RoleMappings[i]
+		if err := role.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("mapRoles[%d]: %v", i, err))
+			continue
+		}
+		role.CanonicalizeARN()
+		key := role.MatchKey()
+		if first, ok := roleKeys[key]; ok {
+			errs = append(errs, fmt.Errorf("mapRoles[%d]: %w %s, already mapped by mapRoles[%d]", i, ErrDuplicateMapping, key, first))
+			continue
+		}
+		roleKeys[key] = i
+	}
+	userKeys := make(map[string]int)
+	for i := range c.UserMappings {
+		user := c.UserMappings[i]
+		if err := user.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("mapUsers[%d]: %v", i, err))
+			continue
+		}
+		user.CanonicalizeARN()
+		key := user.MatchKey()
+		if first, ok := userKeys[key]; ok {
+			errs = append(errs, fmt.Errorf("mapUsers[%d]: %w %s, already mapped by mapUsers[%d]", i, ErrDuplicateMapping, key, first))
+			continue
+		}
+		userKeys[key] = i
+	}
+	for i, account := range c.AutoMappedAWSAccounts {
+		if err := ValidateAccount(account); err != nil {
+			errs = append(errs, fmt.Errorf("mapAccounts[%d]: %v", i, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// ValidateAccount returns an error if account is neither a 12 digit AWS
+// account ID nor a valid account pattern, the entries mapAccounts accepts.
+func ValidateAccount(account string) error {
+	if arn.IsAccountPattern(account) {
+		_, err := arn.CompileAccountPattern(account)
+		return err
+	}
+	if !accountIDRegexp.MatchString(account) {
+		return fmt.Errorf("%q is not a valid AWS account ID", account)
+	}
+	return nil
+}
+
 // ServerURL returns the URL to connect to this server.
 func (c *Config) ServerURL() string {
 	u := url.URL{
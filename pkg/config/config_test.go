@@ -1,7 +1,11 @@
 package config
 
 import (
+	"errors"
+	"strings"
 	"testing"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
 func TestServerUrl(t *testing.T) {
@@ -39,3 +43,64 @@ func TestServerUrl(t *testing.T) {
 		}
 	}
 }
+
+func TestConfigValidate(t *testing.T) {
+	clean := Config{
+		RoleMappings: []RoleMapping{
+			{RoleARN: "arn:aws:iam::012345678912:role/Admin", Username: "admin", Groups: []string{"system:masters"}},
+			{RoleARN: "arn:aws:iam::012345678912:role/Admin", SessionNameLike: "break-glass-*", Username: "break-glass"},
+			{RoleARNRegex: "arn:aws:iam::012345678912:role/dev-.*", Username: "dev"},
+		},
+		UserMappings: []UserMapping{
+			{UserARN: "arn:aws:iam::012345678912:user/Alice", Username: "alice"},
+			// exact ARNs are matched with the case of their resource
+			{UserARN: "arn:aws:iam::012345678912:user/alice", Username: "other-alice"},
+		},
+		AutoMappedAWSAccounts: []string{"111122223333", "4444*"},
+	}
+	if err := clean.Validate(); err != nil {
+		t.Errorf("expected a clean config to validate, got %v", err)
+	}
+
+	bad := Config{
+		RoleMappings: []RoleMapping{
+			{RoleARN: "arn:aws:iam::012345678912:role/Admin", Username: "admin"},
+			{Username: "nothing"},
+			{RoleARN: "arn:aws:sts::012345678912:assumed-role/Admin/session", Username: "admin-again"},
+		},
+		UserMappings: []UserMapping{
+			{UserARN: "arn:aws:iam::012345678912:user/Alice", Username: "alice"},
+			{UserARN: "arn:AWS:iam::012345678912:user/Alice", Username: "alice-again"},
+		},
+		AutoMappedAWSAccounts: []string{"111122223333", "1234", "12a*"},
+	}
+	err := bad.Validate()
+	var agg utilerrors.Aggregate
+	if !errors.As(err, &agg) {
+		t.Fatalf("expected an aggregated error, got %v", err)
+	}
+	expected := []string{
+		"mapRoles[1]: ",
+		"mapRoles[2]: duplicate mapping arn:aws:iam::012345678912:role/Admin, already mapped by mapRoles[0]",
+		"mapUsers[1]: duplicate mapping arn:aws:iam::012345678912:user/Alice, already mapped by mapUsers[0]",
+		"mapAccounts[1]: \"1234\" is not a valid AWS account ID",
+		"mapAccounts[2]: ",
+	}
+	if len(agg.Errors()) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), agg.Errors())
+	}
+	for i, e := range agg.Errors() {
+		if !strings.HasPrefix(e.Error(), expected[i]) {
+			t.Errorf("expected error %d to start with %q, got %q", i, expected[i], e)
+		}
+	}
+	duplicates := 0
+	for _, e := range agg.Errors() {
+		if errors.Is(e, ErrDuplicateMapping) {
+			duplicates++
+		}
+	}
+	if duplicates != 2 {
+		t.Errorf("expected 2 errors to be ErrDuplicateMapping, got %d", duplicates)
+	}
+}
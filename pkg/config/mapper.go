@@ -1,15 +1,128 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 
 	"sigs.k8s.io/aws-iam-authenticator/pkg/arn"
 
+	awsarn "github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/sirupsen/logrus"
 )
 
+// MarshalJSON marshals nil Groups as an empty list rather than null.
+func (m IdentityMapping) MarshalJSON() ([]byte, error) {
+	// identityMapping drops the method so Marshal doesn't recurse
+	type identityMapping IdentityMapping
+	if m.Groups == nil {
+		m.Groups = []string{}
+	}
+	return json.Marshal(identityMapping(m))
+}
+
+// PatternMappingDeniedGroups lists groups that may only be granted by exact
+// ARN mappings. Pattern mappings (SSO, regex or role tag) that grant any of
+// these groups fail validation. Empty by default.
+var PatternMappingDeniedGroups []string
+
+// RequireMappingGroups makes validation reject mappings without any groups,
+// unless they set AllowNoGroups. Off by default.
+var RequireMappingGroups bool
+
+// checkGroups returns an error if groups is empty and RequireMappingGroups is
+// set, unless allowNoGroups is set.
+func checkGroups(groups []string, allowNoGroups bool) error {
+	if RequireMappingGroups && len(groups) == 0 && !allowNoGroups {
+		return fmt.Errorf("at least one group must be supplied, or allownogroups set")
+	}
+	return nil
+}
+
+// TemplateVariables are the variables that may be used in the username and
+// groups of a mapping, e.g. "{{SessionName}}". They are rendered by the server
+// when an identity is mapped.
+var TemplateVariables = []string{
+	"EC2PrivateDNSName",
+	"AccountID",
+	"SessionName",
+	"SessionNameRaw",
+	"AccessKeyID",
+	"ARN",
+}
+
+var templateVariableRegexp = regexp.MustCompile(`{{([^{}]*)}}`)
+
+// validateTemplates returns an error if username or any of groups uses a
+// variable that isn't in TemplateVariables, or has a "{{" or "}}" that
+// isn't part of a variable.
+func validateTemplates(username string, groups []string) error {
+	for _, template := range append([]string{username}, groups...) {
+		rest := templateVariableRegexp.ReplaceAllString(template, "")
+		if strings.Contains(rest, "{{") || strings.Contains(rest, "}}") {
+			return fmt.Errorf("template %q has unbalanced braces", template)
+		}
+		for _, match := range templateVariableRegexp.FindAllStringSubmatch(template, -1) {
+			known := false
+			for _, variable := range TemplateVariables {
+				if match[1] == variable {
+					known = true
+					break
+				}
+			}
+			if !known {
+				return fmt.Errorf("template %q uses unknown variable %s", template, match[0])
+			}
+		}
+	}
+	return nil
+}
+
+// compileARNRegex compiles pattern anchored to match a whole ARN, ignoring
+// case.
+func compileARNRegex(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)^(?:" + pattern + ")$")
+}
+
+// matchARNRegex returns true if subject matches re, the compiled ARN regex
+// pattern. A nil re compiles pattern instead.
+func matchARNRegex(re *regexp.Regexp, pattern, subject string) bool {
+	if re == nil {
+		var err error
+		if re, err = compileARNRegex(pattern); err != nil {
+			logrus.Errorf("Could not compile ARN regex %q: %v", pattern, err)
+			return false
+		}
+	}
+	return re.MatchString(subject)
+}
+
+// sessionNameLikeRegexp is the characters allowed in an sts session name,
+// plus the * and ? wildcards.
+var sessionNameLikeRegexp = regexp.MustCompile(`^[\w+=,.@*?-]{1,64}$`)
+
+// sessionNameRegex returns the ARN regex matching the SessionNameLike glob.
+func sessionNameRegex(glob string) string {
+	quoted := regexp.QuoteMeta(glob)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	return strings.ReplaceAll(quoted, `\?`, ".")
+}
+
+// checkPatternGroups returns an error if groups contains any of
+// PatternMappingDeniedGroups.
+func checkPatternGroups(groups []string) error {
+	for _, group := range groups {
+		for _, denied := range PatternMappingDeniedGroups {
+			if group == denied {
+				return fmt.Errorf("group '%s' can only be granted by an exact ARN mapping", group)
+			}
+		}
+	}
+	return nil
+}
+
 // SSOArnLike returns a string that can be passed to arnlike.ArnLike to
 // match canonicalized IAM Role ARNs against. Assumes Validate() has been called.
 func (m *RoleMapping) SSOArnLike() string {
@@ -17,28 +130,55 @@ func (m *RoleMapping) SSOArnLike() string {
 		return ""
 	}
 
-	var partition string
-	if m.SSO.Partition == "" {
+	partition := m.SSO.Partition
+	if partition == "" {
 		partition = "aws"
 	}
 
 	return strings.ToLower(fmt.Sprintf("arn:%s:iam::%s:role/AWSReservedSSO_%s_*", partition, m.SSO.AccountID, m.SSO.PermissionSetName))
 }
 
+// ssoPermissionSetARN returns the arn.SSOPermissionSetARN form of the roles
+// matched by an SSO mapping, lowercased like SSOArnLike.
+func (m *RoleMapping) ssoPermissionSetARN() string {
+	return strings.TrimSuffix(m.SSOArnLike(), "_*")
+}
+
 // Validate returns an error if the RoleMapping is not valid after being unmarshaled
 func (m *RoleMapping) Validate() error {
 	if m == nil {
 		return fmt.Errorf("RoleMapping is nil")
 	}
 
-	if m.RoleARN == "" && m.SSO == nil {
-		return fmt.Errorf("One of rolearn or SSO must be supplied")
-	} else if m.RoleARN != "" && m.SSO != nil {
-		return fmt.Errorf("Only one of rolearn or SSO can be supplied")
+	supplied := 0
+	for _, set := range []bool{m.RoleARN != "", m.SSO != nil, m.RoleARNRegex != ""} {
+		if set {
+			supplied++
+		}
+	}
+	if supplied == 0 {
+		return fmt.Errorf("One of rolearn, rolearnregex or SSO must be supplied")
+	} else if supplied > 1 {
+		return fmt.Errorf("Only one of rolearn, rolearnregex or SSO can be supplied")
+	}
+
+	if m.RawMatch && m.RoleARN == "" {
+		return fmt.Errorf("rawmatch can only be used with rolearn")
+	}
+
+	if m.SessionNameLike != "" {
+		if m.RoleARN == "" || m.RawMatch {
+			return fmt.Errorf("sessionnamelike can only be used with rolearn without rawmatch")
+		}
+		if !sessionNameLikeRegexp.MatchString(m.SessionNameLike) {
+			return fmt.Errorf("sessionnamelike '%s' is not a valid session name pattern", m.SessionNameLike)
+		}
+		if canonical, err := arn.Canonicalize(strings.ToLower(m.RoleARN)); err != nil || !strings.Contains(canonical, ":role/") {
+			return fmt.Errorf("sessionnamelike can only be used with the ARN of a role")
+		}
 	}
 
 	if m.SSO != nil {
-		accountIDRegexp := regexp.MustCompile("^[0-9]{12}$")
 		if !accountIDRegexp.MatchString(m.SSO.AccountID) {
 			return fmt.Errorf("AccountID '%s' is not a valid AWS Account ID", m.SSO.AccountID)
 		}
@@ -59,22 +199,62 @@ func (m *RoleMapping) Validate() error {
 		}
 
 		ssoArnLikeString := m.SSOArnLike()
-		ok, err := arn.ArnLike(ssoArnLikeString, "arn:*:iam:*:*:role/*")
-		if err != nil {
+		if err := arn.ValidatePattern(ssoArnLikeString, arn.RolePatternShape); err != nil {
 			return fmt.Errorf("SSOArnLike '%s' is not valid: %v", ssoArnLikeString, err)
-		} else if !ok {
-			return fmt.Errorf("SSOArnLike '%s' did not match an ARN for a canonicalized IAM Role", ssoArnLikeString)
 		}
 	}
 
-	return nil
+	if m.RoleARNRegex != "" {
+		if _, err := compileARNRegex(m.RoleARNRegex); err != nil {
+			return fmt.Errorf("rolearnregex '%s' is not valid: %v", m.RoleARNRegex, err)
+		}
+	}
+
+	if m.SSO != nil || m.RoleARNRegex != "" {
+		if err := checkPatternGroups(m.Groups); err != nil {
+			return err
+		}
+	}
+
+	if err := checkGroups(m.Groups, m.AllowNoGroups); err != nil {
+		return err
+	}
+
+	return validateTemplates(m.Username, m.Groups)
 }
 
 // Matches returns true if the supplied ARN or SSO settings matches
 // this RoleMapping
 func (m *RoleMapping) Matches(subject string) bool {
+	return m.MatchesCompiled(subject, nil, nil)
+}
+
+// CompileRegex compiles the regex a RoleARNRegex or SessionNameLike mapping
+// is matched with, for use with MatchesCompiled. It returns nil for other
+// mappings.
+func (m *RoleMapping) CompileRegex() (*regexp.Regexp, error) {
+	switch {
+	case m.SessionNameLike != "":
+		return compileARNRegex(sessionNameRegex(m.SessionNameLike))
+	case m.RoleARNRegex != "":
+		return compileARNRegex(m.RoleARNRegex)
+	}
+	return nil, nil
+}
+
+// MatchesCompiled is like Matches, but uses pattern as the compiled
+// SSOArnLike() of an SSO mapping and re as the result of CompileRegex
+// instead of compiling them on every call. A nil pattern or re falls back
+// to compiling them.
+func (m *RoleMapping) MatchesCompiled(subject string, pattern *arn.CompiledPattern, re *regexp.Regexp) bool {
+	if m.SessionNameLike != "" {
+		return m.matchesSession(subject, re)
+	}
 	if m.RoleARN != "" {
-		return strings.ToLower(m.RoleARN) == strings.ToLower(subject)
+		return arn.MatchesExact(arn.NormalizeCase(m.RoleARN), arn.NormalizeCase(subject))
+	}
+	if m.RoleARNRegex != "" {
+		return matchARNRegex(re, m.RoleARNRegex, subject)
 	}
 
 	// Assume the caller has called Validate(), which parses m.RoleARNLike
@@ -83,43 +263,247 @@ func (m *RoleMapping) Matches(subject string) bool {
 	var ok bool
 	if SSORoleMatchEnabled {
 		var err error
-		ok, err = arn.ArnLike(subject, m.SSOArnLike())
+		if pattern != nil {
+			ok, err = pattern.Match(subject)
+		} else {
+			ok, err = arn.ArnLike(subject, m.SSOArnLike())
+		}
 		if err != nil {
 			logrus.Error("Could not parse subject ARN: ", err)
 		}
+		// the pattern also matches the roles of permission sets whose name
+		// starts with PermissionSetName and an underscore, so compare the
+		// permission set with the random suffix stripped
+		if ok {
+			permissionSetARN, isSSO := arn.SSOPermissionSetARN(subject)
+			ok = isSSO && strings.ToLower(permissionSetARN) == m.ssoPermissionSetARN()
+		}
 	}
 	return ok
 }
 
-// Key returns RoleARN or SSOArnLike(), whichever is not empty.
+// matchesSession returns true if subject is an sts assumed-role ARN for
+// RoleARN with a session name matching SessionNameLike, compiled as re.
+func (m *RoleMapping) matchesSession(subject string, re *regexp.Regexp) bool {
+	parsed, err := awsarn.Parse(subject)
+	if err != nil || parsed.Service != "sts" || !strings.HasPrefix(parsed.Resource, "assumed-role/") {
+		return false
+	}
+	canonical, err := arn.Canonicalize(strings.ToLower(subject))
+	if err != nil || !arn.MatchesExact(strings.ToLower(m.RoleARN), canonical) {
+		return false
+	}
+	session := parsed.Resource[strings.LastIndex(parsed.Resource, "/")+1:]
+	return matchARNRegex(re, sessionNameRegex(m.SessionNameLike), session)
+}
+
+// MatchesRawARN returns true if the mapping is matched against the ARN
+// presented by the caller rather than its canonicalized form, as RawMatch
+// and SessionNameLike mappings are.
+func (m *RoleMapping) MatchesRawARN() bool {
+	return m.RawMatch || m.SessionNameLike != ""
+}
+
+// Key returns RoleARN, RoleARNRegex or SSOArnLike(), whichever is not empty.
+// The SessionNameLike of a RoleARN mapping is appended, so mappings of the
+// same role for different sessions have different keys.
 // Used to get a Key name for map[string]RoleMapping
 func (m *RoleMapping) Key() string {
 	if m.RoleARN != "" {
-		return strings.ToLower(m.RoleARN)
+		return strings.ToLower(m.RoleARN) + m.sessionKey()
+	}
+	if m.RoleARNRegex != "" {
+		return m.RoleARNRegex
 	}
 	return m.SSOArnLike()
 }
 
+// MatchKey returns the key m is indexed by, so mappings with the same
+// MatchKey are duplicates of each other. Unlike Key, exact ARNs keep the
+// case of their resource, as they are matched with it, so role/Foo and
+// role/foo are different mappings. SessionNameLike mappings ignore case,
+// like their matching does.
+func (m *RoleMapping) MatchKey() string {
+	if m.RoleARN != "" && m.SessionNameLike == "" {
+		return arn.NormalizeCase(m.RoleARN)
+	}
+	return m.Key()
+}
+
+// sessionKey is the suffix SessionNameLike adds to the key of a mapping.
+func (m *RoleMapping) sessionKey() string {
+	if m.SessionNameLike == "" {
+		return ""
+	}
+	return " sessionnamelike=" + m.SessionNameLike
+}
+
+// CanonicalizeARN rewrites an exact RoleARN, such as an sts assumed-role
+// ARN, into the canonical IAM form identities are looked up by. RawMatch and
+// pattern mappings, and ARNs that can't be parsed, are left unchanged.
+func (m *RoleMapping) CanonicalizeARN() {
+	if m.RoleARN == "" || m.RawMatch {
+		return
+	}
+	if canonical, err := arn.Canonicalize(m.RoleARN); err == nil {
+		m.RoleARN = canonical
+	}
+}
+
+// SortRoleMappings orders role mappings so that the first one to match an
+// ARN is the most specific: mappings matched against the raw ARN come
+// first, as they pick out particular sessions of a role, then exact
+// rolearn mappings, then SSO patterns, and rolearnregex mappings last.
+// Within each kind but the last, mappings go from the highest
+// arn.Specificity down, so a rolearn for one partition comes before one
+// for arn.AnyPartition. Remaining ties are broken on Key() so the order is
+// stable.
+func SortRoleMappings(roles []RoleMapping) {
+	sort.SliceStable(roles, func(i, j int) bool {
+		a, b := roles[i].Key(), roles[j].Key()
+		if rankA, rankB := roles[i].rank(), roles[j].rank(); rankA != rankB {
+			return rankA < rankB
+		}
+		if roles[i].RoleARNRegex != "" {
+			return a < b
+		}
+		if scoreA, scoreB := arn.Specificity(a), arn.Specificity(b); scoreA != scoreB {
+			return scoreA > scoreB
+		}
+		return a < b
+	})
+}
+
+// rank orders the kinds of RoleMapping for SortRoleMappings.
+func (m *RoleMapping) rank() int {
+	switch {
+	case m.MatchesRawARN():
+		return 0
+	case m.RoleARN != "":
+		return 1
+	case m.SSO != nil:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// Validate returns an error if the RoleTagMapping is not valid after being unmarshaled
+func (m *RoleTagMapping) Validate() error {
+	if m == nil {
+		return fmt.Errorf("RoleTagMapping is nil")
+	}
+
+	if m.TagKey == "" {
+		return fmt.Errorf("Value for tagkey must be supplied")
+	}
+
+	// a tag mapping matches roles by what they are tagged with, like a
+	// pattern mapping
+	if err := checkPatternGroups(m.Groups); err != nil {
+		return err
+	}
+
+	if err := checkGroups(m.Groups, m.AllowNoGroups); err != nil {
+		return err
+	}
+
+	return validateTemplates(m.Username, m.Groups)
+}
+
+// Matches returns true if tags, the tags of a role, include the tag of this
+// RoleTagMapping. Tag keys and values are case sensitive.
+func (m *RoleTagMapping) Matches(tags map[string]string) bool {
+	value, ok := tags[m.TagKey]
+	return ok && value == m.TagValue
+}
+
+// Key returns TagKey=TagValue.
+// Used to get a Key name for map[string]RoleTagMapping
+func (m *RoleTagMapping) Key() string {
+	return m.TagKey + "=" + m.TagValue
+}
+
 // Validate returns an error if the UserMapping is not valid after being unmarshaled
 func (m *UserMapping) Validate() error {
 	if m == nil {
 		return fmt.Errorf("UserMapping is nil")
 	}
 
-	if m.UserARN == "" {
-		return fmt.Errorf("Value for userarn must be supplied")
+	if m.UserARN == "" && m.UserARNRegex == "" {
+		return fmt.Errorf("Value for userarn or userarnregex must be supplied")
+	} else if m.UserARN != "" && m.UserARNRegex != "" {
+		return fmt.Errorf("Only one of userarn or userarnregex can be supplied")
 	}
 
-	return nil
+	if m.UserARNRegex != "" {
+		if m.RawMatch {
+			return fmt.Errorf("rawmatch can only be used with userarn")
+		}
+		if _, err := compileARNRegex(m.UserARNRegex); err != nil {
+			return fmt.Errorf("userarnregex '%s' is not valid: %v", m.UserARNRegex, err)
+		}
+		if err := checkPatternGroups(m.Groups); err != nil {
+			return err
+		}
+	}
+
+	if err := checkGroups(m.Groups, m.AllowNoGroups); err != nil {
+		return err
+	}
+
+	return validateTemplates(m.Username, m.Groups)
 }
 
 // Matches returns true if the supplied ARN string matche this UserMapping
 func (m *UserMapping) Matches(subject string) bool {
-	return strings.ToLower(m.UserARN) == strings.ToLower(subject)
+	return m.MatchesCompiled(subject, nil)
+}
+
+// CompileRegex compiles the UserARNRegex of a mapping for use with
+// MatchesCompiled. It returns nil for exact mappings.
+func (m *UserMapping) CompileRegex() (*regexp.Regexp, error) {
+	if m.UserARNRegex == "" {
+		return nil, nil
+	}
+	return compileARNRegex(m.UserARNRegex)
 }
 
-// Key returns UserARN.
+// MatchesCompiled is like Matches, but uses re as the result of
+// CompileRegex instead of compiling UserARNRegex on every call. A nil re
+// falls back to compiling it.
+func (m *UserMapping) MatchesCompiled(subject string, re *regexp.Regexp) bool {
+	if m.UserARNRegex != "" {
+		return matchARNRegex(re, m.UserARNRegex, subject)
+	}
+	return arn.MatchesExact(arn.NormalizeCase(m.UserARN), arn.NormalizeCase(subject))
+}
+
+// CanonicalizeARN rewrites an exact UserARN into the canonical IAM form
+// identities are looked up by. RawMatch and pattern mappings, and ARNs that
+// can't be parsed, are left unchanged.
+func (m *UserMapping) CanonicalizeARN() {
+	if m.UserARN == "" || m.RawMatch {
+		return
+	}
+	if canonical, err := arn.Canonicalize(m.UserARN); err == nil {
+		m.UserARN = canonical
+	}
+}
+
+// Key returns UserARN or UserARNRegex, whichever is not empty.
 // Used to get a Key name for map[string]UserMapping
 func (m *UserMapping) Key() string {
+	if m.UserARNRegex != "" {
+		return m.UserARNRegex
+	}
 	return m.UserARN
 }
+
+// MatchKey returns the key m is indexed by, see RoleMapping.MatchKey.
+func (m *UserMapping) MatchKey() string {
+	if m.UserARN != "" {
+		return arn.NormalizeCase(m.UserARN)
+	}
+	return m.Key()
+}
@@ -1,8 +1,14 @@
 package config
 
 import (
+	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/arn"
 )
 
 func init() {
@@ -77,6 +83,104 @@ func TestSSORoleMapping(t *testing.T) {
 	}
 }
 
+func TestPartitions(t *testing.T) {
+	partitions := []string{"aws", "aws-cn", "aws-us-gov"}
+	for _, partition := range partitions {
+		t.Run(partition, func(t *testing.T) {
+			roleARN := "arn:" + partition + ":iam::012345678912:role/Foo"
+			// mappers lowercase the canonical ARN before matching SSO mappings
+			ssoRoleARN := "arn:" + partition + ":iam::012345678912:role/awsreservedsso_viewonlyaccess_0123456789abcdef"
+
+			exact := RoleMapping{RoleARN: roleARN, Username: "foo", Groups: []string{"foo"}}
+			if err := exact.Validate(); err != nil {
+				t.Errorf("unexpected error validating %s: %v", roleARN, err)
+			}
+			if !exact.Matches(roleARN) {
+				t.Errorf("expected %s to match itself", roleARN)
+			}
+
+			sso := RoleMapping{
+				SSO: &SSOARNMatcher{
+					PermissionSetName: "ViewOnlyAccess",
+					AccountID:         "012345678912",
+					Partition:         partition,
+				},
+				Username: "sso",
+				Groups:   []string{"sso"},
+			}
+			if err := sso.Validate(); err != nil {
+				t.Errorf("unexpected error validating SSO mapping: %v", err)
+			}
+			if !sso.Matches(ssoRoleARN) {
+				t.Errorf("expected SSO mapping in %s to match %s", partition, ssoRoleARN)
+			}
+
+			regex := RoleMapping{RoleARNRegex: "arn:" + partition + ":iam::012345678912:role/f.*", Username: "regex", Groups: []string{"regex"}}
+			if err := regex.Validate(); err != nil {
+				t.Errorf("unexpected error validating regex mapping: %v", err)
+			}
+			if !regex.Matches(roleARN) {
+				t.Errorf("expected regex mapping to match %s", roleARN)
+			}
+
+			for _, other := range partitions {
+				if other == partition {
+					continue
+				}
+				otherRoleARN := "arn:" + other + ":iam::012345678912:role/Foo"
+				otherSSORoleARN := "arn:" + other + ":iam::012345678912:role/awsreservedsso_viewonlyaccess_0123456789abcdef"
+				if exact.Matches(otherRoleARN) || regex.Matches(otherRoleARN) {
+					t.Errorf("expected %s mappings to not match %s", partition, otherRoleARN)
+				}
+				if sso.Matches(otherSSORoleARN) {
+					t.Errorf("expected %s SSO mapping to not match %s", partition, otherSSORoleARN)
+				}
+			}
+
+			assumed := RoleMapping{RoleARN: "arn:" + partition + ":sts::012345678912:assumed-role/Foo/session", Username: "foo", Groups: []string{"foo"}}
+			assumed.CanonicalizeARN()
+			if assumed.RoleARN != roleARN {
+				t.Errorf("expected canonical ARN %s, got %s", roleARN, assumed.RoleARN)
+			}
+
+			user := UserMapping{UserARN: "arn:" + partition + ":iam::012345678912:user/Bar", Username: "bar", Groups: []string{"bar"}}
+			if err := user.Validate(); err != nil {
+				t.Errorf("unexpected error validating user mapping: %v", err)
+			}
+			if !user.Matches("arn:" + partition + ":iam::012345678912:user/Bar") {
+				t.Errorf("expected user mapping to match in %s", partition)
+			}
+		})
+	}
+
+	defaultPartition := RoleMapping{SSO: &SSOARNMatcher{PermissionSetName: "ViewOnlyAccess", AccountID: "012345678912"}}
+	if !strings.HasPrefix(defaultPartition.SSOArnLike(), "arn:aws:") {
+		t.Errorf("expected an empty partition to default to aws, got %s", defaultPartition.SSOArnLike())
+	}
+}
+
+func TestSSORoleMappingPermissionSet(t *testing.T) {
+	admin := RoleMapping{SSO: &SSOARNMatcher{PermissionSetName: "Admin", AccountID: "012345678912"}}
+	sessions := map[string]bool{
+		"arn:aws:sts::012345678912:assumed-role/AWSReservedSSO_Admin_0123456789abcdef/alice@example.com": true,
+		"arn:aws:sts::012345678912:assumed-role/AWSReservedSSO_Admin_0123456789abcdef/bob@example.com":   true,
+		"arn:aws:sts::012345678912:assumed-role/AWSReservedSSO_Admin_fedcba9876543210/alice@example.com": true,
+		// a different permission set whose name starts with Admin_
+		"arn:aws:sts::012345678912:assumed-role/AWSReservedSSO_Admin_ReadOnly_0123456789abcdef/alice@example.com": false,
+		"arn:aws:sts::012345678912:assumed-role/AWSReservedSSO_Admin_/alice@example.com":                          false,
+	}
+	for session, expected := range sessions {
+		canonical, err := arn.Canonicalize(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// mappers lowercase the canonical ARN before matching SSO mappings
+		if admin.Matches(strings.ToLower(canonical)) != expected {
+			t.Errorf("expected the Admin SSO mapping matching %s to be %t", session, expected)
+		}
+	}
+}
+
 func TestRoleARNMapping(t *testing.T) {
 	rm := RoleMapping{
 		RoleARN:  "arn:aws:iam::012345678912:role/KubeAdmin",
@@ -103,6 +207,10 @@ func TestRoleARNMapping(t *testing.T) {
 		t.Errorf("RoleMapping %v unexpectedly matched %s", rm, unexpectedMatch)
 	}
 
+	if rm.Matches("arn:aws:iam::012345678912:role/kubeadmin") {
+		t.Errorf("RoleMapping %v unexpectedly matched role/kubeadmin", rm)
+	}
+
 	err := rm.Validate()
 	if err != nil {
 		t.Errorf("Received error %v validating RoleMapping %v", err, rm)
@@ -132,13 +240,19 @@ func TestUserARNMapping(t *testing.T) {
 		t.Errorf("UserMapping.Key() does not match expected value.\nActual:   %v\nExpected: %v", actualKey, expectedKey)
 	}
 
-	expectedMatch := "arn:aws:iam::012345678912:user/shanice"
+	expectedMatch := "arn:AWS:iam::012345678912:user/Shanice"
 	matches := um.Matches(expectedMatch)
 	if !matches {
 		t.Errorf("UserMapping %v did not match %s", um, expectedMatch)
 	}
 
-	unexpectedMatch := "arn:aws:iam::012345678912:user/notShanice"
+	// the resource is case sensitive, as in IAM
+	unexpectedMatch := "arn:aws:iam::012345678912:user/shanice"
+	if um.Matches(unexpectedMatch) {
+		t.Errorf("UserMapping %v unexpectedly matched %s", um, unexpectedMatch)
+	}
+
+	unexpectedMatch = "arn:aws:iam::012345678912:user/notShanice"
 	matches = um.Matches(unexpectedMatch)
 	if matches {
 		t.Errorf("UserMapping %v unexpectedly matched %s", um, unexpectedMatch)
@@ -157,3 +271,315 @@ func TestUserARNMapping(t *testing.T) {
 		t.Errorf("Invalid UserMapping %v did not raise error when validated", invalidUserMapping)
 	}
 }
+
+func TestRawMatchValidation(t *testing.T) {
+	rm := RoleMapping{
+		RoleARN:  "arn:aws:sts::012345678912:assumed-role/Admin/break-glass",
+		Username: "admin",
+		Groups:   []string{"system:masters"},
+		RawMatch: true,
+	}
+	if err := rm.Validate(); err != nil {
+		t.Errorf("Received error %v validating RoleMapping %v", err, rm)
+	}
+
+	rm = RoleMapping{
+		SSO: &SSOARNMatcher{
+			PermissionSetName: "ViewOnlyAccess",
+			AccountID:         "012345678912",
+		},
+		Username: "admin",
+		Groups:   []string{"system:masters"},
+		RawMatch: true,
+	}
+	if err := rm.Validate(); err == nil {
+		t.Errorf("RoleMapping %v with rawmatch and SSO did not raise error when validated", rm)
+	}
+}
+
+func TestSessionNameLike(t *testing.T) {
+	rm := RoleMapping{
+		RoleARN:         "arn:aws:iam::012345678912:role/Admin",
+		SessionNameLike: "break-glass-*",
+		Username:        "admin",
+		Groups:          []string{"system:masters"},
+	}
+	if err := rm.Validate(); err != nil {
+		t.Fatalf("Received error %v validating RoleMapping %v", err, rm)
+	}
+
+	cases := map[string]bool{
+		"arn:aws:sts::012345678912:assumed-role/Admin/break-glass-alice": true,
+		"arn:aws:sts::012345678912:assumed-role/admin/Break-Glass-Bob":   true,
+		"arn:aws:sts::012345678912:assumed-role/Admin/alice":             false,
+		"arn:aws:sts::012345678912:assumed-role/Other/break-glass-alice": false,
+		"arn:aws:sts::444455556666:assumed-role/Admin/break-glass-alice": false,
+		// the canonical role ARN carries no session to check
+		"arn:aws:iam::012345678912:role/Admin": false,
+	}
+	for subject, expected := range cases {
+		if rm.Matches(subject) != expected {
+			t.Errorf("expected Matches(%s) to be %v", subject, expected)
+		}
+	}
+	if !rm.MatchesRawARN() {
+		t.Errorf("expected a SessionNameLike mapping to be matched against the raw ARN")
+	}
+
+	invalid := []RoleMapping{
+		{RoleARNRegex: "arn:aws:iam::012345678912:role/.*", SessionNameLike: "alice", Username: "admin", Groups: []string{"admins"}},
+		{SSO: &SSOARNMatcher{PermissionSetName: "Admin", AccountID: "012345678912"}, SessionNameLike: "alice", Username: "admin", Groups: []string{"admins"}},
+		{RoleARN: "arn:aws:sts::012345678912:assumed-role/Admin/alice", RawMatch: true, SessionNameLike: "alice", Username: "admin", Groups: []string{"admins"}},
+		{RoleARN: "arn:aws:iam::012345678912:role/Admin", SessionNameLike: "alice/bob", Username: "admin", Groups: []string{"admins"}},
+		{RoleARN: "arn:aws:iam::012345678912:user/Admin", SessionNameLike: "alice", Username: "admin", Groups: []string{"admins"}},
+	}
+	for _, rm := range invalid {
+		if err := rm.Validate(); err == nil {
+			t.Errorf("RoleMapping %+v with an invalid sessionnamelike did not raise error when validated", rm)
+		}
+	}
+}
+
+func TestPatternMappingDeniedGroups(t *testing.T) {
+	PatternMappingDeniedGroups = []string{"system:masters"}
+	defer func() { PatternMappingDeniedGroups = nil }()
+
+	rm := RoleMapping{
+		SSO: &SSOARNMatcher{
+			PermissionSetName: "AdministratorAccess",
+			AccountID:         "012345678912",
+		},
+		Username: "admin",
+		Groups:   []string{"system:masters"},
+	}
+	if err := rm.Validate(); err == nil {
+		t.Errorf("RoleMapping %v granting a denied group by pattern did not raise error when validated", rm)
+	}
+
+	rm.Groups = []string{"viewers"}
+	if err := rm.Validate(); err != nil {
+		t.Errorf("Received error %v validating RoleMapping %v", err, rm)
+	}
+
+	rm = RoleMapping{
+		RoleARN:  "arn:aws:iam::012345678912:role/Admin",
+		Username: "admin",
+		Groups:   []string{"system:masters"},
+	}
+	if err := rm.Validate(); err != nil {
+		t.Errorf("Received error %v validating RoleMapping %v", err, rm)
+	}
+}
+
+func TestSortRoleMappings(t *testing.T) {
+	roles := []RoleMapping{
+		{SSO: &SSOARNMatcher{PermissionSetName: "Admin", AccountID: "012345678912"}},
+		{SSO: &SSOARNMatcher{PermissionSetName: "Admin_ReadOnly", AccountID: "012345678912"}},
+		{RoleARN: "arn:aws:iam::012345678912:role/b"},
+		{SSO: &SSOARNMatcher{PermissionSetName: "Dev", AccountID: "012345678912"}},
+		{RoleARN: "arn:aws:iam::012345678912:role/a"},
+	}
+	SortRoleMappings(roles)
+
+	expected := []string{
+		"arn:aws:iam::012345678912:role/a",
+		"arn:aws:iam::012345678912:role/b",
+		"arn:aws:iam::012345678912:role/awsreservedsso_admin_readonly_*",
+		"arn:aws:iam::012345678912:role/awsreservedsso_admin_*",
+		"arn:aws:iam::012345678912:role/awsreservedsso_dev_*",
+	}
+	for i, role := range roles {
+		if role.Key() != expected[i] {
+			t.Errorf("position %d: expected %s, got %s", i, expected[i], role.Key())
+		}
+	}
+}
+
+func TestRoleARNRegexMapping(t *testing.T) {
+	rm := RoleMapping{
+		RoleARNRegex: `arn:aws:iam::012345678912:role/(dev|test)-team-[0-9]{2}`,
+		Username:     "team",
+		Groups:       []string{"developers"},
+	}
+	if err := rm.Validate(); err != nil {
+		t.Fatalf("Received error %v validating RoleMapping %v", err, rm)
+	}
+	re, err := rm.CompileRegex()
+	if err != nil {
+		t.Fatalf("Could not compile the regex of RoleMapping %v: %v", rm, err)
+	}
+
+	for _, subject := range []string{
+		"arn:aws:iam::012345678912:role/dev-team-01",
+		"arn:aws:iam::012345678912:role/test-team-42",
+		"arn:aws:iam::012345678912:role/Dev-Team-07",
+	} {
+		if !rm.Matches(subject) || !rm.MatchesCompiled(subject, nil, re) {
+			t.Errorf("RoleMapping %v did not match %s", rm, subject)
+		}
+	}
+	for _, subject := range []string{
+		"arn:aws:iam::012345678912:role/prod-team-01",
+		"arn:aws:iam::012345678912:role/dev-team-001",
+		"arn:aws:iam::012345678912:role/dev-team-01-admin",
+		"arn:aws:iam::111122223333:role/dev-team-01",
+	} {
+		if rm.Matches(subject) || rm.MatchesCompiled(subject, nil, re) {
+			t.Errorf("RoleMapping %v unexpectedly matched near miss %s", rm, subject)
+		}
+	}
+
+	rm.RoleARN = "arn:aws:iam::012345678912:role/dev-team-01"
+	if err := rm.Validate(); err == nil {
+		t.Errorf("RoleMapping %v with rolearn and rolearnregex did not raise error when validated", rm)
+	}
+
+	rm = RoleMapping{RoleARNRegex: `arn:aws:iam::012345678912:role/(dev`, Username: "team"}
+	if err := rm.Validate(); err == nil {
+		t.Errorf("RoleMapping %v with an invalid rolearnregex did not raise error when validated", rm)
+	}
+}
+
+func TestUserARNRegexMapping(t *testing.T) {
+	um := UserMapping{
+		UserARNRegex: `arn:aws:iam::012345678912:user/ci-[0-9]+`,
+		Username:     "ci",
+		Groups:       []string{"ci"},
+	}
+	if err := um.Validate(); err != nil {
+		t.Fatalf("Received error %v validating UserMapping %v", err, um)
+	}
+	if !um.Matches("arn:aws:iam::012345678912:user/ci-123") {
+		t.Errorf("UserMapping %v did not match ci-123", um)
+	}
+	if um.Matches("arn:aws:iam::012345678912:user/ci-bot") {
+		t.Errorf("UserMapping %v unexpectedly matched ci-bot", um)
+	}
+
+	um.UserARN = "arn:aws:iam::012345678912:user/ci-123"
+	if err := um.Validate(); err == nil {
+		t.Errorf("UserMapping %v with userarn and userarnregex did not raise error when validated", um)
+	}
+}
+
+func TestTemplateValidation(t *testing.T) {
+	rm := RoleMapping{
+		RoleARN:  "arn:aws:iam::012345678912:role/KubernetesNode",
+		Username: "aws:{{AccountID}}:instance:{{SessionName}}",
+		Groups:   []string{"system:bootstrappers", "account:{{AccountID}}"},
+	}
+	if err := rm.Validate(); err != nil {
+		t.Errorf("Received error %v validating RoleMapping %v", err, rm)
+	}
+
+	rm.Username = "{{Session}}"
+	if err := rm.Validate(); err == nil {
+		t.Errorf("RoleMapping %v with an unknown username variable did not raise error when validated", rm)
+	}
+
+	um := UserMapping{
+		UserARN:  "arn:aws:iam::012345678912:user/alice",
+		Username: "alice",
+		Groups:   []string{"{{Unknown}}"},
+	}
+	if err := um.Validate(); err == nil {
+		t.Errorf("UserMapping %v with an unknown group variable did not raise error when validated", um)
+	}
+
+	for _, username := range []string{"{{SessionName}", "{SessionName}}", "{{SessionName", "node-{{AccountID}}}}", "{{{{SessionName}}"} {
+		rm.Username = username
+		if err := rm.Validate(); err == nil {
+			t.Errorf("RoleMapping %v with unbalanced braces did not raise error when validated", rm)
+		}
+	}
+}
+
+func TestRequireMappingGroups(t *testing.T) {
+	RequireMappingGroups = true
+	defer func() { RequireMappingGroups = false }()
+
+	cases := []struct {
+		name          string
+		groups        []string
+		allowNoGroups bool
+		wantErr       bool
+	}{
+		{name: "nil groups", groups: nil, wantErr: true},
+		{name: "empty groups", groups: []string{}, wantErr: true},
+		{name: "empty groups allowed", groups: []string{}, allowNoGroups: true},
+		{name: "single group", groups: []string{"system:masters"}},
+		{name: "multiple groups", groups: []string{"system:nodes", "system:bootstrappers"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rm := RoleMapping{
+				RoleARN:       "arn:aws:iam::012345678912:role/test",
+				Username:      "test",
+				Groups:        c.groups,
+				AllowNoGroups: c.allowNoGroups,
+			}
+			if err := rm.Validate(); (err != nil) != c.wantErr {
+				t.Errorf("RoleMapping %v: wantErr %v, got %v", rm, c.wantErr, err)
+			}
+
+			um := UserMapping{
+				UserARN:       "arn:aws:iam::012345678912:user/test",
+				Username:      "test",
+				Groups:        c.groups,
+				AllowNoGroups: c.allowNoGroups,
+			}
+			if err := um.Validate(); (err != nil) != c.wantErr {
+				t.Errorf("UserMapping %v: wantErr %v, got %v", um, c.wantErr, err)
+			}
+		})
+	}
+
+	RequireMappingGroups = false
+	rm := RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/test", Username: "test"}
+	if err := rm.Validate(); err != nil {
+		t.Errorf("Received error %v validating RoleMapping %v without RequireMappingGroups", err, rm)
+	}
+}
+
+func TestIdentityMappingMarshal(t *testing.T) {
+	mapping := IdentityMapping{
+		IdentityARN: "arn:aws:iam::012345678912:role/admin",
+		Username:    "admin",
+		Groups:      []string{"system:masters"},
+		MatchSource: MatchSource{Mapper: "EKSConfigMap", Lookup: "role"},
+	}
+	b, err := json.Marshal(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"identityARN":"arn:aws:iam::012345678912:role/admin","username":"admin","groups":["system:masters"],"matchSource":{"mapper":"EKSConfigMap","lookup":"role"}}`
+	if string(b) != expected {
+		t.Errorf("expected %s, got %s", expected, b)
+	}
+
+	b, err = json.Marshal(&IdentityMapping{Username: "nobody"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"identityARN":"","username":"nobody","groups":[],"matchSource":{}}`
+	if string(b) != expected {
+		t.Errorf("expected nil groups to marshal as an empty list %s, got %s", expected, b)
+	}
+
+	var decoded IdentityMapping
+	if err := json.Unmarshal([]byte(`{"username":"admin","groups":["system:masters"]}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Username != "admin" || !reflect.DeepEqual(decoded.Groups, []string{"system:masters"}) {
+		t.Errorf("unexpected decoded mapping %+v", decoded)
+	}
+
+	b, err = yaml.Marshal(IdentityMapping{Username: "nobody"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "identityARN: \"\"\nusername: nobody\ngroups: []\nmatchSource: {}\n"
+	if string(b) != expected {
+		t.Errorf("expected yaml %q, got %q", expected, b)
+	}
+}
@@ -16,16 +16,36 @@ limitations under the License.
 
 package config
 
+import "time"
+
+// IdentityMapping is the mapping a mapper resolved an identity to. Its JSON
+// field names are stable, and Groups is always marshaled as a list, so it can
+// be emitted to logs and audit pipelines as is.
 type IdentityMapping struct {
-	IdentityARN string
+	IdentityARN string `json:"identityARN" yaml:"identityARN"`
 
 	// Username is the username pattern that this instances assuming this
 	// role will have in Kubernetes.
-	Username string
+	Username string `json:"username" yaml:"username"`
 
 	// Groups is a list of Kubernetes groups this role will authenticate
 	// as (e.g., `system:masters`). Each group name can include placeholders.
-	Groups []string
+	Groups []string `json:"groups" yaml:"groups"`
+
+	// MatchSource records how the mapping was found, for debugging. It is
+	// left empty by mappers that don't report it.
+	MatchSource MatchSource `json:"matchSource" yaml:"matchSource"`
+}
+
+// MatchSource is the mapper and lookup that found an IdentityMapping.
+type MatchSource struct {
+	// Mapper is the Name() of the mapper, such as EKSConfigMap. In a chain
+	// it is the mapper in the chain that found the mapping.
+	Mapper string `json:"mapper,omitempty" yaml:"mapper,omitempty"`
+	// Lookup is the lookup that found the mapping, one of the Lookup
+	// constants of the mapper package such as "role" or "rolePattern", or
+	// empty if the mapper doesn't tell its lookups apart.
+	Lookup string `json:"lookup,omitempty" yaml:"lookup,omitempty"`
 }
 
 // RoleMapping is a mapping of an AWS Role ARN to a Kubernetes username and a
@@ -44,12 +64,18 @@ type IdentityMapping struct {
 // You can use plain values without parameters to have a more static mapping.
 type RoleMapping struct {
 	// RoleARN is the AWS Resource Name of the role. (e.g., "arn:aws:iam::000000000000:role/Foo").
+	// Unless RawMatch is set, an sts assumed-role ARN is canonicalized to its
+	// role and matches every session of it.
 	RoleARN string `json:"rolearn,omitempty" yaml:"rolearn,omitempty"`
 
 	// SSO contains fields used to match Role ARNs that
 	// are generated for AWS SSO sessions.
 	SSO *SSOARNMatcher `json:"sso,omitempty" yaml:"sso,omitempty"`
 
+	// RoleARNRegex is a regular expression matched against the whole
+	// canonicalized role ARN, ignoring case. (e.g., "arn:aws:iam::000000000000:role/(dev|test)-[0-9]+").
+	RoleARNRegex string `json:"rolearnregex,omitempty" yaml:"rolearnregex,omitempty"`
+
 	// Username is the username pattern that this instances assuming this
 	// role will have in Kubernetes.
 	Username string `json:"username" yaml:"username"`
@@ -60,6 +86,24 @@ type RoleMapping struct {
 
 	// UserId is the AWS PrincipalId of the role. (e.g., "ABCXSOTJDDV").
 	UserId string `json:"userid,omitempty" yaml:"userid,omitempty"`
+
+	// RawMatch matches RoleARN against the ARN presented by the caller
+	// rather than its canonicalized form. Only valid with RoleARN.
+	RawMatch bool `json:"rawmatch,omitempty" yaml:"rawmatch,omitempty"`
+
+	// SessionNameLike restricts a RoleARN mapping to sts assumed-role
+	// sessions of the role whose session name matches this glob, using the
+	// * and ? wildcards and ignoring case. (e.g., "break-glass-*").
+	SessionNameLike string `json:"sessionnamelike,omitempty" yaml:"sessionnamelike,omitempty"`
+
+	// AllowNoGroups allows this mapping to have no groups when
+	// RequireMappingGroups is set.
+	AllowNoGroups bool `json:"allownogroups,omitempty" yaml:"allownogroups,omitempty"`
+
+	// Annotations is free-form metadata about the mapping, such as the
+	// owning team or a ticket link. It doesn't affect matching, and unlike
+	// a YAML comment it survives the configmap being re-encoded.
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
 }
 
 // UserMapping is a static mapping of a single AWS User ARN to a
@@ -68,6 +112,10 @@ type UserMapping struct {
 	// UserARN is the AWS Resource Name of the user. (e.g., "arn:aws:iam::000000000000:user/Test").
 	UserARN string `json:"userarn" yaml:"userarn"`
 
+	// UserARNRegex is a regular expression matched against the whole user
+	// ARN, ignoring case. (e.g., "arn:aws:iam::000000000000:user/ci-[0-9]+").
+	UserARNRegex string `json:"userarnregex,omitempty" yaml:"userarnregex,omitempty"`
+
 	// Username is the Kubernetes username this role will authenticate as (e.g., `mycorp:foo`)
 	Username string `json:"username" yaml:"username"`
 
@@ -76,6 +124,46 @@ type UserMapping struct {
 
 	// UserId is the AWS PrincipalId of the user. (e.g., "ABCXSOTJDDV").
 	UserId string `json:"userid,omitempty" yaml:"userid,omitempty"`
+
+	// RawMatch matches UserARN against the ARN presented by the caller
+	// rather than its canonicalized form.
+	RawMatch bool `json:"rawmatch,omitempty" yaml:"rawmatch,omitempty"`
+
+	// AllowNoGroups allows this mapping to have no groups when
+	// RequireMappingGroups is set.
+	AllowNoGroups bool `json:"allownogroups,omitempty" yaml:"allownogroups,omitempty"`
+
+	// Annotations is free-form metadata about the mapping, such as the
+	// owning team or a ticket link. It doesn't affect matching, and unlike
+	// a YAML comment it survives the configmap being re-encoded.
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+}
+
+// RoleTagMapping maps every IAM role carrying a tag to a Kubernetes
+// username and a list of Kubernetes groups. The tags of a role are looked
+// up when none of the ARN mappings match it.
+type RoleTagMapping struct {
+	// TagKey is the key of the tag the role must carry (e.g., "team").
+	TagKey string `json:"tagkey" yaml:"tagkey"`
+
+	// TagValue is the value the tag must have (e.g., "payments").
+	TagValue string `json:"tagvalue" yaml:"tagvalue"`
+
+	// Username is the username pattern that this instances assuming a
+	// matching role will have in Kubernetes.
+	Username string `json:"username" yaml:"username"`
+
+	// Groups is a list of Kubernetes groups matching roles will
+	// authenticate as. Each group name can include placeholders.
+	Groups []string `json:"groups" yaml:"groups"`
+
+	// AllowNoGroups allows this mapping to have no groups when
+	// RequireMappingGroups is set.
+	AllowNoGroups bool `json:"allownogroups,omitempty" yaml:"allownogroups,omitempty"`
+
+	// Annotations is free-form metadata about the mapping, like
+	// RoleMapping.Annotations.
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
 }
 
 // SSOARNMatcher contains fields used to match Role ARNs that
@@ -101,6 +189,19 @@ type SSOARNMatcher struct {
 	Partition string `json:"partition,omitempty" yaml:"partition,omitempty"`
 }
 
+// AccountAllowPolicy decides which accounts a mapper reports as allowed.
+type AccountAllowPolicy string
+
+const (
+	// AccountAllowPolicyExplicit only allows the accounts that are listed
+	// explicitly, such as in mapAccounts. It is the default.
+	AccountAllowPolicyExplicit AccountAllowPolicy = "explicit"
+	// AccountAllowPolicyMatchedMapping also allows any account that a role
+	// or user mapping pins its ARN to. Regex mappings don't allow an
+	// account this way.
+	AccountAllowPolicyMatchedMapping AccountAllowPolicy = "matchedMappingImpliesAllowed"
+)
+
 // Config specifies the configuration for a aws-iam-authenticator server
 type Config struct {
 	// PartitionID is the AWS partition tokens are valid in. See
@@ -168,7 +269,7 @@ type Config struct {
 	// +optional
 	Kubeconfig string
 
-	// BackendMode is an ordered list of backends to get mappings from. Comma-delimited list of: MountedFile,EKSConfigMap,CRD,DynamicFile
+	// BackendMode is an ordered list of backends to get mappings from. Comma-delimited list of: MountedFile,EKSConfigMap,CRD,DynamicFile,DynamoDB,Webhook
 	BackendMode []string
 
 	// Ec2 DescribeInstances rate limiting variables initially set to defaults until we completely
@@ -179,6 +280,87 @@ type Config struct {
 	DynamicFilePath string
 	// Use UserId for mapping, IdentityArn is not used any more when DynamicFileUserIDStrict=true
 	DynamicFileUserIDStrict bool
+	// MountedFilePath is the config file the MountedFile BackendMode reloads
+	// its mappings from when it changes. Empty disables reloading.
+	MountedFilePath string
+	// ConfigMapNamespace is the namespace of the auth configmap for EKSConfigMap BackendMode.
+	// Defaults to kube-system.
+	ConfigMapNamespace string
+	// ConfigMapName is the name of the auth configmap for EKSConfigMap BackendMode.
+	// Defaults to aws-auth.
+	ConfigMapName string
+	// ConfigMapStrictParse makes the EKSConfigMap BackendMode ignore a whole
+	// configmap update if any entry in it is invalid.
+	ConfigMapStrictParse bool
+	// ConfigMapCacheSize is the number of EKSConfigMap BackendMode Map results
+	// to cache. Zero disables the cache.
+	ConfigMapCacheSize int
+	// ConfigMapCacheTTL expires cached EKSConfigMap BackendMode Map results.
+	// Zero keeps them until the configmap changes.
+	ConfigMapCacheTTL time.Duration
+	// ConfigMapResyncInterval makes the EKSConfigMap BackendMode reload the
+	// configmap this often even without watch events. Zero disables it.
+	ConfigMapResyncInterval time.Duration
+	// ConfigMapDebounce makes the EKSConfigMap BackendMode coalesce the
+	// configmap updates its watch delivers within this window, loading
+	// only the last of them. Zero loads every update.
+	ConfigMapDebounce time.Duration
+	// ConfigMapAccountAllowPolicy decides which accounts the EKSConfigMap
+	// BackendMode allows. Empty means AccountAllowPolicyExplicit.
+	ConfigMapAccountAllowPolicy AccountAllowPolicy
+	// ConfigMapPatternMatchMetrics makes the EKSConfigMap BackendMode count
+	// the lookups won by each pattern mapping, labelled by pattern. Off by
+	// default, as every pattern adds a metric series.
+	ConfigMapPatternMatchMetrics bool
+	// ConfigMapMaxMappings is the most role and user mappings the
+	// EKSConfigMap BackendMode expects. A configmap with more is logged,
+	// and ignored if ConfigMapStrictParse is set. Zero means no limit.
+	ConfigMapMaxMappings int
+	// ConfigMapIgnorePath makes the EKSConfigMap BackendMode match rolearn
+	// and userarn mappings ignoring the path of the role or user, so
+	// role/team/Admin and role/Admin match each other.
+	ConfigMapIgnorePath bool
+	// ConfigMapRequireAccountAllowed makes the EKSConfigMap BackendMode warn
+	// about role and user mappings whose ARN's account isn't in mapAccounts.
+	ConfigMapRequireAccountAllowed bool
+	// ConfigMapEnforceAccountAllowed makes the EKSConfigMap BackendMode only
+	// resolve mappings for identities whose account it allows, see
+	// ConfigMapAccountAllowPolicy.
+	ConfigMapEnforceAccountAllowed bool
+	// ConfigMapAccountBypassGroups lets mappings that grant any of these
+	// groups resolve despite ConfigMapEnforceAccountAllowed.
+	ConfigMapAccountBypassGroups []string
+	// ConfigMapAllowedGroups, if not empty, are the only groups EKSConfigMap
+	// BackendMode mappings may grant. ConfigMapDisallowedGroups may never
+	// be granted. A configmap section with a mapping granting any other
+	// group is rejected like one with an invalid entry.
+	ConfigMapAllowedGroups    []string
+	ConfigMapDisallowedGroups []string
+	// ConfigMapWarnDisallowedGroups only logs mappings that break
+	// ConfigMapAllowedGroups or ConfigMapDisallowedGroups instead of
+	// rejecting them.
+	ConfigMapWarnDisallowedGroups bool
+	// ConfigMapInformer makes the EKSConfigMap BackendMode follow the
+	// configmap with a client-go shared informer instead of its own watch.
+	ConfigMapInformer bool
+	// DynamoDBTableName is the table the DynamoDB BackendMode reads mappings from.
+	DynamoDBTableName string
+	// DynamoDBRegion is the region of DynamoDBTableName. Empty uses the default region.
+	DynamoDBRegion string
+	// DynamoDBWarmCache makes the DynamoDB BackendMode load its pattern mappings on start.
+	DynamoDBWarmCache bool
+	// WebhookURL is the endpoint the Webhook BackendMode POSTs identities to.
+	WebhookURL string
+	// WebhookTimeout bounds each Webhook BackendMode request. Defaults to 5s.
+	WebhookTimeout time.Duration
+	// WebhookCacheTTL is how long Webhook BackendMode answers are reused. Zero disables caching.
+	WebhookCacheTTL time.Duration
+	// WebhookCAFile verifies the webhook's serving certificate. Empty uses the system roots.
+	WebhookCAFile string
+	// WebhookClientCertFile and WebhookClientKeyFile are an optional client
+	// certificate presented to the webhook.
+	WebhookClientCertFile string
+	WebhookClientKeyFile  string
 	// ReservedPrefixConfig defines reserved username prefixes for each backend
 	ReservedPrefixConfig map[string]ReservedPrefixConfig
 }
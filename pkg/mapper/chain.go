@@ -0,0 +1,111 @@
+package mapper
+
+import (
+	"context"
+	"errors"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+// ModeChain is the name of a ChainMapper. It is not a backend-mode choice.
+const ModeChain string = "Chain"
+
+// ChainMapper combines an ordered list of mappers into a single Mapper.
+// Earlier mappers take precedence over later ones.
+type ChainMapper struct {
+	mappers []Mapper
+}
+
+var _ Mapper = &ChainMapper{}
+var _ Stopper = &ChainMapper{}
+
+// NewChainMapper returns a Mapper that tries each of mappers in order.
+func NewChainMapper(mappers ...Mapper) *ChainMapper {
+	return &ChainMapper{mappers: mappers}
+}
+
+func (m *ChainMapper) Name() string {
+	return ModeChain
+}
+
+// Start starts every mapper in the chain, returning the errors of any that
+// failed to start.
+func (m *ChainMapper) Start(stopCh <-chan struct{}) error {
+	var errs []error
+	for _, child := range m.mappers {
+		if err := child.Start(stopCh); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// Stop stops every mapper in the chain that implements Stopper.
+func (m *ChainMapper) Stop() {
+	for _, child := range m.mappers {
+		if stopper, ok := child.(Stopper); ok {
+			stopper.Stop()
+		}
+	}
+}
+
+// Running returns true if any mapper in the chain that implements Stopper
+// is running.
+func (m *ChainMapper) Running() bool {
+	for _, child := range m.mappers {
+		if stopper, ok := child.(Stopper); ok && stopper.Running() {
+			return true
+		}
+	}
+	return false
+}
+
+// Map returns the result of the first mapper that doesn't return
+// ErrNotMapped, or ErrNotMapped if none of them map identity.
+func (m *ChainMapper) Map(identity *token.Identity) (*config.IdentityMapping, error) {
+	return m.MapContext(context.Background(), identity)
+}
+
+// MapContext is Map, passing ctx on to the mappers in the chain that are
+// ContextMappers. The MatchSource of the mapping names the mapper in the
+// chain that found it.
+func (m *ChainMapper) MapContext(ctx context.Context, identity *token.Identity) (*config.IdentityMapping, error) {
+	for _, child := range m.mappers {
+		mapping, err := MapContext(ctx, child, identity)
+		if errors.Is(err, ErrNotMapped) {
+			continue
+		}
+		if mapping != nil && mapping.MatchSource.Mapper == "" {
+			// copy rather than modify a mapping the child may still hold
+			named := *mapping
+			named.MatchSource.Mapper = child.Name()
+			mapping = &named
+		}
+		return mapping, err
+	}
+	return nil, ErrNotMapped
+}
+
+// IsAccountAllowed returns true if any mapper in the chain allows accountID.
+func (m *ChainMapper) IsAccountAllowed(accountID string) bool {
+	for _, child := range m.mappers {
+		if child.IsAccountAllowed(accountID) {
+			return true
+		}
+	}
+	return false
+}
+
+// UsernamePrefixReserveList returns the reserved prefixes of every mapper in
+// the chain.
+func (m *ChainMapper) UsernamePrefixReserveList() []string {
+	prefixes := sets.NewString()
+	for _, child := range m.mappers {
+		prefixes.Insert(child.UsernamePrefixReserveList()...)
+	}
+	return prefixes.List()
+}
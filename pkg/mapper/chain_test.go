@@ -0,0 +1,156 @@
+package mapper
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+// fakeMapper maps the ARNs in mappings and allows the accounts in accounts.
+type fakeMapper struct {
+	name     string
+	mappings map[string]*config.IdentityMapping
+	accounts map[string]bool
+	prefixes []string
+	err      error
+	started  bool
+}
+
+func (m *fakeMapper) Name() string { return m.name }
+
+func (m *fakeMapper) Start(_ <-chan struct{}) error {
+	m.started = true
+	return nil
+}
+
+func (m *fakeMapper) Map(identity *token.Identity) (*config.IdentityMapping, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if mapping, ok := m.mappings[identity.CanonicalARN]; ok {
+		return mapping, nil
+	}
+	return nil, ErrNotMapped
+}
+
+func (m *fakeMapper) IsAccountAllowed(accountID string) bool { return m.accounts[accountID] }
+
+func (m *fakeMapper) UsernamePrefixReserveList() []string { return m.prefixes }
+
+func TestChainMapper(t *testing.T) {
+	override := &fakeMapper{
+		name: "override",
+		mappings: map[string]*config.IdentityMapping{
+			"arn:aws:iam::012345678912:role/admin": {Username: "override-admin"},
+		},
+		prefixes: []string{"aws:"},
+	}
+	fallback := &fakeMapper{
+		name: "fallback",
+		mappings: map[string]*config.IdentityMapping{
+			"arn:aws:iam::012345678912:role/admin": {Username: "fallback-admin"},
+			"arn:aws:iam::012345678912:role/dev":   {Username: "fallback-dev"},
+		},
+		accounts: map[string]bool{"012345678912": true},
+		prefixes: []string{"amazon:", "aws:"},
+	}
+	chain := NewChainMapper(override, fallback)
+
+	if err := chain.Start(nil); err != nil {
+		t.Fatalf("unexpected error starting chain: %v", err)
+	}
+	if !override.started || !fallback.started {
+		t.Errorf("expected every mapper in the chain to be started")
+	}
+
+	cases := []struct {
+		name     string
+		arn      string
+		expected string
+		// mapper is the mapper expected in the MatchSource
+		mapper string
+		err    error
+	}{
+		{"precedence", "arn:aws:iam::012345678912:role/admin", "override-admin", "override", nil},
+		{"fall through", "arn:aws:iam::012345678912:role/dev", "fallback-dev", "fallback", nil},
+		{"all miss", "arn:aws:iam::012345678912:role/unknown", "", "", ErrNotMapped},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mapping, err := chain.Map(&token.Identity{CanonicalARN: c.arn})
+			if err != c.err {
+				t.Fatalf("expected error %v, got %v", c.err, err)
+			}
+			if err == nil && mapping.Username != c.expected {
+				t.Errorf("expected username %q, got %q", c.expected, mapping.Username)
+			}
+			if err == nil && mapping.MatchSource.Mapper != c.mapper {
+				t.Errorf("expected the mapping to come from %q, got %q", c.mapper, mapping.MatchSource.Mapper)
+			}
+		})
+	}
+
+	if !chain.IsAccountAllowed("012345678912") {
+		t.Errorf("expected account allowed by fallback to be allowed")
+	}
+	if chain.IsAccountAllowed("000000000000") {
+		t.Errorf("expected account allowed by no mapper not to be allowed")
+	}
+	if prefixes := chain.UsernamePrefixReserveList(); !reflect.DeepEqual(prefixes, []string{"amazon:", "aws:"}) {
+		t.Errorf("unexpected reserved prefixes %v", prefixes)
+	}
+}
+
+func TestChainMapperError(t *testing.T) {
+	broken := &fakeMapper{name: "broken", err: errors.New("backend unavailable")}
+	fallback := &fakeMapper{
+		name: "fallback",
+		mappings: map[string]*config.IdentityMapping{
+			"arn:aws:iam::012345678912:role/dev": {Username: "fallback-dev"},
+		},
+	}
+	chain := NewChainMapper(broken, fallback)
+
+	if _, err := chain.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/dev"}); err != broken.err {
+		t.Errorf("expected error from the first mapper to stop the chain, got %v", err)
+	}
+}
+
+// contextMapper is a fakeMapper that records the context it is called with.
+type contextMapper struct {
+	fakeMapper
+	ctx context.Context
+}
+
+func (m *contextMapper) MapContext(ctx context.Context, identity *token.Identity) (*config.IdentityMapping, error) {
+	m.ctx = ctx
+	return m.Map(identity)
+}
+
+func TestChainMapperContext(t *testing.T) {
+	plain := &fakeMapper{name: "plain"}
+	remote := &contextMapper{fakeMapper: fakeMapper{
+		name: "remote",
+		mappings: map[string]*config.IdentityMapping{
+			"arn:aws:iam::012345678912:role/dev": {Username: "remote-dev"},
+		},
+	}}
+	chain := NewChainMapper(plain, remote)
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "request")
+	mapping, err := MapContext(ctx, chain, &token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.Username != "remote-dev" {
+		t.Errorf("expected remote-dev, got %q", mapping.Username)
+	}
+	if remote.ctx != ctx {
+		t.Error("expected the request context to be passed to the ContextMapper")
+	}
+}
@@ -0,0 +1,107 @@
+package configmap
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+)
+
+// mapCache is an LRU cache of Map results, including identities that are
+// not mapped. It is purged whenever the mappings change.
+type mapCache struct {
+	mutex   sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	// lru holds *mapCacheEntry values, most recently used at the front.
+	lru *list.List
+	// generation is bumped by purge so results computed from mappings
+	// that were replaced meanwhile are not stored.
+	generation uint64
+	// now is overridden in tests
+	now func() time.Time
+}
+
+type mapCacheEntry struct {
+	key string
+	// mapping is nil for identities that are not mapped, err is then the
+	// ErrNotMapped that was returned for them.
+	mapping   *config.IdentityMapping
+	err       error
+	matchKind string
+	expires   time.Time
+}
+
+// newMapCache creates a mapCache holding up to size results. A ttl of zero
+// keeps results until they are evicted or the mappings change.
+func newMapCache(size int, ttl time.Duration) *mapCache {
+	return &mapCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// get returns the cached entry for key and the current generation to pass
+// to add on a miss.
+func (c *mapCache) get(key string) (*mapCacheEntry, uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, c.generation
+	}
+	e := elem.Value.(*mapCacheEntry)
+	if !e.expires.IsZero() && !c.now().Before(e.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, c.generation
+	}
+	c.lru.MoveToFront(elem)
+	return e, c.generation
+}
+
+// add stores a result computed during generation, unless the cache has
+// been purged since.
+func (c *mapCache) add(key string, generation uint64, mapping *config.IdentityMapping, matchKind string, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation {
+		return
+	}
+	e := &mapCacheEntry{key: key, matchKind: matchKind, err: err}
+	if mapping != nil {
+		m := *mapping
+		e.mapping = &m
+	}
+	if c.ttl > 0 {
+		e.expires = c.now().Add(c.ttl)
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = e
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*mapCacheEntry).key)
+	}
+}
+
+// purge drops every cached result. It is a no-op on a nil cache.
+func (c *mapCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
@@ -0,0 +1,97 @@
+package configmap
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/metrics"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+func TestMapCache(t *testing.T) {
+	mapperHits := testutil.ToFloat64(metrics.Get().MapCacheHits.WithLabelValues(mapper.ModeEKSConfigMap))
+	mapperMisses := testutil.ToFloat64(metrics.Get().MapCacheMisses.WithLabelValues(mapper.ModeEKSConfigMap))
+
+	ms := &MapStore{cache: newMapCache(10, 0)}
+	ms.saveMap(nil, []config.RoleMapping{testSSORole}, nil)
+	m := &ConfigMapMapper{ms}
+	identity := &token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/AWSReservedSSO_ViewOnlyAccess_0123456789abcdef"}
+	unmapped := &token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/other"}
+
+	for i := 0; i < 3; i++ {
+		mapping, err := m.Map(identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mapping.Username != testSSORole.Username {
+			t.Fatalf("unexpected mapping %+v", mapping)
+		}
+		if _, err := m.Map(unmapped); !errors.Is(err, mapper.ErrNotMapped) {
+			t.Fatalf("expected ErrNotMapped, got %v", err)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.Get().MapCacheMisses.WithLabelValues(mapper.ModeEKSConfigMap)) - mapperMisses; got != 2 {
+		t.Errorf("expected 2 cache misses for the %s mapper, got %v", mapper.ModeEKSConfigMap, got)
+	}
+	if got := testutil.ToFloat64(metrics.Get().MapCacheHits.WithLabelValues(mapper.ModeEKSConfigMap)) - mapperHits; got != 4 {
+		t.Errorf("expected 4 cache hits for the %s mapper, got %v", mapper.ModeEKSConfigMap, got)
+	}
+
+	// a hit must not scan the roles at all
+	ms.current.Store(&mappings{})
+	if _, err := m.Map(identity); err != nil {
+		t.Errorf("expected cached mapping without scanning, got %v", err)
+	}
+
+	// a configmap update busts the cache
+	updated := testSSORole
+	updated.Username = "radio"
+	ms.saveMap(nil, []config.RoleMapping{updated}, nil)
+	mapping, err := m.Map(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.Username != "radio" {
+		t.Errorf("expected updated mapping after saveMap, got %+v", mapping)
+	}
+}
+
+func TestMapCacheEviction(t *testing.T) {
+	c := newMapCache(2, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for _, key := range []string{"a", "b"} {
+		_, generation := c.get(key)
+		c.add(key, generation, &config.IdentityMapping{Username: key}, mapper.MatchKindRole, nil)
+	}
+	// use a so b is the least recently used
+	if e, _ := c.get("a"); e == nil {
+		t.Fatal("expected a to be cached")
+	}
+	_, generation := c.get("c")
+	c.add("c", generation, nil, mapper.MatchKindNone, mapper.ErrNotMapped)
+	if e, _ := c.get("b"); e != nil {
+		t.Error("expected b to be evicted")
+	}
+	if e, _ := c.get("c"); e == nil || e.mapping != nil {
+		t.Errorf("expected c to be cached as not mapped, got %+v", e)
+	}
+
+	now = now.Add(time.Minute)
+	if e, _ := c.get("a"); e != nil {
+		t.Error("expected a to have expired")
+	}
+
+	// results computed before a purge are dropped
+	_, generation = c.get("d")
+	c.purge()
+	c.add("d", generation, &config.IdentityMapping{Username: "d"}, mapper.MatchKindRole, nil)
+	if e, _ := c.get("d"); e != nil {
+		t.Error("expected a stale result to not be cached")
+	}
+}
@@ -3,15 +3,20 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	core_v1 "k8s.io/api/core/v1"
 	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	client_v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/arn"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper/configmap"
 )
@@ -20,13 +25,85 @@ import (
 type Client interface {
 	AddRole(role *config.RoleMapping) (*core_v1.ConfigMap, error)
 	AddUser(user *config.UserMapping) (*core_v1.ConfigMap, error)
+	BatchAdd(roles []config.RoleMapping, users []config.UserMapping, accounts []string) (*core_v1.ConfigMap, error)
+	ApplyConfig(cfg config.Config) (*core_v1.ConfigMap, error)
+	UpsertRole(role *config.RoleMapping) (*core_v1.ConfigMap, error)
+	UpsertUser(user *config.UserMapping) (*core_v1.ConfigMap, error)
+	PreviewAddRole(role *config.RoleMapping) (*Preview, error)
+	PreviewAddUser(user *config.UserMapping) (*Preview, error)
+	RemoveRole(roleARN string) (*core_v1.ConfigMap, error)
+	RemoveUser(userARN string) (*core_v1.ConfigMap, error)
+	RemoveRoleARNRegex(pattern string) (*core_v1.ConfigMap, error)
+	RemoveUserARNRegex(pattern string) (*core_v1.ConfigMap, error)
+	GetRole(roleARN string) (*config.RoleMapping, error)
+	GetUser(userARN string) (*config.UserMapping, error)
+	ListRoles() ([]config.RoleMapping, error)
+	ListUsers() ([]config.UserMapping, error)
+	ListAccounts() ([]string, error)
 }
 
 const mapName = "aws-auth"
 
+var (
+	// ErrMappingNotFound is returned when removing a mapping that is not in the configmap.
+	ErrMappingNotFound = errors.New("mapping not found")
+	// ErrDuplicateMapping is returned when adding a mapping whose ARN is already in the configmap.
+	ErrDuplicateMapping = errors.New("duplicate mapping")
+	// ErrConfigMapNotFound is returned when the aws-auth configmap does not exist.
+	ErrConfigMapNotFound = errors.New("configmap not found")
+)
+
+// DuplicatePolicy decides what ApplyConfig does with a mapping whose ARN is
+// already in the configmap.
+type DuplicatePolicy string
+
+const (
+	// DuplicateReplace replaces the username and groups of the existing
+	// mapping, like kubectl apply. It is the default.
+	DuplicateReplace DuplicatePolicy = "replace"
+	// DuplicateError fails the apply with ErrDuplicateMapping.
+	DuplicateError DuplicatePolicy = "error"
+	// DuplicateMergeGroups replaces the username of the existing mapping and
+	// adds the groups it doesn't have yet.
+	DuplicateMergeGroups DuplicatePolicy = "mergeGroups"
+)
+
+// Option configures optional "Client" behavior.
+type Option func(cli *client, cmi client_v1.ConfigMapInterface)
+
+// CreateIfMissing makes the client create the aws-auth configmap in
+// namespace when it does not exist yet, instead of failing the update.
+func CreateIfMissing(namespace string) Option {
+	return func(cli *client, cmi client_v1.ConfigMapInterface) {
+		cli.namespace = namespace
+		cli.createMap = func(m *core_v1.ConfigMap) (*core_v1.ConfigMap, error) {
+			return cmi.Create(context.TODO(), m, meta_v1.CreateOptions{})
+		}
+	}
+}
+
+// UsePatch makes the client write changes with a strategic merge patch of
+// only the mapping keys that changed, instead of updating the whole
+// configmap. The patch carries the resourceVersion that was read, so
+// concurrent writers still surface as conflicts and are retried.
+func UsePatch() Option {
+	return func(cli *client, cmi client_v1.ConfigMapInterface) {
+		cli.patchMap = func(patch []byte) (*core_v1.ConfigMap, error) {
+			return cmi.Patch(context.TODO(), mapName, types.StrategicMergePatchType, patch, meta_v1.PatchOptions{})
+		}
+	}
+}
+
+// ApplyDuplicatePolicy sets the DuplicatePolicy of ApplyConfig.
+func ApplyDuplicatePolicy(policy DuplicatePolicy) Option {
+	return func(cli *client, cmi client_v1.ConfigMapInterface) {
+		cli.duplicates = policy
+	}
+}
+
 // New creates a new "Client".
-func New(cli client_v1.ConfigMapInterface) Client {
-	return &client{
+func New(cli client_v1.ConfigMapInterface, opts ...Option) Client {
+	c := &client{
 		getMap: func() (*core_v1.ConfigMap, error) {
 			return cli.Get(context.TODO(), mapName, meta_v1.GetOptions{})
 		},
@@ -35,12 +112,24 @@ func New(cli client_v1.ConfigMapInterface) Client {
 			return cm, err
 		},
 	}
+	for _, opt := range opts {
+		opt(c, cli)
+	}
+	return c
 }
 
 type client struct {
 	// define as function types for testing
 	getMap    func() (*core_v1.ConfigMap, error)
 	updateMap func(m *core_v1.ConfigMap) (cm *core_v1.ConfigMap, err error)
+	// createMap is nil unless CreateIfMissing is set
+	createMap func(m *core_v1.ConfigMap) (cm *core_v1.ConfigMap, err error)
+	namespace string
+	// patchMap is nil unless UsePatch is set
+	patchMap func(patch []byte) (cm *core_v1.ConfigMap, err error)
+	// duplicates is the DuplicatePolicy of ApplyConfig, DuplicateReplace if
+	// empty
+	duplicates DuplicatePolicy
 }
 
 func (cli *client) AddRole(role *config.RoleMapping) (*core_v1.ConfigMap, error) {
@@ -57,61 +146,531 @@ func (cli *client) AddUser(user *config.UserMapping) (*core_v1.ConfigMap, error)
 	return cli.add(nil, user)
 }
 
-func (cli *client) add(role *config.RoleMapping, user *config.UserMapping) (cm *core_v1.ConfigMap, err error) {
-	if role == nil && user == nil {
-		return nil, errors.New("empty role/user")
+// BatchAdd adds all of roles, users and accounts in a single configmap
+// update. If any of them is invalid or already present nothing is written and
+// the returned error aggregates every failure.
+func (cli *client) BatchAdd(roles []config.RoleMapping, users []config.UserMapping, accounts []string) (*core_v1.ConfigMap, error) {
+	if len(roles) == 0 && len(users) == 0 && len(accounts) == 0 {
+		return nil, errors.New("empty batch")
 	}
-	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		cm, err = cli.getMap()
-		if err != nil {
-			if k8s_errors.IsNotFound(err) {
-				logrus.WithError(err).Warn("not found map " + mapName)
+	var errs []error
+	canonicalRoles := make([]config.RoleMapping, 0, len(roles))
+	for i := range roles {
+		if err := roles[i].Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("role %q is invalid: %v", roles[i].Key(), err))
+			continue
+		}
+		canonicalRoles = append(canonicalRoles, *canonicalRole(&roles[i]))
+	}
+	canonicalUsers := make([]config.UserMapping, 0, len(users))
+	for i := range users {
+		if err := users[i].Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("user %q is invalid: %v", users[i].Key(), err))
+			continue
+		}
+		canonicalUsers = append(canonicalUsers, *canonicalUser(&users[i]))
+	}
+	for _, a := range accounts {
+		if err := config.ValidateAccount(a); err != nil {
+			errs = append(errs, fmt.Errorf("account is invalid: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, utilerrors.NewAggregate(errs)
+	}
+	roles, users = canonicalRoles, canonicalUsers
+	return cli.modify(func(userMappings []config.UserMapping, roleMappings []config.RoleMapping, awsAccounts []string) ([]config.UserMapping, []config.RoleMapping, []string, error) {
+		var errs []error
+		roleKeys := make(map[string]bool, len(roleMappings)+len(roles))
+		for _, r := range roleMappings {
+			roleKeys[r.MatchKey()] = true
+		}
+		for _, r := range roles {
+			if roleKeys[r.MatchKey()] {
+				errs = append(errs, fmt.Errorf("%w: cannot add duplicate role ARN %q", ErrDuplicateMapping, r.Key()))
+				continue
 			}
-			return err
+			roleKeys[r.MatchKey()] = true
+			roleMappings = append(roleMappings, r)
 		}
 
-		data := cm.Data
+		userKeys := make(map[string]bool, len(userMappings)+len(users))
+		for _, u := range userMappings {
+			userKeys[u.MatchKey()] = true
+		}
+		for _, u := range users {
+			if userKeys[u.MatchKey()] {
+				errs = append(errs, fmt.Errorf("%w: cannot add duplicate user ARN %q", ErrDuplicateMapping, u.Key()))
+				continue
+			}
+			userKeys[u.MatchKey()] = true
+			userMappings = append(userMappings, u)
+		}
 
-		userMappings, roleMappings, awsAccounts, err := configmap.ParseMap(data)
-		if err != nil {
-			return fmt.Errorf("failed to parse configmap %v", err)
+		accountKeys := make(map[string]bool, len(awsAccounts)+len(accounts))
+		for _, a := range awsAccounts {
+			accountKeys[a] = true
+		}
+		for _, a := range accounts {
+			if accountKeys[a] {
+				errs = append(errs, fmt.Errorf("%w: cannot add duplicate account %q", ErrDuplicateMapping, a))
+				continue
+			}
+			accountKeys[a] = true
+			awsAccounts = append(awsAccounts, a)
 		}
 
-		if role != nil {
-			err = role.Validate()
-			if err != nil {
-				return fmt.Errorf("role is invalid: %v", err)
+		if len(errs) > 0 {
+			return nil, nil, nil, utilerrors.NewAggregate(errs)
+		}
+		return userMappings, roleMappings, awsAccounts, nil
+	})
+}
+
+// ApplyConfig merges the role, user and account mappings of cfg into the
+// configmap in a single update. Mappings whose ARN is already present are
+// handled by the client's DuplicatePolicy, accounts already present are
+// left as they are. If cfg does not pass Config.Validate nothing is written
+// and the returned error aggregates every failure.
+func (cli *client) ApplyConfig(cfg config.Config) (*core_v1.ConfigMap, error) {
+	policy := cli.duplicates
+	switch policy {
+	case "":
+		policy = DuplicateReplace
+	case DuplicateReplace, DuplicateError, DuplicateMergeGroups:
+	default:
+		return nil, fmt.Errorf("unknown duplicate policy %q", policy)
+	}
+	if len(cfg.RoleMappings) == 0 && len(cfg.UserMappings) == 0 && len(cfg.AutoMappedAWSAccounts) == 0 {
+		return nil, errors.New("empty config")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	roles := make([]config.RoleMapping, 0, len(cfg.RoleMappings))
+	for i := range cfg.RoleMappings {
+		roles = append(roles, *canonicalRole(&cfg.RoleMappings[i]))
+	}
+	users := make([]config.UserMapping, 0, len(cfg.UserMappings))
+	for i := range cfg.UserMappings {
+		users = append(users, *canonicalUser(&cfg.UserMappings[i]))
+	}
+	return cli.modify(func(userMappings []config.UserMapping, roleMappings []config.RoleMapping, awsAccounts []string) ([]config.UserMapping, []config.RoleMapping, []string, error) {
+		var errs []error
+		roleKeys := make(map[string]int, len(roleMappings)+len(roles))
+		for i, r := range roleMappings {
+			roleKeys[r.MatchKey()] = i
+		}
+		for _, r := range roles {
+			i, ok := roleKeys[r.MatchKey()]
+			if !ok {
+				roleKeys[r.MatchKey()] = len(roleMappings)
+				roleMappings = append(roleMappings, r)
+				continue
+			}
+			if policy == DuplicateError {
+				errs = append(errs, fmt.Errorf("%w: cannot apply duplicate role ARN %q", ErrDuplicateMapping, r.Key()))
+				continue
+			}
+			roleMappings[i].Username = r.Username
+			roleMappings[i].Groups = applyGroups(roleMappings[i].Groups, r.Groups, policy)
+		}
+
+		userKeys := make(map[string]int, len(userMappings)+len(users))
+		for i, u := range userMappings {
+			userKeys[u.MatchKey()] = i
+		}
+		for _, u := range users {
+			i, ok := userKeys[u.MatchKey()]
+			if !ok {
+				userKeys[u.MatchKey()] = len(userMappings)
+				userMappings = append(userMappings, u)
+				continue
+			}
+			if policy == DuplicateError {
+				errs = append(errs, fmt.Errorf("%w: cannot apply duplicate user ARN %q", ErrDuplicateMapping, u.Key()))
+				continue
+			}
+			userMappings[i].Username = u.Username
+			userMappings[i].Groups = applyGroups(userMappings[i].Groups, u.Groups, policy)
+		}
+
+		accountKeys := make(map[string]bool, len(awsAccounts)+len(cfg.AutoMappedAWSAccounts))
+		for _, a := range awsAccounts {
+			accountKeys[a] = true
+		}
+		for _, a := range cfg.AutoMappedAWSAccounts {
+			if !accountKeys[a] {
+				accountKeys[a] = true
+				awsAccounts = append(awsAccounts, a)
+			}
+		}
+
+		if len(errs) > 0 {
+			return nil, nil, nil, utilerrors.NewAggregate(errs)
+		}
+		return userMappings, roleMappings, awsAccounts, nil
+	})
+}
+
+// applyGroups returns the groups of an existing mapping after applying a
+// mapping with groups applied to it under policy.
+func applyGroups(existing, applied []string, policy DuplicatePolicy) []string {
+	if policy != DuplicateMergeGroups {
+		return applied
+	}
+	merged := append([]string(nil), existing...)
+	for _, group := range applied {
+		found := false
+		for _, m := range merged {
+			if m == group {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, group)
+		}
+	}
+	return merged
+}
+
+// UpsertRole adds role, or replaces the username and groups of the existing
+// mapping with the same ARN.
+func (cli *client) UpsertRole(role *config.RoleMapping) (*core_v1.ConfigMap, error) {
+	if role == nil {
+		return nil, errors.New("empty role")
+	}
+	if err := role.Validate(); err != nil {
+		return nil, fmt.Errorf("role is invalid: %v", err)
+	}
+	role = canonicalRole(role)
+	return cli.modify(func(userMappings []config.UserMapping, roleMappings []config.RoleMapping, awsAccounts []string) ([]config.UserMapping, []config.RoleMapping, []string, error) {
+		for i := range roleMappings {
+			if roleMappings[i].MatchKey() == role.MatchKey() {
+				roleMappings[i].Username = role.Username
+				roleMappings[i].Groups = role.Groups
+				return userMappings, roleMappings, awsAccounts, nil
+			}
+		}
+		return userMappings, append(roleMappings, *role), awsAccounts, nil
+	})
+}
+
+// UpsertUser adds user, or replaces the username and groups of the existing
+// mapping with the same ARN.
+func (cli *client) UpsertUser(user *config.UserMapping) (*core_v1.ConfigMap, error) {
+	if user == nil {
+		return nil, errors.New("empty user")
+	}
+	if err := user.Validate(); err != nil {
+		return nil, fmt.Errorf("user is invalid: %v", err)
+	}
+	user = canonicalUser(user)
+	return cli.modify(func(userMappings []config.UserMapping, roleMappings []config.RoleMapping, awsAccounts []string) ([]config.UserMapping, []config.RoleMapping, []string, error) {
+		for i := range userMappings {
+			if userMappings[i].MatchKey() == user.MatchKey() {
+				userMappings[i].Username = user.Username
+				userMappings[i].Groups = user.Groups
+				return userMappings, roleMappings, awsAccounts, nil
+			}
+		}
+		return append(userMappings, *user), roleMappings, awsAccounts, nil
+	})
+}
+
+func (cli *client) RemoveRole(roleARN string) (*core_v1.ConfigMap, error) {
+	if roleARN == "" {
+		return nil, errors.New("empty role ARN")
+	}
+	return cli.modify(func(userMappings []config.UserMapping, roleMappings []config.RoleMapping, awsAccounts []string) ([]config.UserMapping, []config.RoleMapping, []string, error) {
+		remaining := make([]config.RoleMapping, 0, len(roleMappings))
+		for i := range roleMappings {
+			if r := roleMappings[i]; !roleHasARN(&r, roleARN) {
+				remaining = append(remaining, r)
+			}
+		}
+		if len(remaining) == len(roleMappings) {
+			return nil, nil, nil, fmt.Errorf("%w: role ARN %q", ErrMappingNotFound, roleARN)
+		}
+		return userMappings, remaining, awsAccounts, nil
+	})
+}
+
+func (cli *client) RemoveUser(userARN string) (*core_v1.ConfigMap, error) {
+	if userARN == "" {
+		return nil, errors.New("empty user ARN")
+	}
+	return cli.modify(func(userMappings []config.UserMapping, roleMappings []config.RoleMapping, awsAccounts []string) ([]config.UserMapping, []config.RoleMapping, []string, error) {
+		remaining := make([]config.UserMapping, 0, len(userMappings))
+		for _, u := range userMappings {
+			if u.UserARNRegex != "" || u.MatchKey() != arnKey(userARN) {
+				remaining = append(remaining, u)
+			}
+		}
+		if len(remaining) == len(userMappings) {
+			return nil, nil, nil, fmt.Errorf("%w: user ARN %q", ErrMappingNotFound, userARN)
+		}
+		return remaining, roleMappings, awsAccounts, nil
+	})
+}
+
+// RemoveRoleARNRegex removes the rolearnregex mapping whose pattern is
+// exactly pattern. The pattern is compared literally, not matched.
+func (cli *client) RemoveRoleARNRegex(pattern string) (*core_v1.ConfigMap, error) {
+	if pattern == "" {
+		return nil, errors.New("empty role ARN regex")
+	}
+	return cli.modify(func(userMappings []config.UserMapping, roleMappings []config.RoleMapping, awsAccounts []string) ([]config.UserMapping, []config.RoleMapping, []string, error) {
+		remaining := make([]config.RoleMapping, 0, len(roleMappings))
+		for _, r := range roleMappings {
+			if r.RoleARNRegex != pattern {
+				remaining = append(remaining, r)
+			}
+		}
+		if len(remaining) == len(roleMappings) {
+			return nil, nil, nil, fmt.Errorf("%w: role ARN regex %q", ErrMappingNotFound, pattern)
+		}
+		return userMappings, remaining, awsAccounts, nil
+	})
+}
+
+// RemoveUserARNRegex removes the userarnregex mapping whose pattern is
+// exactly pattern. The pattern is compared literally, not matched.
+func (cli *client) RemoveUserARNRegex(pattern string) (*core_v1.ConfigMap, error) {
+	if pattern == "" {
+		return nil, errors.New("empty user ARN regex")
+	}
+	return cli.modify(func(userMappings []config.UserMapping, roleMappings []config.RoleMapping, awsAccounts []string) ([]config.UserMapping, []config.RoleMapping, []string, error) {
+		remaining := make([]config.UserMapping, 0, len(userMappings))
+		for _, u := range userMappings {
+			if u.UserARNRegex != pattern {
+				remaining = append(remaining, u)
 			}
+		}
+		if len(remaining) == len(userMappings) {
+			return nil, nil, nil, fmt.Errorf("%w: user ARN regex %q", ErrMappingNotFound, pattern)
+		}
+		return remaining, roleMappings, awsAccounts, nil
+	})
+}
 
+// GetRole returns the role mapping for roleARN, compared like the mapper
+// compares them, see config.RoleMapping.MatchKey. A rolearnregex mapping is returned
+// if roleARN is exactly its pattern, an SSO mapping if roleARN is its
+// pattern ignoring case.
+func (cli *client) GetRole(roleARN string) (*config.RoleMapping, error) {
+	if roleARN == "" {
+		return nil, errors.New("empty role ARN")
+	}
+	parsed, err := cli.load()
+	if err != nil {
+		return nil, err
+	}
+	for i := range parsed.RoleMappings {
+		r := &parsed.RoleMappings[i]
+		if r.RoleARNRegex == roleARN || r.RoleARNRegex == "" && roleHasARN(r, roleARN) {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: role ARN %q", ErrMappingNotFound, roleARN)
+}
+
+// GetUser returns the user mapping for userARN, compared like the mapper
+// compares them, see config.UserMapping.MatchKey. A userarnregex mapping is returned
+// if userARN is exactly its pattern.
+func (cli *client) GetUser(userARN string) (*config.UserMapping, error) {
+	if userARN == "" {
+		return nil, errors.New("empty user ARN")
+	}
+	parsed, err := cli.load()
+	if err != nil {
+		return nil, err
+	}
+	for i := range parsed.UserMappings {
+		u := &parsed.UserMappings[i]
+		if u.UserARNRegex == userARN || u.UserARNRegex == "" && u.MatchKey() == arnKey(userARN) {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: user ARN %q", ErrMappingNotFound, userARN)
+}
+
+func (cli *client) ListRoles() ([]config.RoleMapping, error) {
+	parsed, err := cli.load()
+	return parsed.RoleMappings, err
+}
+
+func (cli *client) ListUsers() ([]config.UserMapping, error) {
+	parsed, err := cli.load()
+	return parsed.UserMappings, err
+}
+
+func (cli *client) ListAccounts() ([]string, error) {
+	parsed, err := cli.load()
+	return parsed.AWSAccounts, err
+}
+
+// load fetches and parses the configmap without modifying it.
+func (cli *client) load() (configmap.ParsedMap, error) {
+	cm, err := cli.getMap()
+	if err != nil {
+		if k8s_errors.IsNotFound(err) {
+			return configmap.ParsedMap{}, fmt.Errorf("%w: %s: %v", ErrConfigMapNotFound, mapName, err)
+		}
+		return configmap.ParsedMap{}, err
+	}
+	// duplicate entries are only logged, so the client can remove them
+	parsed, err := configmap.ParseMap(cm.Data)
+	if err := configmap.WithoutDuplicates(err); err != nil {
+		return configmap.ParsedMap{}, fmt.Errorf("failed to parse configmap %v", err)
+	}
+	return parsed, nil
+}
+
+func (cli *client) add(role *config.RoleMapping, user *config.UserMapping) (cm *core_v1.ConfigMap, err error) {
+	mutate, err := cli.addMutation(role, user)
+	if err != nil {
+		return nil, err
+	}
+	return cli.modify(mutate)
+}
+
+// addMutation validates role and user and returns the mutateFunc appending
+// them to the configmap mappings.
+func (cli *client) addMutation(role *config.RoleMapping, user *config.UserMapping) (mutateFunc, error) {
+	if role == nil && user == nil {
+		return nil, errors.New("empty role/user")
+	}
+	// validate before touching the configmap so invalid mappings are never persisted
+	if role != nil {
+		if err := role.Validate(); err != nil {
+			return nil, fmt.Errorf("role is invalid: %v", err)
+		}
+		role = canonicalRole(role)
+	}
+	if user != nil {
+		if err := user.Validate(); err != nil {
+			return nil, fmt.Errorf("user is invalid: %v", err)
+		}
+		user = canonicalUser(user)
+	}
+	return func(userMappings []config.UserMapping, roleMappings []config.RoleMapping, awsAccounts []string) ([]config.UserMapping, []config.RoleMapping, []string, error) {
+		if role != nil {
 			for _, r := range roleMappings {
-				if r.Key() == role.Key() {
-					return fmt.Errorf("cannot add duplicate role ARN %q", role.Key())
+				if r.MatchKey() == role.MatchKey() {
+					return nil, nil, nil, fmt.Errorf("%w: cannot add duplicate role ARN %q", ErrDuplicateMapping, role.Key())
 				}
 			}
 			roleMappings = append(roleMappings, *role)
 		}
 
 		if user != nil {
-			err = user.Validate()
-			if err != nil {
-				return fmt.Errorf("user is invalid: %v", err)
-			}
 			for _, r := range userMappings {
-				if r.Key() == user.Key() {
-					return fmt.Errorf("cannot add duplicate user ARN %q", user.Key())
+				if r.MatchKey() == user.MatchKey() {
+					return nil, nil, nil, fmt.Errorf("%w: cannot add duplicate user ARN %q", ErrDuplicateMapping, user.Key())
 				}
 			}
 			userMappings = append(userMappings, *user)
 		}
+		return userMappings, roleMappings, awsAccounts, nil
+	}, nil
+}
 
-		data, err = configmap.EncodeMap(userMappings, roleMappings, awsAccounts)
+// canonicalRole returns a copy of role with its ARN written the way the
+// mapper reads it: canonicalized, with the partition, service, region and
+// account lowercased and the case-sensitive resource left as is, see
+// arn.NormalizeCase. What is written is then byte for byte what is read.
+func canonicalRole(role *config.RoleMapping) *config.RoleMapping {
+	r := *role
+	if r.RoleARN != "" {
+		r.RoleARN = arn.NormalizeCase(r.RoleARN)
+	}
+	r.CanonicalizeARN()
+	return &r
+}
+
+// canonicalUser returns a copy of user with its ARN written the way the
+// mapper reads it, see canonicalRole.
+func canonicalUser(user *config.UserMapping) *config.UserMapping {
+	u := *user
+	if u.UserARN != "" {
+		u.UserARN = arn.NormalizeCase(u.UserARN)
+	}
+	u.CanonicalizeARN()
+	return &u
+}
+
+// canonicalARN returns the canonical form of an ARN passed to a remove, or
+// the ARN itself if it isn't an identity ARN.
+func canonicalARN(s string) string {
+	if canonical, err := arn.Canonicalize(s); err == nil {
+		return canonical
+	}
+	return s
+}
+
+// roleHasARN returns true if r is the mapping RemoveRole and GetRole
+// address by roleARN: an exact mapping whose MatchKey is arnKey(roleARN), or an SSO mapping whose pattern is roleARN ignoring case.
+func roleHasARN(r *config.RoleMapping, roleARN string) bool {
+	if r.SSO != nil {
+		return r.Key() == strings.ToLower(roleARN)
+	}
+	return r.RoleARNRegex == "" && r.MatchKey() == arnKey(roleARN)
+}
+
+// arnKey returns the MatchKey an exact mapping for identityARN has once
+// written, the canonical ARN with its case normalized.
+func arnKey(identityARN string) string {
+	return arn.NormalizeCase(canonicalARN(identityARN))
+}
+
+// mutateFunc returns the updated mappings to write back to the configmap.
+type mutateFunc func(userMappings []config.UserMapping, roleMappings []config.RoleMapping, awsAccounts []string) ([]config.UserMapping, []config.RoleMapping, []string, error)
+
+// modify loads and parses the configmap, applies mutate to its mappings
+// and updates the configmap with the result, retrying on conflict. If the
+// configmap does not exist and CreateIfMissing is set, it is created instead.
+func (cli *client) modify(mutate mutateFunc) (cm *core_v1.ConfigMap, err error) {
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		create := false
+		cm, err = cli.getMap()
 		if err != nil {
-			return err
+			if !k8s_errors.IsNotFound(err) {
+				return err
+			}
+			if cli.createMap == nil {
+				logrus.WithError(err).Warn("not found map " + mapName)
+				return fmt.Errorf("%w: %s: %v", ErrConfigMapNotFound, mapName, err)
+			}
+			logrus.Infof("not found map %s, creating it in namespace %q", mapName, cli.namespace)
+			cm = &core_v1.ConfigMap{
+				ObjectMeta: meta_v1.ObjectMeta{Name: mapName, Namespace: cli.namespace},
+			}
+			create = true
 		}
 
-		cm.Data = data
+		data, err := applyMutation(cm.Data, mutate)
+		if err != nil {
+			return err
+		}
 
-		updatedCm, err := cli.updateMap(cm)
+		var updatedCm *core_v1.ConfigMap
+		switch {
+		case create:
+			cm.Data = data
+			updatedCm, err = cli.createMap(cm)
+		case cli.patchMap != nil:
+			var patch []byte
+			patch, err = mappingsPatch(cm.ResourceVersion, cm.Data, data)
+			if err != nil {
+				return err
+			}
+			updatedCm, err = cli.patchMap(patch)
+		default:
+			cm.Data = data
+			updatedCm, err = cli.updateMap(cm)
+		}
 		if err != nil {
 			return err
 		}
@@ -121,3 +680,58 @@ func (cli *client) add(role *config.RoleMapping, user *config.UserMapping) (cm *
 	})
 	return cm, err
 }
+
+// applyMutation parses data, applies mutate to its mappings and returns the
+// configmap data with the mappings re-encoded. Keys other than the mapping
+// keys are kept as they are. Of duplicate entries only the last is kept,
+// as the mapper loads them.
+func applyMutation(data map[string]string, mutate mutateFunc) (map[string]string, error) {
+	parsed, err := configmap.ParseMap(data)
+	if err = configmap.WithoutDuplicates(err); err != nil {
+		return nil, fmt.Errorf("failed to parse configmap %v", err)
+	}
+
+	parsed.UserMappings, parsed.RoleMappings, parsed.AWSAccounts, err = mutate(parsed.UserMappings, parsed.RoleMappings, parsed.AWSAccounts)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := configmap.EncodeMap(parsed)
+	if err != nil {
+		return nil, err
+	}
+	updated := make(map[string]string, len(data)+len(encoded))
+	for k, v := range data {
+		updated[k] = v
+	}
+	for _, k := range mappingKeys {
+		delete(updated, k)
+	}
+	for k, v := range encoded {
+		updated[k] = v
+	}
+	return updated, nil
+}
+
+// mappingKeys are the configmap data keys owned by the mappings.
+var mappingKeys = []string{"mapUsers", "mapRoles", "mapAccounts"}
+
+// mappingsPatch returns a patch setting only the mapping keys that differ
+// between old and new, removing those no longer present.
+func mappingsPatch(resourceVersion string, old, new map[string]string) ([]byte, error) {
+	data := map[string]interface{}{}
+	for _, k := range mappingKeys {
+		v, ok := new[k]
+		switch {
+		case !ok && old[k] != "":
+			data[k] = nil
+		case ok && old[k] != v:
+			data[k] = v
+		}
+	}
+	patch := map[string]interface{}{"data": data}
+	if resourceVersion != "" {
+		patch["metadata"] = map[string]interface{}{"resourceVersion": resourceVersion}
+	}
+	return json.Marshal(patch)
+}
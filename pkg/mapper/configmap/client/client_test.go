@@ -1,13 +1,26 @@
 package client
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
 	core_v1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/arn"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper/configmap"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/metrics"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
 )
 
 func TestAddUser(t *testing.T) {
@@ -23,20 +36,51 @@ func TestAddUser(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	u, _, _, err := configmap.ParseMap(cm.Data)
+	parsed, err := configmap.ParseMap(cm.Data)
 	if err != nil {
 		t.Fatal(err)
 	}
+	u := parsed.UserMappings
 	updatedUser := u[0]
 	if !reflect.DeepEqual(newUser, updatedUser) {
 		t.Fatalf("unexpected updated user %+v", updatedUser)
 	}
 
-	if _, err := cli.AddRole(&config.RoleMapping{RoleARN: "a"}); err == nil || !strings.Contains(err.Error(), `cannot add duplicate role ARN`) {
+	if _, err := cli.AddRole(&config.RoleMapping{RoleARN: "a"}); !errors.Is(err, ErrDuplicateMapping) {
 		t.Fatal(err)
 	}
 }
 
+func TestAddCanonicalizesARN(t *testing.T) {
+	cli := makeTestClient(t, nil, nil, nil)
+	assumedRole := "arn:aws:sts::012345678912:assumed-role/Admin/session"
+	newRole := config.RoleMapping{RoleARN: assumedRole, Username: "admin", Groups: []string{"system:masters"}}
+	cm, err := cli.AddRole(&newRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newRole.RoleARN != assumedRole {
+		t.Errorf("expected the caller's mapping to be left unchanged, got %q", newRole.RoleARN)
+	}
+	parsed, err := configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := parsed.RoleMappings
+	if len(r) != 1 || r[0].RoleARN != "arn:aws:iam::012345678912:role/Admin" {
+		t.Fatalf("expected the canonical role ARN to be stored, got %+v", r)
+	}
+
+	// resolve the stored mapping the way the server does
+	identityARN, err := arn.Canonicalize(assumedRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r[0].Matches(identityARN) {
+		t.Errorf("expected stored mapping to match %q", identityARN)
+	}
+}
+
 func TestAddRole(t *testing.T) {
 	cli := makeTestClient(t,
 		[]config.UserMapping{
@@ -50,16 +94,17 @@ func TestAddRole(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, r, _, err := configmap.ParseMap(cm.Data)
+	parsed, err := configmap.ParseMap(cm.Data)
 	if err != nil {
 		t.Fatal(err)
 	}
+	r := parsed.RoleMappings
 	updatedRole := r[0]
 	if !reflect.DeepEqual(newRole, updatedRole) {
 		t.Fatalf("unexpected updated role %+v", updatedRole)
 	}
 
-	if _, err := cli.AddUser(&config.UserMapping{UserARN: "a"}); err == nil || !strings.Contains(err.Error(), `cannot add duplicate user ARN`) {
+	if _, err := cli.AddUser(&config.UserMapping{UserARN: "a"}); !errors.Is(err, ErrDuplicateMapping) {
 		t.Fatal(err)
 	}
 
@@ -80,10 +125,11 @@ func TestAddRole(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, srm, _, err := configmap.ParseMap(cm.Data)
+	parsed, err = configmap.ParseMap(cm.Data)
 	if err != nil {
 		t.Fatal(err)
 	}
+	srm := parsed.RoleMappings
 	updatedRole = srm[0]
 	if !reflect.DeepEqual(newSSORole, updatedRole) {
 		t.Fatalf("unexpected updated role %+v", updatedRole)
@@ -94,9 +140,742 @@ func TestAddRole(t *testing.T) {
 		[]config.RoleMapping{newSSORole},
 		nil,
 	)
-	if _, err := cli.AddRole(&newSSORole); err == nil || !strings.Contains(err.Error(), `cannot add duplicate role ARN`) {
+	if _, err := cli.AddRole(&newSSORole); !errors.Is(err, ErrDuplicateMapping) {
+		t.Fatal(err)
+	}
+}
+
+func TestAddInvalid(t *testing.T) {
+	cli := &client{
+		getMap: func() (*core_v1.ConfigMap, error) {
+			t.Fatal("unexpected configmap get for an invalid mapping")
+			return nil, nil
+		},
+	}
+	_, err := cli.AddRole(&config.RoleMapping{
+		RoleARN:      "arn:aws:iam::012345678912:role/A",
+		RoleARNRegex: "arn:aws:iam::012345678912:role/.*",
+		Username:     "a",
+		Groups:       []string{"a"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "role is invalid") {
+		t.Errorf("expected role validation error, got %v", err)
+	}
+	_, err = cli.AddUser(&config.UserMapping{
+		UserARN:      "arn:aws:iam::012345678912:user/A",
+		UserARNRegex: "arn:aws:iam::012345678912:user/.*",
+		Username:     "a",
+		Groups:       []string{"a"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "user is invalid") {
+		t.Errorf("expected user validation error, got %v", err)
+	}
+	_, err = cli.AddRole(&config.RoleMapping{
+		RoleARNRegex: "arn:aws:iam::012345678912:role/(",
+		Username:     "a",
+		Groups:       []string{"a"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "role is invalid") {
+		t.Errorf("expected role validation error for a malformed pattern, got %v", err)
+	}
+}
+
+func TestBatchAdd(t *testing.T) {
+	existing := config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/A", Username: "a", Groups: []string{"a"}}
+	updates := 0
+	cli := makeTestClient(t, nil, []config.RoleMapping{existing}, []string{"012345678912"})
+	cli.(*client).updateMap = func(m *core_v1.ConfigMap) (*core_v1.ConfigMap, error) {
+		updates++
+		return m, nil
+	}
+
+	roles := []config.RoleMapping{
+		{RoleARN: "arn:aws:iam::012345678912:role/B", Username: "b", Groups: []string{"b"}},
+		{RoleARNRegex: "arn:aws:iam::012345678912:role/team-.*", Username: "c", Groups: []string{"c"}},
+	}
+	users := []config.UserMapping{
+		{UserARN: "arn:aws:iam::012345678912:user/A", Username: "a", Groups: []string{"a"}},
+	}
+	cm, err := cli.BatchAdd(roles, users, []string{"111122223333"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updates != 1 {
+		t.Errorf("expected a single update, got %d", updates)
+	}
+	parsed, err := configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, r, a := parsed.UserMappings, parsed.RoleMappings, parsed.AWSAccounts
+	if !reflect.DeepEqual(r, append([]config.RoleMapping{existing}, roles...)) {
+		t.Errorf("unexpected roles %+v", r)
+	}
+	if !reflect.DeepEqual(u, users) {
+		t.Errorf("unexpected users %+v", u)
+	}
+	if !reflect.DeepEqual(a, []string{"012345678912", "111122223333"}) {
+		t.Errorf("unexpected accounts %+v", a)
+	}
+
+	updates = 0
+	_, err = cli.BatchAdd(append(roles, existing), users, nil)
+	if !errors.Is(err, ErrDuplicateMapping) {
+		t.Fatalf("expected ErrDuplicateMapping, got %v", err)
+	}
+	if !strings.Contains(err.Error(), existing.Key()) {
+		t.Errorf("expected error to name %q, got %v", existing.Key(), err)
+	}
+	if updates != 0 {
+		t.Errorf("expected no update for a failed batch, got %d", updates)
+	}
+
+	_, err = cli.BatchAdd(nil, nil, []string{"123"})
+	if err == nil || !strings.Contains(err.Error(), `"123"`) {
+		t.Errorf("expected an invalid account error naming \"123\", got %v", err)
+	}
+	if updates != 0 {
+		t.Errorf("expected no update for an invalid account, got %d", updates)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	cfg := config.Config{
+		RoleMappings: []config.RoleMapping{
+			{RoleARN: "arn:aws:sts::012345678912:assumed-role/A/session", Username: "a2", Groups: []string{"b"}},
+			{RoleARNRegex: "arn:aws:iam::012345678912:role/team-.*", Username: "team", Groups: []string{"team"}},
+		},
+		UserMappings: []config.UserMapping{
+			{UserARN: "arn:aws:iam::012345678912:user/A", Username: "a", Groups: []string{"a"}},
+		},
+		AutoMappedAWSAccounts: []string{"012345678912", "111122223333"},
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		updates := 0
+		cli := makeTestClient(t, nil, nil, nil)
+		cli.(*client).updateMap = func(m *core_v1.ConfigMap) (*core_v1.ConfigMap, error) {
+			updates++
+			return m, nil
+		}
+		cm, err := cli.ApplyConfig(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updates != 1 {
+			t.Errorf("expected a single update, got %d", updates)
+		}
+		parsed, err := configmap.ParseMap(cm.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, r, a := parsed.UserMappings, parsed.RoleMappings, parsed.AWSAccounts
+		if len(r) != 2 || r[0].RoleARN != "arn:aws:iam::012345678912:role/A" || r[1].RoleARNRegex == "" {
+			t.Errorf("unexpected roles %+v", r)
+		}
+		if !reflect.DeepEqual(u, cfg.UserMappings) {
+			t.Errorf("unexpected users %+v", u)
+		}
+		if !reflect.DeepEqual(a, cfg.AutoMappedAWSAccounts) {
+			t.Errorf("unexpected accounts %+v", a)
+		}
+	})
+
+	existingRole := config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/A", Username: "a", Groups: []string{"a", "b"}}
+	existingUser := config.UserMapping{UserARN: "arn:aws:iam::012345678912:user/B", Username: "b", Groups: []string{"b"}}
+	cases := []struct {
+		policy DuplicatePolicy
+		// groups of role/A after the apply, nil if it fails
+		groups []string
+	}{
+		{"", []string{"b"}},
+		{DuplicateReplace, []string{"b"}},
+		{DuplicateMergeGroups, []string{"a", "b"}},
+		{DuplicateError, nil},
+	}
+	for _, c := range cases {
+		t.Run("overlapping "+string(c.policy), func(t *testing.T) {
+			updates := 0
+			cli := makeTestClient(t, []config.UserMapping{existingUser}, []config.RoleMapping{existingRole}, []string{"012345678912"})
+			cli.(*client).duplicates = c.policy
+			cli.(*client).updateMap = func(m *core_v1.ConfigMap) (*core_v1.ConfigMap, error) {
+				updates++
+				return m, nil
+			}
+			cm, err := cli.ApplyConfig(cfg)
+			if c.groups == nil {
+				if !errors.Is(err, ErrDuplicateMapping) || !strings.Contains(err.Error(), existingRole.Key()) {
+					t.Errorf("expected ErrDuplicateMapping for %q, got %v", existingRole.Key(), err)
+				}
+				if updates != 0 {
+					t.Errorf("expected no update for a failed apply, got %d", updates)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := configmap.ParseMap(cm.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, r, a := parsed.UserMappings, parsed.RoleMappings, parsed.AWSAccounts
+			expectedRole := config.RoleMapping{RoleARN: existingRole.RoleARN, Username: "a2", Groups: c.groups}
+			if len(r) != 2 || !reflect.DeepEqual(r[0], expectedRole) {
+				t.Errorf("expected %+v to be updated in place, got %+v", expectedRole, r)
+			}
+			if !reflect.DeepEqual(u, append(cfg.UserMappings[:1:1], existingUser)) {
+				t.Errorf("unexpected users %+v", u)
+			}
+			if !reflect.DeepEqual(a, []string{"012345678912", "111122223333"}) {
+				t.Errorf("unexpected accounts %+v", a)
+			}
+		})
+	}
+
+	cli := makeTestClient(t, nil, nil, nil)
+	invalid := config.Config{RoleMappings: []config.RoleMapping{{Username: "no-arn"}}}
+	if _, err := cli.ApplyConfig(invalid); err == nil || !strings.Contains(err.Error(), "mapRoles[0]") {
+		t.Errorf("expected an invalid role error, got %v", err)
+	}
+	invalid = config.Config{AutoMappedAWSAccounts: []string{"012345678912", "123"}}
+	if _, err := cli.ApplyConfig(invalid); err == nil || !strings.Contains(err.Error(), "mapAccounts[1]") {
+		t.Errorf("expected an invalid account error, got %v", err)
+	}
+	cli.(*client).duplicates = "first"
+	if _, err := cli.ApplyConfig(cfg); err == nil || !strings.Contains(err.Error(), "unknown duplicate policy") {
+		t.Errorf("expected an unknown policy error, got %v", err)
+	}
+}
+
+func TestUpsertRole(t *testing.T) {
+	cli := makeTestClient(t,
+		nil,
+		[]config.RoleMapping{
+			{RoleARN: "arn:aws:iam::012345678912:role/A", Username: "a", Groups: []string{"a"}},
+		},
+		nil,
+	)
+	cm, err := cli.UpsertRole(&config.RoleMapping{RoleARN: "arn:AWS:iam::012345678912:role/A", Username: "a2", Groups: []string{"b", "c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := parsed.RoleMappings
+	expected := []config.RoleMapping{
+		{RoleARN: "arn:aws:iam::012345678912:role/A", Username: "a2", Groups: []string{"b", "c"}},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Fatalf("unexpected roles after update %+v", r)
+	}
+
+	newRole := config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/B", Username: "b", Groups: []string{"b"}}
+	cm, err = cli.UpsertRole(&newRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err = configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = parsed.RoleMappings
+	if len(r) != 2 || !reflect.DeepEqual(r[1], newRole) {
+		t.Fatalf("unexpected roles after insert %+v", r)
+	}
+}
+
+func TestUpsertUser(t *testing.T) {
+	cli := makeTestClient(t,
+		[]config.UserMapping{
+			{UserARN: "arn:aws:iam::012345678912:user/A", Username: "a", Groups: []string{"a"}},
+		},
+		nil,
+		nil,
+	)
+	cm, err := cli.UpsertUser(&config.UserMapping{UserARN: "arn:AWS:iam::012345678912:user/A", Username: "a2", Groups: []string{"b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := parsed.UserMappings
+	expected := []config.UserMapping{
+		{UserARN: "arn:aws:iam::012345678912:user/A", Username: "a2", Groups: []string{"b"}},
+	}
+	if !reflect.DeepEqual(u, expected) {
+		t.Fatalf("unexpected users after update %+v", u)
+	}
+
+	newUser := config.UserMapping{UserARN: "arn:aws:iam::012345678912:user/B", Username: "b", Groups: []string{"b"}}
+	cm, err = cli.UpsertUser(&newUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err = configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u = parsed.UserMappings
+	if len(u) != 2 || !reflect.DeepEqual(u[1], newUser) {
+		t.Fatalf("unexpected users after insert %+v", u)
+	}
+}
+
+func TestRemoveRole(t *testing.T) {
+	ssoRole := config.RoleMapping{
+		SSO: &config.SSOARNMatcher{
+			PermissionSetName: "ViewOnlyAccess",
+			AccountID:         "012345678912",
+		},
+		Username: "b",
+		Groups:   []string{"b"},
+	}
+	cli := makeTestClient(t,
+		nil,
+		[]config.RoleMapping{
+			{RoleARN: "arn:aws:iam::012345678912:role/A", Username: "a", Groups: []string{"a"}},
+			ssoRole,
+		},
+		nil,
+	)
+	cm, err := cli.RemoveRole("arn:AWS:iam::012345678912:role/A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := parsed.RoleMappings
+	if !reflect.DeepEqual(r, []config.RoleMapping{ssoRole}) {
+		t.Fatalf("unexpected roles after remove %+v", r)
+	}
+
+	if _, err := cli.RemoveRole("arn:aws:iam::012345678912:role/missing"); !errors.Is(err, ErrMappingNotFound) {
+		t.Fatalf("expected ErrMappingNotFound, got %v", err)
+	}
+}
+
+func TestRemoveDuplicate(t *testing.T) {
+	role := config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/A", Username: "a", Groups: []string{"a"}}
+	other := config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/B", Username: "b", Groups: []string{"b"}}
+	cli := makeTestClient(t, nil, []config.RoleMapping{role, other, role}, nil)
+
+	if r, err := cli.ListRoles(); err != nil || len(r) != 2 {
+		t.Errorf("expected the duplicate to be listed once, got %+v, %v", r, err)
+	}
+	cm, err := cli.RemoveRole(role.RoleARN)
+	if err != nil {
+		t.Fatalf("expected a duplicated role to be removable, got %v", err)
+	}
+	parsed, err := configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []config.RoleMapping{other}; !reflect.DeepEqual(parsed.RoleMappings, expected) {
+		t.Errorf("expected every entry for the role to be removed, got %+v", parsed.RoleMappings)
+	}
+}
+
+func TestResourceCase(t *testing.T) {
+	upper := config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/Foo", Username: "upper", Groups: []string{"a"}}
+	lower := config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/foo", Username: "lower", Groups: []string{"b"}}
+	cli := makeTestClient(t, nil, []config.RoleMapping{upper}, nil)
+	cm, err := cli.AddRole(&lower)
+	if err != nil {
+		t.Fatalf("expected role/foo to be added next to role/Foo, got %v", err)
+	}
+	parsed, err := configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []config.RoleMapping{upper, lower}; !reflect.DeepEqual(parsed.RoleMappings, expected) {
+		t.Fatalf("unexpected roles after add %+v", parsed.RoleMappings)
+	}
+
+	cli = makeTestClient(t, nil, []config.RoleMapping{upper, lower}, nil)
+	for roleARN, expected := range map[string]config.RoleMapping{upper.RoleARN: upper, lower.RoleARN: lower} {
+		if r, err := cli.GetRole(roleARN); err != nil || !reflect.DeepEqual(*r, expected) {
+			t.Errorf("expected %+v for %s, got %+v, %v", expected, roleARN, r, err)
+		}
+	}
+
+	cm, err = cli.RemoveRole(upper.RoleARN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err = configmap.ParseMap(cm.Data); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []config.RoleMapping{lower}; !reflect.DeepEqual(parsed.RoleMappings, expected) {
+		t.Errorf("expected only role/Foo to be removed, got %+v", parsed.RoleMappings)
+	}
+
+	cm, err = cli.UpsertRole(&config.RoleMapping{RoleARN: lower.RoleARN, Username: "lower2", Groups: []string{"c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err = configmap.ParseMap(cm.Data); err != nil {
+		t.Fatal(err)
+	}
+	if r := parsed.RoleMappings; len(r) != 2 || !reflect.DeepEqual(r[0], upper) || r[1].Username != "lower2" {
+		t.Errorf("expected only role/foo to be updated, got %+v", r)
+	}
+
+	upperUser := config.UserMapping{UserARN: "arn:aws:iam::012345678912:user/Bob", Username: "upper", Groups: []string{"a"}}
+	lowerUser := config.UserMapping{UserARN: "arn:aws:iam::012345678912:user/bob", Username: "lower", Groups: []string{"b"}}
+	cli = makeTestClient(t, []config.UserMapping{upperUser, lowerUser}, nil, nil)
+	cm, err = cli.RemoveUser(lowerUser.UserARN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err = configmap.ParseMap(cm.Data); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []config.UserMapping{upperUser}; !reflect.DeepEqual(parsed.UserMappings, expected) {
+		t.Errorf("expected only user/bob to be removed, got %+v", parsed.UserMappings)
+	}
+	if u, err := cli.GetUser(upperUser.UserARN); err != nil || !reflect.DeepEqual(*u, upperUser) {
+		t.Errorf("expected %+v, got %+v, %v", upperUser, u, err)
+	}
+}
+
+func TestRemoveUser(t *testing.T) {
+	cli := makeTestClient(t,
+		[]config.UserMapping{
+			{UserARN: "arn:aws:iam::012345678912:user/A", Username: "a", Groups: []string{"a"}},
+			{UserARN: "arn:aws:iam::012345678912:user/B", Username: "b", Groups: []string{"b"}},
+		},
+		nil,
+		nil,
+	)
+	cm, err := cli.RemoveUser("arn:AWS:iam::012345678912:user/A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := parsed.UserMappings
+	expected := []config.UserMapping{
+		{UserARN: "arn:aws:iam::012345678912:user/B", Username: "b", Groups: []string{"b"}},
+	}
+	if !reflect.DeepEqual(u, expected) {
+		t.Fatalf("unexpected users after remove %+v", u)
+	}
+
+	if _, err := cli.RemoveUser("arn:aws:iam::012345678912:user/missing"); !errors.Is(err, ErrMappingNotFound) {
+		t.Fatalf("expected ErrMappingNotFound, got %v", err)
+	}
+}
+
+func TestRemoveARNRegex(t *testing.T) {
+	dev := config.RoleMapping{RoleARNRegex: `arn:aws:iam::012345678912:role/Dev-.*`, Username: "dev", Groups: []string{"dev"}}
+	ci := config.UserMapping{UserARNRegex: `arn:aws:iam::012345678912:user/ci-[0-9]+`, Username: "ci", Groups: []string{"ci"}}
+	cli := makeTestClient(t,
+		[]config.UserMapping{
+			ci,
+			{UserARNRegex: `arn:aws:iam::012345678912:user/bot-.*`, Username: "bot", Groups: []string{"bots"}},
+		},
+		[]config.RoleMapping{
+			dev,
+			{RoleARNRegex: `arn:aws:iam::012345678912:role/Test-.*`, Username: "test", Groups: []string{"test"}},
+		},
+		nil,
+	)
+
+	cm, err := cli.RemoveRoleARNRegex(`arn:aws:iam::012345678912:role/Test-.*`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := parsed.RoleMappings
+	if !reflect.DeepEqual(r, []config.RoleMapping{dev}) {
+		t.Errorf("unexpected roles after remove %+v", r)
+	}
+
+	cm, err = cli.RemoveUserARNRegex(`arn:aws:iam::012345678912:user/bot-.*`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err = configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := parsed.UserMappings
+	if !reflect.DeepEqual(u, []config.UserMapping{ci}) {
+		t.Errorf("unexpected users after remove %+v", u)
+	}
+
+	// patterns are compared literally, not matched
+	if _, err := cli.RemoveRoleARNRegex(`arn:aws:iam::012345678912:role/Dev-1`); !errors.Is(err, ErrMappingNotFound) {
+		t.Errorf("expected ErrMappingNotFound, got %v", err)
+	}
+	if _, err := cli.RemoveUserARNRegex(`arn:aws:iam::012345678912:user/CI-[0-9]+`); !errors.Is(err, ErrMappingNotFound) {
+		t.Errorf("expected ErrMappingNotFound, got %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	users := []config.UserMapping{
+		{UserARN: "arn:aws:iam::012345678912:user/A", Username: "a", Groups: []string{"a"}},
+		{UserARNRegex: "arn:aws:iam::012345678912:user/ci-.*", Username: "ci", Groups: []string{"ci"}},
+	}
+	roles := []config.RoleMapping{
+		{RoleARN: "arn:aws:iam::012345678912:role/A", Username: "a", Groups: []string{"a"}},
+		{
+			SSO: &config.SSOARNMatcher{
+				PermissionSetName: "ViewOnlyAccess",
+				AccountID:         "012345678912",
+			},
+			Username: "b",
+			Groups:   []string{"b"},
+		},
+		{RoleARNRegex: "arn:aws:iam::012345678912:role/team-.*", Username: "c", Groups: []string{"c"}},
+	}
+	accounts := []string{"012345678912", "111122223333"}
+	cli := makeTestClient(t, users, roles, accounts)
+
+	r, err := cli.ListRoles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, roles) {
+		t.Errorf("unexpected roles %+v", r)
+	}
+	u, err := cli.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(u, users) {
+		t.Errorf("unexpected users %+v", u)
+	}
+	a, err := cli.ListAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, accounts) {
+		t.Errorf("unexpected accounts %+v", a)
+	}
+}
+
+func TestGet(t *testing.T) {
+	users := []config.UserMapping{
+		{UserARN: "arn:aws:iam::012345678912:user/A", Username: "a", Groups: []string{"a"}},
+		{UserARNRegex: "arn:aws:iam::012345678912:user/ci-.*", Username: "ci", Groups: []string{"ci"}},
+	}
+	sso := config.RoleMapping{
+		SSO: &config.SSOARNMatcher{
+			PermissionSetName: "ViewOnlyAccess",
+			AccountID:         "012345678912",
+		},
+		Username: "b",
+		Groups:   []string{"b"},
+	}
+	roles := []config.RoleMapping{
+		{RoleARN: "arn:aws:iam::012345678912:role/A", Username: "a", Groups: []string{"a"}},
+		sso,
+		{RoleARNRegex: "arn:aws:iam::012345678912:role/team-.*", Username: "c", Groups: []string{"c"}},
+	}
+	cli := makeTestClient(t, users, roles, nil)
+
+	roleCases := map[string]config.RoleMapping{
+		"arn:AWS:iam::012345678912:role/A": roles[0],
+		// assumed-role ARNs are canonicalized like identities
+		"arn:aws:sts::012345678912:assumed-role/A/session": roles[0],
+		sso.SSOArnLike():                         sso,
+		"arn:aws:iam::012345678912:role/team-.*": roles[2],
+	}
+	for roleARN, expected := range roleCases {
+		r, err := cli.GetRole(roleARN)
+		if err != nil {
+			t.Errorf("unexpected error getting %s: %v", roleARN, err)
+			continue
+		}
+		if !reflect.DeepEqual(*r, expected) {
+			t.Errorf("expected %+v for %s, got %+v", expected, roleARN, *r)
+		}
+	}
+	// a regex is not matched against the query
+	if _, err := cli.GetRole("arn:aws:iam::012345678912:role/team-1"); !errors.Is(err, ErrMappingNotFound) {
+		t.Errorf("expected ErrMappingNotFound, got %v", err)
+	}
+
+	userCases := map[string]config.UserMapping{
+		"arn:AWS:iam::012345678912:user/A":     users[0],
+		"arn:aws:iam::012345678912:user/ci-.*": users[1],
+	}
+	for userARN, expected := range userCases {
+		u, err := cli.GetUser(userARN)
+		if err != nil {
+			t.Errorf("unexpected error getting %s: %v", userARN, err)
+			continue
+		}
+		if !reflect.DeepEqual(*u, expected) {
+			t.Errorf("expected %+v for %s, got %+v", expected, userARN, *u)
+		}
+	}
+	if _, err := cli.GetUser("arn:aws:iam::012345678912:user/missing"); !errors.Is(err, ErrMappingNotFound) {
+		t.Errorf("expected ErrMappingNotFound, got %v", err)
+	}
+}
+
+func TestCreateIfMissing(t *testing.T) {
+	notFound := k8s_errors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, mapName)
+	newRole := config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/A", Username: "a", Groups: []string{"a"}}
+
+	cli := &client{
+		getMap: func() (*core_v1.ConfigMap, error) {
+			return nil, notFound
+		},
+		updateMap: func(m *core_v1.ConfigMap) (*core_v1.ConfigMap, error) {
+			t.Fatal("unexpected update")
+			return nil, nil
+		},
+	}
+	if _, err := cli.AddRole(&newRole); !errors.Is(err, ErrConfigMapNotFound) {
+		t.Fatalf("expected ErrConfigMapNotFound without CreateIfMissing, got %v", err)
+	}
+
+	if _, err := cli.ListRoles(); !errors.Is(err, ErrConfigMapNotFound) {
+		t.Fatalf("expected ErrConfigMapNotFound from ListRoles, got %v", err)
+	}
+
+	var created *core_v1.ConfigMap
+	CreateIfMissing("kube-system")(cli, nil)
+	cli.createMap = func(m *core_v1.ConfigMap) (*core_v1.ConfigMap, error) {
+		created = m
+		return m, nil
+	}
+	cm, err := cli.AddRole(&newRole)
+	if err != nil {
 		t.Fatal(err)
 	}
+	if created == nil {
+		t.Fatal("expected configmap to be created")
+	}
+	if cm.Name != mapName || cm.Namespace != "kube-system" {
+		t.Errorf("unexpected configmap %s/%s", cm.Namespace, cm.Name)
+	}
+	parsed, err := configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := parsed.RoleMappings
+	if !reflect.DeepEqual(r, []config.RoleMapping{newRole}) {
+		t.Errorf("unexpected roles %+v", r)
+	}
+}
+
+func TestUsePatch(t *testing.T) {
+	data, err := configmap.EncodeMap(configmap.ParsedMap{
+		UserMappings: []config.UserMapping{{UserARN: "arn:aws:iam::012345678912:user/A", Username: "a", Groups: []string{"a"}}},
+		AWSAccounts:  []string{"012345678912"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data["other"] = "managed elsewhere"
+	clientset := k8sfake.NewSimpleClientset(&core_v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Name: mapName, Namespace: "kube-system", ResourceVersion: "7"},
+		Data:       data,
+	})
+	var patches [][]byte
+	clientset.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patches = append(patches, action.(k8stesting.PatchAction).GetPatch())
+		return false, nil, nil
+	})
+	clientset.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		t.Fatal("unexpected update with UsePatch")
+		return true, nil, nil
+	})
+
+	cli := New(clientset.CoreV1().ConfigMaps("kube-system"), UsePatch())
+	newRole := config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/A", Username: "a", Groups: []string{"a"}}
+	cm, err := cli.AddRole(&newRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patches) != 1 {
+		t.Fatalf("expected 1 patch, got %d", len(patches))
+	}
+	var patch struct {
+		Metadata map[string]string      `json:"metadata"`
+		Data     map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(patches[0], &patch); err != nil {
+		t.Fatal(err)
+	}
+	if patch.Metadata["resourceVersion"] != "7" {
+		t.Errorf("expected patch to carry resourceVersion 7, got %v", patch.Metadata)
+	}
+	if len(patch.Data) != 1 || patch.Data["mapRoles"] == nil {
+		t.Errorf("expected patch to only modify mapRoles, got %v", patch.Data)
+	}
+
+	if cm.Data["other"] != "managed elsewhere" || cm.Data["mapUsers"] != data["mapUsers"] {
+		t.Errorf("unrelated keys were modified: %v", cm.Data)
+	}
+	parsed, err := configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := parsed.RoleMappings
+	if !reflect.DeepEqual(r, []config.RoleMapping{newRole}) {
+		t.Errorf("unexpected roles %+v", r)
+	}
+}
+
+func TestAddKeepsOtherKeys(t *testing.T) {
+	data, err := configmap.EncodeMap(configmap.ParsedMap{RoleMappings: []config.RoleMapping{
+		{RoleARN: "arn:aws:iam::012345678912:role/a", Username: "a", Groups: []string{"a"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data["example.com/owner"] = "platform-team"
+	cm := &core_v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "aws-auth", Namespace: "kube-system"},
+		Data:       data,
+	}
+	clientset := k8sfake.NewSimpleClientset(cm)
+	cli := New(clientset.CoreV1().ConfigMaps("kube-system"))
+
+	updated, err := cli.AddRole(&config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/b", Username: "b", Groups: []string{"b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Data["example.com/owner"] != "platform-team" {
+		t.Errorf("expected other keys to be kept, got %v", updated.Data)
+	}
+	if parsed, err := configmap.ParseMap(updated.Data); err != nil || len(parsed.RoleMappings) != 2 {
+		t.Errorf("expected both roles in the updated configmap, got %+v, %v", parsed.RoleMappings, err)
+	}
+
+	// removing the last user drops mapUsers, but still keeps other keys
+	if _, err := cli.AddUser(&config.UserMapping{UserARN: "arn:aws:iam::012345678912:user/c", Username: "c", Groups: []string{"c"}}); err != nil {
+		t.Fatal(err)
+	}
+	updated, err = cli.RemoveUser("arn:aws:iam::012345678912:user/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := updated.Data["mapUsers"]; ok {
+		t.Errorf("expected mapUsers to be removed with its last user, got %v", updated.Data)
+	}
+	if updated.Data["example.com/owner"] != "platform-team" {
+		t.Errorf("expected other keys to be kept, got %v", updated.Data)
+	}
 }
 
 func makeTestClient(
@@ -105,7 +884,7 @@ func makeTestClient(
 	roleMappings []config.RoleMapping,
 	awsAccounts []string,
 ) Client {
-	d, err := configmap.EncodeMap(userMappings, roleMappings, awsAccounts)
+	d, err := configmap.EncodeMap(configmap.ParsedMap{UserMappings: userMappings, RoleMappings: roleMappings, AWSAccounts: awsAccounts})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -118,3 +897,46 @@ func makeTestClient(
 		},
 	}
 }
+
+func TestARNCase(t *testing.T) {
+	if !metrics.Initialized() {
+		metrics.InitMetrics(prometheus.NewRegistry())
+	}
+	clientset := k8sfake.NewSimpleClientset(&core_v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "kube-system", Name: mapName},
+	})
+	configMaps := clientset.CoreV1().ConfigMaps("kube-system")
+	const (
+		mixedCase  = "arn:AWS:IAM::012345678912:role/Team/MixedCase"
+		normalized = "arn:aws:iam::012345678912:role/Team/MixedCase"
+	)
+	cli := New(configMaps)
+	cm, err := cli.AddRole(&config.RoleMapping{RoleARN: mixedCase, Username: "mixed", Groups: []string{"dev"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := configmap.ParseMap(cm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := parsed.RoleMappings
+	if len(r) != 1 || r[0].RoleARN != normalized {
+		t.Fatalf("expected the role ARN to be written as %q, got %+v", normalized, r)
+	}
+
+	m := &configmap.ConfigMapMapper{MapStore: configmap.NewWithClient(configMaps, metrics.Get())}
+	if err := m.StartWithContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	mapping, err := m.Map(&token.Identity{CanonicalARN: normalized})
+	if err != nil {
+		t.Fatalf("expected the written role to resolve, got %v", err)
+	}
+	if mapping.Username != "mixed" {
+		t.Errorf("unexpected mapping %+v", mapping)
+	}
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/team/mixedcase"}); err == nil {
+		t.Errorf("expected the case of the role name to be kept")
+	}
+}
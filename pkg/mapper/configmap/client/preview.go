@@ -0,0 +1,119 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+)
+
+// Preview is the result of a dry-run change to the configmap.
+type Preview struct {
+	// Data is the configmap data that would be written.
+	Data map[string]string
+	// Diff lists every changed key followed by its removed ("-") and
+	// added ("+") lines. It is empty when nothing would change.
+	Diff string
+}
+
+// PreviewAddRole returns the configmap data AddRole would write, without
+// updating the configmap.
+func (cli *client) PreviewAddRole(role *config.RoleMapping) (*Preview, error) {
+	if role == nil {
+		return nil, errors.New("empty role")
+	}
+	mutate, err := cli.addMutation(role, nil)
+	if err != nil {
+		return nil, err
+	}
+	return cli.preview(mutate)
+}
+
+// PreviewAddUser returns the configmap data AddUser would write, without
+// updating the configmap.
+func (cli *client) PreviewAddUser(user *config.UserMapping) (*Preview, error) {
+	if user == nil {
+		return nil, errors.New("empty user")
+	}
+	mutate, err := cli.addMutation(nil, user)
+	if err != nil {
+		return nil, err
+	}
+	return cli.preview(mutate)
+}
+
+func (cli *client) preview(mutate mutateFunc) (*Preview, error) {
+	var current map[string]string
+	cm, err := cli.getMap()
+	switch {
+	case err == nil:
+		current = cm.Data
+	case k8s_errors.IsNotFound(err) && cli.createMap != nil:
+		// the configmap would be created
+	case k8s_errors.IsNotFound(err):
+		return nil, fmt.Errorf("%w: %s: %v", ErrConfigMapNotFound, mapName, err)
+	default:
+		return nil, err
+	}
+
+	data, err := applyMutation(current, mutate)
+	if err != nil {
+		return nil, err
+	}
+	return &Preview{Data: data, Diff: diffData(current, data)}, nil
+}
+
+// diffData describes the keys that differ between old and new.
+func diffData(old, new map[string]string) string {
+	keys := make([]string, 0, len(old)+len(new))
+	for k := range old {
+		keys = append(keys, k)
+	}
+	for k := range new {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		if old[k] == new[k] {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:\n", k)
+		oldLines := strings.Split(strings.TrimRight(old[k], "\n"), "\n")
+		newLines := strings.Split(strings.TrimRight(new[k], "\n"), "\n")
+		for _, l := range subtractLines(oldLines, newLines) {
+			fmt.Fprintf(&b, "-%s\n", l)
+		}
+		for _, l := range subtractLines(newLines, oldLines) {
+			fmt.Fprintf(&b, "+%s\n", l)
+		}
+	}
+	return b.String()
+}
+
+// subtractLines returns the lines of a, in order, that are not matched by a
+// line of b. Repeated lines are matched one for one.
+func subtractLines(a, b []string) []string {
+	counts := make(map[string]int, len(b))
+	for _, l := range b {
+		counts[l]++
+	}
+	var out []string
+	for _, l := range a {
+		if l == "" {
+			continue
+		}
+		if counts[l] > 0 {
+			counts[l]--
+			continue
+		}
+		out = append(out, l)
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper/configmap"
+)
+
+func TestPreviewAddRole(t *testing.T) {
+	existing := config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/A", Username: "a", Groups: []string{"a"}}
+	cli := makeTestClient(t, nil, []config.RoleMapping{existing}, []string{"012345678912"})
+	cli.(*client).updateMap = func(m *core_v1.ConfigMap) (*core_v1.ConfigMap, error) {
+		t.Fatal("unexpected update in preview")
+		return nil, nil
+	}
+
+	newRole := config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/B", Username: "b", Groups: []string{"b"}}
+	p, err := cli.PreviewAddRole(&newRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := configmap.ParseMap(p.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := parsed.RoleMappings
+	if !reflect.DeepEqual(r, []config.RoleMapping{existing, newRole}) {
+		t.Errorf("unexpected roles %+v", r)
+	}
+	if !strings.HasPrefix(p.Diff, "mapRoles:\n") {
+		t.Errorf("expected diff to start with the changed key, got %q", p.Diff)
+	}
+	if !strings.Contains(p.Diff, "+- rolearn: arn:aws:iam::012345678912:role/B\n") {
+		t.Errorf("expected diff to add role B, got %q", p.Diff)
+	}
+	if strings.Contains(p.Diff, "mapAccounts") || strings.Contains(p.Diff, "role/A") {
+		t.Errorf("expected diff to only contain changes, got %q", p.Diff)
+	}
+
+	if _, err := cli.PreviewAddRole(&existing); !errors.Is(err, ErrDuplicateMapping) {
+		t.Errorf("expected ErrDuplicateMapping, got %v", err)
+	}
+}
+
+func TestPreviewAddUser(t *testing.T) {
+	cli := makeTestClient(t, nil, nil, nil)
+	cli.(*client).updateMap = func(m *core_v1.ConfigMap) (*core_v1.ConfigMap, error) {
+		t.Fatal("unexpected update in preview")
+		return nil, nil
+	}
+
+	newUser := config.UserMapping{UserARN: "arn:aws:iam::012345678912:user/A", Username: "a", Groups: []string{"a"}}
+	p, err := cli.PreviewAddUser(&newUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := configmap.ParseMap(p.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := parsed.UserMappings
+	if !reflect.DeepEqual(u, []config.UserMapping{newUser}) {
+		t.Errorf("unexpected users %+v", u)
+	}
+	if !strings.Contains(p.Diff, "mapUsers:\n") || !strings.Contains(p.Diff, "+  username: a\n") {
+		t.Errorf("unexpected diff %q", p.Diff)
+	}
+}
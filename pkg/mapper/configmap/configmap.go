@@ -5,34 +5,236 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 	"gopkg.in/yaml.v2"
 	core_v1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 
+	"sigs.k8s.io/aws-iam-authenticator/pkg/arn"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/metrics"
 )
 
-type MapStore struct {
-	mutex sync.RWMutex
+const (
+	// DefaultNamespace is the namespace of the auth configmap if none is configured.
+	DefaultNamespace = "kube-system"
+	// DefaultName is the name of the auth configmap if none is configured.
+	DefaultName = "aws-auth"
+
+	// ReasonParseFailed is the reason of the Warning event recorded against
+	// the configmap when it fails to parse.
+	ReasonParseFailed = "ParseFailed"
+	// ReasonTooManyMappings is the reason of the Warning event recorded
+	// against the configmap when it has more mappings than the maximum.
+	ReasonTooManyMappings = "TooManyMappings"
+	// ReasonDisallowedGroup is the reason of the Warning event recorded
+	// against the configmap when a mapping grants a group the group policy
+	// doesn't allow, and the policy only warns.
+	ReasonDisallowedGroup = "DisallowedGroup"
+	// eventComponent is the source component of recorded events.
+	eventComponent = "aws-iam-authenticator"
+)
+
+// watchBackoff is the backoff used between attempts to re-establish the
+// configmap watch. It is reset after every successful watch.
+var watchBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    math.MaxInt32,
+	Cap:      30 * time.Second,
+}
+
+// mappings is an immutable set of loaded mappings. Updates build a new one
+// and swap it in, so lookups never take a lock.
+type mappings struct {
 	users map[string]config.UserMapping
 	roles map[string]config.RoleMapping
-	// Used as set.
+	// users ordered with exact mappings first, rebuilt whenever users changes.
+	orderedUsers []config.UserMapping
+	// case normalized UserARN of each of orderedUsers.
+	userARNs []string
+	// compiled UserARNRegex of each of orderedUsers, nil for exact mappings.
+	userRegexps []*regexp.Regexp
+	// orderedUsers bucketed by account.
+	userIndex accountIndex
+	// roles ordered by config.SortRoleMappings, rebuilt whenever roles changes.
+	orderedRoles []config.RoleMapping
+	// compiled SSO patterns for orderedRoles, nil for exact mappings.
+	orderedPatterns []*arn.CompiledPattern
+	// compiled rolearnregex and sessionnamelike regexes for orderedRoles,
+	// nil for other mappings.
+	orderedRegexps []*regexp.Regexp
+	// case normalized RoleARN of each of orderedRoles.
+	roleARNs []string
+	// orderedRoles bucketed by account.
+	roleIndex accountIndex
+	// Exact account IDs, used as set.
 	awsAccounts map[string]interface{}
-	configMap   v1.ConfigMapInterface
+	// mapAccounts entries with wildcards, tried when an account isn't in
+	// awsAccounts.
+	accountPatterns []*arn.AccountPattern
+	// mapRoleTags mappings in configmap order, tried once no ARN mapping
+	// matches a role.
+	roleTags []config.RoleTagMapping
+	// ignorePath compares exact ARNs without the path of their role or
+	// user, so roleARNs and userARNs are stored without it.
+	ignorePath bool
+}
+
+// emptyMappings is served until the first configmap is loaded.
+var emptyMappings = &mappings{}
+
+type MapStore struct {
+	// mutex serializes updates. Lookups only load current.
+	mutex   sync.Mutex
+	current atomic.Pointer[mappings]
+
+	configMap v1.ConfigMapInterface
+	// name of the configmap to watch within configMap's namespace
+	name string
+	// loadMutex serializes loading the configmap between the watch and the
+	// resync, and guards uid and resourceVersion.
+	loadMutex sync.Mutex
+	// uid of the last configmap loaded, used to detect recreation.
+	uid types.UID
+	// resourceVersion of the last configmap loaded, so a resync can skip
+	// a configmap that hasn't changed.
+	resourceVersion string
+	// watchResourceVersion is where the next watch resumes from, the
+	// resourceVersion of the last watch event or bookmark. Empty starts the
+	// watch from the current configmap. Only used by the watch goroutine.
+	watchResourceVersion string
+	// resync is how often the configmap is reloaded regardless of watch
+	// events. Zero disables it.
+	resync time.Duration
+	// newTicker creates the resync ticker. Defaults to time.NewTicker.
+	newTicker func(time.Duration) (<-chan time.Time, func())
+	// sleep waits between watch attempts. Defaults to time.Sleep.
+	sleep func(time.Duration)
+	// debounce coalesces the configmaps delivered by the watch within this
+	// window into a single load of the last one. Zero loads each of them.
+	debounce time.Duration
+	// pendingMutex guards pending and pendingTimer, the configmap waiting
+	// for the debounce window to end and the timer that will load it.
+	pendingMutex sync.Mutex
+	pending      *core_v1.ConfigMap
+	pendingTimer *time.Timer
+	// synced is set once the configmap has been loaded.
+	synced atomic.Bool
+	// lastErrorMutex guards lastError and lastErrorTime, the most recent
+	// watch or parse error and when it happened, see LastError.
+	lastErrorMutex sync.Mutex
+	lastError      error
+	lastErrorTime  time.Time
+	// cache of Map results, nil when disabled.
+	cache *mapCache
+	// recorder emits events against the configmap, nil when disabled.
+	recorder record.EventRecorder
+	// runner runs the watch started by ConfigMapMapper.Start.
+	runner mapper.Runner
+	// requireAccountAllowed warns about role and user mappings pinned to an
+	// account that isn't in mapAccounts whenever the mappings are loaded.
+	requireAccountAllowed bool
+	// enforceAccountAllowed makes Map reject mappings for identities whose
+	// account IsAccountAllowed doesn't allow, unless the mapping grants one
+	// of accountBypassGroups.
+	enforceAccountAllowed bool
+	accountBypassGroups   map[string]bool
+	// allowedGroups, if not empty, are the only groups mappings may grant,
+	// and mappings may never grant disallowedGroups. A section with a
+	// mapping granting any other group is rejected like one with an invalid
+	// entry, or only warned about if warnDisallowedGroups is set.
+	allowedGroups        map[string]bool
+	disallowedGroups     map[string]bool
+	warnDisallowedGroups bool
+	// roleTagResolver looks up the tags of roles for the mapRoleTags
+	// mappings, NoRoleTags when nil.
+	roleTagResolver RoleTagResolver
+	// useInformer follows the configmap with a shared informer rather than
+	// watchConfigMap.
+	useInformer bool
+	// strictParse discards a configmap update entirely if any part of it
+	// fails to parse, rather than applying the sections that parsed.
+	strictParse bool
+	// logger receives the store's logs, defaultLogger when nil.
+	logger Logger
+	// metrics the store records to, the global metrics when nil.
+	metrics *metrics.Metrics
+	// accountPolicy decides whether IsAccountAllowed also allows accounts
+	// that only appear in role or user mappings.
+	accountPolicy config.AccountAllowPolicy
+	// patternMetrics counts the lookups won by each pattern mapping.
+	patternMetrics bool
+	// maxMappings is the most role and user mappings expected in the
+	// configmap. Zero means no limit.
+	maxMappings int
+	// ignorePath matches rolearn and userarn mappings ignoring the path of
+	// the role or user.
+	ignorePath bool
+}
+
+// Option configures optional MapStore behavior.
+type Option func(ms *MapStore)
+
+// WithLogger makes the MapStore log to logger instead of the global logrus
+// logger.
+func WithLogger(logger Logger) Option {
+	return func(ms *MapStore) {
+		ms.logger = logger
+	}
 }
 
-func New(masterURL, kubeConfig string) (*MapStore, error) {
+// WithName makes the MapStore watch the configmap called name rather than
+// DefaultName.
+func WithName(name string) Option {
+	return func(ms *MapStore) {
+		if name != "" {
+			ms.name = name
+		}
+	}
+}
+
+// log returns the Logger set with WithLogger, or defaultLogger.
+func (ms *MapStore) log() Logger {
+	if ms.logger == nil {
+		return defaultLogger
+	}
+	return ms.logger
+}
+
+// stats returns the Metrics given to NewWithClient, or the global metrics.
+func (ms *MapStore) stats() metrics.Metrics {
+	if ms.metrics == nil {
+		return metrics.Get()
+	}
+	return *ms.metrics
+}
+
+// New creates a MapStore for the configmap with the given namespace and
+// name. Empty values default to DefaultNamespace and DefaultName.
+func New(masterURL, kubeConfig, namespace, name string, opts ...Option) (*MapStore, error) {
 	clientconfig, err := clientcmd.BuildConfigFromFlags(masterURL, kubeConfig)
 	if err != nil {
 		return nil, err
@@ -42,64 +244,364 @@ func New(masterURL, kubeConfig string) (*MapStore, error) {
 		return nil, err
 	}
 
-	ms := MapStore{}
-	ms.configMap = clientset.CoreV1().ConfigMaps("kube-system")
-	return &ms, nil
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&v1.EventSinkImpl{Interface: clientset.CoreV1().Events(namespace)})
+
+	ms := NewWithClient(clientset.CoreV1().ConfigMaps(namespace), metrics.Get(), append([]Option{WithName(name)}, opts...)...)
+	ms.recorder = eventBroadcaster.NewRecorder(scheme.Scheme, core_v1.EventSource{Component: eventComponent})
+	return ms, nil
+}
+
+// NewWithClient creates a MapStore that watches the configmap called
+// DefaultName, or the name given with WithName, through cm and records to
+// m. It lets callers supply their own client, such as a fake in tests.
+// Unlike New it doesn't emit events.
+func NewWithClient(cm v1.ConfigMapInterface, m metrics.Metrics, opts ...Option) *MapStore {
+	ms := &MapStore{
+		configMap: cm,
+		name:      DefaultName,
+		metrics:   &m,
+	}
+	for _, opt := range opts {
+		opt(ms)
+	}
+	return ms
 }
 
 // Starts a go routine which will watch the configmap and update the in memory data
 // when the values change.
 func (ms *MapStore) startLoadConfigMap(stopCh <-chan struct{}) {
+	ctx, cancel := wait.ContextForChannel(stopCh)
 	go func() {
-		for {
+		defer cancel()
+		ms.watchConfigMap(ctx)
+	}()
+}
+
+// watchConfigMap watches the configmap and updates the in memory data when the
+// values change, re-establishing the watch as needed until ctx is cancelled.
+func (ms *MapStore) watchConfigMap(ctx context.Context) {
+	sleep := ms.sleep
+	if sleep == nil {
+		sleep = func(d time.Duration) {
 			select {
-			case <-stopCh:
+			case <-ctx.Done():
+			case <-time.After(d):
+			}
+		}
+	}
+	backoff := watchBackoff
+	// relisted is set after reloading for an expired resourceVersion, so a
+	// watch that fails again backs off rather than reloading in a loop
+	relisted := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			watcher, err := ms.configMap.Watch(ctx, metav1.ListOptions{
+				Watch:               true,
+				FieldSelector:       fields.OneTermEqualSelector("metadata.name", ms.name).String(),
+				ResourceVersion:     ms.watchResourceVersion,
+				AllowWatchBookmarks: true,
+			})
+			if err != nil && !relisted && ms.watchResourceVersion != "" && (k8s_errors.IsGone(err) || k8s_errors.IsResourceExpired(err)) {
+				ms.log().Warnf("Unable to resume watch of %s configmap: %v, reloading it", ms.name, err)
+				ms.relistConfigMap(ctx)
+				relisted = true
+				continue
+			}
+			if err != nil {
+				delay := backoff.Step()
+				ms.log().Errorf("Unable to re-establish watch: %v, sleeping for %v.", err, delay)
+				ms.stats().ConfigMapWatchFailures.Inc()
+				ms.setLastError(err)
+				sleep(delay)
+				continue
+			}
+			backoff = watchBackoff
+			relisted = false
+
+			rewatch := ms.handleWatchEvents(ctx, watcher)
+			watcher.Stop()
+			if !rewatch {
 				return
-			default:
-				watcher, err := ms.configMap.Watch(context.TODO(), metav1.ListOptions{
-					Watch:         true,
-					FieldSelector: fields.OneTermEqualSelector("metadata.name", "aws-auth").String(),
-				})
-				if err != nil {
-					logrus.Errorf("Unable to re-establish watch: %v, sleeping for 5 seconds.", err)
-					metrics.Get().ConfigMapWatchFailures.Inc()
-					time.Sleep(5 * time.Second)
-					continue
-				}
+			}
+		}
+	}
+}
 
-				for r := range watcher.ResultChan() {
-					switch r.Type {
-					case watch.Error:
-						logrus.WithFields(logrus.Fields{"error": r}).Error("recieved a watch error")
-					case watch.Deleted:
-						logrus.Info("Resetting configmap on delete")
-						userMappings := make([]config.UserMapping, 0)
-						roleMappings := make([]config.RoleMapping, 0)
-						awsAccounts := make([]string, 0)
-						ms.saveMap(userMappings, roleMappings, awsAccounts)
-					case watch.Added, watch.Modified:
-						switch cm := r.Object.(type) {
-						case *core_v1.ConfigMap:
-							if cm.Name != "aws-auth" {
-								break
-							}
-							logrus.Info("Received aws-auth watch event")
-							userMappings, roleMappings, awsAccounts, err := ParseMap(cm.Data)
-							if err != nil {
-								logrus.Errorf("There was an error parsing the config maps.  Only saving data that was good, %+v", err)
-							}
-							ms.saveMap(userMappings, roleMappings, awsAccounts)
-							if err != nil {
-								logrus.Error(err)
-							}
-						}
+// handleWatchEvents handles events from watcher until its channel is closed
+// or the watch has to be restarted, returning true, or ctx is cancelled,
+// returning false.
+func (ms *MapStore) handleWatchEvents(ctx context.Context, watcher watch.Interface) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case r, ok := <-watcher.ResultChan():
+			if !ok {
+				ms.log().Errorf("Watch channel closed.")
+				return true
+			}
+			if ms.handleWatchEvent(ctx, r) {
+				return true
+			}
+		}
+	}
+}
 
-					}
-				}
-				logrus.Error("Watch channel closed.")
+// handleWatchEvent handles a single watch event, returning true if the
+// watch has to be restarted.
+func (ms *MapStore) handleWatchEvent(ctx context.Context, r watch.Event) bool {
+	_, span := mapper.StartSpan(ctx, mapper.ModeEKSConfigMap, "WatchEvent",
+		attribute.String("type", string(r.Type)))
+	defer span.End()
+	if r.Type != watch.Error {
+		if obj, err := meta.Accessor(r.Object); err == nil && obj.GetResourceVersion() != "" {
+			ms.watchResourceVersion = obj.GetResourceVersion()
+		}
+	}
+	switch r.Type {
+	case watch.Error:
+		err := k8s_errors.FromObject(r.Object)
+		if k8s_errors.IsGone(err) || k8s_errors.IsResourceExpired(err) {
+			// resuming from an older resourceVersion would fail the same
+			// way, so reload the configmap and watch from there
+			ms.log().Warnf("Watch of %s configmap expired: %v, reloading it", ms.name, err)
+			ms.relistConfigMap(ctx)
+			return true
+		}
+		ms.log().WithFields(map[string]interface{}{"error": err}).Errorf("recieved a watch error")
+		ms.setLastError(err)
+	case watch.Bookmark:
+		// only the resourceVersion, recorded above, is of interest
+	case watch.Deleted:
+		ms.resetWatched()
+	case watch.Added, watch.Modified:
+		switch cm := r.Object.(type) {
+		case *core_v1.ConfigMap:
+			if cm.Name != ms.name {
+				break
 			}
+			ms.log().Infof("Received %s watch event", ms.name)
+			ms.loadWatched(cm)
 		}
-	}()
+	}
+	return false
+}
+
+// resetConfigMap clears the in memory data after the configmap was deleted.
+func (ms *MapStore) resetConfigMap() {
+	ms.log().Infof("Resetting configmap on delete")
+	userMappings := make([]config.UserMapping, 0)
+	roleMappings := make([]config.RoleMapping, 0)
+	awsAccounts := make([]string, 0)
+	ms.loadMutex.Lock()
+	ms.saveMap(userMappings, roleMappings, awsAccounts)
+	ms.resourceVersion = ""
+	ms.loadMutex.Unlock()
+	ms.recordInvalidEntries(nil)
+	ms.recordLoaded()
+	ms.setLastError(nil)
+}
+
+// relistConfigMap reloads the configmap after its watch expired, so the
+// next watch resumes from it. If it can't be loaded the next watch starts
+// from the current configmap instead.
+func (ms *MapStore) relistConfigMap(ctx context.Context) {
+	ms.watchResourceVersion = ""
+	if err := ms.loadConfigMap(ctx); err != nil {
+		ms.log().Errorf("Unable to reload %s configmap: %v", ms.name, err)
+		ms.setLastError(err)
+	}
+}
+
+// loadConfigMap fetches the configmap once and loads it, so the store is
+// populated before the watch delivers its first event, and the watch
+// resumes from it. A configmap that doesn't exist yet is not an error.
+func (ms *MapStore) loadConfigMap(ctx context.Context) error {
+	cm, err := ms.configMap.Get(ctx, ms.name, metav1.GetOptions{})
+	if k8s_errors.IsNotFound(err) {
+		ms.log().Warnf("%s configmap not found, waiting for it to be created", ms.name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error loading %s configmap: %v", ms.name, err)
+	}
+	ms.handleConfigMap(cm)
+	ms.watchResourceVersion = cm.ResourceVersion
+	return nil
+}
+
+// Reload fetches the configmap and loads it now, without waiting for the
+// watch, returning the error parsing it if any. Sections that failed to
+// parse keep their last good data as they do for watch events. It is safe
+// to call while the watch is running, and works whether or not the mapper
+// has been started.
+func (ms *MapStore) Reload(ctx context.Context) error {
+	cm, err := ms.configMap.Get(ctx, ms.name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error loading %s configmap: %v", ms.name, err)
+	}
+	return ms.handleConfigMap(cm)
+}
+
+// resyncConfigMap reloads the configmap every resync interval until ctx is
+// cancelled, so the store recovers from watch events that were missed.
+func (ms *MapStore) resyncConfigMap(ctx context.Context) {
+	newTicker := ms.newTicker
+	if newTicker == nil {
+		newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+			ticker := time.NewTicker(d)
+			return ticker.C, ticker.Stop
+		}
+	}
+	ticks, stop := newTicker(ms.resync)
+	defer stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+			ms.resyncOnce(ctx)
+		}
+	}
+}
+
+// resyncOnce fetches the configmap and loads it unless its resourceVersion
+// is the one already loaded. A missing configmap is left to the watch.
+func (ms *MapStore) resyncOnce(ctx context.Context) {
+	cm, err := ms.configMap.Get(ctx, ms.name, metav1.GetOptions{})
+	if k8s_errors.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		ms.log().Errorf("Unable to resync %s configmap: %v", ms.name, err)
+		ms.setLastError(err)
+		return
+	}
+	ms.loadMutex.Lock()
+	unchanged := cm.ResourceVersion != "" && cm.ResourceVersion == ms.resourceVersion
+	ms.loadMutex.Unlock()
+	if unchanged {
+		return
+	}
+	ms.log().Infof("Resyncing %s configmap at resourceVersion %s", ms.name, cm.ResourceVersion)
+	ms.handleConfigMap(cm)
+}
+
+// handleConfigMap parses cm and saves the result into the store. It returns
+// the error parsing cm, or why the whole update was ignored, and records it
+// for LastError, clearing the last error if cm loaded cleanly.
+func (ms *MapStore) handleConfigMap(cm *core_v1.ConfigMap) (err error) {
+	ms.loadMutex.Lock()
+	defer ms.loadMutex.Unlock()
+	defer func() { ms.setLastError(err) }()
+	// remembered even if strict parsing rejects cm, so a resync doesn't
+	// keep rejecting it again
+	ms.resourceVersion = cm.ResourceVersion
+	if ms.uid != "" && ms.uid != cm.UID {
+		ms.log().WithFields(map[string]interface{}{
+			"previousUID": ms.uid,
+			"uid":         cm.UID,
+		}).Warnf("%s configmap was recreated", ms.name)
+		ms.stats().ConfigMapRecreated.Inc()
+	}
+	ms.uid = cm.UID
+	parsed, err := logParseMap(ms.log(), cm.Data)
+	userMappings, roleMappings, awsAccounts := parsed.UserMappings, parsed.RoleMappings, parsed.AWSAccounts
+	roleTagMappings, tagErrs := parseRoleTags(cm.Data)
+	if len(tagErrs) > 0 {
+		ms.log().Warnf("Errors parsing configmap: %+v", tagErrs)
+		err = appendParseErrors(err, tagErrs)
+	}
+	err = ms.checkGroups(cm, userMappings, roleMappings, roleTagMappings, err)
+	ms.recordInvalidEntries(err)
+	ms.recordParseFailures(err)
+	if err != nil && ms.strictParse {
+		ms.log().Errorf("There was an error parsing the config maps.  Strict parsing is enabled, ignoring the whole update, %+v", err)
+		ms.stats().ConfigMapRejectedUpdates.Inc()
+		if ms.recorder != nil {
+			ms.recorder.Eventf(cm, core_v1.EventTypeWarning, ReasonParseFailed,
+				"Failed to parse %s, ignoring the whole update: %v", strings.Join(sortedKeys(errorSections(err)), ", "), err)
+		}
+		return err
+	}
+	if n := len(userMappings) + len(roleMappings) + len(roleTagMappings); ms.maxMappings > 0 && n > ms.maxMappings {
+		ms.stats().ConfigMapOverMaxMappings.Inc()
+		if ms.strictParse {
+			ms.log().Errorf("%s configmap has %d mappings, more than the maximum of %d.  Strict parsing is enabled, ignoring the whole update", ms.name, n, ms.maxMappings)
+			ms.stats().ConfigMapRejectedUpdates.Inc()
+			if ms.recorder != nil {
+				ms.recorder.Eventf(cm, core_v1.EventTypeWarning, ReasonTooManyMappings,
+					"%d mappings is more than the maximum of %d, ignoring the whole update", n, ms.maxMappings)
+			}
+			return fmt.Errorf("%s configmap has %d mappings, more than the maximum of %d", ms.name, n, ms.maxMappings)
+		}
+		ms.log().Warnf("%s configmap has %d mappings, more than the maximum of %d", ms.name, n, ms.maxMappings)
+		if ms.recorder != nil {
+			ms.recorder.Eventf(cm, core_v1.EventTypeWarning, ReasonTooManyMappings,
+				"%d mappings is more than the maximum of %d", n, ms.maxMappings)
+		}
+	}
+	if err != nil {
+		ms.log().Errorf("There was an error parsing the config maps.  Keeping the last good data for failed sections, %+v", err)
+		if ms.recorder != nil {
+			ms.recorder.Eventf(cm, core_v1.EventTypeWarning, ReasonParseFailed,
+				"Failed to parse %s, keeping the last good data: %v", strings.Join(sortedKeys(errorSections(err)), ", "), err)
+		}
+	}
+	ms.saveParsedMap(userMappings, roleMappings, awsAccounts, roleTagMappings, err)
+	ms.synced.Store(true)
+	ms.recordLoaded()
+	return err
+}
+
+// recordLoaded sets the last load timestamp to now, so operators can alert
+// when the watch has stopped delivering the configmap.
+func (ms *MapStore) recordLoaded() {
+	ms.stats().ConfigMapLastLoadTimestampSeconds.SetToCurrentTime()
+}
+
+// setLastError records err as the last error for LastError, or clears it
+// if err is nil.
+func (ms *MapStore) setLastError(err error) {
+	ms.lastErrorMutex.Lock()
+	defer ms.lastErrorMutex.Unlock()
+	ms.lastError = err
+	if err != nil {
+		ms.lastErrorTime = time.Now()
+	} else {
+		ms.lastErrorTime = time.Time{}
+	}
+}
+
+// LastError returns the most recent error watching or parsing the
+// configmap, or nil once it has been loaded cleanly since, so a health
+// endpoint can report that updates are failing while the last good
+// mappings are still served. It is safe to call concurrently.
+func (ms *MapStore) LastError() error {
+	ms.lastErrorMutex.Lock()
+	defer ms.lastErrorMutex.Unlock()
+	return ms.lastError
+}
+
+// LastErrorTime returns when the error returned by LastError happened, the
+// zero time if there is none.
+func (ms *MapStore) LastErrorTime() time.Time {
+	ms.lastErrorMutex.Lock()
+	defer ms.lastErrorMutex.Unlock()
+	return ms.lastErrorTime
+}
+
+// HasSynced returns true once the configmap has been loaded at least once.
+// It is safe to call concurrently.
+func (ms *MapStore) HasSynced() bool {
+	return ms.synced.Load()
 }
 
 type ErrParsingMap struct {
@@ -110,25 +612,167 @@ func (err ErrParsingMap) Error() string {
 	return fmt.Sprintf("error parsing config map: %v", err.errors)
 }
 
-func ParseMap(m map[string]string) (userMappings []config.UserMapping, roleMappings []config.RoleMapping, awsAccounts []string, err error) {
+const (
+	// parseErrorSyntax is a section of the configmap that could not be decoded.
+	parseErrorSyntax = "syntax"
+	// parseErrorValidation is a decoded entry that failed validation.
+	parseErrorValidation = "validation"
+	// parseErrorDuplicate is an entry for the same ARN as an earlier one.
+	// Unlike the others it doesn't stop its section being loaded.
+	parseErrorDuplicate = "duplicate"
+	// parseErrorDisallowedGroup is an entry granting a group the group
+	// policy doesn't allow.
+	parseErrorDisallowedGroup = "disallowed_group"
+)
+
+var parseErrorTypes = []string{parseErrorSyntax, parseErrorValidation, parseErrorDuplicate, parseErrorDisallowedGroup}
+
+// parseError is a single error encountered by ParseMap, tagged with the
+// configmap section it came from and the type of failure.
+type parseError struct {
+	section   string
+	errorType string
+	err       error
+	// entry is the ARN, pattern or account ID of the offending entry, empty
+	// for syntax errors.
+	entry string
+}
+
+func (err parseError) Error() string {
+	return err.err.Error()
+}
+
+func (err parseError) Unwrap() error {
+	return err.err
+}
+
+// Is makes the errors for duplicate entries match
+// config.ErrDuplicateMapping.
+func (err parseError) Is(target error) bool {
+	return target == config.ErrDuplicateMapping && err.errorType == parseErrorDuplicate
+}
+
+// WithoutDuplicates returns err, an error returned by ParseMap, without the
+// errors for duplicate entries, of which ParseMap loads the last. It
+// returns nil if those were the only errors.
+func WithoutDuplicates(err error) error {
+	var parseErrs ErrParsingMap
+	if !errors.As(err, &parseErrs) {
+		return err
+	}
+	var errs []error
+	for _, e := range parseErrs.errors {
+		if !errors.Is(e, config.ErrDuplicateMapping) {
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return ErrParsingMap{errors: errs}
+}
+
+// recordInvalidEntries updates the invalid entry gauges from the error
+// returned by the last ParseMap call.
+func (ms *MapStore) recordInvalidEntries(err error) {
+	counts := make(map[string]float64, len(parseErrorTypes))
+	for _, errorType := range parseErrorTypes {
+		counts[errorType] = 0
+	}
+	var parseErrs ErrParsingMap
+	if errors.As(err, &parseErrs) {
+		for _, e := range parseErrs.errors {
+			if pe, ok := e.(parseError); ok {
+				counts[pe.errorType]++
+			}
+		}
+	}
+	for errorType, count := range counts {
+		ms.stats().ConfigMapInvalidEntries.WithLabelValues(errorType).Set(count)
+	}
+}
+
+// recordParseFailures counts a failed parse of the configmap once for
+// every section that had errors.
+func (ms *MapStore) recordParseFailures(err error) {
+	if err == nil {
+		return
+	}
+	failed := errorSections(err)
+	if len(failed) == 0 {
+		failed["unknown"] = true
+	}
+	for section := range failed {
+		ms.stats().ConfigMapParseFailures.WithLabelValues(section).Inc()
+	}
+}
+
+var accountIDRegexp = regexp.MustCompile(`^[0-9]{12}$`)
+
+// ParsedMap is the mappings parsed from the data of an aws-auth configmap.
+type ParsedMap struct {
+	// UserMappings are the valid mapUsers entries, in configmap order.
+	UserMappings []config.UserMapping
+	// RoleMappings are the valid mapRoles entries, in configmap order.
+	RoleMappings []config.RoleMapping
+	// AWSAccounts are the valid mapAccounts entries, in configmap order.
+	AWSAccounts []string
+}
+
+// ParseMap parses the mapping sections of configmap data. Invalid entries
+// are left out of the result and reported in the returned ErrParsingMap.
+func ParseMap(m map[string]string) (ParsedMap, error) {
+	return logParseMap(defaultLogger, m)
+}
+
+// logParseMap is ParseMap, logging the parse errors to log.
+func logParseMap(log Logger, m map[string]string) (parsed ParsedMap, err error) {
+	parsed, errs := parseMap(m)
+	if len(errs) > 0 {
+		log.Warnf("Errors parsing configmap: %+v", errs)
+		err = ErrParsingMap{errors: errs}
+	}
+	return parsed, err
+}
+
+// parseMap is ParseMap, returning every parseError found rather than
+// logging them.
+func parseMap(m map[string]string) (ParsedMap, []error) {
+	var (
+		userMappings []config.UserMapping
+		roleMappings []config.RoleMapping
+		awsAccounts  []string
+	)
 	errs := make([]error, 0)
 	rawUserMappings := make([]config.UserMapping, 0)
 	userMappings = make([]config.UserMapping, 0)
+	// index of each key in userMappings and roleMappings, to find duplicates
+	userKeys := make(map[string]int)
+	roleKeys := make(map[string]int)
 	if userData, ok := m["mapUsers"]; ok {
 		userJson, err := utilyaml.ToJSON([]byte(userData))
 		if err != nil {
-			errs = append(errs, err)
+			errs = append(errs, parseError{"mapUsers", parseErrorSyntax, err, ""})
 		} else {
 			err = json.Unmarshal(userJson, &rawUserMappings)
 			if err != nil {
-				errs = append(errs, err)
+				errs = append(errs, parseError{"mapUsers", parseErrorSyntax, err, ""})
 			}
 
 			for _, userMapping := range rawUserMappings {
 				err = userMapping.Validate()
 				if err != nil {
-					errs = append(errs, err)
+					errs = append(errs, parseError{"mapUsers", parseErrorValidation, err, userMapping.Key()})
 				} else {
+					userMapping.CanonicalizeARN()
+					key := userKey(userMapping)
+					if i, ok := userKeys[key]; ok {
+						errs = append(errs, parseError{"mapUsers", parseErrorDuplicate,
+							fmt.Errorf("mapUsers has more than one entry for %q, using the last one", key), key})
+						userMappings[i] = userMapping
+						continue
+					}
+					userKeys[key] = len(userMappings)
 					userMappings = append(userMappings, userMapping)
 				}
 			}
@@ -140,42 +784,81 @@ func ParseMap(m map[string]string) (userMappings []config.UserMapping, roleMappi
 	if roleData, ok := m["mapRoles"]; ok {
 		roleJson, err := utilyaml.ToJSON([]byte(roleData))
 		if err != nil {
-			errs = append(errs, err)
+			errs = append(errs, parseError{"mapRoles", parseErrorSyntax, err, ""})
 		} else {
 			err = json.Unmarshal(roleJson, &rawRoleMappings)
 			if err != nil {
-				errs = append(errs, err)
+				errs = append(errs, parseError{"mapRoles", parseErrorSyntax, err, ""})
 			}
 
 			for _, roleMapping := range rawRoleMappings {
 				err = roleMapping.Validate()
 				if err != nil {
-					errs = append(errs, err)
+					errs = append(errs, parseError{"mapRoles", parseErrorValidation, err, roleMapping.Key()})
 				} else {
+					roleMapping.CanonicalizeARN()
+					key := roleKey(roleMapping)
+					if i, ok := roleKeys[key]; ok {
+						errs = append(errs, parseError{"mapRoles", parseErrorDuplicate,
+							fmt.Errorf("mapRoles has more than one entry for %q, using the last one", key), key})
+						roleMappings[i] = roleMapping
+						continue
+					}
+					roleKeys[key] = len(roleMappings)
 					roleMappings = append(roleMappings, roleMapping)
 				}
 			}
 		}
 	}
 
+	rawAWSAccounts := make([]string, 0)
 	awsAccounts = make([]string, 0)
 	if accountsData, ok := m["mapAccounts"]; ok {
-		err := yaml.Unmarshal([]byte(accountsData), &awsAccounts)
+		// yaml.v2 keeps the original text of unquoted numbers decoded into
+		// strings, so account IDs with leading zeros are preserved.
+		err := yaml.Unmarshal([]byte(accountsData), &rawAWSAccounts)
 		if err != nil {
-			errs = append(errs, err)
+			errs = append(errs, parseError{"mapAccounts", parseErrorSyntax, err, ""})
 		}
-	}
 
-	if len(errs) > 0 {
-		logrus.Warnf("Errors parsing configmap: %+v", errs)
-		err = ErrParsingMap{errors: errs}
+		for _, awsAccount := range rawAWSAccounts {
+			if arn.IsAccountPattern(awsAccount) {
+				if _, err := arn.CompileAccountPattern(awsAccount); err != nil {
+					errs = append(errs, parseError{"mapAccounts", parseErrorValidation, err, awsAccount})
+				} else {
+					awsAccounts = append(awsAccounts, awsAccount)
+				}
+			} else if !accountIDRegexp.MatchString(awsAccount) {
+				errs = append(errs, parseError{"mapAccounts", parseErrorValidation,
+					fmt.Errorf("mapAccounts entry %q is not a 12 digit AWS account ID", awsAccount), awsAccount})
+			} else {
+				awsAccounts = append(awsAccounts, awsAccount)
+			}
+		}
 	}
-	return userMappings, roleMappings, awsAccounts, err
+
+	return ParsedMap{UserMappings: userMappings, RoleMappings: roleMappings, AWSAccounts: awsAccounts}, errs
 }
 
-func EncodeMap(userMappings []config.UserMapping, roleMappings []config.RoleMapping, awsAccounts []string) (m map[string]string, err error) {
+// EncodeMap encodes mappings as configmap data. The output is
+// deterministic: users, roles and accounts are sorted by their key, so the
+// same mappings always encode to the same data whatever order they are in.
+// Every kind of entry round trips: ParseMap of the result returns the same
+// mappings in that sorted order, and empty sections are left out.
+func EncodeMap(parsed ParsedMap) (m map[string]string, err error) {
 	m = make(map[string]string)
 
+	userMappings := append([]config.UserMapping(nil), parsed.UserMappings...)
+	sort.SliceStable(userMappings, func(i, j int) bool {
+		return userKey(userMappings[i]) < userKey(userMappings[j])
+	})
+	roleMappings := append([]config.RoleMapping(nil), parsed.RoleMappings...)
+	sort.SliceStable(roleMappings, func(i, j int) bool {
+		return roleKey(roleMappings[i]) < roleKey(roleMappings[j])
+	})
+	awsAccounts := append([]string(nil), parsed.AWSAccounts...)
+	sort.Strings(awsAccounts)
+
 	if len(userMappings) > 0 {
 		body, err := yaml.Marshal(userMappings)
 		if err != nil {
@@ -208,21 +891,359 @@ func (ms *MapStore) saveMap(
 	roleMappings []config.RoleMapping,
 	awsAccounts []string) {
 
+	m := &mappings{ignorePath: ms.ignorePath}
+	m.setUsers(userMappings, ms.log())
+	m.setRoles(roleMappings, ms.log())
+	m.setAWSAccounts(awsAccounts, ms.log())
+
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
-	ms.users = make(map[string]config.UserMapping)
-	ms.roles = make(map[string]config.RoleMapping)
-	ms.awsAccounts = make(map[string]interface{})
+	ms.store(m)
+}
+
+// load returns the currently loaded mappings. The result must not be
+// modified.
+func (ms *MapStore) load() *mappings {
+	if m := ms.current.Load(); m != nil {
+		return m
+	}
+	return emptyMappings
+}
+
+// store swaps in m as the loaded mappings. Callers must hold the lock.
+func (ms *MapStore) store(m *mappings) {
+	ms.current.Store(m)
+	ms.cache.purge()
+	ms.recordMappingsLoaded(m)
+	if ms.requireAccountAllowed {
+		ms.checkAccountsAllowed(m)
+	}
+}
 
+func (m *mappings) setUsers(userMappings []config.UserMapping, log Logger) {
+	m.users = make(map[string]config.UserMapping)
 	for _, user := range userMappings {
-		ms.users[user.Key()] = user
+		m.users[userKey(user)] = user
 	}
+	m.orderUsers(log)
+}
+
+func (m *mappings) setRoles(roleMappings []config.RoleMapping, log Logger) {
+	m.roles = make(map[string]config.RoleMapping)
 	for _, role := range roleMappings {
-		ms.roles[role.Key()] = role
+		m.roles[roleKey(role)] = role
 	}
+	m.orderRoles(log)
+}
+
+func (m *mappings) setAWSAccounts(awsAccounts []string, log Logger) {
+	m.awsAccounts = make(map[string]interface{})
+	m.accountPatterns = nil
 	for _, awsAccount := range awsAccounts {
-		ms.awsAccounts[awsAccount] = nil
+		if !arn.IsAccountPattern(awsAccount) {
+			m.awsAccounts[awsAccount] = nil
+			continue
+		}
+		pattern, err := arn.CompileAccountPattern(awsAccount)
+		if err != nil {
+			log.Errorf("Ignoring mapAccounts entry: %v", err)
+			continue
+		}
+		m.accountPatterns = append(m.accountPatterns, pattern)
+	}
+}
+
+// Kinds of mappings reported by the mappings loaded metric.
+const (
+	mappingKindUser    = "user"
+	mappingKindRole    = "role"
+	mappingKindSSORole = "ssoRole"
+	mappingKindAccount = "account"
+)
+
+// recordMappingsLoaded updates the mappings loaded gauges from m.
+func (ms *MapStore) recordMappingsLoaded(m *mappings) {
+	var roles, ssoRoles float64
+	for _, role := range m.roles {
+		if role.SSO != nil {
+			ssoRoles++
+		} else {
+			roles++
+		}
+	}
+	loaded := ms.stats().ConfigMapMappingsLoaded
+	loaded.WithLabelValues(mappingKindUser).Set(float64(len(m.users)))
+	loaded.WithLabelValues(mappingKindRole).Set(roles)
+	loaded.WithLabelValues(mappingKindSSORole).Set(ssoRoles)
+	loaded.WithLabelValues(mappingKindAccount).Set(float64(len(m.awsAccounts) + len(m.accountPatterns)))
+}
+
+// checkAccountsAllowed warns about every role and user mapping of m pinned
+// to an account that isn't in mapAccounts, and counts them. Such mappings
+// load, but IsAccountAllowed won't allow their identities' accounts.
+func (ms *MapStore) checkAccountsAllowed(m *mappings) {
+	notAllowed := ms.stats().ConfigMapAccountNotAllowed
+	var roles, users float64
+	for _, accountID := range sortedKeys(indexedAccounts(m.roleIndex)) {
+		if m.allowsAccount(accountID) {
+			continue
+		}
+		for _, i := range m.roleIndex.byAccount[accountID] {
+			ms.log().Warnf("%s configmap maps role %s in account %s, which is not in mapAccounts", ms.name, m.orderedRoles[i].Key(), accountID)
+			roles++
+		}
+	}
+	for _, accountID := range sortedKeys(indexedAccounts(m.userIndex)) {
+		if m.allowsAccount(accountID) {
+			continue
+		}
+		for _, i := range m.userIndex.byAccount[accountID] {
+			ms.log().Warnf("%s configmap maps user %s in account %s, which is not in mapAccounts", ms.name, m.orderedUsers[i].Key(), accountID)
+			users++
+		}
+	}
+	notAllowed.WithLabelValues(mappingKindRole).Set(roles)
+	notAllowed.WithLabelValues(mappingKindUser).Set(users)
+}
+
+// checkGroups adds a parseError to err, the error returned by ParseMap,
+// for every one of the parsed mappings that grants a group the group policy
+// doesn't allow, so its section is rejected. If warnDisallowedGroups is set
+// the mappings are only warned about and err is returned unchanged. Groups
+// are compared as written, before any templates in them are rendered.
+func (ms *MapStore) checkGroups(cm *core_v1.ConfigMap, userMappings []config.UserMapping, roleMappings []config.RoleMapping, roleTagMappings []config.RoleTagMapping, err error) error {
+	if len(ms.allowedGroups) == 0 && len(ms.disallowedGroups) == 0 {
+		return err
+	}
+	var disallowed []error
+	for _, role := range roleMappings {
+		if group, ok := ms.disallowedGroup(role.Groups); ok {
+			disallowed = append(disallowed, parseError{"mapRoles", parseErrorDisallowedGroup,
+				fmt.Errorf("mapRoles entry %q grants group %q, which is not allowed", role.Key(), group), role.Key()})
+		}
+	}
+	for _, user := range userMappings {
+		if group, ok := ms.disallowedGroup(user.Groups); ok {
+			disallowed = append(disallowed, parseError{"mapUsers", parseErrorDisallowedGroup,
+				fmt.Errorf("mapUsers entry %q grants group %q, which is not allowed", user.Key(), group), user.Key()})
+		}
+	}
+	for _, roleTag := range roleTagMappings {
+		if group, ok := ms.disallowedGroup(roleTag.Groups); ok {
+			disallowed = append(disallowed, parseError{"mapRoleTags", parseErrorDisallowedGroup,
+				fmt.Errorf("mapRoleTags entry %q grants group %q, which is not allowed", roleTag.Key(), group), roleTag.Key()})
+		}
+	}
+	if len(disallowed) == 0 {
+		return err
+	}
+	if ms.warnDisallowedGroups {
+		for _, e := range disallowed {
+			ms.log().Warnf("%s configmap %v", ms.name, e)
+			if ms.recorder != nil {
+				ms.recorder.Eventf(cm, core_v1.EventTypeWarning, ReasonDisallowedGroup, "%v", e)
+			}
+		}
+		return err
 	}
+	return appendParseErrors(err, disallowed)
+}
+
+// appendParseErrors returns err, the error returned by ParseMap, with errs
+// added to it.
+func appendParseErrors(err error, errs []error) error {
+	var parseErrs ErrParsingMap
+	errors.As(err, &parseErrs)
+	parseErrs.errors = append(parseErrs.errors, errs...)
+	return parseErrs
+}
+
+// disallowedGroup returns the first of groups that mappings may not grant.
+func (ms *MapStore) disallowedGroup(groups []string) (string, bool) {
+	for _, group := range groups {
+		if ms.disallowedGroups[group] || (len(ms.allowedGroups) > 0 && !ms.allowedGroups[group]) {
+			return group, true
+		}
+	}
+	return "", false
+}
+
+// indexedAccounts returns the accounts idx has mappings pinned to.
+func indexedAccounts(idx accountIndex) map[string]bool {
+	accounts := make(map[string]bool, len(idx.byAccount))
+	for accountID := range idx.byAccount {
+		accounts[accountID] = true
+	}
+	return accounts
+}
+
+// roleKey is the key of role in mappings.roles, see
+// config.RoleMapping.MatchKey.
+func roleKey(role config.RoleMapping) string {
+	return role.MatchKey()
+}
+
+// userKey is the key of user in mappings.users, see
+// config.UserMapping.MatchKey.
+func userKey(user config.UserMapping) string {
+	return user.MatchKey()
+}
+
+// exactARN returns the form of a case normalized ARN that exact mappings
+// are compared in, without its path if ignorePath is set.
+func (m *mappings) exactARN(normalized string) string {
+	if m.ignorePath {
+		return arn.StripPath(normalized)
+	}
+	return normalized
+}
+
+// orderUsers rebuilds orderedUsers, userARNs, userRegexps and userIndex
+// from users. It must only be called while m is being built.
+func (m *mappings) orderUsers(log Logger) {
+	m.orderedUsers = make([]config.UserMapping, 0, len(m.users))
+	for _, user := range m.users {
+		m.orderedUsers = append(m.orderedUsers, user)
+	}
+	sort.Slice(m.orderedUsers, func(i, j int) bool {
+		a, b := m.orderedUsers[i], m.orderedUsers[j]
+		if exactA, exactB := a.UserARNRegex == "", b.UserARNRegex == ""; exactA != exactB {
+			return exactA
+		}
+		// a userarn for one partition before one for every partition
+		if scoreA, scoreB := arn.Specificity(userKey(a)), arn.Specificity(userKey(b)); a.UserARNRegex == "" && scoreA != scoreB {
+			return scoreA > scoreB
+		}
+		return userKey(a) < userKey(b)
+	})
+
+	m.userARNs = make([]string, len(m.orderedUsers))
+	m.userRegexps = make([]*regexp.Regexp, len(m.orderedUsers))
+	m.userIndex = accountIndex{}
+	for i, user := range m.orderedUsers {
+		m.userARNs[i] = m.exactARN(arn.NormalizeCase(user.UserARN))
+		if user.UserARNRegex != "" {
+			re, err := user.CompileRegex()
+			if err != nil {
+				log.Errorf("Could not compile regex for user mapping %s: %v", user.Key(), err)
+			}
+			m.userRegexps[i] = re
+			m.userIndex.add(i, "", false)
+			continue
+		}
+		accountID, ok := arn.AccountID(user.UserARN)
+		m.userIndex.add(i, accountID, ok)
+	}
+}
+
+// orderRoles rebuilds orderedRoles, orderedPatterns, orderedRegexps,
+// roleARNs and roleIndex from roles. It must only be called while m is
+// being built.
+func (m *mappings) orderRoles(log Logger) {
+	m.orderedRoles = make([]config.RoleMapping, 0, len(m.roles))
+	for _, role := range m.roles {
+		m.orderedRoles = append(m.orderedRoles, role)
+	}
+	config.SortRoleMappings(m.orderedRoles)
+
+	m.orderedPatterns = make([]*arn.CompiledPattern, len(m.orderedRoles))
+	m.orderedRegexps = make([]*regexp.Regexp, len(m.orderedRoles))
+	for i, role := range m.orderedRoles {
+		re, err := role.CompileRegex()
+		if err != nil {
+			log.Errorf("Could not compile regex for role mapping %s: %v", role.Key(), err)
+		}
+		m.orderedRegexps[i] = re
+		if role.SSO == nil {
+			continue
+		}
+		pattern, err := arn.CompilePattern(role.SSOArnLike())
+		if err != nil {
+			log.Errorf("Could not compile pattern for role mapping %s: %v", role.Key(), err)
+			continue
+		}
+		m.orderedPatterns[i] = pattern
+	}
+
+	m.roleARNs = make([]string, len(m.orderedRoles))
+	m.roleIndex = accountIndex{}
+	for i, role := range m.orderedRoles {
+		m.roleARNs[i] = m.exactARN(arn.NormalizeCase(role.RoleARN))
+		switch {
+		case role.RoleARN != "":
+			accountID, ok := arn.AccountID(role.RoleARN)
+			m.roleIndex.add(i, accountID, ok)
+		case role.SSO != nil:
+			m.roleIndex.add(i, role.SSO.AccountID, true)
+		default:
+			m.roleIndex.add(i, "", false)
+		}
+	}
+}
+
+// saveParsedMap is like saveMap, but keeps the currently loaded mappings for
+// any section that ParseMap reported an error for, so a bad entry in one
+// section doesn't drop previously valid mappings.
+func (ms *MapStore) saveParsedMap(
+	userMappings []config.UserMapping,
+	roleMappings []config.RoleMapping,
+	awsAccounts []string,
+	roleTagMappings []config.RoleTagMapping,
+	err error) {
+
+	failed := failedSections(err)
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	// failed sections share the previous, never modified, data.
+	m := *ms.load()
+	m.ignorePath = ms.ignorePath
+	if !failed["mapUsers"] {
+		m.setUsers(userMappings, ms.log())
+	}
+	if !failed["mapRoles"] {
+		m.setRoles(roleMappings, ms.log())
+	}
+	if !failed["mapAccounts"] {
+		m.setAWSAccounts(awsAccounts, ms.log())
+	}
+	if !failed["mapRoleTags"] {
+		m.roleTags = roleTagMappings
+	}
+	ms.store(&m)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// failedSections returns the configmap sections that ParseMap could not
+// fully load, going by the error it returned.
+func failedSections(err error) map[string]bool {
+	return sectionsWithErrors(err, false)
+}
+
+// errorSections returns the configmap sections that had any errors in the
+// error returned by ParseMap, including those that were still loaded.
+func errorSections(err error) map[string]bool {
+	return sectionsWithErrors(err, true)
+}
+
+func sectionsWithErrors(err error, duplicates bool) map[string]bool {
+	sections := make(map[string]bool)
+	var parseErrs ErrParsingMap
+	if errors.As(err, &parseErrs) {
+		for _, e := range parseErrs.errors {
+			if pe, ok := e.(parseError); ok && (duplicates || pe.errorType != parseErrorDuplicate) {
+				sections[pe.section] = true
+			}
+		}
+	}
+	return sections
 }
 
 // UserNotFound is the error returned when the user is not found in the config map.
@@ -232,30 +1253,229 @@ var UserNotFound = errors.New("User not found in configmap")
 var RoleNotFound = errors.New("Role not found in configmap")
 
 func (ms *MapStore) UserMapping(arn string) (config.UserMapping, error) {
-	ms.mutex.RLock()
-	defer ms.mutex.RUnlock()
-	for _, user := range ms.users {
-		if user.Matches(arn) {
-			return user, nil
+	return ms.userMapping(arn, false)
+}
+
+// RoleMapping returns the mapping for the IAM role arn. An STS assumed-role
+// ARN is looked up as the ARN of its role.
+func (ms *MapStore) RoleMapping(subject string) (config.RoleMapping, error) {
+	if roleARN, _, ok := arn.AssumedRole(subject); ok {
+		subject = roleARN
+	}
+	return ms.roleMapping(subject, false)
+}
+
+// userMapping looks up subject in either the RawMatch mappings or the
+// canonical ones. Only mappings for the account of subject are considered.
+//
+// Exact ARNs are compared with the case of their resource preserved, as IAM
+// does, so user/Bob does not match user/bob. userarnregex mappings ignore
+// case.
+func (ms *MapStore) userMapping(subject string, raw bool) (config.UserMapping, error) {
+	m, subject := ms.load(), arn.NormalizeCase(subject)
+	var found *config.UserMapping
+	if raw {
+		found = m.findUser(subject, mapper.LookupRawUser)
+	} else if found = m.findUser(subject, mapper.LookupUser); found == nil {
+		found = m.findUser(subject, mapper.LookupUserPattern)
+	}
+	if found == nil {
+		return config.UserMapping{}, UserNotFound
+	}
+	return *found, nil
+}
+
+// findUser returns the mapping matching a case normalized subject among
+// those in lookup, or nil if none match. It is a mapper.UserLookup.
+func (m *mappings) findUser(subject, lookup string) *config.UserMapping {
+	lower, exact := strings.ToLower(subject), m.exactARN(subject)
+	var found *config.UserMapping
+	m.userIndex.each(subject, len(m.orderedUsers), func(i int) bool {
+		user := &m.orderedUsers[i]
+		if mapper.UserLookupKind(user) != lookup {
+			return false
 		}
+		if m.userMatches(i, lower, exact) {
+			found = user
+			return true
+		}
+		return false
+	})
+	return found
+}
+
+// userMatches returns true if orderedUsers[i] matches the lowercased
+// subject or its exactARN.
+func (m *mappings) userMatches(i int, lower, exact string) bool {
+	user := &m.orderedUsers[i]
+	return user.UserARN != "" && arn.MatchesExact(m.userARNs[i], exact) || user.UserARNRegex != "" && user.MatchesCompiled(lower, m.userRegexps[i])
+}
+
+// roleMapping looks up subject in either the mappings matched against the
+// raw ARN, see config.RoleMapping.MatchesRawARN, or the canonical ones.
+// When several mappings match, the most specific one wins. Only mappings
+// for the account of subject are considered.
+//
+// Exact ARNs are compared with the case of their resource preserved, as IAM
+// does, so role/Foo does not match role/foo. SSO and rolearnregex mappings
+// ignore case.
+func (ms *MapStore) roleMapping(subject string, raw bool) (config.RoleMapping, error) {
+	m, subject := ms.load(), arn.NormalizeCase(subject)
+	var found *config.RoleMapping
+	if raw {
+		found = m.findRole(subject, mapper.LookupRawRole)
+	} else if found = m.findRole(subject, mapper.LookupRole); found == nil {
+		found = m.findRole(subject, mapper.LookupRolePattern)
 	}
-	return config.UserMapping{}, UserNotFound
+	if found == nil {
+		return config.RoleMapping{}, RoleNotFound
+	}
+	return *found, nil
 }
 
-func (ms *MapStore) RoleMapping(arn string) (config.RoleMapping, error) {
-	ms.mutex.RLock()
-	defer ms.mutex.RUnlock()
-	for _, role := range ms.roles {
-		if role.Matches(arn) {
-			return role, nil
+// findRole returns the mapping matching a case normalized subject among
+// those in lookup, or nil if none match. It is a mapper.RoleLookup.
+func (m *mappings) findRole(subject, lookup string) *config.RoleMapping {
+	lower, exact := strings.ToLower(subject), m.exactARN(subject)
+	var found *config.RoleMapping
+	m.roleIndex.each(subject, len(m.orderedRoles), func(i int) bool {
+		role := &m.orderedRoles[i]
+		if mapper.RoleLookupKind(role) != lookup {
+			return false
+		}
+		matched := m.roleMatches(i, subject, lower, exact)
+		if matched {
+			found = role
 		}
+		return matched
+	})
+	return found
+}
+
+// roleMatches returns true if orderedRoles[i] matches the case normalized
+// subject, its lowercased form or its exactARN.
+func (m *mappings) roleMatches(i int, subject, lower, exact string) bool {
+	role := &m.orderedRoles[i]
+	switch {
+	case role.SessionNameLike != "":
+		return role.MatchesCompiled(subject, nil, m.orderedRegexps[i])
+	case role.RoleARN != "":
+		return arn.MatchesExact(m.roleARNs[i], exact)
+	default:
+		return role.MatchesCompiled(lower, m.orderedPatterns[i], m.orderedRegexps[i])
 	}
-	return config.RoleMapping{}, RoleNotFound
 }
 
+// MatchAll returns every role and user mapping that matches subject, exact
+// and pattern mappings alike, in the order they are tried. Unlike
+// RoleMapping and UserMapping it doesn't stop at the first match, so
+// overlapping mappings can be found. RawMatch mappings are included,
+// matched against subject as given.
+func (ms *MapStore) MatchAll(subject string) ([]config.RoleMapping, []config.UserMapping) {
+	m, subject := ms.load(), arn.NormalizeCase(subject)
+	lower, exact := strings.ToLower(subject), m.exactARN(subject)
+	var roles []config.RoleMapping
+	m.roleIndex.each(subject, len(m.orderedRoles), func(i int) bool {
+		if m.roleMatches(i, subject, lower, exact) {
+			roles = append(roles, m.orderedRoles[i])
+		}
+		return false
+	})
+	var users []config.UserMapping
+	m.userIndex.each(subject, len(m.orderedUsers), func(i int) bool {
+		if m.userMatches(i, lower, exact) {
+			users = append(users, m.orderedUsers[i])
+		}
+		return false
+	})
+	return roles, users
+}
+
+// AWSAccount returns true if id is listed in mapAccounts, either exactly or
+// by matching one of its patterns.
 func (ms *MapStore) AWSAccount(id string) bool {
-	ms.mutex.RLock()
-	defer ms.mutex.RUnlock()
-	_, ok := ms.awsAccounts[id]
-	return ok
+	return ms.load().allowsAccount(id)
+}
+
+// allowsAccount returns true if id is listed in the mapAccounts of m.
+func (m *mappings) allowsAccount(id string) bool {
+	if _, ok := m.awsAccounts[id]; ok {
+		return true
+	}
+	for _, pattern := range m.accountPatterns {
+		if pattern.Match(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// mapsAccount returns true if any role or user mapping is pinned to the
+// account id.
+func (m *mappings) mapsAccount(id string) bool {
+	return len(m.roleIndex.byAccount[id]) > 0 || len(m.userIndex.byAccount[id]) > 0
+}
+
+// Snapshot is a copy of every mapping currently loaded in a MapStore.
+type Snapshot struct {
+	Users       []config.UserMapping
+	Roles       []config.RoleMapping
+	AWSAccounts []string
+	RoleTags    []config.RoleTagMapping
+}
+
+// Snapshot returns a copy of the currently loaded mappings. It never
+// reflects a partial update. Roles and role tags are in the order they are
+// matched in; users and accounts are sorted.
+func (ms *MapStore) Snapshot() Snapshot {
+	m := ms.load()
+	snapshot := Snapshot{
+		Users:       make([]config.UserMapping, 0, len(m.users)),
+		Roles:       make([]config.RoleMapping, 0, len(m.orderedRoles)),
+		AWSAccounts: make([]string, 0, len(m.awsAccounts)+len(m.accountPatterns)),
+		RoleTags:    make([]config.RoleTagMapping, 0, len(m.roleTags)),
+	}
+	for _, user := range m.users {
+		user.Groups = append([]string(nil), user.Groups...)
+		user.Annotations = copyAnnotations(user.Annotations)
+		snapshot.Users = append(snapshot.Users, user)
+	}
+	sort.Slice(snapshot.Users, func(i, j int) bool {
+		return snapshot.Users[i].Key() < snapshot.Users[j].Key()
+	})
+	for _, role := range m.orderedRoles {
+		role.Groups = append([]string(nil), role.Groups...)
+		role.Annotations = copyAnnotations(role.Annotations)
+		if role.SSO != nil {
+			sso := *role.SSO
+			role.SSO = &sso
+		}
+		snapshot.Roles = append(snapshot.Roles, role)
+	}
+	for account := range m.awsAccounts {
+		snapshot.AWSAccounts = append(snapshot.AWSAccounts, account)
+	}
+	for _, pattern := range m.accountPatterns {
+		snapshot.AWSAccounts = append(snapshot.AWSAccounts, pattern.String())
+	}
+	sort.Strings(snapshot.AWSAccounts)
+	for _, roleTag := range m.roleTags {
+		roleTag.Groups = append([]string(nil), roleTag.Groups...)
+		roleTag.Annotations = copyAnnotations(roleTag.Annotations)
+		snapshot.RoleTags = append(snapshot.RoleTags, roleTag)
+	}
+	return snapshot
+}
+
+// copyAnnotations returns a copy of the annotations of a mapping, nil if it
+// has none.
+func copyAnnotations(annotations map[string]string) map[string]string {
+	if annotations == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		copied[k] = v
+	}
+	return copied
 }
@@ -1,22 +1,35 @@
 package configmap
 
 import (
+	"context"
+	"errors"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	core_v1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/kubernetes/typed/core/v1/fake"
 	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/metrics"
 )
 
 func init() {
 	config.SSORoleMatchEnabled = true
+	metrics.InitMetrics(prometheus.NewRegistry())
 }
 
 var (
@@ -33,15 +46,19 @@ var (
 )
 
 func makeStore() MapStore {
-	ms := MapStore{
+	m := &mappings{
 		users:       make(map[string]config.UserMapping),
 		roles:       make(map[string]config.RoleMapping),
 		awsAccounts: make(map[string]interface{}),
 	}
-	ms.users["arn:aws:iam::012345678912:user/matt"] = testUser
-	ms.roles["arn:aws:iam::012345678912:role/awsreservedsso_viewonlyaccess_*"] = testSSORole
-	ms.roles["arn:aws:iam::012345678912:role/comp*"] = testRole
-	ms.awsAccounts["123"] = nil
+	m.users["arn:aws:iam::012345678912:user/matt"] = testUser
+	m.roles["arn:aws:iam::012345678912:role/awsreservedsso_viewonlyaccess_*"] = testSSORole
+	m.roles["arn:aws:iam::012345678912:role/comp*"] = testRole
+	m.awsAccounts["111122223333"] = nil
+	m.orderUsers(defaultLogger)
+	m.orderRoles(defaultLogger)
+	ms := MapStore{}
+	ms.current.Store(m)
 	return ms
 }
 
@@ -50,9 +67,8 @@ func makeStoreWClient() (MapStore, *fake.FakeConfigMaps) {
 	fakeConfigMaps.Fake = &fake.FakeCoreV1{}
 	fakeConfigMaps.Fake.Fake = &k8stesting.Fake{}
 	ms := MapStore{
-		users:     make(map[string]config.UserMapping),
-		roles:     make(map[string]config.RoleMapping),
 		configMap: v1.ConfigMapInterface(fakeConfigMaps),
+		name:      DefaultName,
 	}
 	return ms, fakeConfigMaps
 }
@@ -106,13 +122,108 @@ func TestSSORoleMapping(t *testing.T) {
 	}
 }
 
+func TestOverlappingSSORoleMapping(t *testing.T) {
+	admin := config.RoleMapping{
+		SSO:      &config.SSOARNMatcher{PermissionSetName: "Admin", AccountID: "012345678912"},
+		Username: "admin",
+		Groups:   []string{"system:masters"},
+	}
+	readOnly := config.RoleMapping{
+		SSO:      &config.SSOARNMatcher{PermissionSetName: "Admin_ReadOnly", AccountID: "012345678912"},
+		Username: "admin-readonly",
+		Groups:   []string{"viewers"},
+	}
+
+	// map iteration order is random, so load repeatedly to catch flapping
+	for i := 0; i < 20; i++ {
+		ms, _ := makeStoreWClient()
+		ms.saveMap(nil, []config.RoleMapping{admin, readOnly}, nil)
+		role, err := ms.RoleMapping("arn:aws:iam::012345678912:role/awsreservedsso_admin_readonly_0123456789abcdef")
+		if err != nil {
+			t.Fatalf("Could not find a match for the Admin_ReadOnly role: %v", err)
+		}
+		if role.Username != readOnly.Username {
+			t.Fatalf("Expected the more specific Admin_ReadOnly mapping, got %+v", role)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	user, role := testUser, testRole
+	user.Annotations = map[string]string{"owner": "team-a"}
+	role.Annotations = map[string]string{"owner": "team-b"}
+	roleTag := config.RoleTagMapping{TagKey: "team", TagValue: "payments", Username: "payments", Groups: []string{"payments"}}
+	ms := &MapStore{}
+	ms.saveParsedMap([]config.UserMapping{user}, []config.RoleMapping{role, testSSORole}, []string{"111122223333"}, []config.RoleTagMapping{roleTag}, nil)
+
+	snapshot := ms.Snapshot()
+	if len(snapshot.Users) != 1 || len(snapshot.Roles) != 2 || len(snapshot.AWSAccounts) != 1 || len(snapshot.RoleTags) != 1 {
+		t.Fatalf("Snapshot does not contain the loaded mappings: %+v", snapshot)
+	}
+	if !reflect.DeepEqual(snapshot.Users[0], user) {
+		t.Errorf("Snapshot user does not match expected value. (Actual: %+v, Expected: %+v", snapshot.Users[0], user)
+	}
+
+	snapshot.Users[0].Username = "mutated"
+	snapshot.Users[0].Groups[0] = "mutated"
+	snapshot.Users[0].Annotations["owner"] = "mutated"
+	snapshot.Roles[0].Groups[0] = "mutated"
+	snapshot.Roles[0].Annotations["owner"] = "mutated"
+	if !reflect.DeepEqual(snapshot.RoleTags[0], roleTag) {
+		t.Errorf("Snapshot role tag does not match expected value. (Actual: %+v, Expected: %+v", snapshot.RoleTags[0], roleTag)
+	}
+
+	snapshot.Roles[1].SSO.AccountID = "mutated"
+	snapshot.AWSAccounts[0] = "mutated"
+	snapshot.RoleTags[0].Groups[0] = "mutated"
+
+	if actual, _ := ms.UserMapping("arn:aws:iam::012345678912:user/matt"); !reflect.DeepEqual(actual, user) || actual.Annotations["owner"] != "team-a" {
+		t.Errorf("Mutating the snapshot changed user 'matt': %+v", actual)
+	}
+	if actual, _ := ms.RoleMapping("arn:aws:iam::012345678912:role/computer"); !reflect.DeepEqual(actual, role) || actual.Annotations["owner"] != "team-b" {
+		t.Errorf("Mutating the snapshot changed role 'computer': %+v", actual)
+	}
+	if role, _ := ms.RoleMapping("arn:aws:iam::012345678912:role/awsreservedsso_viewonlyaccess_123123123"); !reflect.DeepEqual(role, testSSORole) {
+		t.Errorf("Mutating the snapshot changed the SSO role: %+v", role)
+	}
+	if !ms.AWSAccount("111122223333") {
+		t.Errorf("Mutating the snapshot removed account '111122223333'")
+	}
+	if groups := ms.load().roleTags[0].Groups; groups[0] != "payments" {
+		t.Errorf("Mutating the snapshot changed the role tag mapping: %v", groups)
+	}
+}
+
 func TestAWSAccount(t *testing.T) {
 	ms := makeStore()
-	if !ms.AWSAccount("123") {
-		t.Errorf("Expected aws account '123' to be in accounts list: %v", ms.awsAccounts)
+	if !ms.AWSAccount("111122223333") {
+		t.Errorf("Expected aws account '111122223333' to be in accounts list: %v", ms.load().awsAccounts)
+	}
+	if ms.AWSAccount("222233334444") {
+		t.Errorf("Did not expect account '222233334444' to be in accounts list: %v", ms.load().awsAccounts)
+	}
+}
+
+func TestAWSAccountPatterns(t *testing.T) {
+	ms := &MapStore{}
+	ms.saveMap(nil, nil, []string{"111122223333", "01234567*", "4444555566?6"})
+
+	cases := map[string]bool{
+		"111122223333": true,
+		"012345670000": true,
+		"012345679999": true,
+		"444455556616": true,
+		"444455556617": false,
+		"112345670000": false,
+		"222233334444": false,
 	}
-	if ms.AWSAccount("345") {
-		t.Errorf("Did not expect account '345' to be in accounts list: %v", ms.awsAccounts)
+	for account, allowed := range cases {
+		if ms.AWSAccount(account) != allowed {
+			t.Errorf("expected AWSAccount(%s) to be %v", account, allowed)
+		}
+	}
+	if accounts := ms.Snapshot().AWSAccounts; !reflect.DeepEqual(accounts, []string{"01234567*", "111122223333", "4444555566?6"}) {
+		t.Errorf("expected the snapshot to include the patterns, got %v", accounts)
 	}
 }
 
@@ -132,7 +243,7 @@ var userMapping = `
 
 var roleMapping = `
 - rolearn: "arn:iam:123:role/me"
-  username: "{{Session}}"
+  username: "{{SessionName}}"
   groups:
     - system:nodes
 `
@@ -154,7 +265,7 @@ var updatedUserMapping = `
 
 var updatedRoleMapping = `
 - rolearn: "arn:iam:123:role/me"
-  username: "{{Session}}"
+  username: "{{SessionName}}"
   groups:
     - system:nodes
 - rolearn: "arn:iam:123:role/you"
@@ -164,12 +275,12 @@ var updatedRoleMapping = `
 `
 
 var autoMappedAWSAccountsYAML = `
-- 123
-- 345
+- 111122223333
+- 222233334444
 `
 
 var updatedAWSAccountsYAML = `
-- 567
+- 333344445555
 `
 
 func TestLoadConfigMap(t *testing.T) {
@@ -198,12 +309,12 @@ func TestLoadConfigMap(t *testing.T) {
 
 	time.Sleep(2 * time.Second)
 
-	if !ms.AWSAccount("123") {
-		t.Errorf("AWS Account '123' not in allowed accounts")
+	if !ms.AWSAccount("111122223333") {
+		t.Errorf("AWS Account '111122223333' not in allowed accounts")
 	}
 
-	if !ms.AWSAccount("345") {
-		t.Errorf("AWS Account '345' not in allowed accounts")
+	if !ms.AWSAccount("222233334444") {
+		t.Errorf("AWS Account '222233334444' not in allowed accounts")
 	}
 
 	expectedUser := config.UserMapping{
@@ -229,12 +340,12 @@ func TestLoadConfigMap(t *testing.T) {
 	//TODO: Sync without using sleep
 	time.Sleep(10 * time.Millisecond)
 
-	if ms.AWSAccount("345") {
-		t.Errorf("AWS Account '345' is in map after update")
+	if ms.AWSAccount("222233334444") {
+		t.Errorf("AWS Account '222233334444' is in map after update")
 	}
 
-	if !ms.AWSAccount("567") {
-		t.Errorf("AWS Account '567' is not in map after update")
+	if !ms.AWSAccount("333344445555") {
+		t.Errorf("AWS Account '333344445555' is not in map after update")
 	}
 
 	expectedUser.Groups = append(expectedUser.Groups, "test")
@@ -261,20 +372,157 @@ func TestLoadConfigMap(t *testing.T) {
 
 }
 
+func TestLoadConfigMapKeepsLastGoodSection(t *testing.T) {
+	ms, fakeConfigMaps := makeStoreWClient()
+
+	watcher := watch.NewFake()
+
+	fakeConfigMaps.Fake.Fake.AddWatchReactor("configmaps",
+		func(action k8stesting.Action) (handled bool, ret watch.Interface, err error) {
+			return true, watcher, nil
+		})
+
+	stopCh := make(chan struct{})
+	ms.startLoadConfigMap(stopCh)
+	defer close(stopCh)
+
+	time.Sleep(2 * time.Millisecond)
+
+	meta := metav1.ObjectMeta{Name: "aws-auth"}
+	watcher.Add(&core_v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{
+		"mapUsers": userMapping,
+		"mapRoles": roleMapping,
+	}})
+
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := ms.RoleMapping("arn:iam:123:role/me"); err != nil {
+		t.Fatalf("Expected to find role 'me' but got error: %v", err)
+	}
+
+	watcher.Modify(&core_v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{
+		"mapUsers": updatedUserMapping,
+		"mapRoles": "- rolearn: [not, valid",
+	}})
+
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := ms.RoleMapping("arn:iam:123:role/me"); err != nil {
+		t.Errorf("Expected role 'me' to survive a corrupt mapRoles update, got error: %v", err)
+	}
+	if _, err := ms.UserMapping("arn:iam:beswar"); err != nil {
+		t.Errorf("Expected user 'beswar' from the valid mapUsers update, got error: %v", err)
+	}
+	if _, err := ms.UserMapping("arn:iam:matlan"); err != UserNotFound {
+		t.Errorf("Expected updated mapping not to contain user 'arn:iam:matlan', got err: %v", err)
+	}
+}
+
+func TestWatchConfigMapContextCancel(t *testing.T) {
+	ms, fakeConfigMaps := makeStoreWClient()
+
+	watcher := watch.NewFake()
+
+	fakeConfigMaps.Fake.Fake.AddWatchReactor("configmaps",
+		func(action k8stesting.Action) (handled bool, ret watch.Interface, err error) {
+			return true, watcher, nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ms.watchConfigMap(ctx)
+		close(done)
+	}()
+
+	watcher.Add(&core_v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "aws-auth"}, Data: map[string]string{"mapRoles": roleMapping}})
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected watch to exit after its context was cancelled")
+	}
+	if !watcher.IsStopped() {
+		t.Errorf("expected watch to be stopped after its context was cancelled")
+	}
+}
+
+func TestWatchBackoff(t *testing.T) {
+	ms, fakeConfigMaps := makeStoreWClient()
+
+	// fail three times, succeed once, then fail again after the watch closes
+	watcher := watch.NewFake()
+	attempts := 0
+	fakeConfigMaps.Fake.Fake.AddWatchReactor("configmaps",
+		func(action k8stesting.Action) (handled bool, ret watch.Interface, err error) {
+			attempts++
+			if attempts == 4 {
+				return true, watcher, nil
+			}
+			return true, nil, errors.New("apiserver unavailable")
+		})
+
+	delays := make(chan time.Duration, 10)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	sleeps := 0
+	ms.sleep = func(d time.Duration) {
+		sleeps++
+		delays <- d
+		if sleeps == 4 {
+			<-stopCh
+		}
+	}
+
+	failures := metrics.Get().ConfigMapWatchFailures
+	before := testutil.ToFloat64(failures)
+
+	ms.startLoadConfigMap(stopCh)
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	for i, base := range expected {
+		d := <-delays
+		if d < base || d > base+base/10 {
+			t.Errorf("attempt %d: expected delay in [%v, %v], got %v", i, base, base+base/10, d)
+		}
+	}
+
+	watcher.Stop()
+	if d := <-delays; d < time.Second || d > time.Second+time.Second/10 {
+		t.Errorf("expected backoff to reset after a successful watch, got %v", d)
+	}
+
+	if v := testutil.ToFloat64(failures) - before; v != 4 {
+		t.Errorf("expected 4 watch failures, got %v", v)
+	}
+}
+
+func TestWatchBackoffCap(t *testing.T) {
+	backoff := watchBackoff
+	var d time.Duration
+	for i := 0; i < 20; i++ {
+		d = backoff.Step()
+	}
+	if max := watchBackoff.Cap + watchBackoff.Cap/10; d > max {
+		t.Errorf("expected delay to be capped at %v, got %v", max, d)
+	}
+}
+
 func TestParseMap(t *testing.T) {
 	m1 := map[string]string{
-		"mapRoles": `- rolearn: arn:aws:iam::123456789101:role/test-NodeInstanceRole-1VWRHZ3GKZ1T4
-  username: system:node:{{EC2PrivateDNSName}}
-  groups:
-  - system:bootstrappers
-  - system:nodes
-- sso:
+		"mapRoles": `- sso:
     permissionSetName: ViewOnlyAccess
     accountID: "012345678912"
     partition: aws-cn
   username: user1
   groups:
   - system:basic-users
+- rolearn: arn:aws:iam::123456789101:role/test-NodeInstanceRole-1VWRHZ3GKZ1T4
+  username: system:node:{{EC2PrivateDNSName}}
+  groups:
+  - system:bootstrappers
+  - system:nodes
 `,
 		"mapUsers": `- userarn: arn:aws:iam::123456789101:user/Hello
   username: Hello
@@ -291,11 +539,6 @@ func TestParseMap(t *testing.T) {
 		{UserARN: "arn:aws:iam::123456789101:user/World", Username: "World", Groups: []string{"system:masters"}},
 	}
 	roleMappings := []config.RoleMapping{
-		{
-			RoleARN:  "arn:aws:iam::123456789101:role/test-NodeInstanceRole-1VWRHZ3GKZ1T4",
-			Username: "system:node:{{EC2PrivateDNSName}}",
-			Groups:   []string{"system:bootstrappers", "system:nodes"},
-		},
 		{
 			SSO: &config.SSOARNMatcher{
 				PermissionSetName: "ViewOnlyAccess",
@@ -305,25 +548,30 @@ func TestParseMap(t *testing.T) {
 			Username: "user1",
 			Groups:   []string{"system:basic-users"},
 		},
+		{
+			RoleARN:  "arn:aws:iam::123456789101:role/test-NodeInstanceRole-1VWRHZ3GKZ1T4",
+			Username: "system:node:{{EC2PrivateDNSName}}",
+			Groups:   []string{"system:bootstrappers", "system:nodes"},
+		},
 	}
 	accounts := []string{}
 
-	u, r, a, err := ParseMap(m1)
+	parsed, err := ParseMap(m1)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if !reflect.DeepEqual(u, userMappings) {
-		t.Fatalf("unexpected userMappings %+v", u)
+	if !reflect.DeepEqual(parsed.UserMappings, userMappings) {
+		t.Fatalf("unexpected userMappings %+v", parsed.UserMappings)
 	}
-	if !reflect.DeepEqual(r, roleMappings) {
-		t.Fatalf("unexpected roleMappings %+v", r)
+	if !reflect.DeepEqual(parsed.RoleMappings, roleMappings) {
+		t.Fatalf("unexpected roleMappings %+v", parsed.RoleMappings)
 	}
-	if !reflect.DeepEqual(a, accounts) {
-		t.Fatalf("unexpected accounts %+v", a)
+	if !reflect.DeepEqual(parsed.AWSAccounts, accounts) {
+		t.Fatalf("unexpected accounts %+v", parsed.AWSAccounts)
 	}
 
-	m2, err := EncodeMap(u, r, a)
+	m2, err := EncodeMap(parsed)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -331,3 +579,1252 @@ func TestParseMap(t *testing.T) {
 		t.Fatalf("unexpected %v != %v", m1, m2)
 	}
 }
+
+func TestEncodeParsedMap(t *testing.T) {
+	parsed := ParsedMap{
+		UserMappings: []config.UserMapping{testUser},
+		RoleMappings: []config.RoleMapping{testRole},
+		AWSAccounts:  []string{"012345678912"},
+	}
+	encoded, err := EncodeMap(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reparsed, err := ParseMap(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, reparsed) {
+		t.Errorf("expected %+v to round trip, got %+v", parsed, reparsed)
+	}
+
+	encoded, err = EncodeMap(ParsedMap{})
+	if err != nil || len(encoded) != 0 {
+		t.Errorf("expected an empty ParsedMap to encode to no data, got %v, %v", encoded, err)
+	}
+}
+
+func TestEncodeMapDeterministic(t *testing.T) {
+	users := []config.UserMapping{
+		{UserARN: "arn:aws:iam::123456789101:user/World", Username: "World", Groups: []string{"system:masters"}},
+		{UserARNRegex: "arn:aws:iam::123456789101:user/ci-.*", Username: "ci", Groups: []string{"ci"}},
+		{UserARN: "arn:aws:iam::123456789101:user/Hello", Username: "Hello", Groups: []string{"system:masters"}},
+	}
+	roles := []config.RoleMapping{
+		{RoleARN: "arn:aws:iam::123456789101:role/node", Username: "node", Groups: []string{"system:nodes"}},
+		testSSORole,
+		{RoleARN: "arn:aws:iam::123456789101:role/admin", Username: "admin", Groups: []string{"system:masters"}},
+	}
+	accounts := []string{"222233334444", "111122223333"}
+
+	first, err := EncodeMap(ParsedMap{UserMappings: users, RoleMappings: roles, AWSAccounts: accounts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := EncodeMap(ParsedMap{
+		UserMappings: []config.UserMapping{users[2], users[0], users[1]},
+		RoleMappings: []config.RoleMapping{roles[2], roles[1], roles[0]},
+		AWSAccounts:  []string{accounts[1], accounts[0]},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"mapUsers", "mapRoles", "mapAccounts"} {
+		if first[key] == "" || first[key] != second[key] {
+			t.Errorf("expected %s to encode identically in any order:\n%s\n%s", key, first[key], second[key])
+		}
+	}
+	if users[0].Username != "World" || roles[0].Username != "node" || accounts[0] != "222233334444" {
+		t.Error("expected EncodeMap not to reorder its arguments")
+	}
+	if !strings.HasPrefix(first["mapAccounts"], "- \"111122223333\"") {
+		t.Errorf("expected accounts to be sorted, got %s", first["mapAccounts"])
+	}
+}
+
+// TestEncodeMapRoundTrip checks that every kind of entry survives being
+// parsed, encoded and parsed again unchanged. The fixtures are written in the
+// order EncodeMap sorts entries in.
+func TestEncodeMapRoundTrip(t *testing.T) {
+	mapUsers := `
+- userarn: arn:aws:iam::012345678912:user/Alice
+  username: alice
+  groups: [admins]
+  annotations:
+    team: platform
+- userarnregex: arn:aws:iam::012345678912:user/ci-[0-9]+
+  username: ci
+  groups: [ci]
+`
+	mapRoles := `
+- rolearn: arn:aws:iam::012345678912:role/Admin
+  username: admin
+  groups: [admins]
+- rolearn: arn:aws:iam::012345678912:role/Admin
+  sessionnamelike: break-glass-*
+  username: break-glass
+  groups: [system:masters]
+- sso:
+    permissionSetName: ViewOnlyAccess
+    accountID: "012345678912"
+  username: viewer
+  groups: [viewers]
+- rolearnregex: arn:aws:iam::012345678912:role/dev-.*
+  username: dev
+  groups: [developers]
+`
+	mapAccounts := `
+- "01234567*"
+- "111122223333"
+`
+	cases := []struct {
+		name string
+		data map[string]string
+	}{
+		{"all sections", map[string]string{"mapUsers": mapUsers, "mapRoles": mapRoles, "mapAccounts": mapAccounts}},
+		{"no mapUsers", map[string]string{"mapRoles": mapRoles, "mapAccounts": mapAccounts}},
+		{"no mapRoles", map[string]string{"mapUsers": mapUsers, "mapAccounts": mapAccounts}},
+		{"no mapAccounts", map[string]string{"mapUsers": mapUsers, "mapRoles": mapRoles}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			parsed, err := ParseMap(c.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := EncodeMap(parsed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{"mapUsers", "mapRoles", "mapAccounts"} {
+				if _, ok := c.data[key]; !ok && encoded[key] != "" {
+					t.Errorf("expected the absent %s to stay absent, got %s", key, encoded[key])
+				}
+			}
+			reparsed, err := ParseMap(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(parsed.UserMappings, reparsed.UserMappings) {
+				t.Errorf("users changed in the round trip:\n%+v\n%+v", parsed.UserMappings, reparsed.UserMappings)
+			}
+			if !reflect.DeepEqual(parsed.RoleMappings, reparsed.RoleMappings) {
+				t.Errorf("roles changed in the round trip:\n%+v\n%+v", parsed.RoleMappings, reparsed.RoleMappings)
+			}
+			if !reflect.DeepEqual(parsed.AWSAccounts, reparsed.AWSAccounts) {
+				t.Errorf("accounts changed in the round trip:\n%v\n%v", parsed.AWSAccounts, reparsed.AWSAccounts)
+			}
+		})
+	}
+}
+
+func TestEncodeMapAnnotations(t *testing.T) {
+	roles := []config.RoleMapping{{
+		RoleARN:     "arn:aws:iam::012345678912:role/Admin",
+		Username:    "admin",
+		Groups:      []string{"system:masters"},
+		Annotations: map[string]string{"team": "platform", "ticket": "https://tickets.example.com/OPS-123"},
+	}}
+	users := []config.UserMapping{{
+		UserARN:     "arn:aws:iam::012345678912:user/Alice",
+		Username:    "alice",
+		Groups:      []string{"admins"},
+		Annotations: map[string]string{"owner": "alice@example.com"},
+	}}
+	encoded, err := EncodeMap(ParsedMap{UserMappings: users, RoleMappings: roles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(encoded["mapRoles"], "annotations:") {
+		t.Errorf("expected the annotations to be encoded as a field, got %s", encoded["mapRoles"])
+	}
+
+	parsed, err := ParseMap(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.RoleMappings[0].Annotations, roles[0].Annotations) {
+		t.Errorf("expected role annotations %v to survive, got %v", roles[0].Annotations, parsed.RoleMappings[0].Annotations)
+	}
+	if !reflect.DeepEqual(parsed.UserMappings[0].Annotations, users[0].Annotations) {
+		t.Errorf("expected user annotations %v to survive, got %v", users[0].Annotations, parsed.UserMappings[0].Annotations)
+	}
+
+	encoded, err = EncodeMap(ParsedMap{UserMappings: []config.UserMapping{{UserARN: "arn:aws:iam::012345678912:user/Bob", Username: "bob"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(encoded["mapUsers"], "annotations") {
+		t.Errorf("expected no annotations field without annotations, got %s", encoded["mapUsers"])
+	}
+}
+
+func TestParseMapAccounts(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     string
+		expected []string
+		errs     int
+	}{
+		{
+			name:     "valid",
+			data:     "- 111122223333\n- \"222233334444\"\n",
+			expected: []string{"111122223333", "222233334444"},
+		},
+		{
+			name:     "leading zeros",
+			data:     "- 012345678912\n",
+			expected: []string{"012345678912"},
+		},
+		{
+			name:     "too short",
+			data:     "- 12345\n- 111122223333\n",
+			expected: []string{"111122223333"},
+			errs:     1,
+		},
+		{
+			name:     "non-numeric",
+			data:     "- 11112222333a\n- \"111122223333 \"\n- 111122223333\n",
+			expected: []string{"111122223333"},
+			errs:     2,
+		},
+		{
+			name:     "patterns",
+			data:     "- 01234567*\n- \"4444555566?6\"\n",
+			expected: []string{"01234567*", "4444555566?6"},
+		},
+		{
+			name:     "invalid patterns",
+			data:     "- 0123abc*\n- 444455556?\n- 111122223333\n",
+			expected: []string{"111122223333"},
+			errs:     2,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			parsed, err := ParseMap(map[string]string{"mapAccounts": c.data})
+			if !reflect.DeepEqual(parsed.AWSAccounts, c.expected) {
+				t.Errorf("expected accounts %v, got %v", c.expected, parsed.AWSAccounts)
+			}
+			var parseErrs ErrParsingMap
+			if c.errs == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if !errors.As(err, &parseErrs) || len(parseErrs.errors) != c.errs {
+				t.Errorf("expected %d errors, got %v", c.errs, err)
+			}
+		})
+	}
+}
+
+func TestParseMapDuplicates(t *testing.T) {
+	data := map[string]string{
+		"mapUsers": `
+- userarn: arn:aws:iam::012345678912:user/matt
+  username: matt
+  groups:
+  - viewers
+- userarn: arn:aws:iam::012345678912:user/matt
+  username: matt
+  groups:
+  - editors
+- userarn: arn:aws:iam::012345678912:user/Matt
+  username: other-matt
+  groups:
+  - viewers
+`,
+		"mapRoles": `
+- rolearn: arn:aws:iam::012345678912:role/Admin
+  username: admin
+  groups:
+  - admins
+- rolearn: arn:aws:sts::012345678912:assumed-role/Admin/session
+  username: admin-session
+  groups:
+  - admins
+`,
+	}
+	parsed, err := ParseMap(data)
+	users, roles := parsed.UserMappings, parsed.RoleMappings
+
+	var parseErrs ErrParsingMap
+	if !errors.As(err, &parseErrs) || len(parseErrs.errors) != 2 {
+		t.Fatalf("expected 2 duplicate errors, got %v", err)
+	}
+	for _, expected := range []string{
+		`"arn:aws:iam::012345678912:user/matt"`,
+		`"arn:aws:iam::012345678912:role/Admin"`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to name the duplicate ARN %s, got %v", expected, err)
+		}
+	}
+	if failed := failedSections(err); len(failed) != 0 {
+		t.Errorf("expected duplicates not to fail their section, got %v", failed)
+	}
+	if !errors.Is(parseErrs.errors[0], config.ErrDuplicateMapping) {
+		t.Errorf("expected a duplicate to match config.ErrDuplicateMapping, got %v", parseErrs.errors[0])
+	}
+	if err := WithoutDuplicates(err); err != nil {
+		t.Errorf("expected no errors but duplicates, got %v", err)
+	}
+
+	if len(users) != 2 || !reflect.DeepEqual(users[0].Groups, []string{"editors"}) || users[1].Username != "other-matt" {
+		t.Errorf("expected the last of the duplicate users to be loaded, got %+v", users)
+	}
+	if len(roles) != 1 || roles[0].Username != "admin-session" {
+		t.Errorf("expected the last of the duplicate roles to be loaded, got %+v", roles)
+	}
+
+	ms, _ := makeStoreWClient()
+	ms.handleConfigMap(&core_v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: DefaultName}, Data: data})
+	if user, err := ms.UserMapping("arn:aws:iam::012345678912:user/matt"); err != nil || !reflect.DeepEqual(user.Groups, []string{"editors"}) {
+		t.Errorf("expected a configmap with duplicates to still be loaded, got %+v, %v", user, err)
+	}
+}
+
+func TestInvalidEntriesMetric(t *testing.T) {
+	ms, fakeConfigMaps := makeStoreWClient()
+
+	watcher := watch.NewFake()
+
+	fakeConfigMaps.Fake.Fake.AddWatchReactor("configmaps",
+		func(action k8stesting.Action) (handled bool, ret watch.Interface, err error) {
+			return true, watcher, nil
+		})
+
+	stopCh := make(chan struct{})
+	ms.startLoadConfigMap(stopCh)
+	defer close(stopCh)
+
+	time.Sleep(2 * time.Millisecond)
+
+	meta := metav1.ObjectMeta{Name: "aws-auth"}
+	data := map[string]string{
+		"mapUsers": `
+- userarn: arn:aws:iam::012345678912:user/valid
+  username: valid
+  groups:
+  - system:masters
+- username: missing-arn
+  groups:
+  - system:masters
+- username: also-missing-arn
+`,
+		"mapRoles": `
+- username: missing-arn-and-sso
+`,
+		"mapAccounts": `not: a list`,
+	}
+	watcher.Add(&core_v1.ConfigMap{ObjectMeta: meta, Data: data})
+
+	time.Sleep(10 * time.Millisecond)
+
+	invalidEntries := metrics.Get().ConfigMapInvalidEntries
+	if v := testutil.ToFloat64(invalidEntries.WithLabelValues(parseErrorValidation)); v != 3 {
+		t.Errorf("expected 3 validation errors, got %v", v)
+	}
+	if v := testutil.ToFloat64(invalidEntries.WithLabelValues(parseErrorSyntax)); v != 1 {
+		t.Errorf("expected 1 syntax error, got %v", v)
+	}
+
+	data = map[string]string{
+		"mapUsers": `
+- userarn: arn:aws:iam::012345678912:user/valid
+  username: valid
+  groups:
+  - system:masters
+`,
+	}
+	watcher.Modify(&core_v1.ConfigMap{ObjectMeta: meta, Data: data})
+
+	time.Sleep(10 * time.Millisecond)
+
+	for _, errorType := range parseErrorTypes {
+		if v := testutil.ToFloat64(invalidEntries.WithLabelValues(errorType)); v != 0 {
+			t.Errorf("expected no %s errors after fixing configmap, got %v", errorType, v)
+		}
+	}
+}
+
+func TestParseFailuresMetric(t *testing.T) {
+	ms, _ := makeStoreWClient()
+	failures := metrics.Get().ConfigMapParseFailures
+	before := map[string]float64{}
+	for _, section := range []string{"mapUsers", "mapRoles", "mapAccounts"} {
+		before[section] = testutil.ToFloat64(failures.WithLabelValues(section))
+	}
+
+	meta := metav1.ObjectMeta{Name: DefaultName}
+	ms.handleWatchEvent(context.Background(), watch.Event{
+		Type: watch.Added,
+		Object: &core_v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{
+			"mapRoles":    "not: a list",
+			"mapAccounts": "- 123",
+		}},
+	})
+	ms.handleWatchEvent(context.Background(), watch.Event{
+		Type:   watch.Modified,
+		Object: &core_v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{"mapRoles": "not: a list"}},
+	})
+	ms.handleWatchEvent(context.Background(), watch.Event{
+		Type:   watch.Modified,
+		Object: &core_v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{"mapRoles": roleMapping}},
+	})
+
+	expected := map[string]float64{"mapUsers": 0, "mapRoles": 2, "mapAccounts": 1}
+	for section, want := range expected {
+		if got := testutil.ToFloat64(failures.WithLabelValues(section)) - before[section]; got != want {
+			t.Errorf("expected %v %s parse failures, got %v", want, section, got)
+		}
+	}
+}
+
+func TestMappingsLoadedMetric(t *testing.T) {
+	ms, fakeConfigMaps := makeStoreWClient()
+
+	watcher := watch.NewFake()
+
+	fakeConfigMaps.Fake.Fake.AddWatchReactor("configmaps",
+		func(action k8stesting.Action) (handled bool, ret watch.Interface, err error) {
+			return true, watcher, nil
+		})
+
+	stopCh := make(chan struct{})
+	ms.startLoadConfigMap(stopCh)
+	defer close(stopCh)
+
+	time.Sleep(2 * time.Millisecond)
+
+	meta := metav1.ObjectMeta{Name: "aws-auth"}
+	watcher.Add(&core_v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{
+		"mapUsers":    userMapping,
+		"mapRoles":    roleMapping,
+		"mapAccounts": autoMappedAWSAccountsYAML,
+	}})
+
+	time.Sleep(10 * time.Millisecond)
+
+	loaded := metrics.Get().ConfigMapMappingsLoaded
+	expected := map[string]float64{
+		mappingKindUser:    2,
+		mappingKindRole:    1,
+		mappingKindSSORole: 0,
+		mappingKindAccount: 2,
+	}
+	for kind, count := range expected {
+		if v := testutil.ToFloat64(loaded.WithLabelValues(kind)); v != count {
+			t.Errorf("expected %v %s mappings loaded, got %v", count, kind, v)
+		}
+	}
+
+	watcher.Modify(&core_v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{
+		"mapUsers": updatedUserMapping,
+		"mapRoles": updatedRoleMapping + `
+- sso:
+    permissionSetName: ViewOnlyAccess
+    accountID: "012345678912"
+  username: viewer
+`,
+		"mapAccounts": updatedAWSAccountsYAML,
+	}})
+
+	time.Sleep(10 * time.Millisecond)
+
+	expected = map[string]float64{
+		mappingKindUser:    3,
+		mappingKindRole:    2,
+		mappingKindSSORole: 1,
+		mappingKindAccount: 1,
+	}
+	for kind, count := range expected {
+		if v := testutil.ToFloat64(loaded.WithLabelValues(kind)); v != count {
+			t.Errorf("expected %v %s mappings loaded after update, got %v", count, kind, v)
+		}
+	}
+}
+
+func TestHasSynced(t *testing.T) {
+	ms, fakeConfigMaps := makeStoreWClient()
+
+	watcher := watch.NewFake()
+
+	fakeConfigMaps.Fake.Fake.AddWatchReactor("configmaps",
+		func(action k8stesting.Action) (handled bool, ret watch.Interface, err error) {
+			return true, watcher, nil
+		})
+
+	stopCh := make(chan struct{})
+	ms.startLoadConfigMap(stopCh)
+	defer close(stopCh)
+
+	time.Sleep(2 * time.Millisecond)
+
+	if ms.HasSynced() {
+		t.Errorf("expected HasSynced to be false before any watch event")
+	}
+
+	watcher.Add(&core_v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "aws-auth"}, Data: map[string]string{"mapRoles": roleMapping}})
+
+	time.Sleep(10 * time.Millisecond)
+
+	if !ms.HasSynced() {
+		t.Errorf("expected HasSynced to be true after an Added event")
+	}
+}
+
+func TestConfigMapRecreated(t *testing.T) {
+	ms, fakeConfigMaps := makeStoreWClient()
+
+	watcher := watch.NewFake()
+
+	fakeConfigMaps.Fake.Fake.AddWatchReactor("configmaps",
+		func(action k8stesting.Action) (handled bool, ret watch.Interface, err error) {
+			return true, watcher, nil
+		})
+
+	stopCh := make(chan struct{})
+	ms.startLoadConfigMap(stopCh)
+	defer close(stopCh)
+
+	time.Sleep(2 * time.Millisecond)
+
+	recreated := metrics.Get().ConfigMapRecreated
+	before := testutil.ToFloat64(recreated)
+
+	data := map[string]string{"mapUsers": userMapping}
+	original := &core_v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "aws-auth", UID: "original"}, Data: data}
+	watcher.Add(original)
+	watcher.Modify(original)
+
+	time.Sleep(10 * time.Millisecond)
+
+	if v := testutil.ToFloat64(recreated) - before; v != 0 {
+		t.Errorf("expected no recreation for an update with the same UID, got %v", v)
+	}
+
+	watcher.Delete(original)
+	watcher.Add(&core_v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "aws-auth", UID: "recreated"}, Data: data})
+
+	time.Sleep(10 * time.Millisecond)
+
+	if v := testutil.ToFloat64(recreated) - before; v != 1 {
+		t.Errorf("expected one recreation after delete and create with a new UID, got %v", v)
+	}
+}
+
+func TestConfigMapLastLoadTimestamp(t *testing.T) {
+	ms, _ := makeStoreWClient()
+	lastLoad := metrics.Get().ConfigMapLastLoadTimestampSeconds
+	lastLoad.Set(0)
+
+	before := time.Now()
+	ms.handleWatchEvent(context.Background(), watch.Event{
+		Type:   watch.Added,
+		Object: &core_v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "aws-auth"}, Data: map[string]string{"mapUsers": userMapping}},
+	})
+
+	loaded := testutil.ToFloat64(lastLoad)
+	if loaded < float64(before.Unix()) || loaded > float64(time.Now().Unix()+1) {
+		t.Errorf("expected the last load timestamp to be recent, got %v", loaded)
+	}
+}
+
+// watchRecorder returns each of watchers in turn from a watch of configmaps
+// in clientset, sending the resourceVersion the watch resumes from on the
+// returned channel.
+func watchRecorder(clientset *k8sfake.Clientset, watchers ...*watch.FakeWatcher) <-chan string {
+	versions := make(chan string, len(watchers))
+	clientset.PrependWatchReactor("configmaps", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		versions <- action.(k8stesting.WatchActionImpl).GetWatchRestrictions().ResourceVersion
+		watcher := watchers[0]
+		if len(watchers) > 1 {
+			watchers = watchers[1:]
+		}
+		return true, watcher, nil
+	})
+	return versions
+}
+
+func TestWatchBookmark(t *testing.T) {
+	clientset := k8sfake.NewSimpleClientset()
+	first, second := watch.NewFake(), watch.NewFake()
+	versions := watchRecorder(clientset, first, second)
+	ms := &MapStore{configMap: clientset.CoreV1().ConfigMaps(DefaultNamespace), name: DefaultName}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	ms.startLoadConfigMap(stopCh)
+
+	if v := <-versions; v != "" {
+		t.Errorf("expected the first watch to start from the current configmap, got resourceVersion %q", v)
+	}
+	first.Add(&core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultName, ResourceVersion: "3"},
+		Data:       map[string]string{"mapRoles": roleMapping},
+	})
+	first.Action(watch.Bookmark, &core_v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "9"}})
+	first.Stop()
+
+	if v := <-versions; v != "9" {
+		t.Errorf("expected the watch to resume from the bookmark, got resourceVersion %q", v)
+	}
+	if _, err := ms.RoleMapping("arn:iam:123:role/me"); err != nil {
+		t.Errorf("expected the configmap to be loaded, got %v", err)
+	}
+}
+
+func TestWatchGone(t *testing.T) {
+	cm := &core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: DefaultName, ResourceVersion: "12"},
+		Data:       map[string]string{"mapUsers": updatedUserMapping},
+	}
+	clientset := k8sfake.NewSimpleClientset(cm)
+	first, second, third := watch.NewFake(), watch.NewFake(), watch.NewFake()
+	versions := watchRecorder(clientset, first, second, third)
+	ms := &MapStore{configMap: clientset.CoreV1().ConfigMaps(DefaultNamespace), name: DefaultName}
+	ms.sleep = func(time.Duration) {
+		t.Errorf("expected an expired watch to be restarted without backing off")
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	ms.startLoadConfigMap(stopCh)
+
+	<-versions
+	first.Add(&core_v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: DefaultName, ResourceVersion: "3"}})
+	gone := k8s_errors.NewResourceExpired("too old resource version: 3 (10)")
+	first.Error(&gone.ErrStatus)
+
+	if v := <-versions; v != "12" {
+		t.Errorf("expected the watch to resume from the reloaded configmap, got resourceVersion %q", v)
+	}
+	if !first.IsStopped() {
+		t.Errorf("expected the expired watch to be stopped")
+	}
+	if _, err := ms.UserMapping("arn:iam:beswar"); err != nil {
+		t.Errorf("expected the configmap to be reloaded, got %v", err)
+	}
+
+	// other errors don't restart the watch, which would resume from 15
+	second.Add(&core_v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: DefaultName, ResourceVersion: "15"}})
+	second.Error(&k8s_errors.NewInternalError(errors.New("etcd unavailable")).ErrStatus)
+	second.Error(&k8s_errors.NewGone("gone").ErrStatus)
+	if v := <-versions; v != "12" {
+		t.Errorf("expected only the 410 to restart the watch, got resourceVersion %q", v)
+	}
+}
+
+func TestWatchResumesFromLastEvent(t *testing.T) {
+	cm := &core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: DefaultName, ResourceVersion: "4"},
+		Data:       map[string]string{"mapRoles": roleMapping},
+	}
+	clientset := k8sfake.NewSimpleClientset(cm)
+	first, second := watch.NewFake(), watch.NewFake()
+	versions := make(chan string, 3)
+	watches := 0
+	clientset.PrependWatchReactor("configmaps", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		versions <- action.(k8stesting.WatchActionImpl).GetWatchRestrictions().ResourceVersion
+		watches++
+		switch watches {
+		case 1:
+			return true, first, nil
+		case 2:
+			// the resourceVersion has been compacted away
+			return true, nil, k8s_errors.NewResourceExpired("too old resource version: 6 (8)")
+		default:
+			return true, second, nil
+		}
+	})
+	ms := &MapStore{configMap: clientset.CoreV1().ConfigMaps(DefaultNamespace), name: DefaultName}
+	ms.sleep = func(time.Duration) {
+		t.Errorf("expected an expired resourceVersion to be reloaded without backing off")
+	}
+	if err := ms.loadConfigMap(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	ms.startLoadConfigMap(stopCh)
+
+	if v := <-versions; v != "4" {
+		t.Errorf("expected the first watch to resume from the loaded configmap, got resourceVersion %q", v)
+	}
+	first.Modify(&core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultName, ResourceVersion: "6"},
+		Data:       map[string]string{"mapUsers": updatedUserMapping},
+	})
+	first.Stop()
+
+	if v := <-versions; v != "6" {
+		t.Errorf("expected the watch to resume from the last event, got resourceVersion %q", v)
+	}
+	// the failed resume reloads the configmap, replacing the mappings of
+	// the last event, and resumes from it
+	if v := <-versions; v != "4" {
+		t.Errorf("expected the watch to resume from the reloaded configmap, got resourceVersion %q", v)
+	}
+	if _, err := ms.RoleMapping("arn:iam:123:role/me"); err != nil {
+		t.Errorf("expected the configmap to be reloaded, got %v", err)
+	}
+}
+
+func TestLoadConfigMapCustomName(t *testing.T) {
+	ms, fakeConfigMaps := makeStoreWClient()
+	ms.name = "tenant-auth"
+
+	watcher := watch.NewFake()
+
+	var fieldSelector string
+	fakeConfigMaps.Fake.Fake.AddWatchReactor("configmaps",
+		func(action k8stesting.Action) (handled bool, ret watch.Interface, err error) {
+			fieldSelector = action.(k8stesting.WatchActionImpl).GetWatchRestrictions().Fields.String()
+			return true, watcher, nil
+		})
+
+	stopCh := make(chan struct{})
+	ms.startLoadConfigMap(stopCh)
+	defer close(stopCh)
+
+	time.Sleep(2 * time.Millisecond)
+
+	watcher.Add(&core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+		Data:       map[string]string{"mapUsers": userMapping},
+	})
+	watcher.Add(&core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant-auth"},
+		Data:       map[string]string{"mapUsers": updatedUserMapping},
+	})
+
+	time.Sleep(10 * time.Millisecond)
+
+	if fieldSelector != "metadata.name=tenant-auth" {
+		t.Errorf("expected watch to select the configured name, got %q", fieldSelector)
+	}
+	if _, err := ms.UserMapping("arn:iam:beswar"); err != nil {
+		t.Errorf("expected user from the configured configmap, got %v", err)
+	}
+	if _, err := ms.UserMapping("arn:iam:matlan"); err != UserNotFound {
+		t.Errorf("expected user from the default configmap to be ignored, got %v", err)
+	}
+}
+
+func TestParseFailedEvent(t *testing.T) {
+	ms, _ := makeStoreWClient()
+	recorder := record.NewFakeRecorder(10)
+	ms.recorder = recorder
+
+	ms.handleConfigMap(&core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: DefaultName},
+		Data:       map[string]string{"mapRoles": roleMapping},
+	})
+	select {
+	case e := <-recorder.Events:
+		t.Fatalf("unexpected event for a valid configmap: %s", e)
+	default:
+	}
+
+	ms.handleConfigMap(&core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: DefaultName},
+		Data:       map[string]string{"mapRoles": "not: a list"},
+	})
+	select {
+	case e := <-recorder.Events:
+		if !strings.HasPrefix(e, core_v1.EventTypeWarning+" "+ReasonParseFailed+" ") {
+			t.Errorf("unexpected event %q", e)
+		}
+		if !strings.Contains(e, "mapRoles") {
+			t.Errorf("expected event to name the failed section, got %q", e)
+		}
+	default:
+		t.Fatal("expected a warning event for a malformed configmap")
+	}
+}
+
+func TestMaxMappings(t *testing.T) {
+	for _, strict := range []bool{true, false} {
+		ms, _ := makeStoreWClient()
+		ms.strictParse = strict
+		ms.maxMappings = 4
+		overLimit := testutil.ToFloat64(metrics.Get().ConfigMapOverMaxMappings)
+		rejected := testutil.ToFloat64(metrics.Get().ConfigMapRejectedUpdates)
+
+		meta := metav1.ObjectMeta{Name: DefaultName}
+		// 3 mappings
+		ms.handleWatchEvent(context.Background(), watch.Event{
+			Type: watch.Added,
+			Object: &core_v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{
+				"mapUsers": userMapping,
+				"mapRoles": roleMapping,
+			}},
+		})
+		// 5 mappings
+		ms.handleWatchEvent(context.Background(), watch.Event{
+			Type: watch.Modified,
+			Object: &core_v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{
+				"mapUsers":    updatedUserMapping,
+				"mapRoles":    updatedRoleMapping,
+				"mapAccounts": updatedAWSAccountsYAML,
+			}},
+		})
+
+		if got := testutil.ToFloat64(metrics.Get().ConfigMapOverMaxMappings) - overLimit; got != 1 {
+			t.Errorf("strict %v: expected 1 update over the maximum, got %v", strict, got)
+		}
+		_, err := ms.UserMapping("arn:iam:beswar")
+		if strict {
+			if err == nil {
+				t.Error("did not expect user beswar from a rejected update to be mapped")
+			}
+			if _, err := ms.UserMapping("arn:iam:matlan"); err != nil {
+				t.Errorf("expected user matlan to still be mapped: %v", err)
+			}
+			if ms.AWSAccount("333344445555") {
+				t.Error("did not expect account 333344445555 from a rejected update to be allowed")
+			}
+			if got := testutil.ToFloat64(metrics.Get().ConfigMapRejectedUpdates) - rejected; got != 1 {
+				t.Errorf("expected 1 rejected update, got %v", got)
+			}
+		} else if err != nil {
+			t.Errorf("expected an update over the maximum to be applied without strict parsing: %v", err)
+		}
+	}
+}
+
+func TestStrictParse(t *testing.T) {
+	ms, _ := makeStoreWClient()
+	ms.strictParse = true
+	rejected := metrics.Get().ConfigMapRejectedUpdates
+	before := testutil.ToFloat64(rejected)
+
+	meta := metav1.ObjectMeta{Name: DefaultName}
+	ms.handleWatchEvent(context.Background(), watch.Event{
+		Type: watch.Added,
+		Object: &core_v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{
+			"mapUsers":    userMapping,
+			"mapRoles":    roleMapping,
+			"mapAccounts": autoMappedAWSAccountsYAML,
+		}},
+	})
+	if !ms.synced.Load() {
+		t.Fatal("expected a clean configmap to be applied")
+	}
+
+	// A bad entry in one section must not let the valid sections through.
+	ms.handleWatchEvent(context.Background(), watch.Event{
+		Type: watch.Modified,
+		Object: &core_v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{
+			"mapUsers":    updatedUserMapping,
+			"mapRoles":    roleMapping,
+			"mapAccounts": "- 123",
+		}},
+	})
+	// A bad entry alongside valid entries in the same section.
+	ms.handleWatchEvent(context.Background(), watch.Event{
+		Type: watch.Modified,
+		Object: &core_v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{
+			"mapUsers":    userMapping,
+			"mapRoles":    updatedRoleMapping + "- username: nobody\n",
+			"mapAccounts": autoMappedAWSAccountsYAML,
+		}},
+	})
+
+	if _, err := ms.UserMapping("arn:iam:matlan"); err != nil {
+		t.Errorf("expected user matlan to still be mapped: %v", err)
+	}
+	if _, err := ms.UserMapping("arn:iam:beswar"); err == nil {
+		t.Errorf("did not expect user beswar from a rejected update to be mapped")
+	}
+	if _, err := ms.RoleMapping("arn:iam:123:role/you"); err == nil {
+		t.Errorf("did not expect role you from a rejected update to be mapped")
+	}
+	if !ms.AWSAccount("111122223333") {
+		t.Errorf("expected account 111122223333 to still be allowed")
+	}
+	if got := testutil.ToFloat64(rejected) - before; got != 2 {
+		t.Errorf("expected 2 rejected updates, got %v", got)
+	}
+}
+
+func TestNewWithClient(t *testing.T) {
+	cm := &core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: "custom-auth"},
+		Data:       map[string]string{"mapUsers": updatedUserMapping},
+	}
+	clientset := k8sfake.NewSimpleClientset(cm)
+	m := metrics.Metrics{
+		ConfigMapInvalidEntries:           prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "invalid_entries"}, []string{"type"}),
+		ConfigMapMappingsLoaded:           prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "mappings_loaded"}, []string{"kind"}),
+		ConfigMapLastLoadTimestampSeconds: prometheus.NewGauge(prometheus.GaugeOpts{Name: "last_load"}),
+	}
+	ms := NewWithClient(clientset.CoreV1().ConfigMaps(DefaultNamespace), m, WithName("custom-auth"))
+
+	if err := ms.loadConfigMap(context.Background()); err != nil {
+		t.Fatalf("unexpected error loading the configmap: %v", err)
+	}
+	if _, err := ms.UserMapping("arn:iam:beswar"); err != nil {
+		t.Errorf("expected the named configmap to be loaded, got %v", err)
+	}
+	if got := testutil.ToFloat64(m.ConfigMapMappingsLoaded.WithLabelValues(mappingKindUser)); got != 3 {
+		t.Errorf("expected the given metrics to count 3 user mappings, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.ConfigMapLastLoadTimestampSeconds); got == 0 {
+		t.Errorf("expected the given metrics to record the load")
+	}
+}
+
+func TestReload(t *testing.T) {
+	cm := &core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: DefaultName},
+		Data:       map[string]string{"mapRoles": roleMapping},
+	}
+	configMaps := k8sfake.NewSimpleClientset(cm).CoreV1().ConfigMaps(DefaultNamespace)
+	ms := NewWithClient(configMaps, metrics.Get())
+	ctx := context.Background()
+
+	if err := ms.Reload(ctx); err != nil {
+		t.Fatalf("unexpected error reloading: %v", err)
+	}
+	if _, err := ms.RoleMapping("arn:iam:123:role/me"); err != nil {
+		t.Errorf("expected the configmap to be loaded, got %v", err)
+	}
+
+	cm.Data = map[string]string{"mapRoles": updatedRoleMapping}
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// concurrent reloads all load the same configmap
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ms.Reload(ctx); err != nil {
+				t.Errorf("unexpected error reloading: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := ms.RoleMapping("arn:iam:123:role/you"); err != nil {
+		t.Errorf("expected the update to be loaded without a watch event, got %v", err)
+	}
+
+	cm.Data = map[string]string{"mapRoles": "not: a list"}
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Reload(ctx); err == nil || !strings.Contains(err.Error(), "error parsing config map") {
+		t.Errorf("expected the parse error, got %v", err)
+	}
+	if _, err := ms.RoleMapping("arn:iam:123:role/you"); err != nil {
+		t.Errorf("expected the last good mapRoles to be kept, got %v", err)
+	}
+
+	if err := configMaps.Delete(ctx, DefaultName, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Reload(ctx); err == nil {
+		t.Error("expected an error reloading a missing configmap")
+	}
+}
+
+func TestLastError(t *testing.T) {
+	ms := NewWithClient(nil, metrics.Get())
+	modified := func(mapRoles string) {
+		ms.handleWatchEvent(context.Background(), watch.Event{
+			Type: watch.Modified,
+			Object: &core_v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+				Data:       map[string]string{"mapRoles": mapRoles},
+			},
+		})
+	}
+
+	if err := ms.LastError(); err != nil || !ms.LastErrorTime().IsZero() {
+		t.Errorf("expected no error before loading, got %v at %v", err, ms.LastErrorTime())
+	}
+	modified("not: a list")
+	if err := ms.LastError(); err == nil || !strings.Contains(err.Error(), "error parsing config map") {
+		t.Errorf("expected the parse error, got %v", err)
+	}
+	if ms.LastErrorTime().IsZero() {
+		t.Error("expected the time of the parse error")
+	}
+
+	modified(roleMapping)
+	if err := ms.LastError(); err != nil || !ms.LastErrorTime().IsZero() {
+		t.Errorf("expected a good configmap to clear the error, got %v at %v", err, ms.LastErrorTime())
+	}
+
+	ms.handleWatchEvent(context.Background(), watch.Event{
+		Type:   watch.Error,
+		Object: &k8s_errors.NewInternalError(errors.New("etcd unavailable")).ErrStatus,
+	})
+	if err := ms.LastError(); err == nil || !strings.Contains(err.Error(), "etcd unavailable") {
+		t.Errorf("expected the watch error, got %v", err)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	ms := NewWithClient(nil, metrics.Get())
+	ms.debounce = 100 * time.Millisecond
+	// every load purges the cache, counting its generations counts loads
+	ms.cache = newMapCache(10, 0)
+	loads := func() uint64 {
+		_, generation := ms.cache.get("")
+		return generation
+	}
+
+	for i, mapRoles := range []string{roleMapping, roleMapping, updatedRoleMapping} {
+		ms.handleWatchEvent(context.Background(), watch.Event{
+			Type: watch.Modified,
+			Object: &core_v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultName, ResourceVersion: strconv.Itoa(i + 1)},
+				Data:       map[string]string{"mapRoles": mapRoles},
+			},
+		})
+	}
+	if n := loads(); n != 0 {
+		t.Errorf("expected no load within the window, got %d", n)
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return loads() > 0, nil
+	}); err != nil {
+		t.Fatal("expected the configmap to be loaded once the window ended")
+	}
+	time.Sleep(2 * ms.debounce)
+	if n := loads(); n != 1 {
+		t.Errorf("expected the burst to be loaded once, got %d", n)
+	}
+	if _, err := ms.RoleMapping("arn:iam:123:role/you"); err != nil {
+		t.Errorf("expected the last configmap to be loaded, got %v", err)
+	}
+
+	// a delete discards the configmap waiting to be loaded
+	ms.handleWatchEvent(context.Background(), watch.Event{
+		Type: watch.Modified,
+		Object: &core_v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultName, ResourceVersion: "4"},
+			Data:       map[string]string{"mapRoles": roleMapping},
+		},
+	})
+	ms.handleWatchEvent(context.Background(), watch.Event{
+		Type:   watch.Deleted,
+		Object: &core_v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: DefaultName, ResourceVersion: "5"}},
+	})
+	time.Sleep(2 * ms.debounce)
+	if _, err := ms.RoleMapping("arn:iam:123:role/me"); err == nil {
+		t.Error("expected the configmap pending when it was deleted not to be loaded")
+	}
+}
+
+func TestRequireAccountAllowed(t *testing.T) {
+	logger := &captureLogger{}
+	ms := NewWithClient(nil, metrics.Get(), WithLogger(logger))
+	ms.requireAccountAllowed = true
+	notAllowed := metrics.Get().ConfigMapAccountNotAllowed
+
+	ms.handleConfigMap(&core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+		Data: map[string]string{
+			"mapRoles": `
+- rolearn: arn:aws:iam::111122223333:role/allowed
+  username: allowed
+- rolearn: arn:aws:iam::444455556666:role/outside
+  username: outside
+`,
+			"mapUsers": `
+- userarn: arn:aws:iam::111122223333:user/allowed
+  username: allowed
+`,
+			"mapAccounts": `
+- "111122223333"
+`,
+		},
+	})
+
+	if !logger.logged("warn: aws-auth configmap maps role arn:aws:iam::444455556666:role/outside in account 444455556666, which is not in mapAccounts") {
+		t.Errorf("expected a warning for the role outside mapAccounts, got %q", logger.entries)
+	}
+	if logger.logged("warn: aws-auth configmap maps role arn:aws:iam::111122223333") || logger.logged("warn: aws-auth configmap maps user") {
+		t.Errorf("expected no warning for mappings in mapAccounts, got %q", logger.entries)
+	}
+	if got := testutil.ToFloat64(notAllowed.WithLabelValues(mappingKindRole)); got != 1 {
+		t.Errorf("expected 1 role outside mapAccounts, got %v", got)
+	}
+	if got := testutil.ToFloat64(notAllowed.WithLabelValues(mappingKindUser)); got != 0 {
+		t.Errorf("expected no users outside mapAccounts, got %v", got)
+	}
+	if _, err := ms.RoleMapping("arn:aws:iam::444455556666:role/outside"); err != nil {
+		t.Errorf("expected the mapping to still be loaded, got %v", err)
+	}
+}
+
+func TestGroupPolicy(t *testing.T) {
+	teamRoles := `
+- rolearn: arn:aws:iam::111122223333:role/team
+  username: team
+  groups:
+  - team:developers
+`
+	adminRoles := teamRoles + `- rolearn: arn:aws:iam::111122223333:role/escalate
+  username: escalate
+  groups:
+  - system:masters
+`
+	users := `
+- userarn: arn:aws:iam::111122223333:user/alice
+  username: alice
+  groups:
+  - team:developers
+`
+	policies := map[string]func(ms *MapStore){
+		"disallowed": func(ms *MapStore) {
+			ms.disallowedGroups = map[string]bool{"system:masters": true}
+		},
+		"allowed": func(ms *MapStore) {
+			ms.allowedGroups = map[string]bool{"team:developers": true}
+		},
+	}
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			ms := NewWithClient(nil, metrics.Get())
+			policy(ms)
+
+			ms.handleConfigMap(&core_v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+				Data:       map[string]string{"mapRoles": teamRoles, "mapUsers": users},
+			})
+			if _, err := ms.RoleMapping("arn:aws:iam::111122223333:role/team"); err != nil {
+				t.Errorf("expected a mapping granting only allowed groups to load, got %v", err)
+			}
+
+			ms.handleConfigMap(&core_v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+				Data:       map[string]string{"mapRoles": adminRoles, "mapUsers": users},
+			})
+			if _, err := ms.RoleMapping("arn:aws:iam::111122223333:role/escalate"); err != RoleNotFound {
+				t.Errorf("expected the mapping granting system:masters to be rejected, got %v", err)
+			}
+			if _, err := ms.RoleMapping("arn:aws:iam::111122223333:role/team"); err != nil {
+				t.Errorf("expected the last good mapRoles to be kept, got %v", err)
+			}
+			if _, err := ms.UserMapping("arn:aws:iam::111122223333:user/alice"); err != nil {
+				t.Errorf("expected mapUsers to still load, got %v", err)
+			}
+			invalid := metrics.Get().ConfigMapInvalidEntries.WithLabelValues(parseErrorDisallowedGroup)
+			if got := testutil.ToFloat64(invalid); got != 1 {
+				t.Errorf("expected 1 entry granting a disallowed group, got %v", got)
+			}
+		})
+	}
+
+	t.Run("warn", func(t *testing.T) {
+		logger := &captureLogger{}
+		ms := NewWithClient(nil, metrics.Get(), WithLogger(logger))
+		recorder := record.NewFakeRecorder(10)
+		ms.recorder = recorder
+		ms.disallowedGroups = map[string]bool{"system:masters": true}
+		ms.warnDisallowedGroups = true
+
+		ms.handleConfigMap(&core_v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+			Data:       map[string]string{"mapRoles": adminRoles},
+		})
+		if _, err := ms.RoleMapping("arn:aws:iam::111122223333:role/escalate"); err != nil {
+			t.Errorf("expected the mapping to still load, got %v", err)
+		}
+		if !logger.logged(`warn: aws-auth configmap mapRoles entry "arn:aws:iam::111122223333:role/escalate" grants group "system:masters"`) {
+			t.Errorf("expected a warning for the mapping, got %q", logger.entries)
+		}
+		select {
+		case e := <-recorder.Events:
+			if !strings.HasPrefix(e, core_v1.EventTypeWarning+" "+ReasonDisallowedGroup+" ") {
+				t.Errorf("unexpected event %q", e)
+			}
+		default:
+			t.Error("expected a warning event for the mapping")
+		}
+	})
+}
+
+func TestAnyPartition(t *testing.T) {
+	ms := &MapStore{}
+	ms.saveMap([]config.UserMapping{
+		{UserARN: "arn:*:iam::012345678912:user/matt", Username: "matt"},
+		{UserARN: "arn:aws-cn:iam::012345678912:user/matt", Username: "matt-cn"},
+	}, []config.RoleMapping{
+		{RoleARN: "arn:*:iam::012345678912:role/ops", Username: "ops"},
+		{RoleARN: "arn:aws-cn:iam::012345678912:role/ops", Username: "ops-cn"},
+	}, nil)
+
+	for subject, expected := range map[string]string{
+		"arn:aws:iam::012345678912:role/ops":        "ops",
+		"arn:aws-us-gov:iam::012345678912:role/ops": "ops",
+		"arn:aws-cn:iam::012345678912:role/ops":     "ops-cn",
+	} {
+		role, err := ms.RoleMapping(subject)
+		if err != nil || role.Username != expected {
+			t.Errorf("expected %s to map to %s, got %+v, %v", subject, expected, role, err)
+		}
+	}
+	for subject, expected := range map[string]string{
+		"arn:aws:iam::012345678912:user/matt":        "matt",
+		"arn:aws-us-gov:iam::012345678912:user/matt": "matt",
+		"arn:aws-cn:iam::012345678912:user/matt":     "matt-cn",
+	} {
+		user, err := ms.UserMapping(subject)
+		if err != nil || user.Username != expected {
+			t.Errorf("expected %s to map to %s, got %+v, %v", subject, expected, user, err)
+		}
+	}
+	if _, err := ms.RoleMapping("arn:aws:iam::012345678912:role/Ops"); err == nil {
+		t.Error("expected the resource to still be compared with its case")
+	}
+}
+
+func TestMatchAll(t *testing.T) {
+	ms := &MapStore{}
+	ms.saveMap([]config.UserMapping{
+		{UserARN: "arn:aws:iam::012345678912:user/dev-matt", Username: "matt"},
+		{UserARNRegex: "arn:aws:iam::012345678912:user/dev-.*", Username: "dev"},
+		{UserARN: "arn:aws:iam::012345678912:user/ops", Username: "ops"},
+	}, []config.RoleMapping{
+		{RoleARNRegex: "arn:aws:iam::012345678912:role/dev-.*", Username: "dev"},
+		{RoleARN: "arn:aws:iam::012345678912:role/dev-admin", Username: "admin"},
+		{RoleARNRegex: "arn:aws:iam::012345678912:role/.*-admin", Username: "any-admin"},
+		{RoleARN: "arn:aws:iam::012345678912:role/ops", Username: "ops"},
+	}, nil)
+
+	roles, users := ms.MatchAll("arn:aws:iam::012345678912:role/dev-admin")
+	var usernames []string
+	for _, role := range roles {
+		usernames = append(usernames, role.Username)
+	}
+	if expected := []string{"admin", "any-admin", "dev"}; !reflect.DeepEqual(usernames, expected) {
+		t.Errorf("expected every matching role mapping in the order tried %v, got %v", expected, usernames)
+	}
+	if len(users) != 0 {
+		t.Errorf("expected no user mappings to match a role, got %+v", users)
+	}
+
+	roles, users = ms.MatchAll("arn:aws:iam::012345678912:user/dev-matt")
+	usernames = nil
+	for _, user := range users {
+		usernames = append(usernames, user.Username)
+	}
+	if expected := []string{"matt", "dev"}; !reflect.DeepEqual(usernames, expected) {
+		t.Errorf("expected every matching user mapping in the order tried %v, got %v", expected, usernames)
+	}
+	if len(roles) != 0 {
+		t.Errorf("expected no role mappings to match a user, got %+v", roles)
+	}
+
+	if roles, users := ms.MatchAll("arn:aws:iam::012345678912:role/prod"); len(roles) != 0 || len(users) != 0 {
+		t.Errorf("expected nothing to match, got %+v %+v", roles, users)
+	}
+}
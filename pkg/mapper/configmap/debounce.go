@@ -0,0 +1,65 @@
+package configmap
+
+import (
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// loadWatched loads cm, delivered by the watch or the informer. With
+// debounce set, the first configmap of a burst starts a window of that
+// length and only the last configmap delivered within it is loaded once it
+// ends, so a controller rewriting the configmap several times a second
+// doesn't rebuild the mappings for each write.
+func (ms *MapStore) loadWatched(cm *core_v1.ConfigMap) {
+	if ms.debounce <= 0 {
+		ms.handleConfigMap(cm)
+		return
+	}
+	ms.pendingMutex.Lock()
+	defer ms.pendingMutex.Unlock()
+	ms.pending = cm
+	if ms.pendingTimer == nil {
+		ms.pendingTimer = time.AfterFunc(ms.debounce, ms.loadPending)
+	}
+}
+
+// loadPending loads the last configmap delivered within the debounce
+// window. The lock is held while loading, so a delete delivered meanwhile
+// is applied after it.
+func (ms *MapStore) loadPending() {
+	ms.pendingMutex.Lock()
+	defer ms.pendingMutex.Unlock()
+	cm := ms.pending
+	ms.pending, ms.pendingTimer = nil, nil
+	if cm != nil {
+		ms.handleConfigMap(cm)
+	}
+}
+
+// resetWatched clears the mappings after the watch or the informer saw the
+// configmap deleted, discarding any configmap still waiting to be loaded.
+func (ms *MapStore) resetWatched() {
+	ms.pendingMutex.Lock()
+	defer ms.pendingMutex.Unlock()
+	ms.discardPending()
+	ms.resetConfigMap()
+}
+
+// stopPending discards any configmap still waiting to be loaded once the
+// watch has stopped, so nothing is loaded after Stop returns. A load
+// already in progress is waited for.
+func (ms *MapStore) stopPending() {
+	ms.pendingMutex.Lock()
+	defer ms.pendingMutex.Unlock()
+	ms.discardPending()
+}
+
+// discardPending stops the debounce timer and drops the configmap it would
+// have loaded. Callers must hold pendingMutex.
+func (ms *MapStore) discardPending() {
+	if ms.pendingTimer != nil {
+		ms.pendingTimer.Stop()
+	}
+	ms.pending, ms.pendingTimer = nil, nil
+}
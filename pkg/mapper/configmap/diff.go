@@ -0,0 +1,112 @@
+package configmap
+
+import (
+	"fmt"
+	"sort"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+)
+
+// MappingChange is a difference between two sets of configmap data found by
+// DiffMaps. Old is nil for an added mapping and New for a removed one.
+type MappingChange struct {
+	// Section is mapRoles or mapUsers.
+	Section string
+	// Key is the canonical ARN of the mapping, or its pattern for
+	// rolearnregex, userarnregex, sso and sessionnamelike mappings.
+	Key string
+	Old *config.IdentityMapping
+	New *config.IdentityMapping
+}
+
+// DiffMaps parses the configmap data old and new and returns the mappings
+// only in new, only in old, and in both with a different username or
+// groups. Mappings are matched by canonical ARN or pattern, so a change of
+// ARN is a removal and an addition. The order of groups is ignored. Each
+// result is sorted by section and key. An error is returned if either
+// fails to parse.
+func DiffMaps(old, new map[string]string) (added, removed, changed []MappingChange, err error) {
+	oldMappings, err := diffMappings(old)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse old configmap: %v", err)
+	}
+	newMappings, err := diffMappings(new)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse new configmap: %v", err)
+	}
+
+	for _, key := range sortedDiffKeys(oldMappings, newMappings) {
+		o, n := oldMappings[key], newMappings[key]
+		change := MappingChange{Section: key.section, Key: key.key, Old: o, New: n}
+		switch {
+		case o == nil:
+			added = append(added, change)
+		case n == nil:
+			removed = append(removed, change)
+		case o.Username != n.Username || !sameGroups(o.Groups, n.Groups):
+			changed = append(changed, change)
+		}
+	}
+	return added, removed, changed, nil
+}
+
+type diffKey struct {
+	section string
+	key     string
+}
+
+// diffMappings parses data into its mappings, keyed like mappings.roles and
+// mappings.users.
+func diffMappings(data map[string]string) (map[diffKey]*config.IdentityMapping, error) {
+	parsed, errs := parseMap(data)
+	if len(errs) > 0 {
+		return nil, ErrParsingMap{errors: errs}
+	}
+	m := make(map[diffKey]*config.IdentityMapping, len(parsed.UserMappings)+len(parsed.RoleMappings))
+	for _, role := range parsed.RoleMappings {
+		key := roleKey(role)
+		m[diffKey{"mapRoles", key}] = &config.IdentityMapping{IdentityARN: key, Username: role.Username, Groups: role.Groups}
+	}
+	for _, user := range parsed.UserMappings {
+		key := userKey(user)
+		m[diffKey{"mapUsers", key}] = &config.IdentityMapping{IdentityARN: key, Username: user.Username, Groups: user.Groups}
+	}
+	return m, nil
+}
+
+func sortedDiffKeys(a, b map[diffKey]*config.IdentityMapping) []diffKey {
+	keys := make([]diffKey, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].section != keys[j].section {
+			return keys[i].section < keys[j].section
+		}
+		return keys[i].key < keys[j].key
+	})
+	return keys
+}
+
+// sameGroups reports whether a and b hold the same groups in any order.
+func sameGroups(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[string]int, len(a))
+	for _, group := range a {
+		count[group]++
+	}
+	for _, group := range b {
+		if count[group] == 0 {
+			return false
+		}
+		count[group]--
+	}
+	return true
+}
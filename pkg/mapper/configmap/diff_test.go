@@ -0,0 +1,90 @@
+package configmap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+)
+
+func TestDiffMaps(t *testing.T) {
+	old := map[string]string{
+		"mapRoles": `
+- rolearn: arn:aws:iam::012345678912:role/Admin
+  username: admin
+  groups: [system:masters]
+- rolearnregex: arn:aws:iam::012345678912:role/dev-.*
+  username: dev
+  groups: [viewers]
+`,
+		"mapUsers": `
+- userarn: arn:aws:iam::012345678912:user/alice
+  username: alice
+  groups: [viewers]
+- userarn: arn:aws:iam::012345678912:user/bob
+  username: bob
+  groups: [viewers, editors]
+`,
+	}
+	new := map[string]string{
+		"mapRoles": `
+- rolearn: arn:aws:iam::012345678912:role/Admin
+  username: admin
+  groups: [system:masters]
+- rolearnregex: arn:aws:iam::012345678912:role/dev-.*
+  username: dev
+  groups: [viewers, editors]
+- rolearn: arn:aws:iam::012345678912:role/Reader
+  username: reader
+  groups: [viewers]
+`,
+		"mapUsers": `
+- userarn: arn:aws:iam::012345678912:user/bob
+  username: bob
+  groups: [editors, viewers]
+`,
+	}
+
+	added, removed, changed, err := DiffMaps(old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedAdded := []MappingChange{{
+		Section: "mapRoles",
+		Key:     "arn:aws:iam::012345678912:role/Reader",
+		New:     &config.IdentityMapping{IdentityARN: "arn:aws:iam::012345678912:role/Reader", Username: "reader", Groups: []string{"viewers"}},
+	}}
+	if !reflect.DeepEqual(added, expectedAdded) {
+		t.Errorf("expected added %+v, got %+v", expectedAdded, added)
+	}
+	expectedRemoved := []MappingChange{{
+		Section: "mapUsers",
+		Key:     "arn:aws:iam::012345678912:user/alice",
+		Old:     &config.IdentityMapping{IdentityARN: "arn:aws:iam::012345678912:user/alice", Username: "alice", Groups: []string{"viewers"}},
+	}}
+	if !reflect.DeepEqual(removed, expectedRemoved) {
+		t.Errorf("expected removed %+v, got %+v", expectedRemoved, removed)
+	}
+	// bob's groups are only reordered, so only the pattern has changed
+	if len(changed) != 1 {
+		t.Fatalf("expected 1 changed mapping, got %+v", changed)
+	}
+	if changed[0].Section != "mapRoles" || changed[0].Key != "arn:aws:iam::012345678912:role/dev-.*" {
+		t.Errorf("expected the rolearnregex mapping to change, got %s %s", changed[0].Section, changed[0].Key)
+	}
+	if !reflect.DeepEqual(changed[0].Old.Groups, []string{"viewers"}) || !reflect.DeepEqual(changed[0].New.Groups, []string{"viewers", "editors"}) {
+		t.Errorf("unexpected groups change %v to %v", changed[0].Old.Groups, changed[0].New.Groups)
+	}
+
+	// nothing differs between identical data
+	added, removed, changed, err = DiffMaps(old, old)
+	if err != nil || len(added)+len(removed)+len(changed) != 0 {
+		t.Errorf("expected no changes, got %+v %+v %+v %v", added, removed, changed, err)
+	}
+
+	_, _, _, err = DiffMaps(old, map[string]string{"mapRoles": "not yaml: ["})
+	if err == nil || !strings.Contains(err.Error(), "new configmap") {
+		t.Errorf("expected an error parsing the new configmap, got %v", err)
+	}
+}
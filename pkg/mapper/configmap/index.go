@@ -0,0 +1,58 @@
+package configmap
+
+import (
+	"sigs.k8s.io/aws-iam-authenticator/pkg/arn"
+)
+
+// accountIndex buckets the positions of an ordered list of mappings by the
+// AWS account they are pinned to, so a lookup only has to consider the
+// mappings that could match the account of the ARN being looked up.
+type accountIndex struct {
+	byAccount map[string][]int
+	// positions of mappings that aren't pinned to a single account, such as
+	// regex mappings, which are considered for every account.
+	anyAccount []int
+}
+
+// add records the mapping at position i of the ordered list. Positions must
+// be added in ascending order.
+func (idx *accountIndex) add(i int, accountID string, ok bool) {
+	if !ok {
+		idx.anyAccount = append(idx.anyAccount, i)
+		return
+	}
+	if idx.byAccount == nil {
+		idx.byAccount = make(map[string][]int)
+	}
+	idx.byAccount[accountID] = append(idx.byAccount[accountID], i)
+}
+
+// each calls fn, in ascending order, with the position of every mapping
+// out of n that could match subject until fn returns true. If the account
+// of subject can't be determined all n positions are visited.
+func (idx *accountIndex) each(subject string, n int, fn func(i int) bool) {
+	accountID, ok := arn.AccountID(subject)
+	if !ok {
+		for i := 0; i < n; i++ {
+			if fn(i) {
+				return
+			}
+		}
+		return
+	}
+
+	// merge the account's bucket with anyAccount so the mappings are still
+	// visited in order.
+	pinned, any := idx.byAccount[accountID], idx.anyAccount
+	for len(pinned) > 0 || len(any) > 0 {
+		var i int
+		if len(any) == 0 || (len(pinned) > 0 && pinned[0] < any[0]) {
+			i, pinned = pinned[0], pinned[1:]
+		} else {
+			i, any = any[0], any[1:]
+		}
+		if fn(i) {
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package configmap
+
+import (
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/arn"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+)
+
+func TestAccountIndex(t *testing.T) {
+	idx := accountIndex{}
+	idx.add(0, "111122223333", true)
+	idx.add(1, "444455556666", true)
+	idx.add(2, "", false)
+	idx.add(3, "111122223333", true)
+	idx.add(4, "444455556666", true)
+	idx.add(5, "", false)
+
+	visit := func(subject string) []int {
+		var visited []int
+		idx.each(subject, 6, func(i int) bool {
+			visited = append(visited, i)
+			return false
+		})
+		return visited
+	}
+
+	cases := []struct {
+		subject  string
+		expected []int
+	}{
+		{"arn:aws:iam::111122223333:role/a", []int{0, 2, 3, 5}},
+		{"arn:aws:iam::444455556666:role/a", []int{1, 2, 4, 5}},
+		{"arn:aws:iam::777788889999:role/a", []int{2, 5}},
+		// subjects without an account fall back to every mapping
+		{"arn:iam:matlan", []int{0, 1, 2, 3, 4, 5}},
+	}
+	for _, c := range cases {
+		if visited := visit(c.subject); !reflect.DeepEqual(visited, c.expected) {
+			t.Errorf("expected %s to visit %v, got %v", c.subject, c.expected, visited)
+		}
+	}
+
+	var visited []int
+	idx.each("arn:aws:iam::111122223333:role/a", 6, func(i int) bool {
+		visited = append(visited, i)
+		return i == 2
+	})
+	if !reflect.DeepEqual(visited, []int{0, 2}) {
+		t.Errorf("expected each to stop once fn returns true, visited %v", visited)
+	}
+}
+
+func TestRoleMappingSkipsOtherAccounts(t *testing.T) {
+	ms := &MapStore{}
+	other := testSSORole
+	other.SSO = &config.SSOARNMatcher{PermissionSetName: "ViewOnlyAccess", AccountID: "444455556666"}
+	other.Username = "other-account"
+	ms.saveMap(nil, []config.RoleMapping{testSSORole, other}, nil)
+
+	// Make the other account's rule match any ARN. It must still never be
+	// consulted for a subject in 012345678912.
+	m := *ms.load()
+	m.orderedPatterns = append([]*arn.CompiledPattern(nil), m.orderedPatterns...)
+	catchAll, err := arn.CompilePattern("arn:*:*:*:*:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, role := range m.orderedRoles {
+		if role.Username == other.Username {
+			m.orderedPatterns[i] = catchAll
+		}
+	}
+	ms.current.Store(&m)
+
+	role, err := ms.RoleMapping("arn:aws:iam::012345678912:role/awsreservedsso_viewonlyaccess_0123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.Username != testSSORole.Username {
+		t.Errorf("expected the rule for the subject's account, got %+v", role)
+	}
+	if _, err := ms.RoleMapping("arn:aws:iam::012345678912:role/awsreservedsso_admin_0123"); err != RoleNotFound {
+		t.Errorf("expected a rule for another account not to be consulted, got %v", err)
+	}
+	if role, err := ms.RoleMapping("arn:aws:iam::444455556666:role/awsreservedsso_viewonlyaccess_0123"); err != nil || role.Username != other.Username {
+		t.Errorf("expected the catch-all rule to match its own account, got %+v, %v", role, err)
+	}
+}
+
+func TestUserMappingAccountIndex(t *testing.T) {
+	ms := &MapStore{}
+	ms.saveMap([]config.UserMapping{
+		{UserARN: "arn:aws:iam::012345678912:user/matt", Username: "matt", Groups: []string{"dev"}},
+		{UserARN: "arn:aws:iam::444455556666:user/matt", Username: "other-matt", Groups: []string{"dev"}},
+		{UserARNRegex: `arn:aws:iam::\d{12}:user/ci-.*`, Username: "ci", Groups: []string{"dev"}},
+	}, nil, nil)
+
+	cases := map[string]string{
+		"arn:aws:iam::012345678912:user/matt":  "matt",
+		"arn:aws:iam::444455556666:user/matt":  "other-matt",
+		"arn:aws:iam::777788889999:user/ci-01": "ci",
+	}
+	for subject, username := range cases {
+		user, err := ms.UserMapping(subject)
+		if err != nil {
+			t.Errorf("expected %s to be mapped: %v", subject, err)
+			continue
+		}
+		if user.Username != username {
+			t.Errorf("expected %s to map to %s, got %s", subject, username, user.Username)
+		}
+	}
+	if _, err := ms.UserMapping("arn:aws:iam::777788889999:user/matt"); err != UserNotFound {
+		t.Errorf("expected a user in an unmapped account not to match, got %v", err)
+	}
+}
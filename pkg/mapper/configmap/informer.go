@@ -0,0 +1,79 @@
+package configmap
+
+import (
+	"context"
+
+	core_v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// WithInformer makes the MapStore follow the configmap with a shared
+// informer, which relists and backs off on its own, instead of its own
+// watch loop.
+func WithInformer() Option {
+	return func(ms *MapStore) {
+		ms.useInformer = true
+	}
+}
+
+// informConfigMap runs an informer on the configmap and updates the in
+// memory data from its events until ctx is cancelled.
+func (ms *MapStore) informConfigMap(ctx context.Context) {
+	informer := ms.newInformer(ctx)
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    ms.onInformerAdd,
+		UpdateFunc: ms.onInformerUpdate,
+		DeleteFunc: ms.onInformerDelete,
+	})
+	informer.Run(ctx.Done())
+}
+
+// newInformer returns an informer on just the configmap called ms.name.
+// The resync is left to resyncConfigMap.
+func (ms *MapStore) newInformer(ctx context.Context) toolscache.SharedIndexInformer {
+	selector := fields.OneTermEqualSelector("metadata.name", ms.name).String()
+	lw := &toolscache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return ms.configMap.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return ms.configMap.Watch(ctx, options)
+		},
+	}
+	informer := toolscache.NewSharedIndexInformer(lw, &core_v1.ConfigMap{}, 0, toolscache.Indexers{})
+	informer.SetWatchErrorHandler(func(r *toolscache.Reflector, err error) {
+		ms.stats().ConfigMapWatchFailures.Inc()
+		ms.setLastError(err)
+		toolscache.DefaultWatchErrorHandler(r, err)
+	})
+	return informer
+}
+
+func (ms *MapStore) onInformerAdd(obj interface{}) {
+	if cm, ok := obj.(*core_v1.ConfigMap); ok && cm.Name == ms.name {
+		ms.log().Infof("Received %s informer add", ms.name)
+		ms.loadWatched(cm)
+	}
+}
+
+func (ms *MapStore) onInformerUpdate(_, obj interface{}) {
+	if cm, ok := obj.(*core_v1.ConfigMap); ok && cm.Name == ms.name {
+		ms.log().Infof("Received %s informer update", ms.name)
+		ms.loadWatched(cm)
+	}
+}
+
+func (ms *MapStore) onInformerDelete(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if cm, ok := obj.(*core_v1.ConfigMap); ok && cm.Name == ms.name {
+		ms.resetWatched()
+	}
+}
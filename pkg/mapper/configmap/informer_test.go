@@ -0,0 +1,88 @@
+package configmap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	toolscache "k8s.io/client-go/tools/cache"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/metrics"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+func TestInformer(t *testing.T) {
+	clientset := k8sfake.NewSimpleClientset()
+	watcher := watch.NewFake()
+	versions := watchRecorder(clientset, watcher)
+	ms := NewWithClient(clientset.CoreV1().ConfigMaps(DefaultNamespace), metrics.Get(), WithInformer())
+	m := &ConfigMapMapper{ms}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.StartWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	<-versions
+
+	identity := &token.Identity{CanonicalARN: "arn:iam:123:role/me"}
+	waitFor := func(msg string, mapped func(err error) bool) {
+		t.Helper()
+		err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+			_, err := m.Map(identity)
+			return mapped(err), nil
+		})
+		if err != nil {
+			t.Fatal(msg)
+		}
+	}
+
+	cm := &core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: DefaultName, ResourceVersion: "1"},
+		Data:       map[string]string{"mapRoles": roleMapping},
+	}
+	watcher.Add(cm)
+	waitFor("expected an add to load the configmap", func(err error) bool { return err == nil })
+
+	updated := cm.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Data = map[string]string{"mapUsers": updatedUserMapping}
+	watcher.Modify(updated)
+	waitFor("expected an update to replace the mappings", func(err error) bool { return errors.Is(err, mapper.ErrNotMapped) })
+	if _, err := m.UserMapping("arn:iam:beswar"); err != nil {
+		t.Errorf("expected an update to load the new mappings, got %v", err)
+	}
+
+	watcher.Modify(cm)
+	waitFor("expected a second update to restore the role", func(err error) bool { return err == nil })
+	watcher.Delete(cm)
+	waitFor("expected a delete to reset the mappings", func(err error) bool { return errors.Is(err, mapper.ErrNotMapped) })
+}
+
+func TestInformerIgnoresOtherConfigMaps(t *testing.T) {
+	ms := NewWithClient(k8sfake.NewSimpleClientset().CoreV1().ConfigMaps(DefaultNamespace), metrics.Get())
+	ms.onInformerAdd(&core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: "other"},
+		Data:       map[string]string{"mapRoles": roleMapping},
+	})
+	if _, err := ms.RoleMapping("arn:iam:123:role/me"); err == nil {
+		t.Errorf("expected a configmap with another name to be ignored")
+	}
+
+	cm := &core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: DefaultName},
+		Data:       map[string]string{"mapRoles": roleMapping},
+	}
+	ms.onInformerAdd(cm)
+	ms.onInformerDelete(toolscache.DeletedFinalStateUnknown{Key: DefaultNamespace + "/" + DefaultName, Obj: cm})
+	if _, err := ms.RoleMapping("arn:iam:123:role/me"); err == nil {
+		t.Errorf("expected a delete seen only on relist to reset the mappings")
+	}
+}
@@ -0,0 +1,44 @@
+package configmap
+
+import (
+	"fmt"
+)
+
+// LintError is a problem found in configmap data by LintMap.
+type LintError struct {
+	// Section is the configmap key the problem is in: mapUsers, mapRoles or
+	// mapAccounts.
+	Section string
+	// Entry is the ARN, pattern or account ID of the offending entry. It is
+	// empty if the section as a whole could not be decoded.
+	Entry string
+	// Type is the kind of problem: syntax, validation or duplicate.
+	Type string
+	Err  error
+}
+
+func (err LintError) Error() string {
+	if err.Entry == "" {
+		return fmt.Sprintf("%s: %v", err.Section, err.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", err.Section, err.Entry, err.Err)
+}
+
+func (err LintError) Unwrap() error {
+	return err.Err
+}
+
+// LintMap checks configmap data with the same rules used to load it,
+// without needing a Kubernetes client. Every problem ParseMap would report
+// is returned as a LintError; the result is empty if data is clean.
+func LintMap(data map[string]string) []error {
+	_, errs := parseMap(data)
+	lintErrs := make([]error, 0, len(errs))
+	for _, err := range errs {
+		if pe, ok := err.(parseError); ok {
+			err = LintError{Section: pe.section, Entry: pe.entry, Type: pe.errorType, Err: pe.err}
+		}
+		lintErrs = append(lintErrs, err)
+	}
+	return lintErrs
+}
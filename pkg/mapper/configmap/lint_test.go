@@ -0,0 +1,84 @@
+package configmap
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLintMap(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     map[string]string
+		expected []LintError
+	}{
+		{
+			name: "clean",
+			data: map[string]string{
+				"mapUsers":    userMapping,
+				"mapRoles":    roleMapping,
+				"mapAccounts": autoMappedAWSAccountsYAML,
+			},
+		},
+		{
+			name: "duplicate",
+			data: map[string]string{"mapUsers": `
+- userarn: arn:aws:iam::012345678912:user/matt
+  username: matt
+  groups: [viewers]
+- userarn: arn:aws:iam::012345678912:user/matt
+  username: matt
+  groups: [editors]
+`},
+			expected: []LintError{{Section: "mapUsers", Entry: "arn:aws:iam::012345678912:user/matt", Type: parseErrorDuplicate}},
+		},
+		{
+			name: "both ARNs set",
+			data: map[string]string{"mapRoles": `
+- rolearn: arn:aws:iam::012345678912:role/admin
+  rolearnregex: arn:aws:iam::012345678912:role/admin-.*
+  username: admin
+  groups: [admins]
+`},
+			expected: []LintError{{Section: "mapRoles", Entry: "arn:aws:iam::012345678912:role/admin", Type: parseErrorValidation}},
+		},
+		{
+			name: "bad pattern",
+			data: map[string]string{"mapUsers": `
+- userarnregex: arn:aws:iam::012345678912:user/(ci
+  username: ci
+  groups: [ci]
+`},
+			expected: []LintError{{Section: "mapUsers", Entry: "arn:aws:iam::012345678912:user/(ci", Type: parseErrorValidation}},
+		},
+		{
+			name: "syntax and account",
+			data: map[string]string{
+				"mapRoles":    "not: a list",
+				"mapAccounts": "- 123",
+			},
+			expected: []LintError{
+				{Section: "mapRoles", Type: parseErrorSyntax},
+				{Section: "mapAccounts", Entry: "123", Type: parseErrorValidation},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			errs := LintMap(c.data)
+			if errs == nil || len(errs) != len(c.expected) {
+				t.Fatalf("expected %d problems, got %v", len(c.expected), errs)
+			}
+			for i, err := range errs {
+				var lintErr LintError
+				if !errors.As(err, &lintErr) || lintErr.Err == nil {
+					t.Fatalf("expected a LintError, got %#v", err)
+				}
+				lintErr.Err = nil
+				if !reflect.DeepEqual(lintErr, c.expected[i]) {
+					t.Errorf("expected %+v, got %+v", c.expected[i], lintErr)
+				}
+			}
+		})
+	}
+}
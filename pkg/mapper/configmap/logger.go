@@ -0,0 +1,32 @@
+package configmap
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// Logger is the logging interface used by MapStore, so its logs can be
+// routed to a host application's logger. Use WithLogger to set it.
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	// WithFields returns a Logger that adds fields to every entry.
+	WithFields(fields map[string]interface{}) Logger
+}
+
+// LogrusLogger adapts a logrus logger or entry to Logger.
+func LogrusLogger(l logrus.FieldLogger) Logger {
+	return logrusLogger{l}
+}
+
+type logrusLogger struct {
+	logrus.FieldLogger
+}
+
+func (l logrusLogger) WithFields(fields map[string]interface{}) Logger {
+	return logrusLogger{l.FieldLogger.WithFields(fields)}
+}
+
+// defaultLogger logs to the global logrus logger, and is used when no
+// Logger is set.
+var defaultLogger = LogrusLogger(logrus.StandardLogger())
@@ -0,0 +1,77 @@
+package configmap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// captureLogger records every entry logged to it.
+type captureLogger struct {
+	mutex   sync.Mutex
+	entries []string
+}
+
+func (l *captureLogger) logf(level, format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries = append(l.entries, level+": "+fmt.Sprintf(format, args...))
+}
+
+func (l *captureLogger) Infof(format string, args ...interface{}) {
+	l.logf("info", format, args...)
+}
+
+func (l *captureLogger) Warnf(format string, args ...interface{}) {
+	l.logf("warn", format, args...)
+}
+
+func (l *captureLogger) Errorf(format string, args ...interface{}) {
+	l.logf("error", format, args...)
+}
+
+func (l *captureLogger) WithFields(fields map[string]interface{}) Logger {
+	return l
+}
+
+// logged returns true if an entry starting with prefix was logged.
+func (l *captureLogger) logged(prefix string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, entry := range l.entries {
+		if strings.HasPrefix(entry, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWithLogger(t *testing.T) {
+	ms, _ := makeStoreWClient()
+	logger := &captureLogger{}
+	WithLogger(logger)(&ms)
+
+	ms.handleWatchEvent(context.Background(), watch.Event{
+		Type: watch.Modified,
+		Object: &core_v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+			Data:       map[string]string{"mapRoles": "not: a list"},
+		},
+	})
+
+	for _, prefix := range []string{
+		"info: Received aws-auth watch event",
+		"warn: Errors parsing configmap",
+		"error: There was an error parsing the config maps.",
+	} {
+		if !logger.logged(prefix) {
+			t.Errorf("expected %q to be logged, got %q", prefix, logger.entries)
+		}
+	}
+}
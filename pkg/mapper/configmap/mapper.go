@@ -1,9 +1,15 @@
 package configmap
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/wait"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
-	"strings"
 
+	"sigs.k8s.io/aws-iam-authenticator/pkg/arn"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
 )
@@ -13,51 +19,230 @@ type ConfigMapMapper struct {
 }
 
 var _ mapper.Mapper = &ConfigMapMapper{}
+var _ mapper.Stopper = &ConfigMapMapper{}
 
-func NewConfigMapMapper(cfg config.Config) (*ConfigMapMapper, error) {
-	ms, err := New(cfg.Master, cfg.Kubeconfig)
+func NewConfigMapMapper(cfg config.Config, opts ...Option) (*ConfigMapMapper, error) {
+	ms, err := New(cfg.Master, cfg.Kubeconfig, cfg.ConfigMapNamespace, cfg.ConfigMapName, opts...)
 	if err != nil {
 		return nil, err
 	}
+	ms.strictParse = cfg.ConfigMapStrictParse
+	ms.resync = cfg.ConfigMapResyncInterval
+	ms.debounce = cfg.ConfigMapDebounce
+	ms.patternMetrics = cfg.ConfigMapPatternMatchMetrics
+	ms.maxMappings = cfg.ConfigMapMaxMappings
+	ms.ignorePath = cfg.ConfigMapIgnorePath
+	ms.requireAccountAllowed = cfg.ConfigMapRequireAccountAllowed
+	ms.enforceAccountAllowed = cfg.ConfigMapEnforceAccountAllowed
+	if len(cfg.ConfigMapAccountBypassGroups) > 0 {
+		ms.accountBypassGroups = make(map[string]bool, len(cfg.ConfigMapAccountBypassGroups))
+		for _, group := range cfg.ConfigMapAccountBypassGroups {
+			ms.accountBypassGroups[group] = true
+		}
+	}
+	ms.allowedGroups = groupSet(cfg.ConfigMapAllowedGroups)
+	ms.disallowedGroups = groupSet(cfg.ConfigMapDisallowedGroups)
+	ms.warnDisallowedGroups = cfg.ConfigMapWarnDisallowedGroups
+	if cfg.ConfigMapInformer {
+		ms.useInformer = true
+	}
+	switch cfg.ConfigMapAccountAllowPolicy {
+	case "", config.AccountAllowPolicyExplicit, config.AccountAllowPolicyMatchedMapping:
+		ms.accountPolicy = cfg.ConfigMapAccountAllowPolicy
+	default:
+		return nil, fmt.Errorf("unknown configmap account allow policy %q", cfg.ConfigMapAccountAllowPolicy)
+	}
+	if cfg.ConfigMapCacheSize > 0 {
+		ms.cache = newMapCache(cfg.ConfigMapCacheSize, cfg.ConfigMapCacheTTL)
+	}
 	return &ConfigMapMapper{ms}, nil
 }
 
+// groupSet returns groups as a set, or nil if there are none.
+func groupSet(groups []string) map[string]bool {
+	if len(groups) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		set[group] = true
+	}
+	return set
+}
+
 func (m *ConfigMapMapper) Name() string {
 	return mapper.ModeEKSConfigMap
 }
 
 func (m *ConfigMapMapper) Start(stopCh <-chan struct{}) error {
-	m.startLoadConfigMap(stopCh)
+	ctx, cancel := wait.ContextForChannel(stopCh)
+	if err := m.start(ctx, cancel); err != nil {
+		cancel()
+		return err
+	}
 	return nil
 }
 
-func (m *ConfigMapMapper) Map(identity *token.Identity) (*config.IdentityMapping, error) {
-	canonicalARN := strings.ToLower(identity.CanonicalARN)
+// StartWithContext is like Start, but watches the configmap until ctx is
+// cancelled rather than until a stop channel is closed.
+func (m *ConfigMapMapper) StartWithContext(ctx context.Context) error {
+	return m.start(ctx, func() {})
+}
 
-	rm, err := m.RoleMapping(canonicalARN)
-	// TODO: Check for non Role/UserNotFound errors
-	if err == nil {
-		return &config.IdentityMapping{
-			IdentityARN: canonicalARN,
-			Username:    rm.Username,
-			Groups:      rm.Groups,
-		}, nil
+// start loads the configmap and watches it until ctx is cancelled or Stop is
+// called, then calls done.
+func (m *ConfigMapMapper) start(ctx context.Context, done func()) error {
+	spanCtx, span := mapper.StartSpan(ctx, m.Name(), "Start")
+	defer span.End()
+	if m.runner.Running() {
+		return mapper.ErrAlreadyRunning
 	}
+	if err := m.loadConfigMap(spanCtx); err != nil {
+		return err
+	}
+	return m.runner.Go(ctx, func(ctx context.Context) {
+		defer done()
+		defer m.stopPending()
+		var wg sync.WaitGroup
+		if m.resync > 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.resyncConfigMap(ctx)
+			}()
+		}
+		if m.useInformer {
+			m.informConfigMap(ctx)
+		} else {
+			m.watchConfigMap(ctx)
+		}
+		wg.Wait()
+	})
+}
 
-	um, err := m.UserMapping(canonicalARN)
+// Stop stops watching the configmap and waits for the watch to exit. The
+// last loaded mappings are still served, and the mapper can be started
+// again.
+func (m *ConfigMapMapper) Stop() {
+	m.runner.Stop()
+}
+
+// Running returns true if the configmap is being watched.
+func (m *ConfigMapMapper) Running() bool {
+	return m.runner.Running()
+}
+
+func (m *ConfigMapMapper) Map(identity *token.Identity) (*config.IdentityMapping, error) {
+	span := mapper.StartMapSpan(m.Name(), identity)
+	mapping, matchKind, err := m.cachedMapIdentity(identity)
 	if err == nil {
-		return &config.IdentityMapping{
-			IdentityARN: canonicalARN,
-			Username:    um.Username,
-			Groups:      um.Groups,
-		}, nil
+		if err = m.checkAccount(identity, mapping); err != nil {
+			mapping, matchKind = nil, mapper.MatchKindNone
+		}
 	}
+	mapper.EndMapSpan(span, matchKind, err)
+	return mapping, err
+}
+
+// checkAccount returns a NotMappedError for mapping if enforceAccountAllowed
+// is set and the account of identity isn't allowed, unless mapping grants
+// one of accountBypassGroups.
+func (m *ConfigMapMapper) checkAccount(identity *token.Identity, mapping *config.IdentityMapping) error {
+	if !m.enforceAccountAllowed {
+		return nil
+	}
+	accountID := identity.AccountID
+	if accountID == "" {
+		accountID, _ = arn.AccountID(mapping.IdentityARN)
+	}
+	if m.IsAccountAllowed(accountID) {
+		return nil
+	}
+	for _, group := range mapping.Groups {
+		if m.accountBypassGroups[group] {
+			m.log().WithFields(map[string]interface{}{
+				"arn":     mapping.IdentityARN,
+				"account": accountID,
+				"group":   group,
+			}).Warnf("Mapping grants bypass group %s, allowing it although its account is not allowed", group)
+			return nil
+		}
+	}
+	m.log().WithFields(map[string]interface{}{
+		"arn":     mapping.IdentityARN,
+		"account": accountID,
+	}).Infof("Rejecting mapping as its account is not allowed")
+	return mapper.NewNotMappedError(mapping.IdentityARN, false, mapping.MatchSource.Lookup)
+}
+
+// cachedMapIdentity is mapIdentity, served from the result cache if enabled.
+func (m *ConfigMapMapper) cachedMapIdentity(identity *token.Identity) (*config.IdentityMapping, string, error) {
+	if m.cache == nil {
+		return m.mapIdentity(identity)
+	}
+	// both ARNs take part in matching, RawMatch mappings use the raw one
+	key := arn.NormalizeCase(identity.CanonicalARN) + "\x00" + arn.NormalizeCase(identity.ARN)
+	e, generation := m.cache.get(key)
+	if e != nil {
+		m.stats().MapCacheHits.WithLabelValues(m.Name()).Inc()
+		if e.mapping == nil {
+			return nil, e.matchKind, e.err
+		}
+		mapping := *e.mapping
+		return &mapping, e.matchKind, nil
+	}
+	m.stats().MapCacheMisses.WithLabelValues(m.Name()).Inc()
 
-	return nil, mapper.ErrNotMapped
+	mapping, matchKind, err := m.mapIdentity(identity)
+	if err == nil || errors.Is(err, mapper.ErrNotMapped) {
+		m.cache.add(key, generation, mapping, matchKind, err)
+	}
+	return mapping, matchKind, err
+}
+
+// mapIdentity maps identity against a single snapshot of the mappings,
+// with the precedence of mapper.MapIdentity, then with the mapRoleTags
+// mappings if none of those match.
+func (m *ConfigMapMapper) mapIdentity(identity *token.Identity) (*config.IdentityMapping, string, error) {
+	current := m.load()
+	mapping, matchKind, err := m.mapARN(current, identity)
+	if errors.Is(err, mapper.ErrNotMapped) {
+		return m.mapRoleTags(current, identity, err)
+	}
+	return mapping, matchKind, err
+}
+
+// mapARN maps identity with the role and user mappings of current. With
+// patternMetrics set, the pattern mapping that wins is counted; results
+// served from the cache aren't.
+func (m *ConfigMapMapper) mapARN(current *mappings, identity *token.Identity) (*config.IdentityMapping, string, error) {
+	if !m.patternMetrics {
+		return mapper.MapIdentity(m.Name(), identity, current.findRole, current.findUser, m.IsAccountAllowed)
+	}
+	roles := func(subject, lookup string) *config.RoleMapping {
+		role := current.findRole(subject, lookup)
+		if role != nil && (role.RoleARN == "" || role.SessionNameLike != "") {
+			m.stats().ARNLikeRuleMatches.WithLabelValues(role.Key()).Inc()
+		}
+		return role
+	}
+	users := func(subject, lookup string) *config.UserMapping {
+		user := current.findUser(subject, lookup)
+		if user != nil && user.UserARNRegex != "" {
+			m.stats().ARNLikeRuleMatches.WithLabelValues(user.Key()).Inc()
+		}
+		return user
+	}
+	return mapper.MapIdentity(m.Name(), identity, roles, users, m.IsAccountAllowed)
 }
 
+// IsAccountAllowed returns true if accountID is in mapAccounts or, with the
+// matchedMappingImpliesAllowed policy, if a mapping is pinned to it.
 func (m *ConfigMapMapper) IsAccountAllowed(accountID string) bool {
-	return m.AWSAccount(accountID)
+	if m.AWSAccount(accountID) {
+		return true
+	}
+	return m.accountPolicy == config.AccountAllowPolicyMatchedMapping && m.load().mapsAccount(accountID)
 }
 
 func (m *ConfigMapMapper) UsernamePrefixReserveList() []string {
@@ -0,0 +1,926 @@
+package configmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	goruntime "runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	core_v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/arn"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper/file"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/metrics"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+func TestMapRawMatch(t *testing.T) {
+	sessionARN := "arn:aws:sts::012345678912:assumed-role/Admin/break-glass"
+	identity := &token.Identity{
+		ARN:          sessionARN,
+		CanonicalARN: "arn:aws:iam::012345678912:role/Admin",
+	}
+
+	ms := &MapStore{}
+	ms.saveMap(nil, []config.RoleMapping{
+		{RoleARN: sessionARN, Username: "break-glass", Groups: []string{"system:masters"}},
+	}, nil)
+	m := &ConfigMapMapper{ms}
+	if _, err := m.Map(identity); !errors.Is(err, mapper.ErrNotMapped) {
+		t.Fatalf("expected a non-canonical rolearn not to match without rawmatch, got %v", err)
+	}
+
+	ms.saveMap(nil, []config.RoleMapping{
+		{RoleARN: sessionARN, Username: "break-glass", Groups: []string{"system:masters"}, RawMatch: true},
+	}, nil)
+	mapping, err := m.Map(identity)
+	if err != nil {
+		t.Fatalf("expected rawmatch mapping to match %s, got %v", sessionARN, err)
+	}
+	if mapping.Username != "break-glass" {
+		t.Errorf("unexpected mapping %+v", mapping)
+	}
+
+	if _, err := m.Map(&token.Identity{
+		ARN:          "arn:aws:sts::012345678912:assumed-role/Admin/other-session",
+		CanonicalARN: "arn:aws:iam::012345678912:role/Admin",
+	}); !errors.Is(err, mapper.ErrNotMapped) {
+		t.Errorf("expected rawmatch mapping not to match another session, got %v", err)
+	}
+}
+
+func TestMapSessionNameLike(t *testing.T) {
+	ms := &MapStore{}
+	ms.saveMap(nil, []config.RoleMapping{
+		{RoleARN: "arn:aws:iam::012345678912:role/Admin", SessionNameLike: "break-glass-*", Username: "break-glass", Groups: []string{"system:masters"}},
+		{RoleARN: "arn:aws:iam::012345678912:role/Admin", Username: "admin", Groups: []string{"viewers"}},
+	}, nil)
+	m := &ConfigMapMapper{ms}
+
+	cases := map[string]string{
+		"arn:aws:sts::012345678912:assumed-role/Admin/break-glass-alice": "break-glass",
+		"arn:aws:sts::012345678912:assumed-role/Admin/alice":             "admin",
+	}
+	for sessionARN, username := range cases {
+		mapping, err := m.Map(&token.Identity{ARN: sessionARN, CanonicalARN: "arn:aws:iam::012345678912:role/Admin"})
+		if err != nil {
+			t.Fatalf("expected %s to be mapped, got %v", sessionARN, err)
+		}
+		if mapping.Username != username {
+			t.Errorf("expected %s to map to %s, got %s", sessionARN, username, mapping.Username)
+		}
+	}
+}
+
+func TestMapContext(t *testing.T) {
+	ms := &MapStore{}
+	ms.saveMap(nil, []config.RoleMapping{
+		{RoleARN: "arn:aws:iam::012345678912:role/Admin", Username: "admin", Groups: []string{"system:masters"}},
+	}, nil)
+	m := &ConfigMapMapper{ms}
+
+	// ConfigMapMapper reads from memory, so MapContext uses Map and a
+	// cancelled context doesn't fail the lookup.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, canonicalARN := range []string{
+		"arn:aws:iam::012345678912:role/Admin",
+		"arn:aws:iam::012345678912:role/Other",
+	} {
+		identity := &token.Identity{CanonicalARN: canonicalARN}
+		expected, expectedErr := m.Map(identity)
+		actual, err := mapper.MapContext(ctx, m, identity)
+		if !reflect.DeepEqual(actual, expected) || !reflect.DeepEqual(err, expectedErr) {
+			t.Errorf("expected MapContext(%s) to return %+v, %v like Map, got %+v, %v", canonicalARN, expected, expectedErr, actual, err)
+		}
+	}
+}
+
+func TestMapNotMappedError(t *testing.T) {
+	ms := &MapStore{cache: newMapCache(10, 0)}
+	ms.saveMap(nil, []config.RoleMapping{testSSORole}, []string{"012345678912"})
+	m := &ConfigMapMapper{ms}
+
+	cases := []struct {
+		identity  *token.Identity
+		kind      string
+		attempted []string
+		allowed   bool
+	}{
+		{
+			identity:  &token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/Other", AccountID: "012345678912"},
+			kind:      "role",
+			attempted: []string{mapper.LookupRole, mapper.LookupUser, mapper.LookupRolePattern, mapper.LookupUserPattern},
+			allowed:   true,
+		},
+		{
+			identity: &token.Identity{
+				ARN:          "arn:aws:iam::444455556666:user/Matt",
+				CanonicalARN: "arn:aws:iam::444455556666:user/Matt",
+				AccountID:    "444455556666",
+			},
+			kind:      "user",
+			attempted: []string{mapper.LookupRawRole, mapper.LookupRole, mapper.LookupUser, mapper.LookupRawUser, mapper.LookupRolePattern, mapper.LookupUserPattern},
+		},
+	}
+	for _, c := range cases {
+		// the second lookup is served from the cache
+		for i := 0; i < 2; i++ {
+			_, err := m.Map(c.identity)
+			var notMapped mapper.NotMappedError
+			if !errors.As(err, &notMapped) || !errors.Is(err, mapper.ErrNotMapped) {
+				t.Fatalf("expected a NotMappedError for %s, got %v", c.identity.CanonicalARN, err)
+			}
+			if notMapped.ARN != c.identity.CanonicalARN || notMapped.ARNKind != c.kind {
+				t.Errorf("expected %s of kind %s, got %+v", c.identity.CanonicalARN, c.kind, notMapped)
+			}
+			if !reflect.DeepEqual(notMapped.Attempted, c.attempted) {
+				t.Errorf("expected lookups %v, got %v", c.attempted, notMapped.Attempted)
+			}
+			if notMapped.AccountAllowed != c.allowed {
+				t.Errorf("expected AccountAllowed %v for %s", c.allowed, c.identity.CanonicalARN)
+			}
+		}
+	}
+}
+
+func TestIsAccountAllowedPolicy(t *testing.T) {
+	users := []config.UserMapping{testUser}
+	roles := []config.RoleMapping{testRole, testSSORole, {RoleARNRegex: "arn:aws:iam::999988887777:role/.*", Username: "regex"}}
+
+	cases := []struct {
+		policy  config.AccountAllowPolicy
+		allowed map[string]bool
+	}{
+		{
+			policy: config.AccountAllowPolicyExplicit,
+			allowed: map[string]bool{
+				"111122223333": true,
+				"012345678912": false,
+				"999988887777": false,
+			},
+		},
+		{
+			policy: config.AccountAllowPolicyMatchedMapping,
+			allowed: map[string]bool{
+				"111122223333": true,
+				"012345678912": true,
+				"999988887777": false,
+				"444455556666": false,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(string(c.policy), func(t *testing.T) {
+			ms := &MapStore{accountPolicy: c.policy}
+			ms.saveMap(users, roles, []string{"111122223333"})
+			m := &ConfigMapMapper{ms}
+			for account, allowed := range c.allowed {
+				if got := m.IsAccountAllowed(account); got != allowed {
+					t.Errorf("expected IsAccountAllowed(%s) to be %v, got %v", account, allowed, got)
+				}
+				if m.AWSAccount(account) && account != "111122223333" {
+					t.Errorf("expected AWSAccount(%s) to only report mapAccounts", account)
+				}
+			}
+		})
+	}
+}
+
+func TestNewConfigMapMapperAccountAllowPolicy(t *testing.T) {
+	cfg := config.Config{Master: "https://127.0.0.1:6443"}
+	m, err := NewConfigMapMapper(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.accountPolicy != "" {
+		t.Errorf("expected the default policy to be empty, got %q", m.accountPolicy)
+	}
+
+	cfg.ConfigMapAccountAllowPolicy = "anything"
+	if _, err := NewConfigMapMapper(cfg); err == nil {
+		t.Error("expected an unknown account allow policy to be rejected")
+	}
+}
+
+func TestStartLoadsConfigMap(t *testing.T) {
+	cm := &core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: DefaultName},
+		Data:       map[string]string{"mapRoles": roleMapping},
+	}
+	ms := &MapStore{
+		configMap: k8sfake.NewSimpleClientset(cm).CoreV1().ConfigMaps(DefaultNamespace),
+		name:      DefaultName,
+	}
+	m := &ConfigMapMapper{ms}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := m.Start(stopCh); err != nil {
+		t.Fatalf("unexpected error starting mapper: %v", err)
+	}
+
+	mapping, err := m.Map(&token.Identity{CanonicalARN: "arn:iam:123:role/me"})
+	if err != nil {
+		t.Fatalf("expected role to be mapped as soon as Start returns, got %v", err)
+	}
+	if mapping.Username != "{{SessionName}}" {
+		t.Errorf("unexpected mapping %+v", mapping)
+	}
+}
+
+func TestStartConfigMapErrors(t *testing.T) {
+	clientset := k8sfake.NewSimpleClientset()
+	ms := &MapStore{
+		configMap: clientset.CoreV1().ConfigMaps(DefaultNamespace),
+		name:      DefaultName,
+	}
+	m := &ConfigMapMapper{ms}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := m.Start(stopCh); err != nil {
+		t.Errorf("expected a missing configmap not to fail Start, got %v", err)
+	}
+
+	m.Stop()
+
+	clientset.PrependReactor("get", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	if err := m.Start(stopCh); err == nil {
+		t.Errorf("expected an error fetching the configmap to fail Start")
+	}
+}
+
+func TestResync(t *testing.T) {
+	clientset := k8sfake.NewSimpleClientset()
+	// the watch never delivers an event, only the resync can load changes
+	clientset.PrependWatchReactor("configmaps", k8stesting.DefaultWatchReactor(watch.NewFake(), nil))
+	configMaps := clientset.CoreV1().ConfigMaps(DefaultNamespace)
+	ticks := make(chan time.Time)
+	ms := &MapStore{
+		configMap: configMaps,
+		name:      DefaultName,
+		resync:    time.Minute,
+		newTicker: func(d time.Duration) (<-chan time.Time, func()) {
+			if d != time.Minute {
+				t.Errorf("expected the resync interval, got %v", d)
+			}
+			return ticks, func() {}
+		},
+	}
+	m := &ConfigMapMapper{ms}
+	if err := m.StartWithContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	cm := &core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: DefaultName, ResourceVersion: "1"},
+		Data:       map[string]string{"mapAccounts": autoMappedAWSAccountsYAML},
+	}
+	if _, err := configMaps.Create(context.Background(), cm, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// a send only returns once the previous tick has been handled
+	tick := func() {
+		ticks <- time.Now()
+		ticks <- time.Now()
+	}
+
+	tick()
+	if !m.IsAccountAllowed("111122223333") {
+		t.Fatal("expected a resync to load the configmap without a watch event")
+	}
+
+	ms.saveMap(nil, nil, nil)
+	tick()
+	if m.IsAccountAllowed("111122223333") {
+		t.Error("expected a resync to skip a configmap with an unchanged resourceVersion")
+	}
+
+	cm.ResourceVersion = "2"
+	cm.Data["mapAccounts"] = updatedAWSAccountsYAML
+	if _, err := configMaps.Update(context.Background(), cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	tick()
+	if !m.IsAccountAllowed("333344445555") {
+		t.Error("expected a resync to load a changed configmap")
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	cm := &core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: DefaultName},
+		Data:       map[string]string{"mapRoles": roleMapping},
+	}
+	clientset := k8sfake.NewSimpleClientset(cm)
+	watcher := watch.NewFake()
+	clientset.PrependWatchReactor("configmaps", k8stesting.DefaultWatchReactor(watcher, nil))
+	ms := &MapStore{
+		configMap: clientset.CoreV1().ConfigMaps(DefaultNamespace),
+		name:      DefaultName,
+	}
+	m := &ConfigMapMapper{ms}
+	before := goruntime.NumGoroutine()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if m.Running() {
+		t.Fatal("expected mapper not to be running before Start")
+	}
+	if err := m.Start(stopCh); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Running() {
+		t.Fatal("expected mapper to be running after Start")
+	}
+	if err := m.Start(stopCh); err != mapper.ErrAlreadyRunning {
+		t.Errorf("expected ErrAlreadyRunning starting twice, got %v", err)
+	}
+
+	m.Stop()
+	if m.Running() {
+		t.Fatal("expected mapper not to be running after Stop")
+	}
+	m.Stop()
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:iam:123:role/me"}); err != nil {
+		t.Errorf("expected the last loaded mappings to be served after Stop, got %v", err)
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		return goruntime.NumGoroutine() <= before, nil
+	}); err != nil {
+		t.Errorf("expected background goroutines to exit after Stop, %d running, %d before Start", goruntime.NumGoroutine(), before)
+	}
+
+	// it can be restarted
+	if err := m.StartWithContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Running() {
+		t.Error("expected mapper to be running after restarting")
+	}
+	m.Stop()
+}
+
+func TestStopDiscardsPending(t *testing.T) {
+	cm := &core_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: DefaultName, ResourceVersion: "1"},
+		Data:       map[string]string{"mapRoles": roleMapping},
+	}
+	clientset := k8sfake.NewSimpleClientset(cm)
+	watcher := watch.NewFake()
+	clientset.PrependWatchReactor("configmaps", k8stesting.DefaultWatchReactor(watcher, nil))
+	ms := &MapStore{
+		configMap: clientset.CoreV1().ConfigMaps(DefaultNamespace),
+		name:      DefaultName,
+		debounce:  100 * time.Millisecond,
+	}
+	m := &ConfigMapMapper{ms}
+	if err := m.StartWithContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := cm.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Data["mapRoles"] = updatedRoleMapping
+	watcher.Modify(updated)
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		ms.pendingMutex.Lock()
+		defer ms.pendingMutex.Unlock()
+		return ms.pending != nil, nil
+	}); err != nil {
+		t.Fatal("expected the update to wait for the debounce window")
+	}
+	m.Stop()
+
+	time.Sleep(2 * ms.debounce)
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:iam:123:role/you"}); err == nil {
+		t.Error("expected the configmap pending when the mapper stopped not to be loaded")
+	}
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:iam:123:role/me"}); err != nil {
+		t.Errorf("expected the mappings loaded before Stop to be served, got %v", err)
+	}
+}
+
+func TestMapCanonicalizesStoredARN(t *testing.T) {
+	parsed, err := ParseMap(map[string]string{
+		"mapRoles": `
+- rolearn: arn:aws:sts::012345678912:assumed-role/Admin/session
+  username: admin
+  groups:
+  - system:masters
+`,
+		"mapUsers": `
+- userarn: arn:aws:iam::012345678912:user/Alice
+  username: alice
+  groups:
+  - dev
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := &MapStore{}
+	ms.saveMap(parsed.UserMappings, parsed.RoleMappings, nil)
+	m := &ConfigMapMapper{ms}
+
+	mapping, err := m.Map(&token.Identity{
+		ARN:          "arn:aws:sts::012345678912:assumed-role/Admin/other-session",
+		CanonicalARN: "arn:aws:iam::012345678912:role/Admin",
+	})
+	if err != nil {
+		t.Fatalf("expected assumed-role mapping to match its canonical role, got %v", err)
+	}
+	if mapping.Username != "admin" {
+		t.Errorf("unexpected mapping %+v", mapping)
+	}
+
+	mapping, err = m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678912:user/Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.Username != "alice" {
+		t.Errorf("unexpected mapping %+v", mapping)
+	}
+}
+
+// benchmarkRoles builds n exact role mappings plus one SSO mapping.
+func benchmarkRoles(n int) []config.RoleMapping {
+	roles := make([]config.RoleMapping, 0, n+1)
+	for i := 0; i < n; i++ {
+		roles = append(roles, config.RoleMapping{
+			RoleARN:  fmt.Sprintf("arn:aws:iam::012345678912:role/role-%d", i),
+			Username: fmt.Sprintf("role-%d", i),
+			Groups:   []string{"system:nodes"},
+		})
+	}
+	return append(roles, testSSORole)
+}
+
+func TestMapConcurrentUpdates(t *testing.T) {
+	ms := &MapStore{}
+	roles := benchmarkRoles(10)
+	ms.saveMap([]config.UserMapping{testUser}, roles, []string{"111122223333"})
+	m := &ConfigMapMapper{ms}
+	identity := &token.Identity{
+		ARN:          "arn:aws:sts::012345678912:assumed-role/role-5/session",
+		CanonicalARN: "arn:aws:iam::012345678912:role/role-5",
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// every update keeps role-5, so readers must never miss it
+				if _, err := m.Map(identity); err != nil {
+					t.Errorf("expected role-5 to stay mapped during updates, got %v", err)
+					return
+				}
+				m.IsAccountAllowed("111122223333")
+				ms.Snapshot()
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		if i%2 == 0 {
+			ms.saveMap(nil, roles, nil)
+		} else {
+			ms.saveParsedMap(nil, roles[:6], nil, nil, ErrParsingMap{errors: []error{
+				parseError{"mapUsers", parseErrorSyntax, errors.New("bad"), ""},
+			}})
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func BenchmarkMapParallel(b *testing.B) {
+	ms := &MapStore{}
+	roles := benchmarkRoles(100)
+	ms.saveMap(nil, roles, nil)
+	m := &ConfigMapMapper{ms}
+	identity := &token.Identity{
+		ARN:          "arn:aws:sts::012345678912:assumed-role/role-50/session",
+		CanonicalARN: "arn:aws:iam::012345678912:role/role-50",
+	}
+
+	run := func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := m.Map(identity); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	}
+	b.Run("readers", run)
+	b.Run("readers with updates", func(b *testing.B) {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					ms.saveMap(nil, roles, nil)
+				}
+			}
+		}()
+		run(b)
+		close(stop)
+		<-done
+	})
+}
+
+func TestMapResourceCaseSensitive(t *testing.T) {
+	ms := &MapStore{cache: newMapCache(10, 0)}
+	ms.saveMap([]config.UserMapping{
+		{UserARN: "arn:aws:iam::012345678912:user/Bob", Username: "Bob", Groups: []string{"dev"}},
+	}, []config.RoleMapping{
+		{RoleARN: "arn:aws:iam::012345678912:role/Foo", Username: "upper", Groups: []string{"dev"}},
+		{RoleARN: "arn:aws:iam::012345678912:role/foo", Username: "lower", Groups: []string{"dev"}},
+		testSSORole,
+	}, nil)
+	m := &ConfigMapMapper{ms}
+
+	cases := []struct {
+		canonicalARN string
+		username     string
+	}{
+		{"arn:aws:iam::012345678912:role/Foo", "upper"},
+		{"arn:aws:iam::012345678912:role/foo", "lower"},
+		// partition, service and account are not case sensitive
+		{"arn:AWS:IAM::012345678912:role/Foo", "upper"},
+		{"arn:aws:iam::012345678912:user/Bob", "Bob"},
+		{"arn:aws:iam::012345678912:user/bob", ""},
+		{"arn:aws:iam::012345678912:role/FOO", ""},
+		// SSO role names are matched ignoring case
+		{"arn:aws:iam::012345678912:role/AWSReservedSSO_ViewOnlyAccess_0123abcd", testSSORole.Username},
+	}
+	for _, c := range cases {
+		mapping, err := m.Map(&token.Identity{CanonicalARN: c.canonicalARN})
+		if c.username == "" {
+			if !errors.Is(err, mapper.ErrNotMapped) {
+				t.Errorf("expected %s not to be mapped, got %+v, %v", c.canonicalARN, mapping, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected %s to be mapped: %v", c.canonicalARN, err)
+			continue
+		}
+		if mapping.Username != c.username {
+			t.Errorf("expected %s to map to %s, got %s", c.canonicalARN, c.username, mapping.Username)
+		}
+	}
+	if len(ms.Snapshot().Roles) != 3 {
+		t.Errorf("expected role/Foo and role/foo to be kept as separate mappings, got %+v", ms.Snapshot().Roles)
+	}
+}
+
+func TestMapMatchesFileMapper(t *testing.T) {
+	parsed, err := ParseMap(map[string]string{
+		"mapRoles": `
+- rolearn: arn:aws:iam::012345678912:role/Admin
+  username: admin
+  groups:
+  - system:masters
+- rolearn: arn:aws:sts::012345678912:assumed-role/Deploy/session
+  username: deploy
+  groups:
+  - deployers
+- rolearn: arn:aws:sts::012345678912:assumed-role/Admin/break-glass
+  rawmatch: true
+  username: break-glass
+  groups:
+  - system:masters
+- rolearn: arn:aws:iam::012345678912:role/Admin
+  sessionnamelike: oncall-*
+  username: oncall
+  groups:
+  - oncall
+- rolearnregex: arn:aws:iam::012345678912:role/team-.*
+  username: team
+  groups:
+  - team
+- sso:
+    permissionSetName: ViewOnlyAccess
+    accountID: "012345678912"
+  username: viewer
+  groups:
+  - view
+`,
+		"mapUsers": `
+- userarn: arn:aws:iam::012345678912:user/Alice
+  username: alice
+  groups:
+  - dev
+- userarnregex: arn:aws:iam::012345678912:user/ci-.*
+  username: ci
+  groups:
+  - ci
+`,
+		"mapAccounts": `
+- "111122223333"
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := &MapStore{}
+	ms.saveMap(parsed.UserMappings, parsed.RoleMappings, parsed.AWSAccounts)
+	cm := &ConfigMapMapper{ms}
+	fm, err := file.NewFileMapper(config.Config{RoleMappings: parsed.RoleMappings, UserMappings: parsed.UserMappings, AutoMappedAWSAccounts: parsed.AWSAccounts})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identities := []token.Identity{
+		{CanonicalARN: "arn:aws:iam::012345678912:role/Admin", AccountID: "012345678912"},
+		{CanonicalARN: "arn:AWS:IAM::012345678912:role/Admin", AccountID: "012345678912"},
+		{CanonicalARN: "arn:aws:iam::012345678912:role/admin", AccountID: "012345678912"},
+		{ARN: "arn:aws:sts::012345678912:assumed-role/Admin/break-glass", CanonicalARN: "arn:aws:iam::012345678912:role/Admin"},
+		{ARN: "arn:aws:sts::012345678912:assumed-role/Admin/oncall-bob", CanonicalARN: "arn:aws:iam::012345678912:role/Admin"},
+		{ARN: "arn:aws:sts::012345678912:assumed-role/Admin/bob", CanonicalARN: "arn:aws:iam::012345678912:role/Admin"},
+		{ARN: "arn:aws:sts::012345678912:assumed-role/Deploy/ci", CanonicalARN: "arn:aws:iam::012345678912:role/Deploy"},
+		{CanonicalARN: "arn:aws:iam::012345678912:role/team-a"},
+		{CanonicalARN: "arn:aws:iam::012345678912:role/Team-A"},
+		{CanonicalARN: "arn:aws:iam::012345678912:role/AWSReservedSSO_ViewOnlyAccess_0123456789abcdef"},
+		{CanonicalARN: "arn:aws:iam::012345678912:user/Alice"},
+		{CanonicalARN: "arn:aws:iam::012345678912:user/alice"},
+		{CanonicalARN: "arn:aws:iam::012345678912:user/ci-build"},
+		{ARN: "arn:aws:iam::111122223333:user/Bob", CanonicalARN: "arn:aws:iam::111122223333:user/Bob", AccountID: "111122223333"},
+	}
+	mapped := 0
+	for _, identity := range identities {
+		name := identity.CanonicalARN
+		if identity.ARN != "" {
+			name = identity.ARN
+		}
+		t.Run(name, func(t *testing.T) {
+			cmMapping, cmErr := cm.Map(&identity)
+			fmMapping, fmErr := fm.Map(&identity)
+			// the mappings only differ in the name of the mapper
+			if fmMapping != nil {
+				fmMapping.MatchSource.Mapper = cm.Name()
+			}
+			if !reflect.DeepEqual(cmMapping, fmMapping) {
+				t.Errorf("configmap mapped to %+v, file to %+v", cmMapping, fmMapping)
+			}
+			if !reflect.DeepEqual(cmErr, fmErr) {
+				t.Errorf("configmap returned %v, file %v", cmErr, fmErr)
+			}
+			if cmErr == nil {
+				mapped++
+			}
+		})
+	}
+	if mapped != 11 {
+		t.Errorf("expected 11 of the identities to be mapped, got %d", mapped)
+	}
+}
+
+func TestARNLikeRuleMatches(t *testing.T) {
+	regexRole := config.RoleMapping{RoleARNRegex: "arn:aws:iam::012345678912:role/team-.*", Username: "team", Groups: []string{"team"}}
+	regexUser := config.UserMapping{UserARNRegex: "arn:aws:iam::012345678912:user/ci-.*", Username: "ci", Groups: []string{"ci"}}
+	matches := metrics.Get().ARNLikeRuleMatches
+	counts := func() []float64 {
+		return []float64{
+			testutil.ToFloat64(matches.WithLabelValues(testSSORole.Key())),
+			testutil.ToFloat64(matches.WithLabelValues(regexRole.Key())),
+			testutil.ToFloat64(matches.WithLabelValues(regexUser.Key())),
+			testutil.ToFloat64(matches.WithLabelValues(testRole.Key())),
+		}
+	}
+	identities := []string{
+		"arn:aws:iam::012345678912:role/AWSReservedSSO_ViewOnlyAccess_0123456789abcdef",
+		"arn:aws:iam::012345678912:role/team-a",
+		"arn:aws:iam::012345678912:role/team-b",
+		"arn:aws:iam::012345678912:user/ci-build",
+		testRole.RoleARN,
+	}
+
+	for _, enabled := range []bool{false, true} {
+		ms := &MapStore{patternMetrics: enabled}
+		ms.saveMap([]config.UserMapping{regexUser}, []config.RoleMapping{testRole, testSSORole, regexRole}, nil)
+		m := &ConfigMapMapper{ms}
+
+		before := counts()
+		for _, arn := range identities {
+			if _, err := m.Map(&token.Identity{CanonicalARN: arn}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected := []float64{0, 0, 0, 0}
+		if enabled {
+			// the SSO role once, the regex role twice, the regex user once
+			// and never the exact role
+			expected = []float64{1, 2, 1, 0}
+		}
+		after := counts()
+		for i := range after {
+			if got := after[i] - before[i]; got != expected[i] {
+				t.Errorf("enabled %v: expected counter %d to advance by %v, got %v", enabled, i, expected[i], got)
+			}
+		}
+	}
+}
+
+func TestMapExactBeatsPattern(t *testing.T) {
+	roles := []config.RoleMapping{
+		{RoleARNRegex: "arn:aws:iam::012345678912:.*", Username: "anything"},
+		testRole,
+	}
+	users := []config.UserMapping{
+		{UserARNRegex: "arn:aws:iam::012345678912:user/.*", Username: "any-user"},
+		testUser,
+	}
+	cases := map[string]string{
+		testRole.RoleARN: testRole.Username,
+		// the role pattern also matches the user ARN, but loses to the exact user
+		testUser.UserARN:                       testUser.Username,
+		"arn:aws:iam::012345678912:role/other": "anything",
+		"arn:aws:iam::012345678912:user/other": "anything",
+	}
+
+	// the order the mappings are loaded in must not matter
+	for _, reverse := range []bool{false, true} {
+		if reverse {
+			roles[0], roles[1] = roles[1], roles[0]
+			users[0], users[1] = users[1], users[0]
+		}
+		ms := &MapStore{}
+		ms.saveMap(users, roles, nil)
+		m := &ConfigMapMapper{ms}
+		for arn, username := range cases {
+			mapping, err := m.Map(&token.Identity{CanonicalARN: arn})
+			if err != nil {
+				t.Fatalf("expected %s to be mapped, got %v", arn, err)
+			}
+			if mapping.Username != username {
+				t.Errorf("expected %s to map to %q, got %q", arn, username, mapping.Username)
+			}
+		}
+	}
+}
+
+func TestMapMatchSource(t *testing.T) {
+	ms := &MapStore{}
+	ms.saveMap(
+		[]config.UserMapping{
+			testUser,
+			{UserARNRegex: "arn:aws:iam::012345678912:user/ci-.*", Username: "ci"},
+		},
+		[]config.RoleMapping{
+			testRole,
+			{RoleARNRegex: "arn:aws:iam::012345678912:role/team-.*", Username: "team"},
+		},
+		nil)
+	m := &ConfigMapMapper{ms}
+
+	cases := map[string]string{
+		testRole.RoleARN:                          mapper.LookupRole,
+		testUser.UserARN:                          mapper.LookupUser,
+		"arn:aws:iam::012345678912:role/team-a":   mapper.LookupRolePattern,
+		"arn:aws:iam::012345678912:user/ci-build": mapper.LookupUserPattern,
+	}
+	for arn, lookup := range cases {
+		mapping, err := m.Map(&token.Identity{CanonicalARN: arn})
+		if err != nil {
+			t.Fatalf("expected %s to be mapped, got %v", arn, err)
+		}
+		expected := config.MatchSource{Mapper: mapper.ModeEKSConfigMap, Lookup: lookup}
+		if mapping.MatchSource != expected {
+			t.Errorf("expected %s to be mapped by %+v, got %+v", arn, expected, mapping.MatchSource)
+		}
+	}
+}
+
+func TestMapIgnorePath(t *testing.T) {
+	roles := []config.RoleMapping{
+		{RoleARN: "arn:aws:iam::012345678912:role/Admin", Username: "admin"},
+		{RoleARN: "arn:aws:iam::012345678912:role/platform/Deploy", Username: "deploy"},
+	}
+	users := []config.UserMapping{
+		{UserARN: "arn:aws:iam::012345678912:user/Bob", Username: "bob"},
+	}
+	cases := []struct {
+		arn      string
+		username string
+	}{
+		{"arn:aws:iam::012345678912:role/team/Admin", "admin"},
+		{"arn:aws:iam::012345678912:user/team/Bob", "bob"},
+		// sts drops the path of an assumed role, so the canonical ARN of a
+		// session has none
+		{"arn:aws:sts::012345678912:assumed-role/Deploy/ci", "deploy"},
+	}
+	for _, ignorePath := range []bool{false, true} {
+		ms := &MapStore{ignorePath: ignorePath}
+		ms.saveMap(users, roles, nil)
+		m := &ConfigMapMapper{ms}
+		for _, c := range cases {
+			canonicalARN, err := arn.Canonicalize(c.arn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mapping, err := m.Map(&token.Identity{ARN: c.arn, CanonicalARN: canonicalARN})
+			if !ignorePath {
+				if !errors.Is(err, mapper.ErrNotMapped) {
+					t.Errorf("expected %s not to match a mapping with another path, got %+v, %v", c.arn, mapping, err)
+				}
+				continue
+			}
+			if err != nil || mapping.Username != c.username {
+				t.Errorf("expected %s to map to %q ignoring paths, got %+v, %v", c.arn, c.username, mapping, err)
+			}
+		}
+	}
+}
+
+func TestMapAssumedRole(t *testing.T) {
+	ms := &MapStore{}
+	ms.saveMap(nil, []config.RoleMapping{
+		{RoleARN: "arn:aws:iam::012345678912:role/team/Admin", Username: "admin-{{SessionName}}"},
+	}, nil)
+	m := &ConfigMapMapper{ms}
+	const sessionARN = "arn:aws:sts::012345678912:assumed-role/team/Admin/alice"
+
+	mapping, err := m.Map(&token.Identity{ARN: sessionARN})
+	if err != nil {
+		t.Fatalf("expected an assumed-role ARN to match the mapping of its role, got %v", err)
+	}
+	if mapping.IdentityARN != "arn:aws:iam::012345678912:role/team/Admin" || mapping.Username != "admin-{{SessionName}}" {
+		t.Errorf("unexpected mapping %+v", mapping)
+	}
+	if _, err := m.RoleMapping(sessionARN); err != nil {
+		t.Errorf("expected RoleMapping to look up an assumed-role ARN as its role, got %v", err)
+	}
+}
+
+func TestAccountBypassGroups(t *testing.T) {
+	logger := &captureLogger{}
+	ms := &MapStore{
+		logger:                logger,
+		enforceAccountAllowed: true,
+		accountBypassGroups:   map[string]bool{"system:masters": true},
+	}
+	ms.saveMap(nil, []config.RoleMapping{
+		{RoleARN: "arn:aws:iam::444455556666:role/admin", Username: "admin", Groups: []string{"system:masters"}},
+		{RoleARN: "arn:aws:iam::444455556666:role/dev", Username: "dev", Groups: []string{"dev"}},
+		{RoleARN: "arn:aws:iam::111122223333:role/dev", Username: "allowed-dev", Groups: []string{"dev"}},
+	}, []string{"111122223333"})
+	m := &ConfigMapMapper{ms}
+
+	mapping, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::444455556666:role/admin", AccountID: "444455556666"})
+	if err != nil {
+		t.Fatalf("expected a mapping granting a bypass group to resolve, got %v", err)
+	}
+	if mapping.Username != "admin" {
+		t.Errorf("unexpected mapping %+v", mapping)
+	}
+	if !logger.logged("warn: Mapping grants bypass group system:masters") {
+		t.Errorf("expected the bypass to be logged, got %q", logger.entries)
+	}
+
+	_, err = m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::444455556666:role/dev", AccountID: "444455556666"})
+	var notMapped mapper.NotMappedError
+	if !errors.As(err, &notMapped) || notMapped.AccountAllowed {
+		t.Errorf("expected a mapping in an account that isn't allowed to be rejected, got %v", err)
+	}
+
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::111122223333:role/dev"}); err != nil {
+		t.Errorf("expected a mapping in an allowed account to resolve, got %v", err)
+	}
+
+	ms.enforceAccountAllowed = false
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::444455556666:role/dev", AccountID: "444455556666"}); err != nil {
+		t.Errorf("expected accounts not to be checked by default, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package configmap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+// RoleTagResolver looks up the tags of IAM roles for the mapRoleTags
+// mappings, for example with the IAM ListRoleTags API.
+type RoleTagResolver interface {
+	// RoleTags returns the tags of the role with the canonical ARN roleARN.
+	RoleTags(ctx context.Context, roleARN string) (map[string]string, error)
+}
+
+// NoRoleTags is the RoleTagResolver used when none is configured. No role
+// has any tags, so mapRoleTags mappings never match.
+type NoRoleTags struct{}
+
+func (NoRoleTags) RoleTags(context.Context, string) (map[string]string, error) {
+	return nil, nil
+}
+
+// WithRoleTagResolver makes the mapRoleTags mappings look up the tags of
+// roles with resolver. Tags are cached for ttl so every Map of a role
+// doesn't call IAM, zero doesn't cache them. Failed lookups aren't cached.
+func WithRoleTagResolver(resolver RoleTagResolver, ttl time.Duration) Option {
+	return func(ms *MapStore) {
+		if ttl > 0 {
+			resolver = newRoleTagCache(resolver, ttl)
+		}
+		ms.roleTagResolver = resolver
+	}
+}
+
+// tagResolver returns the RoleTagResolver of the store, NoRoleTags if none
+// was configured.
+func (ms *MapStore) tagResolver() RoleTagResolver {
+	if ms.roleTagResolver == nil {
+		return NoRoleTags{}
+	}
+	return ms.roleTagResolver
+}
+
+// roleTagCache is a RoleTagResolver caching the tags returned by another.
+type roleTagCache struct {
+	resolver RoleTagResolver
+	ttl      time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]roleTagEntry
+	// swept is when expired entries were last removed.
+	swept time.Time
+	// now is overridden in tests
+	now func() time.Time
+}
+
+type roleTagEntry struct {
+	tags    map[string]string
+	expires time.Time
+}
+
+func newRoleTagCache(resolver RoleTagResolver, ttl time.Duration) *roleTagCache {
+	return &roleTagCache{
+		resolver: resolver,
+		ttl:      ttl,
+		entries:  make(map[string]roleTagEntry),
+		now:      time.Now,
+	}
+}
+
+func (c *roleTagCache) RoleTags(ctx context.Context, roleARN string) (map[string]string, error) {
+	c.mutex.Lock()
+	e, ok := c.entries[roleARN]
+	c.mutex.Unlock()
+	if ok && c.now().Before(e.expires) {
+		return e.tags, nil
+	}
+
+	// concurrent misses for a role may each call the resolver, the last
+	// one to return is cached
+	tags, err := c.resolver.RoleTags(ctx, roleARN)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.now()
+	// roles that are no longer mapped would otherwise be kept forever
+	if now.Sub(c.swept) >= c.ttl {
+		for key, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, key)
+			}
+		}
+		c.swept = now
+	}
+	c.entries[roleARN] = roleTagEntry{tags: tags, expires: now.Add(c.ttl)}
+	return tags, nil
+}
+
+// parseRoleTags parses the mapRoleTags section of the configmap data m,
+// returning the valid mappings and a parseError for every problem.
+// Mappings for the same tag as an earlier one replace it.
+func parseRoleTags(m map[string]string) (roleTagMappings []config.RoleTagMapping, errs []error) {
+	roleTagMappings = make([]config.RoleTagMapping, 0)
+	data, ok := m["mapRoleTags"]
+	if !ok {
+		return roleTagMappings, nil
+	}
+	rawRoleTagMappings := make([]config.RoleTagMapping, 0)
+	tagJson, err := utilyaml.ToJSON([]byte(data))
+	if err != nil {
+		return roleTagMappings, []error{parseError{"mapRoleTags", parseErrorSyntax, err, ""}}
+	}
+	if err := json.Unmarshal(tagJson, &rawRoleTagMappings); err != nil {
+		errs = append(errs, parseError{"mapRoleTags", parseErrorSyntax, err, ""})
+	}
+
+	keys := make(map[string]int)
+	for _, roleTagMapping := range rawRoleTagMappings {
+		if err := roleTagMapping.Validate(); err != nil {
+			errs = append(errs, parseError{"mapRoleTags", parseErrorValidation, err, roleTagMapping.Key()})
+			continue
+		}
+		key := roleTagMapping.Key()
+		if i, ok := keys[key]; ok {
+			errs = append(errs, parseError{"mapRoleTags", parseErrorDuplicate,
+				fmt.Errorf("mapRoleTags has more than one entry for %q, using the last one", key), key})
+			roleTagMappings[i] = roleTagMapping
+			continue
+		}
+		keys[key] = len(roleTagMappings)
+		roleTagMappings = append(roleTagMappings, roleTagMapping)
+	}
+	return roleTagMappings, errs
+}
+
+// mapRoleTags maps identity with the mapRoleTags mappings of current once
+// none of the ARN mappings matched it, notMapped being the error saying so.
+// The first mapping, in configmap order, whose tag the role carries wins.
+// Identities that aren't roles are not looked up.
+func (m *ConfigMapMapper) mapRoleTags(current *mappings, identity *token.Identity, notMapped error) (*config.IdentityMapping, string, error) {
+	var notMappedErr mapper.NotMappedError
+	if len(current.roleTags) == 0 || !errors.As(notMapped, &notMappedErr) || notMappedErr.ARNKind != "role" {
+		return nil, mapper.MatchKindNone, notMapped
+	}
+	tags, err := m.tagResolver().RoleTags(context.Background(), notMappedErr.ARN)
+	if err != nil {
+		return nil, mapper.MatchKindNone, fmt.Errorf("error looking up tags of %s: %v", notMappedErr.ARN, err)
+	}
+	for i := range current.roleTags {
+		if roleTag := &current.roleTags[i]; roleTag.Matches(tags) {
+			return &config.IdentityMapping{
+				IdentityARN: notMappedErr.ARN,
+				Username:    roleTag.Username,
+				Groups:      roleTag.Groups,
+				MatchSource: config.MatchSource{Mapper: m.Name(), Lookup: mapper.LookupRoleTag},
+			}, mapper.MatchKindRole, nil
+		}
+	}
+	return nil, mapper.MatchKindNone, mapper.NewNotMappedError(notMappedErr.ARN, notMappedErr.AccountAllowed,
+		append(notMappedErr.Attempted, mapper.LookupRoleTag)...)
+}
@@ -0,0 +1,192 @@
+package configmap
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/metrics"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+// fakeRoleTags is a RoleTagResolver serving tags from a map and counting
+// the lookups of each role.
+type fakeRoleTags struct {
+	mutex sync.Mutex
+	tags  map[string]map[string]string
+	err   error
+	calls map[string]int
+}
+
+func (f *fakeRoleTags) RoleTags(_ context.Context, roleARN string) (map[string]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[roleARN]++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.tags[roleARN], nil
+}
+
+func (f *fakeRoleTags) called(roleARN string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls[roleARN]
+}
+
+var roleTagConfigMap = &core_v1.ConfigMap{
+	ObjectMeta: metav1.ObjectMeta{Namespace: DefaultNamespace, Name: DefaultName},
+	Data: map[string]string{
+		"mapRoles": `
+- rolearn: arn:aws:iam::111122223333:role/ops
+  username: ops
+  groups:
+  - ops
+`,
+		"mapRoleTags": `
+- tagkey: team
+  tagvalue: payments
+  username: payments:{{SessionName}}
+  groups:
+  - payments-developers
+`,
+	},
+}
+
+func TestMapRoleTags(t *testing.T) {
+	resolver := &fakeRoleTags{tags: map[string]map[string]string{
+		"arn:aws:iam::111122223333:role/Payments": {"team": "payments", "env": "prod"},
+		"arn:aws:iam::111122223333:role/ops":      {"team": "payments"},
+		"arn:aws:iam::111122223333:role/Search":   {"team": "search"},
+	}}
+	ms := NewWithClient(nil, metrics.Get(), WithRoleTagResolver(resolver, time.Minute))
+	if err := ms.handleConfigMap(roleTagConfigMap); err != nil {
+		t.Fatalf("unexpected error loading the configmap: %v", err)
+	}
+	m := &ConfigMapMapper{ms}
+
+	mapping, err := m.Map(&token.Identity{
+		ARN:          "arn:aws:sts::111122223333:assumed-role/Payments/jdoe",
+		CanonicalARN: "arn:aws:iam::111122223333:role/Payments",
+		AccountID:    "111122223333",
+	})
+	if err != nil {
+		t.Fatalf("expected the tagged role to be mapped, got %v", err)
+	}
+	expected := &config.IdentityMapping{
+		IdentityARN: "arn:aws:iam::111122223333:role/Payments",
+		Username:    "payments:{{SessionName}}",
+		Groups:      []string{"payments-developers"},
+		MatchSource: config.MatchSource{Mapper: mapper.ModeEKSConfigMap, Lookup: mapper.LookupRoleTag},
+	}
+	if !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("expected %+v, got %+v", expected, mapping)
+	}
+
+	// an ARN mapping wins without looking the tags up
+	mapping, err = m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::111122223333:role/ops"})
+	if err != nil || mapping.Username != "ops" {
+		t.Errorf("expected the rolearn mapping, got %+v, %v", mapping, err)
+	}
+	if n := resolver.called("arn:aws:iam::111122223333:role/ops"); n != 0 {
+		t.Errorf("expected no tag lookup for a role with an ARN mapping, got %d", n)
+	}
+
+	var notMapped mapper.NotMappedError
+	_, err = m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::111122223333:role/Search"})
+	if !errors.As(err, &notMapped) {
+		t.Fatalf("expected a role without a mapped tag not to be mapped, got %v", err)
+	}
+	if attempted := notMapped.Attempted; attempted[len(attempted)-1] != mapper.LookupRoleTag {
+		t.Errorf("expected the tag lookup to be attempted last, got %v", attempted)
+	}
+
+	if _, err = m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::111122223333:user/Payments"}); !errors.Is(err, mapper.ErrNotMapped) {
+		t.Errorf("expected a user not to be mapped, got %v", err)
+	}
+	if n := resolver.called("arn:aws:iam::111122223333:user/Payments"); n != 0 {
+		t.Errorf("expected no tag lookup for a user, got %d", n)
+	}
+
+	resolver.err = errors.New("throttled")
+	if _, err = m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::111122223333:role/Other"}); err == nil || errors.Is(err, mapper.ErrNotMapped) {
+		t.Errorf("expected the tag lookup error, got %v", err)
+	}
+}
+
+func TestMapRoleTagsWithoutResolver(t *testing.T) {
+	ms := NewWithClient(nil, metrics.Get())
+	if err := ms.handleConfigMap(roleTagConfigMap); err != nil {
+		t.Fatalf("unexpected error loading the configmap: %v", err)
+	}
+	m := &ConfigMapMapper{ms}
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::111122223333:role/Payments"}); !errors.Is(err, mapper.ErrNotMapped) {
+		t.Errorf("expected tag mappings not to match without a resolver, got %v", err)
+	}
+}
+
+func TestRoleTagCache(t *testing.T) {
+	const roleARN = "arn:aws:iam::111122223333:role/Payments"
+	resolver := &fakeRoleTags{tags: map[string]map[string]string{roleARN: {"team": "payments"}}}
+	cache := newRoleTagCache(resolver, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		tags, err := cache.RoleTags(ctx, roleARN)
+		if err != nil || tags["team"] != "payments" {
+			t.Fatalf("unexpected tags %v, %v", tags, err)
+		}
+	}
+	if n := resolver.called(roleARN); n != 1 {
+		t.Errorf("expected the tags to be looked up once, got %d", n)
+	}
+
+	now = now.Add(time.Minute)
+	resolver.err = errors.New("throttled")
+	if _, err := cache.RoleTags(ctx, roleARN); err == nil {
+		t.Error("expected the error looking up expired tags")
+	}
+	resolver.err = nil
+	if _, err := cache.RoleTags(ctx, roleARN); err != nil {
+		t.Errorf("expected the failed lookup not to be cached, got %v", err)
+	}
+	if n := resolver.called(roleARN); n != 3 {
+		t.Errorf("expected the tags to be looked up again once expired, got %d", n)
+	}
+}
+
+func TestParseRoleTags(t *testing.T) {
+	roleTags, errs := parseRoleTags(map[string]string{"mapRoleTags": `
+- tagkey: team
+  tagvalue: payments
+  username: payments
+- tagvalue: search
+  username: search
+- tagkey: team
+  tagvalue: payments
+  username: payments-again
+`})
+	if len(roleTags) != 1 || roleTags[0].Username != "payments-again" {
+		t.Errorf("expected the last mapping for the tag, got %+v", roleTags)
+	}
+	var types []string
+	for _, err := range errs {
+		types = append(types, err.(parseError).errorType)
+	}
+	if expected := []string{parseErrorValidation, parseErrorDuplicate}; !reflect.DeepEqual(types, expected) {
+		t.Errorf("expected %v errors, got %v", expected, errs)
+	}
+}
@@ -107,6 +107,7 @@ func TestConfigMap(t *testing.T) {
 			cs := fake.NewSimpleClientset()
 			ms := MapStore{}
 			ms.configMap = cs.CoreV1().ConfigMaps("kube-system")
+			ms.name = DefaultName
 
 			stopCh := make(chan struct{})
 			ms.startLoadConfigMap(stopCh)
@@ -117,7 +118,7 @@ func TestConfigMap(t *testing.T) {
 			time.Sleep(2 * time.Millisecond)
 
 			for _, em := range tt.expectedRoleMappings {
-				m, err := ms.RoleMapping(strings.ToLower(em.RoleARN))
+				m, err := ms.RoleMapping(em.RoleARN)
 				if err != nil {
 					t.Errorf("%v", err)
 				}
@@ -125,14 +126,12 @@ func TestConfigMap(t *testing.T) {
 					t.Errorf("expected role mapping %v, got %v", em, m)
 				}
 			}
-			ms.mutex.Lock()
-			if len(tt.expectedRoleMappings) != len(ms.roles) {
-				t.Errorf("expected role mappings %v, got %v", tt.expectedRoleMappings, ms.roles)
+			if len(tt.expectedRoleMappings) != len(ms.load().roles) {
+				t.Errorf("expected role mappings %v, got %v", tt.expectedRoleMappings, ms.load().roles)
 			}
-			ms.mutex.Unlock()
 
 			for _, em := range tt.expectedUserMappings {
-				m, err := ms.UserMapping(strings.ToLower(em.UserARN))
+				m, err := ms.UserMapping(em.UserARN)
 				if err != nil {
 					t.Errorf("%v", err)
 				}
@@ -140,11 +139,9 @@ func TestConfigMap(t *testing.T) {
 					t.Errorf("expected user mapping %v, got %v", em, m)
 				}
 			}
-			ms.mutex.Lock()
-			if len(tt.expectedUserMappings) != len(ms.users) {
-				t.Errorf("expected user mappings %v, got %v", tt.expectedUserMappings, ms.users)
+			if len(tt.expectedUserMappings) != len(ms.load().users) {
+				t.Errorf("expected user mappings %v, got %v", tt.expectedUserMappings, ms.load().users)
 			}
-			ms.mutex.Unlock()
 
 			for accountID, eok := range tt.expectedAWSAccounts {
 				ok := ms.AWSAccount(strings.ToLower(accountID))
@@ -152,11 +149,9 @@ func TestConfigMap(t *testing.T) {
 					t.Errorf("expected account %s %v, got %v", accountID, eok, ok)
 				}
 			}
-			ms.mutex.Lock()
-			if len(tt.expectedAWSAccounts) != len(ms.awsAccounts) {
-				t.Errorf("expected accounts %v, got %v", tt.expectedAWSAccounts, ms.awsAccounts)
+			if len(tt.expectedAWSAccounts) != len(ms.load().awsAccounts) {
+				t.Errorf("expected accounts %v, got %v", tt.expectedAWSAccounts, ms.load().awsAccounts)
 			}
-			ms.mutex.Unlock()
 		})
 	}
 }
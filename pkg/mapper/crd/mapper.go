@@ -110,6 +110,7 @@ func (m *CRDMapper) Map(identity *token.Identity) (*config.IdentityMapping, erro
 				IdentityARN: canonicalARN,
 				Username:    iamidentity.Spec.Username,
 				Groups:      iamidentity.Spec.Groups,
+				MatchSource: config.MatchSource{Mapper: m.Name()},
 			}, nil
 		}
 	}
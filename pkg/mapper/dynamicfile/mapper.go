@@ -47,6 +47,7 @@ func (m *DynamicFileMapper) Map(identity *token.Identity) (*config.IdentityMappi
 			IdentityARN: canonicalARN,
 			Username:    rm.Username,
 			Groups:      rm.Groups,
+			MatchSource: config.MatchSource{Mapper: m.Name(), Lookup: mapper.LookupRole},
 		}, nil
 	}
 
@@ -56,6 +57,7 @@ func (m *DynamicFileMapper) Map(identity *token.Identity) (*config.IdentityMappi
 			IdentityARN: canonicalARN,
 			Username:    um.Username,
 			Groups:      um.Groups,
+			MatchSource: config.MatchSource{Mapper: m.Name(), Lookup: mapper.LookupUser},
 		}, nil
 	}
 
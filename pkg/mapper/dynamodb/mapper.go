@@ -0,0 +1,272 @@
+// Package dynamodb implements a Mapper that reads mappings from a DynamoDB
+// table.
+//
+// Every item is keyed by the "arn" string attribute:
+//   - exact role or user mappings use the canonical ARN with its partition,
+//     service, region and account lowercased, see arn.NormalizeCase, and are
+//     looked up with GetItem. The resource keeps its case, as IAM compares
+//     it case sensitively,
+//   - pattern mappings (rolearnregex, userarnregex or sso) use the mapping
+//     Key() and set "pattern" to true; they are found with a Scan that is
+//     cached for patternRefreshInterval,
+//   - allowed accounts use the account ID and set "account" to true.
+//
+// The "role" or "user" attribute holds the mapping itself, using the same
+// field names as the aws-auth configmap.
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/arn"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+// patternRefreshInterval is how long scanned pattern mappings are reused
+// before the table is scanned again.
+var patternRefreshInterval = time.Minute
+
+// API is the subset of the DynamoDB client used by the mapper.
+type API interface {
+	GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error)
+	ScanPagesWithContext(ctx aws.Context, input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, opts ...request.Option) error
+}
+
+// item is a single row of the mappings table.
+type item struct {
+	ARN     string              `dynamodbav:"arn"`
+	Pattern bool                `dynamodbav:"pattern,omitempty"`
+	Account bool                `dynamodbav:"account,omitempty"`
+	Role    *config.RoleMapping `dynamodbav:"role,omitempty"`
+	User    *config.UserMapping `dynamodbav:"user,omitempty"`
+}
+
+type DynamoDBMapper struct {
+	client    API
+	tableName string
+	warmCache bool
+
+	mutex          sync.Mutex
+	patternRoles   []config.RoleMapping
+	patternUsers   []config.UserMapping
+	patternsLoaded time.Time
+	// now is overridden in tests
+	now func() time.Time
+
+	usernamePrefixReserveList []string
+}
+
+var _ mapper.Mapper = &DynamoDBMapper{}
+
+// NewDynamoDBMapper creates a DynamoDBMapper reading from the table
+// cfg.DynamoDBTableName in cfg.DynamoDBRegion.
+func NewDynamoDBMapper(cfg config.Config) (*DynamoDBMapper, error) {
+	if cfg.DynamoDBTableName == "" {
+		return nil, fmt.Errorf("dynamodb table name must be set for backend-mode %q", mapper.ModeDynamoDB)
+	}
+	awsCfg := aws.NewConfig()
+	if cfg.DynamoDBRegion != "" {
+		awsCfg = awsCfg.WithRegion(cfg.DynamoDBRegion)
+	}
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %v", err)
+	}
+	m := NewDynamoDBMapperWithClient(dynamodb.New(sess), cfg.DynamoDBTableName, cfg.DynamoDBWarmCache)
+	if value, exists := cfg.ReservedPrefixConfig[mapper.ModeDynamoDB]; exists {
+		m.usernamePrefixReserveList = value.UsernamePrefixReserveList
+	}
+	return m, nil
+}
+
+// NewDynamoDBMapperWithClient creates a DynamoDBMapper using client to read
+// tableName. If warmCache is set, Start loads the pattern mappings.
+func NewDynamoDBMapperWithClient(client API, tableName string, warmCache bool) *DynamoDBMapper {
+	return &DynamoDBMapper{
+		client:    client,
+		tableName: tableName,
+		warmCache: warmCache,
+		now:       time.Now,
+	}
+}
+
+func (m *DynamoDBMapper) Name() string {
+	return mapper.ModeDynamoDB
+}
+
+func (m *DynamoDBMapper) Start(_ <-chan struct{}) error {
+	if !m.warmCache {
+		return nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.loadPatterns(context.Background())
+}
+
+func (m *DynamoDBMapper) Map(identity *token.Identity) (*config.IdentityMapping, error) {
+	return m.MapContext(context.Background(), identity)
+}
+
+// MapContext is Map, passing ctx on to the DynamoDB requests so they are
+// abandoned when the authentication request is.
+func (m *DynamoDBMapper) MapContext(ctx context.Context, identity *token.Identity) (*config.IdentityMapping, error) {
+	canonicalARN := arn.NormalizeCase(identity.CanonicalARN)
+	lower := strings.ToLower(canonicalARN)
+
+	it, err := m.getItem(ctx, canonicalARN)
+	if err != nil {
+		return nil, err
+	}
+	if it != nil && !it.Pattern {
+		switch {
+		case it.Role != nil:
+			return &config.IdentityMapping{
+				IdentityARN: canonicalARN,
+				Username:    it.Role.Username,
+				Groups:      it.Role.Groups,
+				MatchSource: config.MatchSource{Mapper: m.Name(), Lookup: mapper.LookupRole},
+			}, nil
+		case it.User != nil:
+			return &config.IdentityMapping{
+				IdentityARN: canonicalARN,
+				Username:    it.User.Username,
+				Groups:      it.User.Groups,
+				MatchSource: config.MatchSource{Mapper: m.Name(), Lookup: mapper.LookupUser},
+			}, nil
+		}
+	}
+
+	roles, users, err := m.patterns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		if roles[i].Matches(lower) {
+			return &config.IdentityMapping{
+				IdentityARN: canonicalARN,
+				Username:    roles[i].Username,
+				Groups:      roles[i].Groups,
+				MatchSource: config.MatchSource{Mapper: m.Name(), Lookup: mapper.LookupRolePattern},
+			}, nil
+		}
+	}
+	for i := range users {
+		if users[i].Matches(lower) {
+			return &config.IdentityMapping{
+				IdentityARN: canonicalARN,
+				Username:    users[i].Username,
+				Groups:      users[i].Groups,
+				MatchSource: config.MatchSource{Mapper: m.Name(), Lookup: mapper.LookupUserPattern},
+			}, nil
+		}
+	}
+
+	return nil, mapper.ErrNotMapped
+}
+
+func (m *DynamoDBMapper) IsAccountAllowed(accountID string) bool {
+	it, err := m.getItem(context.Background(), accountID)
+	if err != nil {
+		logrus.WithError(err).Errorf("failed to look up account %q in dynamodb", accountID)
+		return false
+	}
+	return it != nil && it.Account
+}
+
+func (m *DynamoDBMapper) UsernamePrefixReserveList() []string {
+	return m.usernamePrefixReserveList
+}
+
+// getItem returns the item with the given key, or nil if there is none.
+func (m *DynamoDBMapper) getItem(ctx context.Context, key string) (*item, error) {
+	out, err := m.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(m.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"arn": {S: aws.String(key)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %q from dynamodb table %q: %v", key, m.tableName, err)
+	}
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+	var it item
+	if err := dynamodbattribute.UnmarshalMap(out.Item, &it); err != nil {
+		return nil, fmt.Errorf("failed to decode dynamodb item %q: %v", key, err)
+	}
+	return &it, nil
+}
+
+// patterns returns the cached pattern mappings, scanning the table again
+// if they are older than patternRefreshInterval.
+func (m *DynamoDBMapper) patterns(ctx context.Context) ([]config.RoleMapping, []config.UserMapping, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.patternsLoaded.IsZero() || m.now().Sub(m.patternsLoaded) >= patternRefreshInterval {
+		if err := m.loadPatterns(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+	return m.patternRoles, m.patternUsers, nil
+}
+
+// loadPatterns scans the table for pattern mappings. Invalid items are
+// logged and skipped. Callers must hold m.mutex.
+func (m *DynamoDBMapper) loadPatterns(ctx context.Context) error {
+	var roles []config.RoleMapping
+	var users []config.UserMapping
+	var decodeErr error
+	err := m.client.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:                 aws.String(m.tableName),
+		FilterExpression:          aws.String("#pattern = :true"),
+		ExpressionAttributeNames:  map[string]*string{"#pattern": aws.String("pattern")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":true": {BOOL: aws.Bool(true)}},
+	}, func(out *dynamodb.ScanOutput, _ bool) bool {
+		var items []item
+		if decodeErr = dynamodbattribute.UnmarshalListOfMaps(out.Items, &items); decodeErr != nil {
+			return false
+		}
+		for _, it := range items {
+			switch {
+			case it.Role != nil:
+				if err := it.Role.Validate(); err != nil {
+					logrus.WithError(err).Warnf("ignoring invalid dynamodb role mapping %q", it.ARN)
+					continue
+				}
+				roles = append(roles, *it.Role)
+			case it.User != nil:
+				if err := it.User.Validate(); err != nil {
+					logrus.WithError(err).Warnf("ignoring invalid dynamodb user mapping %q", it.ARN)
+					continue
+				}
+				users = append(users, *it.User)
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to scan dynamodb table %q: %v", m.tableName, err)
+	}
+
+	config.SortRoleMappings(roles)
+	m.patternRoles = roles
+	m.patternUsers = users
+	m.patternsLoaded = m.now()
+	return nil
+}
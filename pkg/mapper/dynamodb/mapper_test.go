@@ -0,0 +1,257 @@
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+type fakeAPI struct {
+	items    map[string]item
+	gets     int
+	scans    int
+	scanErr  error
+	lastScan *dynamodb.ScanInput
+}
+
+func (f *fakeAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, _ ...request.Option) (*dynamodb.GetItemOutput, error) {
+	f.gets++
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	it, ok := f.items[aws.StringValue(input.Key["arn"].S)]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	av, err := dynamodbattribute.MarshalMap(it)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.GetItemOutput{Item: av}, nil
+}
+
+func (f *fakeAPI) ScanPagesWithContext(ctx aws.Context, input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, _ ...request.Option) error {
+	f.scans++
+	f.lastScan = input
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if f.scanErr != nil {
+		return f.scanErr
+	}
+	var items []map[string]*dynamodb.AttributeValue
+	for _, it := range f.items {
+		if !it.Pattern {
+			continue
+		}
+		av, err := dynamodbattribute.MarshalMap(it)
+		if err != nil {
+			return err
+		}
+		items = append(items, av)
+	}
+	// deliver one item per page to exercise pagination
+	for i := range items {
+		if !fn(&dynamodb.ScanOutput{Items: items[i : i+1]}, i == len(items)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{items: map[string]item{
+		"arn:aws:iam::012345678912:role/Admin": {
+			ARN:  "arn:aws:iam::012345678912:role/Admin",
+			Role: &config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/Admin", Username: "admin", Groups: []string{"system:masters"}},
+		},
+		"arn:aws:iam::012345678912:user/alice": {
+			ARN:  "arn:aws:iam::012345678912:user/alice",
+			User: &config.UserMapping{UserARN: "arn:aws:iam::012345678912:user/alice", Username: "alice", Groups: []string{"dev"}},
+		},
+		"team-roles": {
+			ARN:     "team-roles",
+			Pattern: true,
+			Role:    &config.RoleMapping{RoleARNRegex: "arn:aws:iam::012345678912:role/team-.*", Username: "team:{{SessionName}}", Groups: []string{"team"}},
+		},
+		"ci-users": {
+			ARN:     "ci-users",
+			Pattern: true,
+			User:    &config.UserMapping{UserARNRegex: "arn:aws:iam::012345678912:user/ci-.*", Username: "ci", Groups: []string{"ci"}},
+		},
+		"invalid": {
+			ARN:     "invalid",
+			Pattern: true,
+			Role:    &config.RoleMapping{RoleARNRegex: "(", Username: "x", Groups: []string{"x"}},
+		},
+		"111122223333": {ARN: "111122223333", Account: true},
+	}}
+}
+
+func TestMap(t *testing.T) {
+	m := NewDynamoDBMapperWithClient(newFakeAPI(), "mappings", false)
+
+	cases := []struct {
+		arn      string
+		expected *config.IdentityMapping
+	}{
+		{
+			arn:      "arn:AWS:iam::012345678912:role/Admin",
+			expected: &config.IdentityMapping{IdentityARN: "arn:aws:iam::012345678912:role/Admin", Username: "admin", Groups: []string{"system:masters"}, MatchSource: config.MatchSource{Mapper: mapper.ModeDynamoDB, Lookup: mapper.LookupRole}},
+		},
+		{
+			arn:      "arn:aws:iam::012345678912:user/alice",
+			expected: &config.IdentityMapping{IdentityARN: "arn:aws:iam::012345678912:user/alice", Username: "alice", Groups: []string{"dev"}, MatchSource: config.MatchSource{Mapper: mapper.ModeDynamoDB, Lookup: mapper.LookupUser}},
+		},
+		{
+			arn:      "arn:aws:iam::012345678912:role/team-a",
+			expected: &config.IdentityMapping{IdentityARN: "arn:aws:iam::012345678912:role/team-a", Username: "team:{{SessionName}}", Groups: []string{"team"}, MatchSource: config.MatchSource{Mapper: mapper.ModeDynamoDB, Lookup: mapper.LookupRolePattern}},
+		},
+		{
+			arn:      "arn:aws:iam::012345678912:user/ci-build",
+			expected: &config.IdentityMapping{IdentityARN: "arn:aws:iam::012345678912:user/ci-build", Username: "ci", Groups: []string{"ci"}, MatchSource: config.MatchSource{Mapper: mapper.ModeDynamoDB, Lookup: mapper.LookupUserPattern}},
+		},
+		{
+			arn: "arn:aws:iam::012345678912:role/other",
+		},
+		{
+			// the resource is compared case sensitively
+			arn: "arn:aws:iam::012345678912:role/admin",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.arn, func(t *testing.T) {
+			mapping, err := m.Map(&token.Identity{CanonicalARN: c.arn})
+			if c.expected == nil {
+				if !errors.Is(err, mapper.ErrNotMapped) {
+					t.Fatalf("expected ErrNotMapped, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(mapping, c.expected) {
+				t.Errorf("expected %+v, got %+v", c.expected, mapping)
+			}
+		})
+	}
+}
+
+func TestPatternCache(t *testing.T) {
+	api := newFakeAPI()
+	m := NewDynamoDBMapperWithClient(api, "mappings", true)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	if err := m.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	if api.scans != 1 {
+		t.Fatalf("expected Start to scan once, got %d", api.scans)
+	}
+	if aws.StringValue(api.lastScan.TableName) != "mappings" {
+		t.Errorf("unexpected table %q", aws.StringValue(api.lastScan.TableName))
+	}
+
+	identity := &token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/team-a"}
+	for i := 0; i < 3; i++ {
+		if _, err := m.Map(identity); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if api.scans != 1 {
+		t.Errorf("expected cached patterns to be reused, got %d scans", api.scans)
+	}
+
+	now = now.Add(patternRefreshInterval)
+	if _, err := m.Map(identity); err != nil {
+		t.Fatal(err)
+	}
+	if api.scans != 2 {
+		t.Errorf("expected patterns to be rescanned after %v, got %d scans", patternRefreshInterval, api.scans)
+	}
+
+	api.scanErr = errors.New("throttled")
+	now = now.Add(patternRefreshInterval)
+	if _, err := m.Map(identity); err == nil {
+		t.Error("expected scan error to be returned")
+	}
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/Admin"}); err != nil {
+		t.Errorf("expected exact mappings to not need a scan, got %v", err)
+	}
+}
+
+func TestMapContextCancelled(t *testing.T) {
+	api := newFakeAPI()
+	m := NewDynamoDBMapperWithClient(api, "mappings", false)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, arn := range []string{
+		"arn:aws:iam::012345678912:role/Admin",
+		"arn:aws:iam::012345678912:role/team-a",
+	} {
+		_, err := m.MapContext(ctx, &token.Identity{CanonicalARN: arn})
+		if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+			t.Errorf("expected %s to fail with the cancelled context, got %v", arn, err)
+		}
+	}
+	if api.scans != 0 {
+		t.Errorf("expected no scan after the lookup was cancelled, got %d", api.scans)
+	}
+}
+
+func TestStartWithoutWarmCache(t *testing.T) {
+	api := newFakeAPI()
+	m := NewDynamoDBMapperWithClient(api, "mappings", false)
+	if err := m.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	if api.scans != 0 {
+		t.Errorf("expected no scan without warm cache, got %d", api.scans)
+	}
+}
+
+func TestIsAccountAllowed(t *testing.T) {
+	m := NewDynamoDBMapperWithClient(newFakeAPI(), "mappings", false)
+	if !m.IsAccountAllowed("111122223333") {
+		t.Error("expected account 111122223333 to be allowed")
+	}
+	if m.IsAccountAllowed("444455556666") {
+		t.Error("expected account 444455556666 to not be allowed")
+	}
+	if m.IsAccountAllowed("arn:aws:iam::012345678912:role/Admin") {
+		t.Error("expected a role item to not allow an account")
+	}
+}
+
+func TestNewDynamoDBMapper(t *testing.T) {
+	if _, err := NewDynamoDBMapper(config.Config{}); err == nil {
+		t.Error("expected an error without a table name")
+	}
+	m, err := NewDynamoDBMapper(config.Config{
+		DynamoDBTableName: "mappings",
+		DynamoDBRegion:    "us-west-2",
+		ReservedPrefixConfig: map[string]config.ReservedPrefixConfig{
+			mapper.ModeDynamoDB: {UsernamePrefixReserveList: []string{"aws:"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.UsernamePrefixReserveList(), []string{"aws:"}) {
+		t.Errorf("unexpected reserved prefixes %v", m.UsernamePrefixReserveList())
+	}
+}
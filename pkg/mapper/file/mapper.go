@@ -1,9 +1,26 @@
 package file
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/yaml"
 
 	"sigs.k8s.io/aws-iam-authenticator/pkg/arn"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
@@ -11,101 +28,602 @@ import (
 )
 
 type FileMapper struct {
+	mutex                     sync.RWMutex
 	roleMap                   map[string]config.RoleMapping
 	userMap                   map[string]config.UserMapping
 	accountMap                map[string]bool
 	usernamePrefixReserveList []string
+	// filename is watched by Start and the mappings reloaded from it when
+	// it changes. Empty disables reloading.
+	filename string
+	// roleMap ordered by config.SortRoleMappings, and the userarnregex
+	// mappings in userMap ordered by key, so Map is deterministic.
+	orderedRoles []config.RoleMapping
+	regexUsers   []config.UserMapping
+	// the compiled regexes of orderedRoles and regexUsers, see
+	// config.RoleMapping.CompileRegex.
+	roleRegexps []*regexp.Regexp
+	userRegexps []*regexp.Regexp
+	// the entries of accountMap with wildcards, compiled.
+	accountPatterns []*arn.AccountPattern
+	// runner runs the watch started by Start.
+	runner mapper.Runner
+	// duplicates decides what happens to several mappings for the same ARN,
+	// DuplicateKeyLastWins when empty.
+	duplicates DuplicateKeyPolicy
+}
+
+// DuplicateKeyPolicy decides what a FileMapper does when several role or
+// user mappings are for the same ARN or pattern.
+type DuplicateKeyPolicy string
+
+const (
+	// DuplicateKeyError fails to load the mappings.
+	DuplicateKeyError DuplicateKeyPolicy = "error"
+	// DuplicateKeyLastWins keeps the last of the mappings. It is the
+	// default.
+	DuplicateKeyLastWins DuplicateKeyPolicy = "lastWins"
+	// DuplicateKeyMergeGroups keeps the last of the mappings, with the
+	// groups of all of them in the order they first appear.
+	DuplicateKeyMergeGroups DuplicateKeyPolicy = "mergeGroups"
+)
+
+// Option configures optional FileMapper behavior.
+type Option func(m *FileMapper)
+
+// WithDuplicateKeyPolicy sets what happens to several mappings for the same
+// ARN. It also applies when the config file is reloaded.
+func WithDuplicateKeyPolicy(policy DuplicateKeyPolicy) Option {
+	return func(m *FileMapper) {
+		m.duplicates = policy
+	}
 }
 
 var _ mapper.Mapper = &FileMapper{}
+var _ mapper.Stopper = &FileMapper{}
+
+// reloadDebounce is how long Start waits for events on the config file to
+// settle before reloading it, as a ConfigMap volume update fires several.
+var reloadDebounce = time.Second
+
+// mappingFile holds mappings in the same format as the server config's
+// mapRoles, mapUsers and mapAccounts.
+type mappingFile struct {
+	RoleMappings          []config.RoleMapping `json:"mapRoles"`
+	UserMappings          []config.UserMapping `json:"mapUsers"`
+	AutoMappedAWSAccounts []string             `json:"mapAccounts"`
+}
+
+// mountedFile is the part of the server config file read by FileMapper.
+type mountedFile struct {
+	Server mappingFile `json:"server"`
+}
+
+// dirFile is a file read by NewFileMapperFromDir. It holds either top level
+// mappings or, like the server config file, mappings under server.
+type dirFile struct {
+	mappingFile
+	Server *mappingFile `json:"server"`
+}
+
+// Formats of the data passed to NewFileMapperFromBytes.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// LoadConfigFile reads the mappings in the server config file at path into
+// a config.Config for NewFileMapper, validating each of them. Files ending
+// in .json are read as JSON and .yaml or .yml as YAML. Otherwise the file is
+// read as JSON if it starts with '{', and YAML if not.
+func LoadConfigFile(path string) (config.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config.Config{}, err
+	}
+	cfg, err := parseConfig(data, isJSON(path, data), path)
+	if err != nil {
+		return config.Config{}, err
+	}
+	cfg.MountedFilePath = path
+	return cfg, nil
+}
+
+// NewFileMapperFromBytes creates a FileMapper from data holding mappings in
+// the format of the server config file, for callers that embed them rather
+// than mount a file. format is FormatJSON or FormatYAML, or empty to read
+// data as JSON if it starts with '{'. Each mapping is validated and
+// canonicalized as by NewFileMapper. The mapper has no file to watch.
+func NewFileMapperFromBytes(data []byte, format string, opts ...Option) (*FileMapper, error) {
+	var asJSON bool
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		asJSON = true
+	case FormatYAML, "yml":
+	case "":
+		asJSON = startsWithBrace(data)
+	default:
+		return nil, fmt.Errorf("unknown mappings format %q", format)
+	}
+	cfg, err := parseConfig(data, asJSON, "mappings")
+	if err != nil {
+		return nil, err
+	}
+	return NewFileMapper(cfg, opts...)
+}
+
+// parseConfig parses and validates the mappings in data, a server config
+// file. source names data in errors.
+func parseConfig(data []byte, asJSON bool, source string) (config.Config, error) {
+	var file mountedFile
+	var err error
+	if asJSON {
+		err = json.Unmarshal(data, &file)
+	} else {
+		err = yaml.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return config.Config{}, fmt.Errorf("error parsing %s: %v", source, err)
+	}
+	cfg := config.Config{
+		RoleMappings:          file.Server.RoleMappings,
+		UserMappings:          file.Server.UserMappings,
+		AutoMappedAWSAccounts: file.Server.AutoMappedAWSAccounts,
+	}
+	// duplicates are left to the DuplicateKeyPolicy of the mapper
+	if err := validate(cfg, DuplicateKeyLastWins); err != nil {
+		return config.Config{}, fmt.Errorf("error loading %s: %v", source, err)
+	}
+	return cfg, nil
+}
+
+// validate validates cfg with config.Config.Validate, ignoring duplicate
+// mappings unless duplicates is DuplicateKeyError.
+func validate(cfg config.Config, duplicates DuplicateKeyPolicy) error {
+	err := cfg.Validate()
+	if duplicates == DuplicateKeyError {
+		return err
+	}
+	return utilerrors.FilterOut(err, func(err error) bool {
+		return errors.Is(err, config.ErrDuplicateMapping)
+	})
+}
+
+// isJSON returns true if the config file at path holding data is JSON.
+func isJSON(path string, data []byte) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return true
+	case ".yaml", ".yml":
+		return false
+	}
+	return startsWithBrace(data)
+}
+
+// startsWithBrace returns true if data starts with '{', ignoring leading
+// whitespace, so it is read as JSON rather than YAML.
+func startsWithBrace(data []byte) bool {
+	trimmed := bytes.TrimLeftFunc(data, unicode.IsSpace)
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+func NewFileMapper(cfg config.Config, opts ...Option) (*FileMapper, error) {
+	fileMapper := &FileMapper{filename: cfg.MountedFilePath}
+	for _, opt := range opts {
+		opt(fileMapper)
+	}
+	switch fileMapper.duplicates {
+	case "", DuplicateKeyError, DuplicateKeyLastWins, DuplicateKeyMergeGroups:
+	default:
+		return nil, fmt.Errorf("unknown duplicate key policy %q", fileMapper.duplicates)
+	}
+	if err := validate(cfg, fileMapper.duplicates); err != nil {
+		return nil, err
+	}
+	roleMap, userMap, accountMap, err := buildMaps(cfg.RoleMappings, cfg.UserMappings, cfg.AutoMappedAWSAccounts, fileMapper.duplicates)
+	if err != nil {
+		return nil, err
+	}
+	fileMapper.roleMap = roleMap
+	fileMapper.userMap = userMap
+	fileMapper.accountMap = accountMap
+	fileMapper.sortMappings()
+	if value, exists := cfg.ReservedPrefixConfig[mapper.ModeMountedFile]; exists {
+		fileMapper.usernamePrefixReserveList = value.UsernamePrefixReserveList
+	}
+	return fileMapper, nil
+}
+
+// NewFileMapperFromDir creates a FileMapper from every *.yaml, *.yml and
+// *.json file in dir. Each file holds mapRoles, mapUsers and mapAccounts
+// lists, either at the top level or under server as in the server config
+// file LoadConfigFile reads. Files are read in name order, and it is an
+// error for an ARN to be mapped twice, whether in one file or in two.
+func NewFileMapperFromDir(dir string) (*FileMapper, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
 
-func NewFileMapper(cfg config.Config) (*FileMapper, error) {
 	fileMapper := &FileMapper{
 		roleMap:    make(map[string]config.RoleMapping),
 		userMap:    make(map[string]config.UserMapping),
 		accountMap: make(map[string]bool),
 	}
+	roleSources := make(map[string]string)
+	userSources := make(map[string]string)
 
-	for _, m := range cfg.RoleMappings {
-		err := m.Validate()
+	// os.ReadDir returns entries sorted by filename
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		if entry.IsDir() {
+			continue
+		}
+		filename := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(filename)
 		if err != nil {
 			return nil, err
 		}
-		if m.RoleARN != "" {
+		var parsed dirFile
+		if err := yaml.Unmarshal(data, &parsed); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", filename, err)
+		}
+		file := parsed.mappingFile
+		if parsed.Server != nil {
+			file = *parsed.Server
+		}
+		cfg := config.Config{RoleMappings: file.RoleMappings, UserMappings: file.UserMappings, AutoMappedAWSAccounts: file.AutoMappedAWSAccounts}
+		if err := validate(cfg, DuplicateKeyError); err != nil {
+			return nil, fmt.Errorf("error loading %s: %w", filename, err)
+		}
+		roleMap, userMap, accountMap, err := buildMaps(file.RoleMappings, file.UserMappings, file.AutoMappedAWSAccounts, DuplicateKeyError)
+		if err != nil {
+			return nil, fmt.Errorf("error loading %s: %v", filename, err)
+		}
+
+		for key, m := range roleMap {
+			if source, exists := roleSources[key]; exists {
+				return nil, fmt.Errorf("role mapping for %s in %s conflicts with %s", key, filename, source)
+			}
+			roleSources[key] = filename
+			fileMapper.roleMap[key] = m
+		}
+		for key, m := range userMap {
+			if source, exists := userSources[key]; exists {
+				return nil, fmt.Errorf("user mapping for %s in %s conflicts with %s", key, filename, source)
+			}
+			userSources[key] = filename
+			fileMapper.userMap[key] = m
+		}
+		for account := range accountMap {
+			fileMapper.accountMap[account] = true
+		}
+	}
+	fileMapper.sortMappings()
+	return fileMapper, nil
+}
+
+// sortMappings rebuilds orderedRoles, regexUsers, their compiled regexes
+// and accountPatterns from roleMap, userMap and accountMap.
+func (m *FileMapper) sortMappings() {
+	m.orderedRoles = make([]config.RoleMapping, 0, len(m.roleMap))
+	for _, roleMapping := range m.roleMap {
+		m.orderedRoles = append(m.orderedRoles, roleMapping)
+	}
+	config.SortRoleMappings(m.orderedRoles)
+	m.roleRegexps = make([]*regexp.Regexp, len(m.orderedRoles))
+	for i := range m.orderedRoles {
+		m.roleRegexps[i], _ = m.orderedRoles[i].CompileRegex()
+	}
+
+	m.regexUsers = nil
+	for _, userMapping := range m.userMap {
+		if userMapping.UserARNRegex != "" {
+			m.regexUsers = append(m.regexUsers, userMapping)
+		}
+	}
+	sort.Slice(m.regexUsers, func(i, j int) bool {
+		return m.regexUsers[i].Key() < m.regexUsers[j].Key()
+	})
+	m.userRegexps = make([]*regexp.Regexp, len(m.regexUsers))
+	for i := range m.regexUsers {
+		m.userRegexps[i], _ = m.regexUsers[i].CompileRegex()
+	}
+
+	m.accountPatterns = nil
+	for account := range m.accountMap {
+		if !arn.IsAccountPattern(account) {
+			continue
+		}
+		pattern, err := arn.CompileAccountPattern(account)
+		if err != nil {
+			logrus.Errorf("Ignoring mapAccounts entry: %v", err)
+			continue
+		}
+		m.accountPatterns = append(m.accountPatterns, pattern)
+	}
+}
+
+// buildMaps indexes the mappings for FileMapper, handling mappings for the
+// same key as duplicates says. The mappings must have been validated with
+// validate.
+func buildMaps(
+	roleMappings []config.RoleMapping,
+	userMappings []config.UserMapping,
+	accounts []string,
+	duplicates DuplicateKeyPolicy) (map[string]config.RoleMapping, map[string]config.UserMapping, map[string]bool, error) {
+
+	roleMap := make(map[string]config.RoleMapping)
+	userMap := make(map[string]config.UserMapping)
+	accountMap := make(map[string]bool)
+
+	for _, m := range roleMappings {
+		if m.RoleARN != "" && !m.RawMatch {
 			canonicalizedARN, err := arn.Canonicalize(m.RoleARN)
 			if err != nil {
-				return nil, err
+				return nil, nil, nil, err
 			}
 			m.RoleARN = canonicalizedARN
 		}
-		fileMapper.roleMap[m.Key()] = m
-	}
-	for _, m := range cfg.UserMappings {
-		err := m.Validate()
-		if err != nil {
-			return nil, err
+		key := m.MatchKey()
+		if existing, ok := roleMap[key]; ok {
+			switch duplicates {
+			case DuplicateKeyError:
+				return nil, nil, nil, fmt.Errorf("duplicate role mapping for %s", key)
+			case DuplicateKeyMergeGroups:
+				m.Groups = mergeGroups(existing.Groups, m.Groups)
+			}
 		}
+		roleMap[key] = m
+	}
+	for _, m := range userMappings {
 		var key string
-		if m.UserARN != "" {
-			canonicalizedARN, err := arn.Canonicalize(strings.ToLower(m.UserARN))
+		if m.UserARNRegex != "" || m.RawMatch {
+			key = m.MatchKey()
+		} else if m.UserARN != "" {
+			canonicalizedARN, err := arn.Canonicalize(arn.NormalizeCase(m.UserARN))
 			if err != nil {
-				return nil, fmt.Errorf("error canonicalizing ARN: %v", err)
+				return nil, nil, nil, fmt.Errorf("error canonicalizing ARN: %v", err)
 			}
 			key = canonicalizedARN
 		}
-		fileMapper.userMap[key] = m
+		if existing, ok := userMap[key]; ok {
+			switch duplicates {
+			case DuplicateKeyError:
+				return nil, nil, nil, fmt.Errorf("duplicate user mapping for %s", key)
+			case DuplicateKeyMergeGroups:
+				m.Groups = mergeGroups(existing.Groups, m.Groups)
+			}
+		}
+		userMap[key] = m
 	}
-	for _, m := range cfg.AutoMappedAWSAccounts {
-		fileMapper.accountMap[m] = true
+	for _, m := range accounts {
+		if arn.IsAccountPattern(m) {
+			if _, err := arn.CompileAccountPattern(m); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		accountMap[m] = true
 	}
-	if value, exists := cfg.ReservedPrefixConfig[mapper.ModeMountedFile]; exists {
-		fileMapper.usernamePrefixReserveList = value.UsernamePrefixReserveList
+	return roleMap, userMap, accountMap, nil
+}
+
+// mergeGroups returns the union of a and b, in the order each group first
+// appears.
+func mergeGroups(a, b []string) []string {
+	merged := make([]string, 0, len(a)+len(b))
+	seen := make(map[string]bool, len(a)+len(b))
+	for _, group := range append(append([]string(nil), a...), b...) {
+		if !seen[group] {
+			seen[group] = true
+			merged = append(merged, group)
+		}
 	}
-	return fileMapper, nil
+	return merged
 }
 
 func NewFileMapperWithMaps(
 	lowercaseRoleMap map[string]config.RoleMapping,
 	lowercaseUserMap map[string]config.UserMapping,
 	accountMap map[string]bool) *FileMapper {
-	return &FileMapper{
+	fileMapper := &FileMapper{
 		roleMap:    lowercaseRoleMap,
 		userMap:    lowercaseUserMap,
 		accountMap: accountMap,
 	}
+	fileMapper.sortMappings()
+	return fileMapper
 }
 
 func (m *FileMapper) Name() string {
 	return mapper.ModeMountedFile
 }
 
-func (m *FileMapper) Start(_ <-chan struct{}) error {
+// Start watches the config file, if there is one, and reloads the mappings
+// when it changes until stopCh is closed or Stop is called.
+func (m *FileMapper) Start(stopCh <-chan struct{}) error {
+	if m.filename == "" {
+		return nil
+	}
+	if m.runner.Running() {
+		return mapper.ErrAlreadyRunning
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// Watch the directory rather than the file, as ConfigMap volumes are
+	// updated by swapping a symlink rather than writing to the file.
+	if err := watcher.Add(filepath.Dir(m.filename)); err != nil {
+		watcher.Close()
+		return err
+	}
+	ctx, cancel := wait.ContextForChannel(stopCh)
+	err = m.runner.Go(ctx, func(ctx context.Context) {
+		defer cancel()
+		m.watchFile(watcher, ctx.Done())
+	})
+	if err != nil {
+		cancel()
+		watcher.Close()
+	}
+	return err
+}
+
+// Stop stops watching the config file and waits for the watcher to be
+// closed. The last loaded mappings are still served, and the mapper can be
+// started again.
+func (m *FileMapper) Stop() {
+	m.runner.Stop()
+}
+
+// Running returns true if the config file is being watched.
+func (m *FileMapper) Running() bool {
+	return m.runner.Running()
+}
+
+func (m *FileMapper) watchFile(watcher *fsnotify.Watcher, stopCh <-chan struct{}) {
+	defer watcher.Close()
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-stopCh:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Name != m.filename && filepath.Base(event.Name) != "..data" {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0 {
+				reload = time.After(reloadDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logrus.Errorf("watchFile: watcher error for %s: %v", m.filename, err)
+		case <-reload:
+			reload = nil
+			if err := m.reload(); err != nil {
+				logrus.Errorf("watchFile: keeping previous mappings, could not reload %s: %v", m.filename, err)
+			}
+		}
+	}
+}
+
+// reload replaces the mappings with those in the config file. On error the
+// current mappings are kept.
+func (m *FileMapper) reload() error {
+	cfg, err := LoadConfigFile(m.filename)
+	if err != nil {
+		return err
+	}
+	roleMap, userMap, accountMap, err := buildMaps(cfg.RoleMappings, cfg.UserMappings, cfg.AutoMappedAWSAccounts, m.duplicates)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.roleMap = roleMap
+	m.userMap = userMap
+	m.accountMap = accountMap
+	m.sortMappings()
+	logrus.Infof("Reloaded mappings from %s", m.filename)
 	return nil
 }
 
+// Map returns the mapping for identity, with the precedence of
+// mapper.MapIdentity. An exact rolearn always wins over an SSO or regex
+// pattern; see config.SortRoleMappings for how overlapping patterns are
+// ordered.
 func (m *FileMapper) Map(identity *token.Identity) (*config.IdentityMapping, error) {
-	canonicalARN := strings.ToLower(identity.CanonicalARN)
-	for _, roleMapping := range m.roleMap {
-		if roleMapping.Matches(canonicalARN) {
-			return &config.IdentityMapping{
-				IdentityARN: canonicalARN,
-				Username:    roleMapping.Username,
-				Groups:      roleMapping.Groups,
-			}, nil
+	span := mapper.StartMapSpan(m.Name(), identity)
+	mapping, matchKind, err := m.mapIdentity(identity)
+	mapper.EndMapSpan(span, matchKind, err)
+	return mapping, err
+}
+
+func (m *FileMapper) mapIdentity(identity *token.Identity) (*config.IdentityMapping, string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return mapper.MapIdentity(m.Name(), identity, m.findRole, m.findUser, m.accountAllowed)
+}
+
+// findRole returns the first of orderedRoles in lookup matching subject, a
+// case normalized ARN. Exact ARNs are compared with the case of their
+// resource preserved, SSO and regex patterns ignore case. It is a
+// mapper.RoleLookup and callers must hold the lock.
+func (m *FileMapper) findRole(subject, lookup string) *config.RoleMapping {
+	lower := strings.ToLower(subject)
+	for i := range m.orderedRoles {
+		role := &m.orderedRoles[i]
+		if mapper.RoleLookupKind(role) != lookup {
+			continue
+		}
+		var matched bool
+		switch {
+		case role.SessionNameLike != "":
+			matched = role.MatchesCompiled(subject, nil, m.roleRegexps[i])
+		case role.RoleARN != "":
+			matched = arn.MatchesExact(arn.NormalizeCase(role.RoleARN), subject)
+		default:
+			matched = role.MatchesCompiled(lower, nil, m.roleRegexps[i])
+		}
+		if matched {
+			return role
 		}
 	}
-	if userMapping, exists := m.userMap[canonicalARN]; exists {
-		return &config.IdentityMapping{
-			IdentityARN: canonicalARN,
-			Username:    userMapping.Username,
-			Groups:      userMapping.Groups,
-		}, nil
+	return nil
+}
+
+// findUser returns the user mapping in lookup matching subject, a case
+// normalized ARN. It is a mapper.UserLookup and callers must hold the lock.
+func (m *FileMapper) findUser(subject, lookup string) *config.UserMapping {
+	lower := strings.ToLower(subject)
+	if lookup == mapper.LookupUserPattern {
+		for i := range m.regexUsers {
+			if m.regexUsers[i].MatchesCompiled(lower, m.userRegexps[i]) {
+				return &m.regexUsers[i]
+			}
+		}
+		return nil
 	}
-	return nil, mapper.ErrNotMapped
+	// a mapping for the partition of subject wins over one for every
+	// partition. The maps passed to NewFileMapperWithMaps are keyed by the
+	// lowercased ARN instead.
+	for _, key := range []string{subject, arn.WithAnyPartition(subject), lower, arn.WithAnyPartition(lower)} {
+		if user, ok := m.userMap[key]; ok && mapper.UserLookupKind(&user) == lookup && arn.MatchesExact(arn.NormalizeCase(user.UserARN), subject) {
+			return &user
+		}
+	}
+	return nil
 }
 
 func (m *FileMapper) IsAccountAllowed(accountID string) bool {
-	return m.accountMap[accountID]
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.accountAllowed(accountID)
+}
+
+// accountAllowed is IsAccountAllowed for callers holding the lock. Exact
+// account IDs are checked before the patterns.
+func (m *FileMapper) accountAllowed(accountID string) bool {
+	if m.accountMap[accountID] {
+		return true
+	}
+	for _, pattern := range m.accountPatterns {
+		if pattern.Match(accountID) {
+			return true
+		}
+	}
+	return false
 }
 
 func (m *FileMapper) UsernamePrefixReserveList() []string {
@@ -1,11 +1,24 @@
 package file
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+	"strings"
 	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
 )
 
 func init() {
@@ -82,6 +95,8 @@ func TestNewFileMapper(t *testing.T) {
 		},
 	}
 
+	expected.sortMappings()
+
 	actual, err := NewFileMapper(cfg)
 	if err != nil {
 		t.Errorf("Could not build FileMapper from test config: %v", err)
@@ -106,6 +121,7 @@ func TestMap(t *testing.T) {
 		IdentityARN: identityArn,
 		Username:    "shreyas",
 		Groups:      []string{"system:masters"},
+		MatchSource: config.MatchSource{Mapper: mapper.ModeMountedFile, Lookup: mapper.LookupRole},
 	}
 	actual, err := fm.Map(&identity)
 	if err != nil {
@@ -123,6 +139,7 @@ func TestMap(t *testing.T) {
 		IdentityARN: identityArn,
 		Username:    "cookie-cutter",
 		Groups:      []string{"system:masters"},
+		MatchSource: config.MatchSource{Mapper: mapper.ModeMountedFile, Lookup: mapper.LookupRolePattern},
 	}
 	actual, err = fm.Map(&identity)
 	if err != nil {
@@ -140,6 +157,7 @@ func TestMap(t *testing.T) {
 		IdentityARN: identityArn,
 		Username:    "donald",
 		Groups:      []string{"system:masters"},
+		MatchSource: config.MatchSource{Mapper: mapper.ModeMountedFile, Lookup: mapper.LookupUser},
 	}
 	actual, err = fm.Map(&identity)
 	if err != nil {
@@ -149,3 +167,799 @@ func TestMap(t *testing.T) {
 		t.Errorf("FileMapper.Map() does not match expected value for userMapping:\nActual:   %v\nExpected: %v", actual, expected)
 	}
 }
+
+func TestMapNotMappedError(t *testing.T) {
+	fm, err := NewFileMapper(newConfig())
+	if err != nil {
+		t.Fatalf("Could not build FileMapper from test config: %v", err)
+	}
+
+	cases := map[string]string{
+		"arn:aws:iam::012345678910:role/unknown": "role",
+		"arn:aws:iam::012345678910:user/unknown": "user",
+	}
+	for identityArn, kind := range cases {
+		_, err := fm.Map(&token.Identity{CanonicalARN: identityArn, AccountID: "000000000000"})
+		var notMapped mapper.NotMappedError
+		if !errors.As(err, &notMapped) || !errors.Is(err, mapper.ErrNotMapped) {
+			t.Fatalf("expected a NotMappedError for %s, got %v", identityArn, err)
+		}
+		if notMapped.ARNKind != kind {
+			t.Errorf("expected %s to be of kind %s, got %q", identityArn, kind, notMapped.ARNKind)
+		}
+		if len(notMapped.Attempted) == 0 {
+			t.Errorf("expected the lookups tried for %s to be reported", identityArn)
+		}
+		if !notMapped.AccountAllowed {
+			t.Errorf("expected the auto-mapped account to be reported as allowed")
+		}
+	}
+}
+
+func TestMapContext(t *testing.T) {
+	fm, err := NewFileMapper(config.Config{
+		RoleMappings: []config.RoleMapping{
+			{RoleARN: "arn:aws:iam::012345678910:role/test-role", Username: "test", Groups: []string{"dev"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not build FileMapper: %v", err)
+	}
+
+	// FileMapper doesn't call a backend, so MapContext uses Map and a
+	// cancelled context doesn't fail the lookup.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, canonicalARN := range []string{
+		"arn:aws:iam::012345678910:role/test-role",
+		"arn:aws:iam::012345678910:role/unknown",
+	} {
+		identity := &token.Identity{CanonicalARN: canonicalARN}
+		expected, expectedErr := fm.Map(identity)
+		actual, err := mapper.MapContext(ctx, fm, identity)
+		if !reflect.DeepEqual(actual, expected) || !reflect.DeepEqual(err, expectedErr) {
+			t.Errorf("expected MapContext(%s) to return %+v, %v like Map, got %+v, %v", canonicalARN, expected, expectedErr, actual, err)
+		}
+	}
+}
+
+func TestMapRawMatch(t *testing.T) {
+	cfg := newConfig()
+	cfg.RoleMappings = append(cfg.RoleMappings, config.RoleMapping{
+		RoleARN:  "arn:aws:sts::012345678910:assumed-role/test-role/break-glass",
+		Username: "break-glass",
+		Groups:   []string{"system:masters"},
+		RawMatch: true,
+	})
+	// remove the mapping that would otherwise match the canonical ARN
+	cfg.RoleMappings = cfg.RoleMappings[1:]
+	fm, err := NewFileMapper(cfg)
+	if err != nil {
+		t.Fatalf("Could not build FileMapper from test config: %v", err)
+	}
+
+	identity := token.Identity{
+		ARN:          "arn:aws:sts::012345678910:assumed-role/test-role/break-glass",
+		CanonicalARN: "arn:aws:iam::012345678910:role/test-role",
+	}
+	actual, err := fm.Map(&identity)
+	if err != nil {
+		t.Fatalf("Could not map %s: %s", identity.ARN, err)
+	}
+	if actual.Username != "break-glass" {
+		t.Errorf("FileMapper.Map() returned unexpected mapping %v", actual)
+	}
+
+	identity.ARN = "arn:aws:sts::012345678910:assumed-role/test-role/other-session"
+	if _, err := fm.Map(&identity); !errors.Is(err, mapper.ErrNotMapped) {
+		t.Errorf("expected rawmatch mapping not to match another session, got %v", err)
+	}
+}
+
+func TestMapAnyPartition(t *testing.T) {
+	fm, err := NewFileMapper(config.Config{
+		RoleMappings: []config.RoleMapping{
+			{RoleARN: "arn:*:iam::012345678910:role/test-role", Username: "any", Groups: []string{"dev"}},
+			{RoleARN: "arn:aws-cn:iam::012345678910:role/test-role", Username: "cn", Groups: []string{"dev"}},
+		},
+		UserMappings: []config.UserMapping{
+			{UserARN: "arn:*:iam::012345678910:user/matt", Username: "matt", Groups: []string{"dev"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not build FileMapper: %v", err)
+	}
+
+	for canonicalARN, expected := range map[string]string{
+		"arn:aws:iam::012345678910:role/test-role":        "any",
+		"arn:aws-us-gov:iam::012345678910:role/test-role": "any",
+		"arn:aws-cn:iam::012345678910:role/test-role":     "cn",
+		"arn:aws:iam::012345678910:user/matt":             "matt",
+		"arn:aws-us-gov:iam::012345678910:user/matt":      "matt",
+	} {
+		actual, err := fm.Map(&token.Identity{ARN: canonicalARN, CanonicalARN: canonicalARN})
+		if err != nil {
+			t.Errorf("Could not map %s: %v", canonicalARN, err)
+		} else if actual.Username != expected {
+			t.Errorf("expected %s to map to %s, got %s", canonicalARN, expected, actual.Username)
+		}
+	}
+}
+
+func TestMapResourceCase(t *testing.T) {
+	fm, err := NewFileMapper(config.Config{
+		RoleMappings: []config.RoleMapping{
+			{RoleARN: "arn:aws:iam::012345678910:role/Foo", Username: "upper", Groups: []string{"dev"}},
+			{RoleARN: "arn:aws:iam::012345678910:role/foo", Username: "lower", Groups: []string{"dev"}},
+		},
+		UserMappings: []config.UserMapping{
+			{UserARN: "arn:aws:iam::012345678910:user/Bob", Username: "upper", Groups: []string{"dev"}},
+			{UserARN: "arn:aws:iam::012345678910:user/bob", Username: "lower", Groups: []string{"dev"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not build FileMapper: %v", err)
+	}
+
+	for canonicalARN, expected := range map[string]string{
+		"arn:aws:iam::012345678910:role/Foo": "upper",
+		"arn:aws:iam::012345678910:role/foo": "lower",
+		"arn:aws:iam::012345678910:user/Bob": "upper",
+		"arn:aws:iam::012345678910:user/bob": "lower",
+	} {
+		actual, err := fm.Map(&token.Identity{ARN: canonicalARN, CanonicalARN: canonicalARN})
+		if err != nil {
+			t.Errorf("Could not map %s: %v", canonicalARN, err)
+		} else if actual.Username != expected {
+			t.Errorf("expected %s to map to %s, got %s", canonicalARN, expected, actual.Username)
+		}
+	}
+	if _, err := fm.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678910:user/BOB"}); !errors.Is(err, mapper.ErrNotMapped) {
+		t.Errorf("expected user/BOB not to be mapped, got %v", err)
+	}
+}
+
+func TestMapSessionNameLike(t *testing.T) {
+	cfg := newConfig()
+	cfg.RoleMappings = append(cfg.RoleMappings, config.RoleMapping{
+		RoleARN:         "arn:aws:iam::012345678910:role/test-role",
+		SessionNameLike: "break-glass-*",
+		Username:        "break-glass",
+		Groups:          []string{"system:masters"},
+	})
+	fm, err := NewFileMapper(cfg)
+	if err != nil {
+		t.Fatalf("Could not build FileMapper from test config: %v", err)
+	}
+
+	cases := map[string]string{
+		"arn:aws:sts::012345678910:assumed-role/test-role/break-glass-alice": "break-glass",
+		"arn:aws:sts::012345678910:assumed-role/test-role/alice":             "shreyas",
+	}
+	for sessionARN, username := range cases {
+		mapping, err := fm.Map(&token.Identity{ARN: sessionARN, CanonicalARN: "arn:aws:iam::012345678910:role/test-role"})
+		if err != nil {
+			t.Fatalf("Could not map %s: %s", sessionARN, err)
+		}
+		if mapping.Username != username {
+			t.Errorf("expected %s to map to %s, got %s", sessionARN, username, mapping.Username)
+		}
+	}
+}
+
+func TestMapARNRegex(t *testing.T) {
+	cfg := newConfig()
+	cfg.RoleMappings = append(cfg.RoleMappings, config.RoleMapping{
+		RoleARNRegex: `arn:aws:iam::012345678910:role/(dev|test)-[0-9]+`,
+		Username:     "team",
+		Groups:       []string{"developers"},
+	})
+	cfg.UserMappings = append(cfg.UserMappings, config.UserMapping{
+		UserARNRegex: `arn:aws:iam::012345678910:user/ci-[0-9]+`,
+		Username:     "ci",
+		Groups:       []string{"ci"},
+	})
+	fm, err := NewFileMapper(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for arn, username := range map[string]string{
+		"arn:aws:iam::012345678910:role/dev-1":  "team",
+		"arn:aws:iam::012345678910:role/test-2": "team",
+		"arn:aws:iam::012345678910:user/ci-3":   "ci",
+	} {
+		mapping, err := fm.Map(&token.Identity{CanonicalARN: arn})
+		if err != nil {
+			t.Errorf("expected %s to be mapped, got %v", arn, err)
+			continue
+		}
+		if mapping.Username != username {
+			t.Errorf("expected %s to map to %q, got %q", arn, username, mapping.Username)
+		}
+	}
+
+	if _, err := fm.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678910:role/prod-1"}); !errors.Is(err, mapper.ErrNotMapped) {
+		t.Errorf("expected near miss not to be mapped, got %v", err)
+	}
+}
+
+const reloadConfig = `
+clusterID: test-cluster
+server:
+  mapRoles:
+  - rolearn: arn:aws:iam::012345678910:role/test-role
+    username: %s
+    groups:
+    - system:masters
+`
+
+func TestReload(t *testing.T) {
+	reloadDebounce = 10 * time.Millisecond
+
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte(fmt.Sprintf(reloadConfig, "before")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := newConfig()
+	cfg.RoleMappings[0].Username = "before"
+	cfg.MountedFilePath = filename
+	fm, err := NewFileMapper(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := fm.Start(stopCh); err != nil {
+		t.Fatalf("unexpected error starting mapper: %v", err)
+	}
+
+	identity := &token.Identity{CanonicalARN: "arn:aws:iam::012345678910:role/test-role"}
+	waitForUsername := func(expected string) {
+		t.Helper()
+		var username string
+		for i := 0; i < 100; i++ {
+			if mapping, err := fm.Map(identity); err == nil {
+				username = mapping.Username
+				if username == expected {
+					return
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("expected username %q after reload, got %q", expected, username)
+	}
+
+	if err := os.WriteFile(filename, []byte(fmt.Sprintf(reloadConfig, "after")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForUsername("after")
+
+	// a bad edit keeps the previous mappings
+	if err := os.WriteFile(filename, []byte("server:\n  mapRoles:\n  - username: missing-arn\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	waitForUsername("after")
+}
+
+func TestStartStop(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte(fmt.Sprintf(reloadConfig, "before")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := newConfig()
+	cfg.MountedFilePath = filename
+	fm, err := NewFileMapper(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before := runtime.NumGoroutine()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	for i := 0; i < 2; i++ {
+		if err := fm.Start(stopCh); err != nil {
+			t.Fatalf("unexpected error starting mapper: %v", err)
+		}
+		if !fm.Running() {
+			t.Fatal("expected mapper to be running after Start")
+		}
+		if err := fm.Start(stopCh); err != mapper.ErrAlreadyRunning {
+			t.Errorf("expected ErrAlreadyRunning starting twice, got %v", err)
+		}
+		fm.Stop()
+		fm.Stop()
+		if fm.Running() {
+			t.Fatal("expected mapper not to be running after Stop")
+		}
+	}
+
+	// fsnotify's goroutines exit asynchronously once the watcher is closed
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected background goroutines to exit after Stop, %d running, %d before Start", after, before)
+	}
+}
+
+const loadConfigYAML = `
+clusterID: test-cluster
+server:
+  mapRoles:
+  - rolearn: arn:aws:sts::012345678910:assumed-role/test-role/session
+    username: "{{SessionName}}"
+    groups:
+    - system:nodes
+  - sso:
+      permissionSetName: ViewOnlyAccess
+      accountID: "012345678910"
+    username: "sso:{{SessionNameRaw}}"
+    groups:
+    - viewers
+  mapUsers:
+  - userarnregex: arn:aws:iam::012345678910:user/ci-.*
+    username: "ci:{{AccountID}}"
+    groups:
+    - ci
+  mapAccounts:
+  - "012345678910"
+`
+
+const loadConfigJSON = `{
+  "clusterID": "test-cluster",
+  "server": {
+    "mapRoles": [
+      {
+        "rolearn": "arn:aws:sts::012345678910:assumed-role/test-role/session",
+        "username": "{{SessionName}}",
+        "groups": ["system:nodes"]
+      },
+      {
+        "sso": {"permissionSetName": "ViewOnlyAccess", "accountID": "012345678910"},
+        "username": "sso:{{SessionNameRaw}}",
+        "groups": ["viewers"]
+      }
+    ],
+    "mapUsers": [
+      {
+        "userarnregex": "arn:aws:iam::012345678910:user/ci-.*",
+        "username": "ci:{{AccountID}}",
+        "groups": ["ci"]
+      }
+    ],
+    "mapAccounts": ["012345678910"]
+  }
+}`
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yaml": loadConfigYAML,
+		"config.yml":  loadConfigYAML,
+		"config.json": loadConfigJSON,
+		// no extension, sniffed from the content
+		"config-yaml": loadConfigYAML,
+		"config-json": "\n  " + loadConfigJSON,
+	})
+
+	var expected *FileMapper
+	for _, name := range []string{"config.yaml", "config.yml", "config.json", "config-yaml", "config-json"} {
+		filename := filepath.Join(dir, name)
+		cfg, err := LoadConfigFile(filename)
+		if err != nil {
+			t.Fatalf("unexpected error loading %s: %v", name, err)
+		}
+		if cfg.MountedFilePath != filename {
+			t.Errorf("expected MountedFilePath %s, got %s", filename, cfg.MountedFilePath)
+		}
+		if username := cfg.RoleMappings[0].Username; username != "{{SessionName}}" {
+			t.Errorf("expected the username template to be unchanged in %s, got %q", name, username)
+		}
+		fm, err := NewFileMapper(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error creating mapper from %s: %v", name, err)
+		}
+		fm.filename = ""
+		if expected == nil {
+			expected = fm
+		} else if !reflect.DeepEqual(expected, fm) {
+			t.Errorf("expected %s to load the same mappings as config.yaml\n%+v\n%+v", name, expected, fm)
+		}
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		// YAML is not valid JSON
+		"yaml.json": loadConfigYAML,
+		"invalid.yaml": `
+server:
+  mapRoles:
+  - rolearn: arn:aws:iam::012345678910:role/test-role
+    rolearnregex: arn:aws:iam::012345678910:role/.*
+    username: test
+    groups: [test]
+`,
+	})
+	for _, name := range []string{"yaml.json", "invalid.yaml", "missing.yaml"} {
+		if _, err := LoadConfigFile(filepath.Join(dir, name)); err == nil {
+			t.Errorf("expected an error loading %s", name)
+		}
+	}
+}
+
+func TestNewFileMapperFromBytes(t *testing.T) {
+	cfg, err := parseConfig([]byte(loadConfigYAML), false, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := NewFileMapper(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		data   string
+		format string
+	}{
+		{loadConfigYAML, FormatYAML},
+		{loadConfigYAML, "YML"},
+		{loadConfigJSON, FormatJSON},
+		{loadConfigYAML, ""},
+		{"\n  " + loadConfigJSON, ""},
+	}
+	for _, c := range cases {
+		fm, err := NewFileMapperFromBytes([]byte(c.data), c.format)
+		if err != nil {
+			t.Fatalf("unexpected error with format %q: %v", c.format, err)
+		}
+		if !reflect.DeepEqual(expected, fm) {
+			t.Errorf("expected format %q to load the same mappings as the YAML\n%+v\n%+v", c.format, expected, fm)
+		}
+	}
+
+	// the assumed-role ARN is canonicalized as by NewFileMapper
+	fm, err := NewFileMapperFromBytes([]byte(loadConfigJSON), FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := fm.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678910:role/test-role"})
+	if err != nil {
+		t.Fatalf("expected the canonical role to be mapped: %v", err)
+	}
+	if mapping.Groups[0] != "system:nodes" {
+		t.Errorf("unexpected mapping %+v", mapping)
+	}
+}
+
+func TestNewFileMapperFromBytesErrors(t *testing.T) {
+	invalid := `
+server:
+  mapUsers:
+  - userarn: arn:aws:iam::012345678910:user/alice
+    username: "{{Sesion}}"
+    groups: [dev]
+`
+	_, err := NewFileMapperFromBytes([]byte(invalid), FormatYAML)
+	if err == nil || !strings.Contains(err.Error(), "mapUsers[0]") || !strings.Contains(err.Error(), "{{Sesion}}") {
+		t.Errorf("expected the validation error of mapUsers[0], got %v", err)
+	}
+
+	invalid = `
+server:
+  mapRoles:
+  - username: nobody
+  mapUsers:
+  - userarn: arn:aws:iam::012345678910:user/alice
+    username: "{{Sesion}}"
+  mapAccounts:
+  - "1234"
+`
+	_, err = NewFileMapperFromBytes([]byte(invalid), FormatYAML)
+	for _, entry := range []string{"mapRoles[0]", "mapUsers[0]", "mapAccounts[0]"} {
+		if err == nil || !strings.Contains(err.Error(), entry) {
+			t.Errorf("expected every invalid entry to be reported, %s is missing from %v", entry, err)
+		}
+	}
+
+	if _, err := NewFileMapperFromBytes([]byte(loadConfigYAML), FormatJSON); err == nil {
+		t.Error("expected an error parsing YAML as JSON")
+	}
+	if _, err := NewFileMapperFromBytes([]byte(loadConfigYAML), "toml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestNewFileMapperFromDir(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"10-platform.yaml": `
+mapRoles:
+- rolearn: arn:aws:iam::012345678910:role/platform
+  username: platform
+  groups:
+  - system:masters
+mapAccounts:
+- "012345678910"
+`,
+		"20-team.json": `{
+  "mapRoles": [{"rolearn": "arn:aws:iam::012345678910:role/team", "username": "team", "groups": ["developers"]}],
+  "mapUsers": [{"userarn": "arn:aws:iam::012345678910:user/alice", "username": "alice", "groups": ["developers"]}]
+}`,
+		"30-server.yaml": `
+server:
+  mapRoles:
+  - rolearn: arn:aws:iam::012345678910:role/server
+    username: server
+    groups:
+    - developers
+`,
+		"README.md": "not a mapping file",
+	})
+
+	fm, err := NewFileMapperFromDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for arn, username := range map[string]string{
+		"arn:aws:iam::012345678910:role/platform": "platform",
+		"arn:aws:iam::012345678910:role/team":     "team",
+		"arn:aws:iam::012345678910:user/alice":    "alice",
+		"arn:aws:iam::012345678910:role/server":   "server",
+	} {
+		mapping, err := fm.Map(&token.Identity{CanonicalARN: arn})
+		if err != nil {
+			t.Errorf("expected %s to be mapped, got %v", arn, err)
+			continue
+		}
+		if mapping.Username != username {
+			t.Errorf("expected %s to map to %q, got %q", arn, username, mapping.Username)
+		}
+	}
+	if !fm.IsAccountAllowed("012345678910") {
+		t.Errorf("expected account from 10-platform.yaml to be allowed")
+	}
+}
+
+func TestIsAccountAllowedPatterns(t *testing.T) {
+	cfg := newConfig()
+	cfg.AutoMappedAWSAccounts = append(cfg.AutoMappedAWSAccounts, "01234567*")
+	fm, err := NewFileMapper(cfg)
+	if err != nil {
+		t.Fatalf("Could not build FileMapper from test config: %v", err)
+	}
+	cases := map[string]bool{
+		"000000000000": true,
+		"012345670000": true,
+		"112345670000": false,
+	}
+	for account, allowed := range cases {
+		if fm.IsAccountAllowed(account) != allowed {
+			t.Errorf("expected IsAccountAllowed(%s) to be %v", account, allowed)
+		}
+	}
+
+	cfg.AutoMappedAWSAccounts = []string{"0123abc*"}
+	if _, err := NewFileMapper(cfg); err == nil {
+		t.Errorf("expected an invalid account pattern to be rejected")
+	}
+}
+
+func TestNewFileMapperFromDirConflict(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.yaml": `
+mapRoles:
+- rolearn: arn:aws:iam::012345678910:role/shared
+  username: a
+`,
+		"b.yaml": `
+mapRoles:
+- rolearn: arn:aws:sts::012345678910:assumed-role/shared/session
+  username: b
+`,
+	})
+
+	_, err := NewFileMapperFromDir(dir)
+	if err == nil {
+		t.Fatalf("expected an error for a role mapped in two files")
+	}
+	for _, name := range []string{"a.yaml", "b.yaml"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("expected error to name %s, got %v", name, err)
+		}
+	}
+
+	dir = writeFiles(t, map[string]string{
+		"a.yaml": `
+mapRoles:
+- rolearn: arn:aws:iam::012345678910:role/shared
+  username: a
+- rolearn: arn:aws:iam::012345678910:role/shared
+  username: b
+`,
+	})
+	_, err = NewFileMapperFromDir(dir)
+	if !errors.Is(err, config.ErrDuplicateMapping) || !strings.Contains(err.Error(), "a.yaml") {
+		t.Errorf("expected a duplicate mapping error naming a.yaml for a role mapped twice in one file, got %v", err)
+	}
+}
+
+func TestMapOverlappingPatterns(t *testing.T) {
+	cfg := newConfig()
+	cfg.RoleMappings = []config.RoleMapping{
+		{
+			SSO:      &config.SSOARNMatcher{PermissionSetName: "Admin", AccountID: "012345678910"},
+			Username: "admin",
+		},
+		{
+			SSO:      &config.SSOARNMatcher{PermissionSetName: "Admin_ReadOnly", AccountID: "012345678910"},
+			Username: "admin-readonly",
+		},
+		{
+			RoleARN:  "arn:aws:iam::012345678910:role/AWSReservedSSO_Admin_ReadOnly_break-glass",
+			Username: "break-glass",
+		},
+	}
+
+	cases := map[string]string{
+		"arn:aws:iam::012345678910:role/awsreservedsso_admin_0123456789abcdef":          "admin",
+		"arn:aws:iam::012345678910:role/awsreservedsso_admin_readonly_0123456789abcdef": "admin-readonly",
+		"arn:aws:iam::012345678910:role/AWSReservedSSO_Admin_ReadOnly_break-glass":      "break-glass",
+	}
+
+	// map iteration order is random, so build repeatedly to catch flapping
+	for i := 0; i < 20; i++ {
+		fm, err := NewFileMapper(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for arn, username := range cases {
+			mapping, err := fm.Map(&token.Identity{CanonicalARN: arn})
+			if err != nil {
+				t.Fatalf("expected %s to be mapped, got %v", arn, err)
+			}
+			if mapping.Username != username {
+				t.Fatalf("expected %s to map to %q, got %q", arn, username, mapping.Username)
+			}
+		}
+	}
+}
+
+func TestMapSpan(t *testing.T) {
+	fm, err := NewFileMapper(newConfig())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	mapper.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer mapper.SetTracerProvider(nil)
+
+	identity := &token.Identity{CanonicalARN: "arn:aws:iam::012345678910:user/donald"}
+	if _, err := fm.Map(identity); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	attrs := map[attribute.Key]string{}
+	for _, kv := range spans[0].Attributes() {
+		attrs[kv.Key] = kv.Value.AsString()
+	}
+	if attrs["match"] != mapper.MatchKindUser || attrs["result"] != "mapped" {
+		t.Errorf("unexpected span attributes %v", attrs)
+	}
+}
+
+func TestMapExactBeatsPattern(t *testing.T) {
+	cfg := newConfig()
+	cfg.RoleMappings = []config.RoleMapping{
+		{RoleARNRegex: "arn:aws:iam::012345678910:.*", Username: "anything"},
+		{RoleARN: "arn:aws:iam::012345678910:role/test-role", Username: "exact-role"},
+	}
+	cfg.UserMappings = []config.UserMapping{
+		{UserARNRegex: "arn:aws:iam::012345678910:user/.*", Username: "any-user"},
+		{UserARN: "arn:aws:iam::012345678910:user/donald", Username: "exact-user"},
+	}
+
+	cases := map[string]string{
+		"arn:aws:iam::012345678910:role/test-role": "exact-role",
+		// the role pattern also matches the user ARN, but loses to the exact user
+		"arn:aws:iam::012345678910:user/donald": "exact-user",
+		"arn:aws:iam::012345678910:role/other":  "anything",
+		"arn:aws:iam::012345678910:user/other":  "anything",
+	}
+
+	// map iteration order is random, so build repeatedly to catch flapping
+	for i := 0; i < 20; i++ {
+		fm, err := NewFileMapper(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for arn, username := range cases {
+			mapping, err := fm.Map(&token.Identity{CanonicalARN: arn})
+			if err != nil {
+				t.Fatalf("expected %s to be mapped, got %v", arn, err)
+			}
+			if mapping.Username != username {
+				t.Fatalf("expected %s to map to %q, got %q", arn, username, mapping.Username)
+			}
+		}
+	}
+}
+
+func TestDuplicateKeyPolicy(t *testing.T) {
+	cfg := newConfig()
+	cfg.RoleMappings = []config.RoleMapping{
+		{RoleARN: "arn:aws:iam::012345678910:role/test-role", Username: "first", Groups: []string{"a", "b"}},
+		// the same role, from its assumed-role ARN
+		{RoleARN: "arn:aws:sts::012345678910:assumed-role/test-role/session", Username: "second", Groups: []string{"b", "c"}},
+	}
+	cfg.UserMappings = []config.UserMapping{
+		{UserARN: "arn:aws:iam::012345678910:user/donald", Username: "first", Groups: []string{"x"}},
+		{UserARN: "arn:aws:iam::012345678910:user/donald", Username: "second", Groups: []string{"y", "x"}},
+	}
+
+	cases := []struct {
+		policy     DuplicateKeyPolicy
+		roleGroups []string
+		userGroups []string
+		wantErr    bool
+	}{
+		{policy: "", roleGroups: []string{"b", "c"}, userGroups: []string{"y", "x"}},
+		{policy: DuplicateKeyLastWins, roleGroups: []string{"b", "c"}, userGroups: []string{"y", "x"}},
+		{policy: DuplicateKeyMergeGroups, roleGroups: []string{"a", "b", "c"}, userGroups: []string{"x", "y"}},
+		{policy: DuplicateKeyError, wantErr: true},
+		{policy: "firstWins", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(string(c.policy), func(t *testing.T) {
+			fm, err := NewFileMapper(cfg, WithDuplicateKeyPolicy(c.policy))
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for arn, groups := range map[string][]string{
+				"arn:aws:iam::012345678910:role/test-role": c.roleGroups,
+				"arn:aws:iam::012345678910:user/donald":    c.userGroups,
+			} {
+				mapping, err := fm.Map(&token.Identity{CanonicalARN: arn})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if mapping.Username != "second" || !reflect.DeepEqual(mapping.Groups, groups) {
+					t.Errorf("expected %s to map to second with %v, got %+v", arn, groups, mapping)
+				}
+			}
+		})
+	}
+
+	// merging must not modify the groups of the config's mappings
+	original := append([]string(nil), cfg.RoleMappings[0].Groups...)
+	if _, err := NewFileMapper(cfg, WithDuplicateKeyPolicy(DuplicateKeyMergeGroups)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.RoleMappings[0].Groups, original) {
+		t.Errorf("expected the config's groups to be unchanged, got %v", cfg.RoleMappings[0].Groups)
+	}
+}
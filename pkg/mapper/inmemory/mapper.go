@@ -0,0 +1,154 @@
+package inmemory
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+// InMemoryMapper is a Mapper whose contents are populated entirely by the
+// caller through Set and Delete. Entries can optionally be evicted once more
+// than maxEntries are stored (least recently used first) or once they are
+// older than their TTL.
+type InMemoryMapper struct {
+	mutex      sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[string]*list.Element
+	// lru holds *entry values, most recently used at the front.
+	lru      *list.List
+	accounts map[string]bool
+	// now is overridden in tests
+	now func() time.Time
+}
+
+type entry struct {
+	key     string
+	mapping config.IdentityMapping
+	expires time.Time
+}
+
+var _ mapper.Mapper = &InMemoryMapper{}
+
+// NewInMemoryMapper creates an empty InMemoryMapper. A maxEntries of zero
+// disables LRU eviction and a ttl of zero means entries never expire.
+func NewInMemoryMapper(maxEntries int, ttl time.Duration) *InMemoryMapper {
+	return &InMemoryMapper{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		accounts:   make(map[string]bool),
+		now:        time.Now,
+	}
+}
+
+func (m *InMemoryMapper) Name() string {
+	return mapper.ModeInMemory
+}
+
+func (m *InMemoryMapper) Start(_ <-chan struct{}) error {
+	return nil
+}
+
+// Set stores the mapping for an ARN using the mapper's default TTL,
+// replacing any existing mapping for it.
+func (m *InMemoryMapper) Set(arn string, mapping config.IdentityMapping) {
+	m.SetWithTTL(arn, mapping, m.ttl)
+}
+
+// SetWithTTL stores the mapping for an ARN, expiring it after ttl. A ttl of
+// zero means the entry never expires.
+func (m *InMemoryMapper) SetWithTTL(arn string, mapping config.IdentityMapping, ttl time.Duration) {
+	key := strings.ToLower(arn)
+	e := &entry{key: key, mapping: mapping}
+	if ttl > 0 {
+		e.expires = m.now().Add(ttl)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if elem, ok := m.entries[key]; ok {
+		elem.Value = e
+		m.lru.MoveToFront(elem)
+		return
+	}
+	m.entries[key] = m.lru.PushFront(e)
+	if m.maxEntries > 0 && m.lru.Len() > m.maxEntries {
+		m.removeElement(m.lru.Back())
+	}
+}
+
+// Delete removes the mapping for an ARN, if present.
+func (m *InMemoryMapper) Delete(arn string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if elem, ok := m.entries[strings.ToLower(arn)]; ok {
+		m.removeElement(elem)
+	}
+}
+
+// SetAccountAllowed controls whether IsAccountAllowed returns true for the
+// account.
+func (m *InMemoryMapper) SetAccountAllowed(accountID string, allowed bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if allowed {
+		m.accounts[accountID] = true
+	} else {
+		delete(m.accounts, accountID)
+	}
+}
+
+// Len returns the number of stored entries, including any that have expired
+// but not yet been looked up.
+func (m *InMemoryMapper) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.lru.Len()
+}
+
+func (m *InMemoryMapper) Map(identity *token.Identity) (*config.IdentityMapping, error) {
+	canonicalARN := strings.ToLower(identity.CanonicalARN)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	elem, ok := m.entries[canonicalARN]
+	if !ok {
+		return nil, mapper.ErrNotMapped
+	}
+	e := elem.Value.(*entry)
+	if !e.expires.IsZero() && !m.now().Before(e.expires) {
+		m.removeElement(elem)
+		return nil, mapper.ErrNotMapped
+	}
+	m.lru.MoveToFront(elem)
+
+	return &config.IdentityMapping{
+		IdentityARN: canonicalARN,
+		Username:    e.mapping.Username,
+		Groups:      append([]string(nil), e.mapping.Groups...),
+		MatchSource: config.MatchSource{Mapper: m.Name()},
+	}, nil
+}
+
+func (m *InMemoryMapper) IsAccountAllowed(accountID string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.accounts[accountID]
+}
+
+func (m *InMemoryMapper) UsernamePrefixReserveList() []string {
+	return []string{}
+}
+
+// removeElement must be called with the mutex held.
+func (m *InMemoryMapper) removeElement(elem *list.Element) {
+	m.lru.Remove(elem)
+	delete(m.entries, elem.Value.(*entry).key)
+}
@@ -0,0 +1,101 @@
+package inmemory
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+func TestSetMapDelete(t *testing.T) {
+	m := NewInMemoryMapper(0, 0)
+
+	identityArn := "arn:aws:iam::012345678910:role/test-role"
+	m.Set("arn:aws:iam::012345678910:role/Test-Role", config.IdentityMapping{
+		Username: "test",
+		Groups:   []string{"system:masters"},
+	})
+
+	expected := &config.IdentityMapping{
+		IdentityARN: identityArn,
+		Username:    "test",
+		Groups:      []string{"system:masters"},
+		MatchSource: config.MatchSource{Mapper: mapper.ModeInMemory},
+	}
+	actual, err := m.Map(&token.Identity{CanonicalARN: identityArn})
+	if err != nil {
+		t.Fatalf("Could not map %s: %s", identityArn, err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("InMemoryMapper.Map() does not match expected value:\nActual:   %v\nExpected: %v", actual, expected)
+	}
+
+	m.Delete(identityArn)
+	if _, err := m.Map(&token.Identity{CanonicalARN: identityArn}); err != mapper.ErrNotMapped {
+		t.Errorf("expected ErrNotMapped after Delete, got %v", err)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	m := NewInMemoryMapper(2, 0)
+
+	m.Set("arn:aws:iam::012345678910:role/a", config.IdentityMapping{Username: "a"})
+	m.Set("arn:aws:iam::012345678910:role/b", config.IdentityMapping{Username: "b"})
+	// touch a so that b is least recently used
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678910:role/a"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Set("arn:aws:iam::012345678910:role/c", config.IdentityMapping{Username: "c"})
+
+	if m.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", m.Len())
+	}
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678910:role/b"}); err != mapper.ErrNotMapped {
+		t.Errorf("expected least recently used entry to be evicted, got %v", err)
+	}
+	for _, arn := range []string{"arn:aws:iam::012345678910:role/a", "arn:aws:iam::012345678910:role/c"} {
+		if _, err := m.Map(&token.Identity{CanonicalARN: arn}); err != nil {
+			t.Errorf("expected %s to be mapped, got %v", arn, err)
+		}
+	}
+}
+
+func TestTTL(t *testing.T) {
+	now := time.Now()
+	m := NewInMemoryMapper(0, time.Minute)
+	m.now = func() time.Time { return now }
+
+	m.Set("arn:aws:iam::012345678910:role/a", config.IdentityMapping{Username: "a"})
+	m.SetWithTTL("arn:aws:iam::012345678910:role/b", config.IdentityMapping{Username: "b"}, time.Hour)
+
+	now = now.Add(30 * time.Second)
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678910:role/a"}); err != nil {
+		t.Errorf("expected entry to be mapped before expiry, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678910:role/a"}); err != mapper.ErrNotMapped {
+		t.Errorf("expected ErrNotMapped after expiry, got %v", err)
+	}
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678910:role/b"}); err != nil {
+		t.Errorf("expected entry with longer TTL to be mapped, got %v", err)
+	}
+	if m.Len() != 1 {
+		t.Errorf("expected expired entry to be removed, got %d entries", m.Len())
+	}
+}
+
+func TestIsAccountAllowed(t *testing.T) {
+	m := NewInMemoryMapper(0, 0)
+	m.SetAccountAllowed("012345678910", true)
+	if !m.IsAccountAllowed("012345678910") {
+		t.Errorf("expected account to be allowed")
+	}
+	m.SetAccountAllowed("012345678910", false)
+	if m.IsAccountAllowed("012345678910") {
+		t.Errorf("expected account to be disallowed")
+	}
+}
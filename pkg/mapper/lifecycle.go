@@ -0,0 +1,84 @@
+package mapper
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrAlreadyRunning is returned when starting a mapper that is already
+// running.
+var ErrAlreadyRunning = errors.New("mapper is already running")
+
+// Stopper is implemented by mappers that do work in the background once
+// started, so they can be shut down and started again.
+type Stopper interface {
+	// Stop stops the background work started by Start and waits for it to
+	// exit. It is safe to call more than once, or on a mapper that was
+	// never started.
+	Stop()
+	// Running returns true if the mapper has been started and its
+	// background work has not exited.
+	Running() bool
+}
+
+// Runner runs the background goroutine of a mapper so that it can be
+// stopped, waited for and restarted. The zero value is ready to use.
+type Runner struct {
+	mutex  sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Go runs fn in a new goroutine with a context that is cancelled when ctx
+// is, or when Stop is called. It returns ErrAlreadyRunning if a previous fn
+// has not exited yet.
+func (r *Runner) Go(ctx context.Context, fn func(ctx context.Context)) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.running() {
+		return ErrAlreadyRunning
+	}
+	ctx, r.cancel = context.WithCancel(ctx)
+	done := make(chan struct{})
+	r.done = done
+	go func() {
+		defer close(done)
+		fn(ctx)
+	}()
+	return nil
+}
+
+// Stop cancels the context of the running fn, if any, and waits for it to
+// return.
+func (r *Runner) Stop() {
+	r.mutex.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel, r.done = nil, nil
+	r.mutex.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Running returns true if fn was started by Go and has not returned.
+func (r *Runner) Running() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.running()
+}
+
+// running is Running for callers holding the lock.
+func (r *Runner) running() bool {
+	if r.done == nil {
+		return false
+	}
+	select {
+	case <-r.done:
+		return false
+	default:
+		return true
+	}
+}
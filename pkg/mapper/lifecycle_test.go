@@ -0,0 +1,78 @@
+package mapper
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRunner(t *testing.T) {
+	var r Runner
+	if r.Running() {
+		t.Fatal("expected a zero Runner not to be running")
+	}
+	// Stop before Go is a no-op
+	r.Stop()
+
+	exited := make(chan struct{}, 2)
+	fn := func(ctx context.Context) {
+		<-ctx.Done()
+		exited <- struct{}{}
+	}
+	if err := r.Go(context.Background(), fn); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Running() {
+		t.Error("expected Runner to be running after Go")
+	}
+	if err := r.Go(context.Background(), fn); err != ErrAlreadyRunning {
+		t.Errorf("expected ErrAlreadyRunning, got %v", err)
+	}
+
+	r.Stop()
+	select {
+	case <-exited:
+	default:
+		t.Fatal("expected Stop to wait for fn to exit")
+	}
+	if r.Running() {
+		t.Error("expected Runner not to be running after Stop")
+	}
+	r.Stop()
+
+	// it can be started again once stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := r.Go(ctx, fn); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	<-exited
+	if r.Running() {
+		t.Error("expected Runner not to be running once fn returns")
+	}
+	r.Stop()
+}
+
+// stoppableMapper is a fakeMapper with a Runner.
+type stoppableMapper struct {
+	fakeMapper
+	Runner
+}
+
+func (m *stoppableMapper) Start(_ <-chan struct{}) error {
+	return m.Go(context.Background(), func(ctx context.Context) { <-ctx.Done() })
+}
+
+func TestChainMapperStop(t *testing.T) {
+	a, b := &stoppableMapper{}, &stoppableMapper{}
+	chain := NewChainMapper(a, &fakeMapper{}, b)
+	if err := chain.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !chain.Running() || !a.Running() || !b.Running() {
+		t.Fatal("expected the chain and its mappers to be running")
+	}
+	chain.Stop()
+	if chain.Running() || a.Running() || b.Running() {
+		t.Error("expected Stop to stop every mapper in the chain")
+	}
+}
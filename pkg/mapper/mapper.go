@@ -1,10 +1,14 @@
 package mapper
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
+
 	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
 
+	awsarn "github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
@@ -23,19 +27,121 @@ const (
 	ModeCRD string = "CRD"
 
 	ModeDynamicFile string = "DynamicFile"
+
+	ModeDynamoDB string = "DynamoDB"
+
+	ModeWebhook string = "Webhook"
+
+	// ModeInMemory is not a backend-mode choice, it is populated by embedders
+	ModeInMemory string = "InMemory"
 )
 
 var (
-	ValidBackendModeChoices      = []string{ModeFile, ModeConfigMap, ModeMountedFile, ModeEKSConfigMap, ModeCRD, ModeDynamicFile}
+	ValidBackendModeChoices      = []string{ModeFile, ModeConfigMap, ModeMountedFile, ModeEKSConfigMap, ModeCRD, ModeDynamicFile, ModeDynamoDB, ModeWebhook}
 	DeprecatedBackendModeChoices = map[string]string{
 		ModeFile:      ModeMountedFile,
 		ModeConfigMap: ModeEKSConfigMap,
 	}
-	BackendModeChoices = []string{ModeMountedFile, ModeEKSConfigMap, ModeCRD, ModeDynamicFile}
+	BackendModeChoices = []string{ModeMountedFile, ModeEKSConfigMap, ModeCRD, ModeDynamicFile, ModeDynamoDB, ModeWebhook}
 )
 
 var ErrNotMapped = errors.New("ARN is not mapped")
 
+// Lookups recorded in NotMappedError.Attempted.
+const (
+	// LookupRole is a lookup of the canonical ARN in the rolearn mappings.
+	LookupRole = "role"
+	// LookupRawRole is a lookup of the raw ARN in the rawmatch and
+	// sessionnamelike role mappings.
+	LookupRawRole = "rawRole"
+	// LookupUser is a lookup of the canonical ARN in the userarn mappings.
+	LookupUser = "user"
+	// LookupRawUser is a lookup of the raw ARN in the rawmatch user mappings.
+	LookupRawUser = "rawUser"
+	// LookupRolePattern is a lookup of the canonical ARN in the sso and
+	// rolearnregex mappings.
+	LookupRolePattern = "rolePattern"
+	// LookupUserPattern is a lookup of the canonical ARN in the
+	// userarnregex mappings.
+	LookupUserPattern = "userPattern"
+	// LookupRoleTag is a lookup of the tags of the canonical role ARN in the
+	// EKSConfigMap mapRoleTags mappings.
+	LookupRoleTag = "roleTag"
+)
+
+// NotMappedError is returned by a mapper that has no mapping for an
+// identity, describing what was looked up. errors.Is(err, ErrNotMapped) is
+// true for it.
+type NotMappedError struct {
+	// ARN is the canonical ARN of the identity.
+	ARN string
+	// ARNKind is the resource type of ARN, such as role or user, or empty
+	// if ARN can't be parsed.
+	ARNKind string
+	// Attempted are the lookups that were tried, in order.
+	Attempted []string
+	// AccountAllowed is true if the mapper allows the identity's account.
+	AccountAllowed bool
+}
+
+// NewNotMappedError returns a NotMappedError for the canonical ARN.
+func NewNotMappedError(arn string, accountAllowed bool, attempted ...string) NotMappedError {
+	return NotMappedError{
+		ARN:            arn,
+		ARNKind:        arnKind(arn),
+		Attempted:      attempted,
+		AccountAllowed: accountAllowed,
+	}
+}
+
+func (err NotMappedError) Error() string {
+	return fmt.Sprintf("%v: %s (tried %s)", ErrNotMapped, err.ARN, strings.Join(err.Attempted, ", "))
+}
+
+func (err NotMappedError) Is(target error) bool {
+	return target == ErrNotMapped
+}
+
+// arnKind returns the resource type of an ARN, e.g. role for
+// arn:aws:iam::123456789012:role/admin.
+func arnKind(arn string) string {
+	parsed, err := awsarn.Parse(arn)
+	if err != nil {
+		return ""
+	}
+	kind, _, _ := strings.Cut(parsed.Resource, "/")
+	return kind
+}
+
+// InitError is the error from creating a single mapper in a chain.
+type InitError struct {
+	// Mode is the backend mode of the mapper that failed.
+	Mode string
+	Err  error
+}
+
+func (err InitError) Error() string {
+	return fmt.Sprintf("backend-mode %q creation failed: %v", err.Mode, err.Err)
+}
+
+func (err InitError) Unwrap() error {
+	return err.Err
+}
+
+// ChainInitError aggregates the errors from every mapper in a chain that
+// could not be created.
+type ChainInitError struct {
+	Errors []InitError
+}
+
+func (err ChainInitError) Error() string {
+	msgs := make([]string, 0, len(err.Errors))
+	for _, e := range err.Errors {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
 type Mapper interface {
 	Name() string
 	// Start must be non-blocking
@@ -45,6 +151,22 @@ type Mapper interface {
 	UsernamePrefixReserveList() []string
 }
 
+// ContextMapper is implemented by mappers that call remote backends, so
+// they can honor the deadline and cancellation of the request an identity
+// is being mapped for.
+type ContextMapper interface {
+	MapContext(ctx context.Context, identity *token.Identity) (*config.IdentityMapping, error)
+}
+
+// MapContext maps identity with m, passing ctx on if m is a ContextMapper.
+// Other mappers ignore ctx and their Map is called instead.
+func MapContext(ctx context.Context, m Mapper, identity *token.Identity) (*config.IdentityMapping, error) {
+	if cm, ok := m.(ContextMapper); ok {
+		return cm.MapContext(ctx, identity)
+	}
+	return m.Map(identity)
+}
+
 func ValidateBackendMode(modes []string) []error {
 	var errs []error
 
@@ -1,6 +1,8 @@
 package mapper
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
@@ -57,3 +59,32 @@ func TestValidateBackendMode(t *testing.T) {
 		})
 	}
 }
+
+func TestNotMappedError(t *testing.T) {
+	cases := []struct {
+		arn  string
+		kind string
+	}{
+		{"arn:aws:iam::012345678912:role/admin", "role"},
+		{"arn:aws:iam::012345678912:user/matt", "user"},
+		{"arn:aws:iam::012345678912:role/path/to/admin", "role"},
+		{"not-an-arn", ""},
+	}
+	for _, c := range cases {
+		err := NewNotMappedError(c.arn, true, LookupRole, LookupUser)
+		if err.ARNKind != c.kind {
+			t.Errorf("expected %s to be of kind %q, got %q", c.arn, c.kind, err.ARNKind)
+		}
+		if !errors.Is(err, ErrNotMapped) {
+			t.Errorf("expected %v to be ErrNotMapped", err)
+		}
+		if !errors.Is(fmt.Errorf("wrapped: %w", err), ErrNotMapped) {
+			t.Errorf("expected a wrapped %v to be ErrNotMapped", err)
+		}
+	}
+
+	err := NewNotMappedError("arn:aws:iam::012345678912:role/admin", false, LookupRole, LookupRawRole)
+	if expected := "ARN is not mapped: arn:aws:iam::012345678912:role/admin (tried role, rawRole)"; err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
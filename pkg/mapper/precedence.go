@@ -0,0 +1,136 @@
+package mapper
+
+import (
+	"sigs.k8s.io/aws-iam-authenticator/pkg/arn"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+// RoleLookup returns the role mapping matching subject, or nil if there is
+// none. lookup is LookupRawRole, LookupRole or LookupRolePattern, and only
+// the mappings RoleLookupKind puts in that lookup are considered. subject
+// is the raw ARN of the identity for LookupRawRole and its canonical ARN
+// otherwise, case normalized with arn.NormalizeCase. Exact ARNs must be
+// compared with the case of their resource preserved.
+type RoleLookup func(subject, lookup string) *config.RoleMapping
+
+// UserLookup is RoleLookup for user mappings, with LookupRawUser, LookupUser
+// or LookupUserPattern and UserLookupKind.
+type UserLookup func(subject, lookup string) *config.UserMapping
+
+// RoleLookupKind returns the lookup of MapIdentity that considers role.
+func RoleLookupKind(role *config.RoleMapping) string {
+	switch {
+	case role.MatchesRawARN():
+		return LookupRawRole
+	case role.RoleARN != "":
+		return LookupRole
+	default:
+		return LookupRolePattern
+	}
+}
+
+// UserLookupKind returns the lookup of MapIdentity that considers user.
+func UserLookupKind(user *config.UserMapping) string {
+	switch {
+	case user.RawMatch:
+		return LookupRawUser
+	case user.UserARN != "":
+		return LookupUser
+	default:
+		return LookupUserPattern
+	}
+}
+
+// MapIdentity maps identity with the precedence shared by the mappers that
+// hold their mappings in memory:
+//
+//  1. role mappings matched against the raw ARN, rawmatch and
+//     sessionnamelike, as they pick out particular sessions of a role,
+//  2. rolearn mappings,
+//  3. userarn mappings,
+//  4. rawmatch user mappings,
+//  5. sso and rolearnregex mappings,
+//  6. userarnregex mappings.
+//
+// So an exact mapping always wins over a pattern that also matches the
+// identity, whatever order the mappings were loaded in. The raw lookups are
+// skipped if the identity has no raw ARN. Which of several mappings
+// matching in one lookup wins is up to the lookup, see
+// config.SortRoleMappings. If none match a NotMappedError is returned, with
+// accountAllowed deciding its AccountAllowed. The MatchSource of the mapping
+// is set to name, the name of the mapper, and the lookup that found it.
+//
+// The canonical ARN is identity.CanonicalARN, or identity.ARN if that is
+// empty, with an STS assumed-role ARN converted to the ARN of its IAM role,
+// so callers that don't canonicalize still match rolearn mappings.
+func MapIdentity(name string, identity *token.Identity, roles RoleLookup, users UserLookup, accountAllowed func(accountID string) bool) (*config.IdentityMapping, string, error) {
+	canonicalARN := arn.NormalizeCase(canonicalARN(identity))
+	rawARN := arn.NormalizeCase(identity.ARN)
+
+	var attempted []string
+	role := func(subject, lookup string) *config.RoleMapping {
+		if subject == "" {
+			return nil
+		}
+		attempted = append(attempted, lookup)
+		return roles(subject, lookup)
+	}
+	user := func(subject, lookup string) *config.UserMapping {
+		if subject == "" {
+			return nil
+		}
+		attempted = append(attempted, lookup)
+		return users(subject, lookup)
+	}
+	roleMapping := func(rm *config.RoleMapping) (*config.IdentityMapping, string, error) {
+		return &config.IdentityMapping{
+			IdentityARN: canonicalARN,
+			Username:    rm.Username,
+			Groups:      rm.Groups,
+			MatchSource: config.MatchSource{Mapper: name, Lookup: attempted[len(attempted)-1]},
+		}, MatchKindRole, nil
+	}
+	userMapping := func(um *config.UserMapping) (*config.IdentityMapping, string, error) {
+		return &config.IdentityMapping{
+			IdentityARN: canonicalARN,
+			Username:    um.Username,
+			Groups:      um.Groups,
+			MatchSource: config.MatchSource{Mapper: name, Lookup: attempted[len(attempted)-1]},
+		}, MatchKindUser, nil
+	}
+
+	if rm := role(rawARN, LookupRawRole); rm != nil {
+		return roleMapping(rm)
+	}
+	if rm := role(canonicalARN, LookupRole); rm != nil {
+		return roleMapping(rm)
+	}
+	if um := user(canonicalARN, LookupUser); um != nil {
+		return userMapping(um)
+	}
+	if um := user(rawARN, LookupRawUser); um != nil {
+		return userMapping(um)
+	}
+	if rm := role(canonicalARN, LookupRolePattern); rm != nil {
+		return roleMapping(rm)
+	}
+	if um := user(canonicalARN, LookupUserPattern); um != nil {
+		return userMapping(um)
+	}
+
+	return nil, MatchKindNone, NewNotMappedError(canonicalARN, accountAllowed(identity.AccountID), attempted...)
+}
+
+// canonicalARN returns the ARN identity is matched against by the canonical
+// lookups of MapIdentity.
+func canonicalARN(identity *token.Identity) string {
+	canonical := identity.CanonicalARN
+	if canonical == "" {
+		canonical = identity.ARN
+	}
+	if roleARN, _, ok := arn.AssumedRole(canonical); ok {
+		return roleARN
+	}
+	return canonical
+}
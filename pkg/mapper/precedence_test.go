@@ -0,0 +1,158 @@
+package mapper
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+func TestMapIdentity(t *testing.T) {
+	const (
+		canonicalARN = "arn:aws:iam::012345678912:role/Admin"
+		rawARN       = "arn:aws:sts::012345678912:assumed-role/Admin/alice"
+	)
+	identity := token.Identity{ARN: rawARN, CanonicalARN: canonicalARN}
+	// every lookup finds a mapping
+	roles := map[string]*config.RoleMapping{
+		LookupRawRole:     {Username: "raw-role"},
+		LookupRole:        {Username: "role"},
+		LookupRolePattern: {Username: "role-pattern"},
+	}
+	users := map[string]*config.UserMapping{
+		LookupUser:        {Username: "user"},
+		LookupRawUser:     {Username: "raw-user"},
+		LookupUserPattern: {Username: "user-pattern"},
+	}
+
+	// each lookup in order of precedence, and the mapping it finds
+	order := []struct {
+		lookup   string
+		username string
+	}{
+		{LookupRawRole, "raw-role"},
+		{LookupRole, "role"},
+		{LookupUser, "user"},
+		{LookupRawUser, "raw-user"},
+		{LookupRolePattern, "role-pattern"},
+		{LookupUserPattern, "user-pattern"},
+	}
+	for _, step := range order {
+		roleLookup := func(subject, lookup string) *config.RoleMapping {
+			expected := canonicalARN
+			if lookup == LookupRawRole {
+				expected = rawARN
+			}
+			if subject != expected {
+				t.Errorf("unexpected %s lookup of %s", lookup, subject)
+			}
+			return roles[lookup]
+		}
+		userLookup := func(subject, lookup string) *config.UserMapping {
+			expected := canonicalARN
+			if lookup == LookupRawUser {
+				expected = rawARN
+			}
+			if subject != expected {
+				t.Errorf("unexpected %s lookup of %s", lookup, subject)
+			}
+			return users[lookup]
+		}
+		mapping, _, err := MapIdentity("test", &identity, roleLookup, userLookup, func(string) bool { return false })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mapping.Username != step.username || mapping.IdentityARN != canonicalARN {
+			t.Errorf("expected the %s lookup to win with %s, got %+v", step.lookup, step.username, mapping)
+		}
+		if source := (config.MatchSource{Mapper: "test", Lookup: step.lookup}); mapping.MatchSource != source {
+			t.Errorf("expected match source %+v, got %+v", source, mapping.MatchSource)
+		}
+		// the next step wins once this one finds nothing
+		delete(roles, step.lookup)
+		delete(users, step.lookup)
+	}
+
+	none := func(string, string) *config.RoleMapping { return nil }
+	noUsers := func(string, string) *config.UserMapping { return nil }
+	cases := []struct {
+		identity  token.Identity
+		attempted []string
+	}{
+		{
+			identity:  identity,
+			attempted: []string{LookupRawRole, LookupRole, LookupUser, LookupRawUser, LookupRolePattern, LookupUserPattern},
+		},
+		{
+			identity:  token.Identity{CanonicalARN: canonicalARN},
+			attempted: []string{LookupRole, LookupUser, LookupRolePattern, LookupUserPattern},
+		},
+	}
+	for _, c := range cases {
+		_, _, err := MapIdentity("test", &c.identity, none, noUsers, func(string) bool { return false })
+		var notMapped NotMappedError
+		if !errors.As(err, &notMapped) {
+			t.Fatalf("expected a NotMappedError, got %v", err)
+		}
+		if !reflect.DeepEqual(notMapped.Attempted, c.attempted) {
+			t.Errorf("expected lookups %v, got %v", c.attempted, notMapped.Attempted)
+		}
+	}
+}
+
+func TestLookupKind(t *testing.T) {
+	roles := map[string]config.RoleMapping{
+		LookupRawRole:     {RoleARN: "arn:aws:sts::012345678912:assumed-role/Admin/alice", RawMatch: true},
+		LookupRole:        {RoleARN: "arn:aws:iam::012345678912:role/Admin"},
+		LookupRolePattern: {RoleARNRegex: "arn:aws:iam::012345678912:role/.*"},
+	}
+	for lookup, role := range roles {
+		if got := RoleLookupKind(&role); got != lookup {
+			t.Errorf("expected %+v in the %s lookup, got %s", role, lookup, got)
+		}
+	}
+	sessionRole := config.RoleMapping{RoleARN: "arn:aws:iam::012345678912:role/Admin", SessionNameLike: "a*"}
+	if got := RoleLookupKind(&sessionRole); got != LookupRawRole {
+		t.Errorf("expected a sessionnamelike mapping in the %s lookup, got %s", LookupRawRole, got)
+	}
+
+	users := map[string]config.UserMapping{
+		LookupRawUser:     {UserARN: "arn:aws:iam::012345678912:user/alice", RawMatch: true},
+		LookupUser:        {UserARN: "arn:aws:iam::012345678912:user/alice"},
+		LookupUserPattern: {UserARNRegex: "arn:aws:iam::012345678912:user/.*"},
+	}
+	for lookup, user := range users {
+		if got := UserLookupKind(&user); got != lookup {
+			t.Errorf("expected %+v in the %s lookup, got %s", user, lookup, got)
+		}
+	}
+}
+
+func TestMapIdentityAssumedRole(t *testing.T) {
+	const (
+		roleARN    = "arn:aws:iam::012345678912:role/Admin"
+		sessionARN = "arn:aws:sts::012345678912:assumed-role/Admin/alice"
+	)
+	roles := func(subject, lookup string) *config.RoleMapping {
+		if lookup == LookupRole && subject == roleARN {
+			return &config.RoleMapping{RoleARN: roleARN, Username: "admin"}
+		}
+		return nil
+	}
+	users := func(string, string) *config.UserMapping { return nil }
+	for _, identity := range []token.Identity{
+		{ARN: sessionARN},
+		{ARN: sessionARN, CanonicalARN: sessionARN},
+	} {
+		mapping, _, err := MapIdentity("test", &identity, roles, users, func(string) bool { return false })
+		if err != nil {
+			t.Errorf("expected %+v to match the mapping of its role, got %v", identity, err)
+			continue
+		}
+		if mapping.IdentityARN != roleARN || mapping.Username != "admin" {
+			t.Errorf("unexpected mapping %+v", mapping)
+		}
+	}
+}
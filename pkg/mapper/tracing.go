@@ -0,0 +1,72 @@
+package mapper
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+const tracerName = "sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
+
+// Match kinds recorded on Map spans.
+const (
+	MatchKindRole = "role"
+	MatchKindUser = "user"
+	MatchKindNone = "none"
+)
+
+type tracerHolder struct {
+	tracer trace.Tracer
+}
+
+var activeTracer atomic.Value
+
+// SetTracerProvider enables OpenTelemetry spans around mapper operations.
+// Tracing is disabled by default, or when tp is nil.
+func SetTracerProvider(tp trace.TracerProvider) {
+	if tp == nil {
+		activeTracer.Store(tracerHolder{})
+		return
+	}
+	activeTracer.Store(tracerHolder{tracer: tp.Tracer(tracerName)})
+}
+
+// StartSpan starts a span named "<mapper>.<operation>". It returns a no-op
+// span without allocating when tracing is disabled.
+func StartSpan(ctx context.Context, mapperName, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	holder, _ := activeTracer.Load().(tracerHolder)
+	if holder.tracer == nil {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return holder.tracer.Start(ctx, mapperName+"."+operation, trace.WithAttributes(attrs...))
+}
+
+// StartMapSpan starts a span for a Map call on the identity.
+func StartMapSpan(mapperName string, identity *token.Identity) trace.Span {
+	_, span := StartSpan(context.Background(), mapperName, "Map", attribute.String("arn", identity.CanonicalARN))
+	return span
+}
+
+// EndMapSpan records the match kind and result of a Map call and ends the span.
+func EndMapSpan(span trace.Span, matchKind string, err error) {
+	if span.IsRecording() {
+		result := "mapped"
+		if errors.Is(err, ErrNotMapped) {
+			result = "not_mapped"
+		} else if err != nil {
+			result = "error"
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.SetAttributes(
+			attribute.String("match", matchKind),
+			attribute.String("result", result),
+		)
+	}
+	span.End()
+}
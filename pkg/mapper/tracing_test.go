@@ -0,0 +1,52 @@
+package mapper
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+func TestMapSpans(t *testing.T) {
+	identity := &token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/test"}
+
+	// disabled by default
+	span := StartMapSpan(ModeMountedFile, identity)
+	if span.IsRecording() {
+		t.Errorf("expected a no-op span when no tracer provider is set")
+	}
+	EndMapSpan(span, MatchKindRole, nil)
+
+	recorder := tracetest.NewSpanRecorder()
+	SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer SetTracerProvider(nil)
+
+	EndMapSpan(StartMapSpan(ModeMountedFile, identity), MatchKindRole, nil)
+	EndMapSpan(StartMapSpan(ModeMountedFile, identity), MatchKindNone, ErrNotMapped)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	expected := []map[attribute.Key]string{
+		{"arn": identity.CanonicalARN, "match": MatchKindRole, "result": "mapped"},
+		{"arn": identity.CanonicalARN, "match": MatchKindNone, "result": "not_mapped"},
+	}
+	for i, span := range spans {
+		if span.Name() != "MountedFile.Map" {
+			t.Errorf("unexpected span name %q", span.Name())
+		}
+		attrs := map[attribute.Key]string{}
+		for _, kv := range span.Attributes() {
+			attrs[kv.Key] = kv.Value.AsString()
+		}
+		for k, v := range expected[i] {
+			if attrs[k] != v {
+				t.Errorf("span %d: expected attribute %s=%q, got %q", i, k, v, attrs[k])
+			}
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Package webhook implements a Mapper that asks an HTTP endpoint for the
+// mapping of each identity.
+//
+// The mapper POSTs a JSON request of the form
+//
+//	{"arn": "arn:aws:iam::000000000000:role/admin", "accountID": "000000000000"}
+//
+// with the lowercased canonical ARN. The endpoint replies 200 with a JSON
+// config.IdentityMapping ({"username": "...", "groups": [...]}) or 404 if
+// the identity is not mapped. Both answers are cached for the configured TTL.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/metrics"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	// maxCacheEntries bounds the cache; expired entries are swept once it
+	// is reached and the cache is reset if that is not enough.
+	maxCacheEntries = 10000
+	// maxResponseBytes bounds how much of a response body is read.
+	maxResponseBytes = 1 << 20
+)
+
+// request is the body POSTed to the webhook.
+type request struct {
+	ARN       string `json:"arn"`
+	AccountID string `json:"accountID,omitempty"`
+}
+
+type cacheEntry struct {
+	// mapping is nil for identities the webhook reported as not mapped.
+	mapping *config.IdentityMapping
+	expires time.Time
+}
+
+type WebhookMapper struct {
+	url      string
+	client   *http.Client
+	cacheTTL time.Duration
+
+	mutex sync.Mutex
+	cache map[string]cacheEntry
+	// now is overridden in tests
+	now func() time.Time
+
+	usernamePrefixReserveList []string
+}
+
+var _ mapper.Mapper = &WebhookMapper{}
+
+// NewWebhookMapper creates a WebhookMapper for cfg.WebhookURL.
+func NewWebhookMapper(cfg config.Config) (*WebhookMapper, error) {
+	if cfg.WebhookURL == "" {
+		return nil, fmt.Errorf("webhook URL must be set for backend-mode %q", mapper.ModeWebhook)
+	}
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	timeout := cfg.WebhookTimeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	m := NewWebhookMapperWithClient(client, cfg.WebhookURL, cfg.WebhookCacheTTL)
+	if value, exists := cfg.ReservedPrefixConfig[mapper.ModeWebhook]; exists {
+		m.usernamePrefixReserveList = value.UsernamePrefixReserveList
+	}
+	return m, nil
+}
+
+// NewWebhookMapperWithClient creates a WebhookMapper POSTing to url with
+// client. A cacheTTL of zero disables caching.
+func NewWebhookMapperWithClient(client *http.Client, url string, cacheTTL time.Duration) *WebhookMapper {
+	return &WebhookMapper{
+		url:      url,
+		client:   client,
+		cacheTTL: cacheTTL,
+		cache:    make(map[string]cacheEntry),
+		now:      time.Now,
+	}
+}
+
+func newTLSConfig(cfg config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.WebhookCAFile != "" {
+		pem, err := os.ReadFile(cfg.WebhookCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in webhook CA file %q", cfg.WebhookCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.WebhookClientCertFile != "" || cfg.WebhookClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.WebhookClientCertFile, cfg.WebhookClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load webhook client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func (m *WebhookMapper) Name() string {
+	return mapper.ModeWebhook
+}
+
+func (m *WebhookMapper) Start(_ <-chan struct{}) error {
+	return nil
+}
+
+func (m *WebhookMapper) Map(identity *token.Identity) (*config.IdentityMapping, error) {
+	return m.MapContext(context.Background(), identity)
+}
+
+// MapContext is Map, cancelling the webhook request when ctx is done.
+func (m *WebhookMapper) MapContext(ctx context.Context, identity *token.Identity) (*config.IdentityMapping, error) {
+	canonicalARN := strings.ToLower(identity.CanonicalARN)
+
+	if mapping, ok := m.cached(canonicalARN); ok {
+		metrics.Get().MapCacheHits.WithLabelValues(m.Name()).Inc()
+		if mapping == nil {
+			return nil, mapper.ErrNotMapped
+		}
+		return mapping, nil
+	}
+	if m.cacheTTL > 0 {
+		metrics.Get().MapCacheMisses.WithLabelValues(m.Name()).Inc()
+	}
+
+	mapping, err := m.query(ctx, canonicalARN, identity.AccountID)
+	if err != nil {
+		// errors are not cached so the next request retries the webhook
+		return nil, err
+	}
+	m.store(canonicalARN, mapping)
+	if mapping == nil {
+		return nil, mapper.ErrNotMapped
+	}
+	return mapping, nil
+}
+
+// IsAccountAllowed always returns false, the webhook only maps identities.
+func (m *WebhookMapper) IsAccountAllowed(accountID string) bool {
+	return false
+}
+
+func (m *WebhookMapper) UsernamePrefixReserveList() []string {
+	return m.usernamePrefixReserveList
+}
+
+// query asks the webhook for the mapping of canonicalARN. It returns a nil
+// mapping if the webhook replied that the identity is not mapped.
+func (m *WebhookMapper) query(ctx context.Context, canonicalARN, accountID string) (*config.IdentityMapping, error) {
+	body, err := json.Marshal(request{ARN: canonicalARN, AccountID: accountID})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("webhook request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("webhook returned unexpected status %d", resp.StatusCode)
+	}
+
+	var mapping config.IdentityMapping
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&mapping); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook response: %v", err)
+	}
+	if mapping.Username == "" {
+		return nil, fmt.Errorf("webhook response for %q has no username", canonicalARN)
+	}
+	mapping.IdentityARN = canonicalARN
+	mapping.MatchSource = config.MatchSource{Mapper: m.Name()}
+	return &mapping, nil
+}
+
+func (m *WebhookMapper) cached(key string) (*config.IdentityMapping, bool) {
+	if m.cacheTTL <= 0 {
+		return nil, false
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, ok := m.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if !m.now().Before(e.expires) {
+		delete(m.cache, key)
+		return nil, false
+	}
+	return e.mapping, true
+}
+
+func (m *WebhookMapper) store(key string, mapping *config.IdentityMapping) {
+	if m.cacheTTL <= 0 {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := m.now()
+	if len(m.cache) >= maxCacheEntries {
+		for k, e := range m.cache {
+			if !now.Before(e.expires) {
+				delete(m.cache, k)
+			}
+		}
+		if len(m.cache) >= maxCacheEntries {
+			m.cache = make(map[string]cacheEntry)
+		}
+	}
+	m.cache[key] = cacheEntry{mapping: mapping, expires: now.Add(m.cacheTTL)}
+}
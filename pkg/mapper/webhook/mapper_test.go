@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/config"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/metrics"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+)
+
+func init() {
+	metrics.InitMetrics(prometheus.NewRegistry())
+}
+
+func newTestServer(t *testing.T, calls *int32, handler func(w http.ResponseWriter, req request)) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		if r.Method != http.MethodPost {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		handler(w, req)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMap(t *testing.T) {
+	var calls int32
+	server := newTestServer(t, &calls, func(w http.ResponseWriter, req request) {
+		switch req.ARN {
+		case "arn:aws:iam::012345678912:role/admin":
+			if req.AccountID != "012345678912" {
+				t.Errorf("unexpected account ID %q", req.AccountID)
+			}
+			w.Write([]byte(`{"username": "admin", "groups": ["system:masters"]}`))
+		case "arn:aws:iam::012345678912:role/malformed":
+			w.Write([]byte(`{"username": `))
+		case "arn:aws:iam::012345678912:role/empty":
+			w.Write([]byte(`{}`))
+		case "arn:aws:iam::012345678912:role/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	m := NewWebhookMapperWithClient(server.Client(), server.URL, 0)
+
+	mapping, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/Admin", AccountID: "012345678912"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &config.IdentityMapping{
+		IdentityARN: "arn:aws:iam::012345678912:role/admin",
+		Username:    "admin",
+		Groups:      []string{"system:masters"},
+		MatchSource: config.MatchSource{Mapper: mapper.ModeWebhook},
+	}
+	if !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("expected %+v, got %+v", expected, mapping)
+	}
+
+	if _, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/other"}); !errors.Is(err, mapper.ErrNotMapped) {
+		t.Errorf("expected ErrNotMapped for a 404, got %v", err)
+	}
+
+	for _, role := range []string{"malformed", "empty", "broken"} {
+		_, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/" + role})
+		if err == nil || errors.Is(err, mapper.ErrNotMapped) {
+			t.Errorf("expected an error for the %s response, got %v", role, err)
+		}
+	}
+}
+
+func TestMapTimeout(t *testing.T) {
+	var calls int32
+	done := make(chan struct{})
+	server := newTestServer(t, &calls, func(w http.ResponseWriter, req request) {
+		<-done
+	})
+	defer close(done)
+
+	client := server.Client()
+	client.Timeout = 50 * time.Millisecond
+	m := NewWebhookMapperWithClient(client, server.URL, time.Minute)
+
+	_, err := m.Map(&token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/admin"})
+	if err == nil || !strings.Contains(err.Error(), "webhook request failed") {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	if len(m.cache) != 0 {
+		t.Errorf("expected errors to not be cached, got %v", m.cache)
+	}
+}
+
+func TestMapContextDeadline(t *testing.T) {
+	var calls int32
+	done := make(chan struct{})
+	server := newTestServer(t, &calls, func(w http.ResponseWriter, req request) {
+		<-done
+	})
+	defer close(done)
+
+	m := NewWebhookMapperWithClient(server.Client(), server.URL, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := m.MapContext(ctx, &token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/admin"})
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("expected the request deadline to abort the webhook call, got %v", err)
+	}
+	if len(m.cache) != 0 {
+		t.Errorf("expected errors to not be cached, got %v", m.cache)
+	}
+}
+
+func TestMapCache(t *testing.T) {
+	var calls int32
+	server := newTestServer(t, &calls, func(w http.ResponseWriter, req request) {
+		if req.ARN == "arn:aws:iam::012345678912:role/admin" {
+			w.Write([]byte(`{"username": "admin", "groups": ["system:masters"]}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	hits := testutil.ToFloat64(metrics.Get().MapCacheHits.WithLabelValues(mapper.ModeWebhook))
+	misses := testutil.ToFloat64(metrics.Get().MapCacheMisses.WithLabelValues(mapper.ModeWebhook))
+	m := NewWebhookMapperWithClient(server.Client(), server.URL, time.Minute)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	mapped := &token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/admin"}
+	notMapped := &token.Identity{CanonicalARN: "arn:aws:iam::012345678912:role/other"}
+	for i := 0; i < 3; i++ {
+		if _, err := m.Map(mapped); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Map(notMapped); !errors.Is(err, mapper.ErrNotMapped) {
+			t.Fatalf("expected ErrNotMapped, got %v", err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 webhook calls with caching, got %d", calls)
+	}
+	if got := testutil.ToFloat64(metrics.Get().MapCacheMisses.WithLabelValues(mapper.ModeWebhook)) - misses; got != 2 {
+		t.Errorf("expected 2 cache misses, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.Get().MapCacheHits.WithLabelValues(mapper.ModeWebhook)) - hits; got != 4 {
+		t.Errorf("expected 4 cache hits after the first lookups, got %v", got)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := m.Map(mapped); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("expected expired entry to call the webhook again, got %d calls", calls)
+	}
+}
+
+func TestNewWebhookMapper(t *testing.T) {
+	if _, err := NewWebhookMapper(config.Config{}); err == nil {
+		t.Error("expected an error without a URL")
+	}
+	if _, err := NewWebhookMapper(config.Config{WebhookURL: "https://example.com", WebhookCAFile: "/does/not/exist"}); err == nil {
+		t.Error("expected an error for a missing CA file")
+	}
+	m, err := NewWebhookMapper(config.Config{WebhookURL: "https://example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.client.Timeout != defaultTimeout {
+		t.Errorf("expected default timeout %v, got %v", defaultTimeout, m.client.Timeout)
+	}
+}
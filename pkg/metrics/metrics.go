@@ -34,12 +34,24 @@ func Get() Metrics {
 
 // Metrics are handles to the collectors for prometheus for the various metrics we are tracking.
 type Metrics struct {
-	ConfigMapWatchFailures       prometheus.Counter
-	Latency                      *prometheus.HistogramVec
-	EC2DescribeInstanceCallCount prometheus.Counter
-	StsConnectionFailure         prometheus.Counter
-	StsResponses                 *prometheus.CounterVec
-	DynamicFileFailures          prometheus.Counter
+	ConfigMapWatchFailures            prometheus.Counter
+	ConfigMapParseFailures            *prometheus.CounterVec
+	ConfigMapRejectedUpdates          prometheus.Counter
+	ConfigMapInvalidEntries           *prometheus.GaugeVec
+	ConfigMapRecreated                prometheus.Counter
+	ConfigMapOverMaxMappings          prometheus.Counter
+	ConfigMapMappingsLoaded           *prometheus.GaugeVec
+	ConfigMapAccountNotAllowed        *prometheus.GaugeVec
+	ConfigMapLastLoadTimestampSeconds prometheus.Gauge
+	MapCacheHits                      *prometheus.CounterVec
+	MapCacheMisses                    *prometheus.CounterVec
+	MapUnmapped                       *prometheus.CounterVec
+	ARNLikeRuleMatches                *prometheus.CounterVec
+	Latency                           *prometheus.HistogramVec
+	EC2DescribeInstanceCallCount      prometheus.Counter
+	StsConnectionFailure              prometheus.Counter
+	StsResponses                      *prometheus.CounterVec
+	DynamicFileFailures               prometheus.Counter
 }
 
 func createMetrics(reg prometheus.Registerer) Metrics {
@@ -53,6 +65,90 @@ func createMetrics(reg prometheus.Registerer) Metrics {
 				Help:      "EKS Configmap watch failures",
 			},
 		),
+		ConfigMapParseFailures: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "configmap_parse_failures_total",
+				Help:      "EKS Configmap parse failures by the section that failed",
+			}, []string{"section"},
+		),
+		ConfigMapRejectedUpdates: factory.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "configmap_rejected_updates_total",
+				Help:      "EKS Configmap updates ignored entirely by strict parsing",
+			},
+		),
+		ConfigMapInvalidEntries: factory.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "configmap_invalid_entries",
+				Help:      "Number of invalid entries in the last parsed EKS Configmap by error type",
+			}, []string{"type"},
+		),
+		ConfigMapRecreated: factory.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "configmap_recreated_total",
+				Help:      "EKS Configmap observed with a new UID after being deleted and recreated",
+			},
+		),
+		ConfigMapOverMaxMappings: factory.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "configmap_over_max_mappings_total",
+				Help:      "EKS Configmap updates with more mappings than the configured maximum",
+			},
+		),
+		ConfigMapMappingsLoaded: factory.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "configmap_mappings_loaded",
+				Help:      "Number of mappings currently loaded from the EKS Configmap by kind",
+			}, []string{"kind"},
+		),
+		ConfigMapAccountNotAllowed: factory.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "configmap_mappings_account_not_allowed",
+				Help:      "Number of mappings in the EKS Configmap whose account is not in mapAccounts by kind",
+			}, []string{"kind"},
+		),
+		ConfigMapLastLoadTimestampSeconds: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "configmap_last_load_timestamp_seconds",
+				Help:      "Unix time the EKS Configmap mappings were last loaded from a watch event",
+			},
+		),
+		MapCacheHits: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "map_cache_hits_total",
+				Help:      "Mapper result cache hits by mapper",
+			}, []string{"mapper"},
+		),
+		MapCacheMisses: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "map_cache_misses_total",
+				Help:      "Mapper result cache misses by mapper",
+			}, []string{"mapper"},
+		),
+		MapUnmapped: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "map_unmapped_total",
+				Help:      "Authenticated identities no mapper in the chain had a mapping for by the last mapper tried",
+			}, []string{"mapper"},
+		),
+		ARNLikeRuleMatches: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "arn_like_rule_matches_total",
+				Help:      "EKS Configmap pattern mappings that won a lookup by pattern, if enabled",
+			}, []string{"pattern"},
+		),
 		DynamicFileFailures: factory.NewCounter(
 			prometheus.CounterOpts{
 				Namespace: Namespace,
@@ -20,6 +20,7 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -35,7 +36,9 @@ import (
 	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper/configmap"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper/crd"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper/dynamicfile"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper/dynamodb"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper/file"
+	"sigs.k8s.io/aws-iam-authenticator/pkg/mapper/webhook"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/metrics"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
 
@@ -88,7 +91,7 @@ func New(cfg config.Config, stopCh <-chan struct{}) *Server {
 	for _, m := range mappers {
 		logrus.Infof("starting mapper %q", m.Name())
 		if err := m.Start(stopCh); err != nil {
-			logrus.Fatalf("start mapper %q failed", m.Name())
+			logrus.Fatalf("start mapper %q failed: %v", m.Name(), err)
 		}
 	}
 
@@ -215,39 +218,38 @@ func (c *Server) getHandler(mappers []mapper.Mapper, ec2DescribeQps int, ec2Desc
 func BuildMapperChain(cfg config.Config) ([]mapper.Mapper, error) {
 	modes := cfg.BackendMode
 	mappers := []mapper.Mapper{}
+	var initErr mapper.ChainInitError
 	for _, mode := range modes {
+		var m mapper.Mapper
+		var err error
 		switch mode {
 		case mapper.ModeFile:
 			fallthrough
 		case mapper.ModeMountedFile:
-			fileMapper, err := file.NewFileMapper(cfg)
-			if err != nil {
-				return nil, fmt.Errorf("backend-mode %q creation failed: %v", mode, err)
-			}
-			mappers = append(mappers, fileMapper)
+			m, err = file.NewFileMapper(cfg)
 		case mapper.ModeConfigMap:
 			fallthrough
 		case mapper.ModeEKSConfigMap:
-			configMapMapper, err := configmap.NewConfigMapMapper(cfg)
-			if err != nil {
-				return nil, fmt.Errorf("backend-mode %q creation failed: %v", mode, err)
-			}
-			mappers = append(mappers, configMapMapper)
+			m, err = configmap.NewConfigMapMapper(cfg)
 		case mapper.ModeCRD:
-			crdMapper, err := crd.NewCRDMapper(cfg)
-			if err != nil {
-				return nil, fmt.Errorf("backend-mode %q creation failed: %v", mode, err)
-			}
-			mappers = append(mappers, crdMapper)
+			m, err = crd.NewCRDMapper(cfg)
 		case mapper.ModeDynamicFile:
-			dynamicFileMapper, err := dynamicfile.NewDynamicFileMapper(cfg)
-			if err != nil {
-				return nil, fmt.Errorf("backend-mode %q creation failed: %v", mode, err)
-			}
-			mappers = append(mappers, dynamicFileMapper)
+			m, err = dynamicfile.NewDynamicFileMapper(cfg)
+		case mapper.ModeDynamoDB:
+			m, err = dynamodb.NewDynamoDBMapper(cfg)
+		case mapper.ModeWebhook:
+			m, err = webhook.NewWebhookMapper(cfg)
 		default:
-			return nil, fmt.Errorf("backend-mode %q is not a valid mode", mode)
+			err = fmt.Errorf("backend-mode %q is not a valid mode", mode)
 		}
+		if err != nil {
+			initErr.Errors = append(initErr.Errors, mapper.InitError{Mode: mode, Err: err})
+			continue
+		}
+		mappers = append(mappers, m)
+	}
+	if len(initErr.Errors) > 0 {
+		return nil, initErr
 	}
 	return mappers, nil
 }
@@ -327,7 +329,7 @@ func (h *handler) authenticateEndpoint(w http.ResponseWriter, req *http.Request)
 		log = log.WithField("arn", identity.CanonicalARN)
 	}
 
-	username, groups, err := h.doMapping(identity)
+	username, groups, err := h.doMapping(req.Context(), identity)
 	if err != nil {
 		metrics.Get().Latency.WithLabelValues(metrics.Unknown).Observe(duration(start))
 		log.WithError(err).Warn("access denied")
@@ -382,11 +384,13 @@ func ReservedPrefixExists(username string, reservedList []string) bool {
 	return false
 }
 
-func (h *handler) doMapping(identity *token.Identity) (string, []string, error) {
+func (h *handler) doMapping(ctx context.Context, identity *token.Identity) (string, []string, error) {
 	var errs []error
 
+	var last string
 	for _, m := range h.mappers {
-		mapping, err := m.Map(identity)
+		last = m.Name()
+		mapping, err := mapper.MapContext(ctx, m, identity)
 		if err == nil {
 			// Mapping found, try to render any templates like {{EC2PrivateDNSName}}
 			username, groups, err := h.renderTemplates(*mapping, identity)
@@ -398,7 +402,13 @@ func (h *handler) doMapping(identity *token.Identity) (string, []string, error)
 			}
 			return username, groups, nil
 		} else {
-			if err != mapper.ErrNotMapped {
+			if errors.Is(err, mapper.ErrNotMapped) {
+				logrus.WithFields(logrus.Fields{
+					"mapper":    m.Name(),
+					"arn":       identity.CanonicalARN,
+					"accountid": identity.AccountID,
+				}).Debug("identity not mapped by mapper")
+			} else {
 				errs = append(errs, fmt.Errorf("mapper %s Map error: %v", m.Name(), err))
 			}
 
@@ -411,6 +421,13 @@ func (h *handler) doMapping(identity *token.Identity) (string, []string, error)
 	if len(errs) > 0 {
 		return "", nil, utilerrors.NewAggregate(errs)
 	}
+	// only count identities no mapper in the chain mapped
+	metrics.Get().MapUnmapped.WithLabelValues(last).Inc()
+	logrus.WithFields(logrus.Fields{
+		"mapper":    last,
+		"arn":       identity.CanonicalARN,
+		"accountid": identity.AccountID,
+	}).Info("identity not mapped")
 	return "", nil, mapper.ErrNotMapped
 }
 
@@ -454,6 +471,7 @@ func (h *handler) renderTemplate(template string, identity *token.Identity) (str
 	template = strings.Replace(template, "{{SessionName}}", sessionName, -1)
 	template = strings.Replace(template, "{{SessionNameRaw}}", identity.SessionName, -1)
 	template = strings.Replace(template, "{{AccessKeyID}}", identity.AccessKeyID, -1)
+	template = strings.Replace(template, "{{ARN}}", identity.CanonicalARN, -1)
 
 	return template, nil
 }
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -12,6 +13,7 @@ import (
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	authenticationv1beta1 "k8s.io/api/authentication/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
@@ -964,3 +966,110 @@ func TestRenderTemplate(t *testing.T) {
 		})
 	}
 }
+
+func TestRenderTemplates(t *testing.T) {
+	h := &handler{}
+	identity := &token.Identity{
+		CanonicalARN: "arn:aws:iam::123:role/admin",
+		AccountID:    "123",
+		SessionName:  "jdoe@example.com",
+	}
+	mapping := config.IdentityMapping{
+		Username: "{{AccountID}}:{{SessionName}}",
+		Groups:   []string{"static", "{{ARN}}", "{{AccountID}}-{{SessionNameRaw}}"},
+	}
+
+	username, groups, err := h.renderTemplates(mapping, identity)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if username != "123:jdoe-example.com" {
+		t.Errorf("unexpected username %q", username)
+	}
+	expectedGroups := []string{"static", "arn:aws:iam::123:role/admin", "123-jdoe@example.com"}
+	if !reflect.DeepEqual(groups, expectedGroups) {
+		t.Errorf("expected groups %v, got %v", expectedGroups, groups)
+	}
+}
+
+func TestDoMappingUnmappedMetric(t *testing.T) {
+	h := setup(nil)
+	h.mappers = []mapper.Mapper{file.NewFileMapperWithMaps(map[string]config.RoleMapping{
+		"arn:aws:iam::012345678910:role/test": {
+			RoleARN:  "arn:aws:iam::012345678910:role/Test",
+			Username: "TestUser",
+		},
+	}, nil, nil)}
+	unmapped := func() float64 {
+		return testutil.ToFloat64(metrics.Get().MapUnmapped.WithLabelValues(mapper.ModeMountedFile))
+	}
+
+	if _, _, err := h.doMapping(context.Background(), &token.Identity{
+		CanonicalARN: "arn:aws:iam::012345678910:role/Test",
+		AccountID:    "012345678910",
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := unmapped(); got != 0 {
+		t.Errorf("expected no unmapped identities after a mapped one, got %v", got)
+	}
+
+	if _, _, err := h.doMapping(context.Background(), &token.Identity{
+		CanonicalARN: "arn:aws:iam::012345678910:role/Other",
+		AccountID:    "012345678910",
+	}); !errors.Is(err, mapper.ErrNotMapped) {
+		t.Fatalf("expected ErrNotMapped, got %v", err)
+	}
+	if got := unmapped(); got != 1 {
+		t.Errorf("expected 1 unmapped identity, got %v", got)
+	}
+
+	// a miss is not counted when a later mapper in the chain maps the
+	// identity
+	h.mappers = []mapper.Mapper{
+		file.NewFileMapperWithMaps(nil, nil, nil),
+		file.NewFileMapperWithMaps(map[string]config.RoleMapping{
+			"arn:aws:iam::012345678910:role/other": {
+				RoleARN:  "arn:aws:iam::012345678910:role/Other",
+				Username: "OtherUser",
+			},
+		}, nil, nil),
+	}
+	if username, _, err := h.doMapping(context.Background(), &token.Identity{
+		CanonicalARN: "arn:aws:iam::012345678910:role/Other",
+		AccountID:    "012345678910",
+	}); err != nil || username != "OtherUser" {
+		t.Fatalf("expected the second mapper to map the identity, got %q, %v", username, err)
+	}
+	if got := unmapped(); got != 1 {
+		t.Errorf("expected the first mapper's miss not to be counted, got %v", got)
+	}
+}
+
+func TestBuildMapperChainInitErrors(t *testing.T) {
+	cfg := config.Config{
+		BackendMode: []string{mapper.ModeMountedFile, mapper.ModeEKSConfigMap, mapper.ModeDynamicFile},
+		RoleMappings: []config.RoleMapping{
+			{Username: "no-arn-or-sso"},
+		},
+		Kubeconfig:      "/does/not/exist",
+		DynamicFilePath: "/does/not/exist",
+	}
+
+	_, err := BuildMapperChain(cfg)
+	var initErr mapper.ChainInitError
+	if !errors.As(err, &initErr) {
+		t.Fatalf("expected ChainInitError, got %v", err)
+	}
+	if len(initErr.Errors) != 2 {
+		t.Fatalf("expected 2 init errors, got %d: %v", len(initErr.Errors), initErr)
+	}
+	for i, mode := range []string{mapper.ModeMountedFile, mapper.ModeEKSConfigMap} {
+		if initErr.Errors[i].Mode != mode {
+			t.Errorf("expected error %d to be for %s, got %s", i, mode, initErr.Errors[i].Mode)
+		}
+		if !strings.Contains(err.Error(), mode) {
+			t.Errorf("expected error message to name %s: %v", mode, err)
+		}
+	}
+}